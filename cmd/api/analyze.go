@@ -0,0 +1,126 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/db"
+	"audio-go/internal/jobs"
+	"audio-go/internal/loudness"
+	"audio-go/internal/pcm"
+	"audio-go/internal/store"
+	"audio-go/internal/waveform"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+// analyzeJob computes what is derived from a track's decoded audio
+const analyzeJob jobs.Kind[trackPayload] = "analyze_track"
+
+// enqueueAnalysis queues the analysis of a track's new audio
+func (app *application) enqueueAnalysis(ctx context.Context, track *store.Track) {
+	if track.AudioKey == "" {
+		return
+	}
+	if _, err := analyzeJob.Enqueue(ctx, app.store.Jobs, trackPayload{TrackID: track.ID}, jobs.Options{}); err != nil {
+		app.logger.Errorw("queueing analysis failed", "track_id", track.ID, "error", err)
+	}
+}
+
+// analyzeHandler runs analysis jobs
+func (app *application) analyzeHandler() jobs.Handler {
+	return analyzeJob.Handle(func(ctx context.Context, job *jobs.Job, p trackPayload) error {
+		return app.analyzeTrack(ctx, p.TrackID)
+	})
+}
+
+// analyzeTrack decodes the track's audio in one pass and stores its
+// waveform and loudness. Formats without a decoder (the lossy ones) are
+// left alone.
+func (app *application) analyzeTrack(ctx context.Context, trackID int64) error {
+	ctx = db.WithPrimary(ctx)
+
+	track, err := app.store.Tracks.GetByID(ctx, trackID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if track.AudioKey == "" {
+		return nil
+	}
+
+	src := blob.NewReaderAt(ctx, app.blobs, track.AudioKey, track.Size)
+	info, err := audio.Probe(src, track.Size)
+	if err != nil {
+		var parseErr *audio.ParseError
+		if errors.Is(err, audio.ErrUnknownFormat) || errors.As(err, &parseErr) {
+			return nil
+		}
+		return err
+	}
+	decoded, err := pcm.NewDecoder(src, info)
+	if errors.Is(err, pcm.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	format := decoded.Format()
+	peaks, err := waveform.NewBuilder(format, app.config.waveform.levels, app.config.waveform.bits)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	meter, err := loudness.NewMeter(format)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	buf := make([]float32, 8192*format.Channels)
+	for {
+		n, err := decoded.ReadFrames(buf)
+		peaks.Write(buf[:n*format.Channels])
+		meter.Write(buf[:n*format.Channels])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := app.saveWaveform(ctx, track, peaks.Data()); err != nil {
+		return err
+	}
+	return app.saveLoudness(ctx, track, meter)
+}
+
+// saveWaveform stores the levels of a waveform and records them on the
+// track. Levels stored for audio that was replaced meanwhile are removed.
+func (app *application) saveWaveform(ctx context.Context, track *store.Track, levels []*waveform.Data) error {
+	if len(levels) == 0 {
+		return nil
+	}
+
+	wf := &store.Waveform{SampleRate: levels[0].SampleRate, Bits: levels[0].Bits}
+	for _, data := range levels {
+		b, err := data.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		key := waveformKey(track, data.SamplesPerPixel)
+		if err := app.blobs.Put(ctx, key, bytes.NewReader(b), int64(len(b)), waveform.DatContentType); err != nil {
+			return err
+		}
+		wf.Levels = append(wf.Levels, data.SamplesPerPixel)
+	}
+
+	err := app.store.Tracks.SetWaveform(ctx, track.ID, track.Checksum, wf)
+	if errors.Is(err, store.ErrVersionConflict) || errors.Is(err, store.ErrNotFound) {
+		app.deletePrefix(ctx, waveformPrefix(track))
+		return nil
+	}
+	return err
+}
@@ -2,7 +2,12 @@ package main
 
 import (
 	"audio-go/internal/auth"
+	"audio-go/internal/blob"
+	"audio-go/internal/db"
+	"audio-go/internal/events"
+	"audio-go/internal/jobs"
 	"audio-go/internal/store"
+	"audio-go/internal/transcode"
 	"context"
 	"errors"
 	"github.com/go-chi/chi/v5"
@@ -18,14 +23,107 @@ type application struct {
 	config        config
 	store         store.Storage
 	authenticator auth.Authenticator
+	blobs         blob.Store
+	db            *db.Cluster
+	events        *events.Bus // in-process subscribers of the outbox feed
+	transcoder    *transcode.Transcoder
+	workers       []*jobs.Pool // background job pools, drained on shutdown
 	logger        *zap.SugaredLogger
 }
 
 type config struct {
-	addr string
-	db   dbConfig
-	env  string
-	auth authConfig
+	addr       string
+	db         dbConfig
+	env        string
+	auth       authConfig
+	pagination paginationConfig
+	events     eventsConfig
+	blob       blobConfig
+	upload     uploadConfig
+	download   downloadConfig
+	stream     streamConfig
+	hls        hlsConfig
+	cors       corsConfig
+	artwork    artworkConfig
+	transcode  transcodeConfig
+	waveform   waveformConfig
+	jobs       jobsConfig
+}
+
+type blobConfig struct {
+	backend  string // "local" or "s3"
+	localDir string
+	s3       blob.S3Config
+}
+
+type uploadConfig struct {
+	maxBytes     int64
+	allowedTypes []string
+	timeout      time.Duration // read deadline for upload bodies
+	expiry       time.Duration // how long an idle resumable upload is kept
+	gcInterval   time.Duration // how often expired resumable uploads are collected
+	// decode lossless uploads in full and reject those failing their checksum
+	verifyLossless bool
+}
+
+type artworkConfig struct {
+	maxBytes int64
+	sizes    []int // of the square variants, in pixels
+}
+
+type transcodeConfig struct {
+	ladder     []transcode.Spec             // renditions made of every upload
+	commands   map[string]transcode.Command // external encoders by format
+	workers    int
+	attempts   int           // per rendition, within one job
+	backoff    time.Duration // after the first failed attempt, doubling
+	maxBackoff time.Duration
+}
+
+type waveformConfig struct {
+	levels []int // zoom levels in samples per pixel
+	bits   int   // of the stored peaks, 8 or 16
+}
+
+type jobsConfig struct {
+	workers      int           // of the pool running ingest analysis
+	pollInterval time.Duration // how often idle workers look for due jobs
+	lease        time.Duration // visibility timeout, renewed by worker heartbeats
+	drainTimeout time.Duration // how long shutdown waits for running jobs
+}
+
+type streamConfig struct {
+	secret  string        // signs stream URLs
+	urlTTL  time.Duration // lifetime of a signed stream URL
+	timeout time.Duration // write deadline for stream bodies
+}
+
+type hlsConfig struct {
+	keySecret       string // derives the AES-128 segment keys
+	segmentDuration time.Duration
+}
+
+type corsConfig struct {
+	origins []string // allowed to read streaming responses, "*" for any
+}
+
+type downloadConfig struct {
+	timeout time.Duration // write deadline for download bodies
+}
+
+type eventsConfig struct {
+	webhookURLs   []string
+	webhookSecret string
+	natsURL       string
+	natsSubject   string // subject prefix, events go to "<prefix>.<type>"
+	pollInterval  time.Duration
+	maxAttempts   int // deliveries before an event is dead-lettered
+}
+
+type paginationConfig struct {
+	secret       string // signs list cursors so clients can't forge them
+	defaultLimit int
+	maxLimit     int
 }
 
 type authConfig struct {
@@ -48,10 +146,18 @@ type dbConfig struct {
 	maxOpenConns int
 	maxIdleConns int
 	maxIdleTime  string
+	replicas     replicaConfig
+}
+
+type replicaConfig struct {
+	addrs         []string
+	maxLag        string
+	checkInterval string
 }
 
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
+	r.Use(app.dbSessionMiddleware)
 
 	r.Route("/v1", func(r chi.Router) {
 		r.Get("/health", app.healthCheckHandler)
@@ -64,6 +170,91 @@ func (app *application) mount() http.Handler {
 		r.Post("/signup", app.SignUp) // SignUp route
 	})
 
+	// Current user routes
+	r.Route("/v1/users/me", func(r chi.Router) {
+		r.Use(app.AuthMiddleware)
+		r.Get("/", app.getCurrentUserHandler)
+		r.Patch("/", app.updateCurrentUserHandler)
+	})
+
+	// Track routes
+	r.Route("/v1/tracks", func(r chi.Router) {
+		// Players can't always set headers, so streams also take signed URLs
+		r.Group(func(r chi.Router) {
+			r.Use(app.signedURLMiddleware, app.tracksContextMiddleware)
+			r.Use(app.withDeadlines(0, app.config.stream.timeout))
+			r.Get("/{trackID}/stream", app.streamTrackHandler)
+			r.Head("/{trackID}/stream", app.streamTrackHandler)
+			r.Get("/{trackID}/hls/master.m3u8", app.hlsMasterPlaylistHandler)
+			r.Get("/{trackID}/hls/media.m3u8", app.hlsMediaPlaylistHandler)
+			r.Get("/{trackID}/hls/{segment}", app.hlsSegmentHandler)
+			r.Get("/{trackID}/waveform", app.waveformHandler)
+			r.Get("/{trackID}/waveform.svg", app.waveformImageHandler("svg"))
+			r.Get("/{trackID}/waveform.png", app.waveformImageHandler("png"))
+		})
+		// DASH players are usually served from another origin
+		r.Group(func(r chi.Router) {
+			r.Use(app.corsMiddleware)
+			r.Options("/{trackID}/manifest.mpd", preflightHandler)
+			r.Options("/{trackID}/dash/{segment}", preflightHandler)
+			r.Group(func(r chi.Router) {
+				r.Use(app.signedURLMiddleware, app.tracksContextMiddleware)
+				r.Use(app.withDeadlines(0, app.config.stream.timeout))
+				r.Get("/{trackID}/manifest.mpd", app.dashManifestHandler)
+				r.Get("/{trackID}/dash/init.mp4", app.dashInitHandler)
+				r.Get("/{trackID}/dash/{segment}", app.dashSegmentHandler)
+			})
+		})
+		r.With(app.AuthMiddleware, app.tracksContextMiddleware).
+			Get("/{trackID}/hls/key", app.hlsKeyHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.AuthMiddleware)
+			r.Get("/", app.listTracksHandler)
+			r.Post("/", app.createTrackHandler)
+
+			r.Route("/{trackID}", func(r chi.Router) {
+				r.Use(app.tracksContextMiddleware)
+				r.Get("/", app.getTrackHandler)
+				r.With(app.withDeadlines(0, app.config.download.timeout)).
+					Get("/download", app.downloadTrackHandler)
+				r.Get("/artwork", app.getArtworkHandler)
+				r.With(app.withDeadlines(0, app.config.download.timeout)).
+					Get("/renditions/{name}", app.getRenditionHandler)
+				r.Post("/stream-url", app.streamURLHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.requireTrackOwner)
+					r.Patch("/", app.updateTrackHandler)
+					r.Delete("/", app.deleteTrackHandler)
+
+					r.With(app.withDeadlines(app.config.upload.timeout, app.config.upload.timeout)).
+						Post("/audio", app.uploadTrackAudioHandler)
+					r.Put("/artwork", app.uploadArtworkHandler)
+					r.Delete("/artwork", app.deleteArtworkHandler)
+				})
+			})
+		})
+	})
+
+	// Resumable upload routes (tus 1.0)
+	r.Route("/v1/uploads", func(r chi.Router) {
+		r.Options("/", app.tusOptionsHandler)
+
+		r.Group(func(r chi.Router) {
+			r.Use(app.AuthMiddleware, app.tusResumableMiddleware)
+			r.Post("/", app.createUploadHandler)
+
+			r.Route("/{uploadID}", func(r chi.Router) {
+				r.Use(app.uploadsContextMiddleware)
+				r.Head("/", app.headUploadHandler)
+				r.Delete("/", app.deleteUploadHandler)
+				r.With(app.withDeadlines(app.config.upload.timeout, app.config.upload.timeout)).
+					Patch("/", app.patchUploadHandler)
+			})
+		})
+	})
+
 	return r
 }
 
@@ -98,8 +289,21 @@ func (app *application) run(mux http.Handler) error {
 		// Log that a termination signal has been caught
 		app.logger.Infow("signal caught", "signal", s.String())
 
-		// Initiate the shutdown process of the server and send the result to the shutdown channel
-		shutdown <- srv.Shutdown(ctx)
+		// Initiate the shutdown process of the server
+		err := srv.Shutdown(ctx)
+
+		// Let running background jobs finish; those that don't in time are
+		// cancelled and queued again
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), app.config.jobs.drainTimeout)
+		defer cancelDrain()
+		for _, pool := range app.workers {
+			if err := pool.Shutdown(drainCtx); err != nil {
+				app.logger.Warnw("background jobs cancelled at shutdown", "error", err)
+			}
+		}
+
+		// Send the result to the shutdown channel
+		shutdown <- err
 	}()
 
 	// Log that the server has started, including address and environment info
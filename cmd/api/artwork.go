@@ -0,0 +1,284 @@
+package main
+
+import (
+	"audio-go/internal/artwork"
+	"audio-go/internal/store"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoArtwork   = errors.New("track has no artwork")
+	errArtworkSize = errors.New("invalid artwork size")
+)
+
+// artworkTypes are the content types accepted for uploaded artwork
+var artworkTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// artworkPrefix is where a version of a track's artwork is stored
+func artworkPrefix(trackID int64, hash string) string {
+	return fmt.Sprintf("tracks/%d/artwork/%s/", trackID, hash)
+}
+
+func artworkVariantKey(trackID int64, art *store.Artwork, size int, format string) string {
+	return fmt.Sprintf("%s%d.%s", artworkPrefix(trackID, art.Hash), size, format)
+}
+
+// getArtworkHandler serves a square variant of the track's cover art. ?size=
+// picks the smallest variant at least that large (the largest if none is),
+// ?format= picks jpeg, png or webp, otherwise WebP is sent to clients that
+// accept it. Variants never change under a given ETag.
+func (app *application) getArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	art := track.Artwork
+	if art == nil || len(art.Sizes) == 0 {
+		app.notFoundResponse(w, r, errNoArtwork)
+		return
+	}
+
+	size := art.Sizes[len(art.Sizes)-1]
+	if s := r.URL.Query().Get("size"); s != "" {
+		want, err := strconv.Atoi(s)
+		if err != nil || want <= 0 {
+			app.badRequestResponse(w, r, errArtworkSize)
+			return
+		}
+		for _, v := range art.Sizes {
+			if v >= want {
+				size = v
+				break
+			}
+		}
+	}
+
+	format := art.Format
+	switch f := r.URL.Query().Get("format"); {
+	case f == "webp" || f == art.Format:
+		format = f
+	case f != "":
+		app.badRequestResponse(w, r, fmt.Errorf("artwork is available as %s or webp", art.Format))
+		return
+	case strings.Contains(r.Header.Get("Accept"), "image/webp"):
+		format = "webp"
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if derivedNotModified(w, r, track, fmt.Sprintf(`"%s-%d-%s"`, art.Hash, size, format)) {
+		return
+	}
+
+	rc, err := app.blobs.Get(r.Context(), artworkVariantKey(track.ID, art, size, format))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", artwork.MIMEType(format))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, rc); err != nil && r.Context().Err() == nil {
+		app.logger.Warnw("artwork response cut short", "track_id", track.ID, "error", err)
+	}
+}
+
+// derivedNotModified sets the caching headers of immutable content derived
+// from the track, private unless the track is listed or public, and answers
+// a matching If-None-Match with 304
+func derivedNotModified(w http.ResponseWriter, r *http.Request, track *store.Track, tag string) bool {
+	cacheControl := "public, max-age=86400"
+	if track.Visibility == store.VisibilityPrivate {
+		cacheControl = "private, max-age=86400"
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimPrefix(strings.TrimSpace(t), "W/"); t == tag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uploadArtworkHandler replaces the track's cover art with a JPEG or PNG
+// image, sent raw or as the "file" part of multipart/form-data. Uploaded
+// artwork is no longer replaced by art embedded in later audio uploads.
+func (app *application) uploadArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.artwork.maxBytes)
+	body, contentType, _, err := uploadBody(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	if !artworkTypes[strings.ToLower(contentType)] {
+		app.unsupportedMediaTypeResponse(w, r, fmt.Errorf("%w: %q", artwork.ErrUnsupported, contentType))
+		return
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			app.payloadTooLargeResponse(w, r, err)
+			return
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	img, err := artwork.Decode(data)
+	switch {
+	case errors.Is(err, artwork.ErrUnsupported):
+		app.unsupportedMediaTypeResponse(w, r, err)
+		return
+	case err != nil:
+		app.badRequestResponse(w, r, err)
+		return
+	case artwork.MIMEType(img.Format) != strings.ToLower(contentType):
+		app.unsupportedMediaTypeResponse(w, r,
+			fmt.Errorf("%w: content is %s, declared as %s", errContentMismatch, artwork.MIMEType(img.Format), contentType))
+		return
+	}
+
+	previous := track.Artwork
+	if err := app.ingestArtwork(r.Context(), track, data, img, store.ArtworkUpload); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.store.Tracks.Update(r.Context(), track); err != nil {
+		app.deleteArtwork(r.Context(), track.ID, track.Artwork, previous)
+		switch {
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	app.deleteArtwork(r.Context(), track.ID, previous, track.Artwork)
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusOK, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// deleteArtworkHandler removes the track's cover art
+func (app *application) deleteArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	previous := track.Artwork
+	if previous == nil {
+		app.notFoundResponse(w, r, errNoArtwork)
+		return
+	}
+
+	track.Artwork = nil
+	if err := app.store.Tracks.Update(r.Context(), track); err != nil {
+		switch {
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	app.deleteArtwork(r.Context(), track.ID, previous, nil)
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusOK, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ingestArtwork stores a decoded cover with its variants and sets
+// track.Artwork. The caller saves the track, then removes the artwork it
+// replaced with deleteArtwork.
+func (app *application) ingestArtwork(ctx context.Context, track *store.Track, data []byte, img *artwork.Image, source string) error {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+	if track.Artwork != nil && track.Artwork.Hash == hash {
+		// Same image, already stored
+		track.Artwork.Source = source
+		return nil
+	}
+
+	variants, err := img.Variants(app.config.artwork.sizes)
+	if err != nil {
+		return err
+	}
+	art := &store.Artwork{
+		Hash:          hash,
+		Source:        source,
+		MIMEType:      artwork.MIMEType(img.Format),
+		Width:         img.Width,
+		Height:        img.Height,
+		Sizes:         img.Sizes(app.config.artwork.sizes),
+		Format:        img.VariantFormat(),
+		DominantColor: img.DominantColor(),
+	}
+
+	prefix := artworkPrefix(track.ID, hash)
+	if err := app.blobs.Put(ctx, prefix+"source", bytes.NewReader(data), int64(len(data)), art.MIMEType); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		key := artworkVariantKey(track.ID, art, v.Size, v.Format)
+		if err := app.blobs.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.MIMEType); err != nil {
+			app.deleteArtwork(ctx, track.ID, art, nil)
+			return err
+		}
+	}
+
+	track.Artwork = art
+	return nil
+}
+
+// ingestEmbeddedArtwork takes the cover from an uploaded audio file unless
+// the owner uploaded artwork of their own. Unusable art is logged and skipped.
+func (app *application) ingestEmbeddedArtwork(ctx context.Context, track *store.Track, data []byte) {
+	if track.Artwork != nil && track.Artwork.Source == store.ArtworkUpload {
+		return
+	}
+	img, err := artwork.Decode(data)
+	if err == nil {
+		err = app.ingestArtwork(ctx, track, data, img, store.ArtworkEmbedded)
+	}
+	if err != nil {
+		app.logger.Warnw("embedded artwork skipped", "track_id", track.ID, "error", err)
+	}
+}
+
+// readArtworkSource returns the original image the variants were made from
+func (app *application) readArtworkSource(ctx context.Context, trackID int64, art *store.Artwork) ([]byte, error) {
+	rc, err := app.blobs.Get(ctx, artworkPrefix(trackID, art.Hash)+"source")
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, app.config.artwork.maxBytes))
+}
+
+// deleteArtwork removes the stored images of art, unless they are those of
+// keep, logging rather than failing the request
+func (app *application) deleteArtwork(ctx context.Context, trackID int64, art, keep *store.Artwork) {
+	if art == nil || (keep != nil && keep.Hash == art.Hash) {
+		return
+	}
+	app.deletePrefix(ctx, artworkPrefix(trackID, art.Hash))
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"audio-go/internal/store" // Adjust this import path according to your project structure
-	"context"
 	"net/http"
 )
 
@@ -32,7 +31,7 @@ func (app *application) SignIn(w http.ResponseWriter, r *http.Request) {
 		Password: *store.NewPassword(req.Password), // Initialize password with NewPassword constructor
 	}
 
-	ctx := context.Background()
+	ctx := r.Context()
 	// Call SignIn method from the store
 	resp, err := app.store.Users.SignIn(ctx, user)
 	if err != nil {
@@ -63,7 +62,7 @@ func (app *application) SignUp(w http.ResponseWriter, r *http.Request) {
 		Email:    req.Email,
 		Password: *store.NewPassword(req.Password), // Initialize password with NewPassword constructor
 	}
-	ctx := context.Background()
+	ctx := r.Context()
 	// Call SignUp method from the store
 	resp, err := app.store.Users.SignUp(ctx, user)
 	if err != nil {
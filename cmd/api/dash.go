@@ -0,0 +1,89 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/dash"
+	"audio-go/internal/hls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dashManifestHandler serves a static MPD for the track. Segments are cut as
+// for HLS and share its cache, but are fragmented MP4 and unencrypted.
+func (app *application) dashManifestHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	plan, ok := app.loadHLSPlan(w, r, track)
+	if !ok {
+		return
+	}
+
+	program := dash.Program{
+		Title:  track.Title,
+		Artist: track.Artist,
+		Album:  track.Tags.Album,
+	}
+	query := signedQuery(r)
+	body, err := dash.Manifest(plan, program, dash.ManifestOptions{
+		Initialization: "dash/init.mp4" + query,
+		Media:          "dash/$Number$.m4s" + query,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", dash.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	setGainHeaders(w.Header(), track)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// dashInitHandler serves the initialization segment. It is small and built
+// from the plan alone, so it isn't cached.
+func (app *application) dashInitHandler(w http.ResponseWriter, r *http.Request) {
+	plan, ok := app.loadHLSPlan(w, r, getTrackFromContext(r))
+	if !ok {
+		return
+	}
+	writeDASHSegment(w, dash.InitSegment(plan))
+}
+
+// dashSegmentHandler serves a media segment, "<seq>.m4s"
+func (app *application) dashSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	plan, ok := app.loadHLSPlan(w, r, track)
+	if !ok {
+		return
+	}
+	seq, ok := plan.ParseSegmentName(chi.URLParam(r, "segment"), "m4s")
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("no such segment"))
+		return
+	}
+
+	name := fmt.Sprintf("dash/%d.m4s", seq)
+	data, err := app.packagedSegment(r.Context(), track, plan, seq, name, dash.SegmentContentType,
+		func(w io.Writer, seg hls.Segment, packets []audio.Packet, src io.ReaderAt) error {
+			return dash.WriteMediaSegment(w, seq, seg, packets, src)
+		})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	writeDASHSegment(w, data)
+}
+
+func writeDASHSegment(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", dash.SegmentContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
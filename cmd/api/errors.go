@@ -31,3 +31,39 @@ func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request
 	app.logger.Warnf("forbidden error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 	writeJSONError(w, http.StatusForbidden, "forbidden")
 }
+
+// conflictResponse handles 409 status code errors (the resource changed underneath the client)
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("edit conflict", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusConflict, "the resource was modified by another request, please fetch it and try again")
+}
+
+// preconditionFailedResponse handles 412 status code errors (If-Match did not match the current ETag)
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusPreconditionFailed, "precondition failed")
+}
+
+// preconditionRequiredResponse handles 428 status code errors (If-Match is mandatory for the request)
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("precondition required", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusPreconditionRequired, "the If-Match header is required")
+}
+
+// payloadTooLargeResponse handles 413 status code errors
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("payload too large", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusRequestEntityTooLarge, "the request body is too large")
+}
+
+// unsupportedMediaTypeResponse handles 415 status code errors
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("unsupported media type", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+}
+
+// rangeNotSatisfiableResponse handles 416 status code errors
+func (app *application) rangeNotSatisfiableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("range not satisfiable", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	writeJSONError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+}
@@ -5,13 +5,17 @@ import (
 )
 
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	data := map[string]string{
+	data := map[string]any{
 
 		"status": "ok",
 		"env":    app.config.env,
 		// "version": version,
 	}
 
+	if app.db != nil {
+		data["replicas"] = app.db.Replicas()
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, data); err != nil {
 		app.internalServerError(w, r, err)
 	}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/hls"
+	"audio-go/internal/store"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const playlistContentType = "application/vnd.apple.mpegurl"
+
+// audioID names a track's current audio in the keys of files made from
+// it. It follows the audio's checksum, so a new upload never sees stale
+// ones.
+func audioID(track *store.Track) string {
+	if len(track.Checksum) >= 16 {
+		return track.Checksum[:16]
+	}
+	return "v" + strconv.FormatInt(track.Version, 10)
+}
+
+// hlsPrefix is where the packaging (HLS and DASH) of a track's current audio
+// is cached
+func hlsPrefix(track *store.Track) string {
+	return fmt.Sprintf("tracks/%d/hls/%s/", track.ID, audioID(track))
+}
+
+// hlsKey derives the AES-128 key of a track's segments. Keys need no
+// storage and change with the audio.
+func (app *application) hlsKey(track *store.Track) []byte {
+	mac := hmac.New(sha256.New, []byte(app.config.hls.keySecret))
+	fmt.Fprintf(mac, "hls:%s", hlsPrefix(track))
+	return mac.Sum(nil)[:16]
+}
+
+// hlsPlan returns the segmentation of the track's audio, indexing the file
+// and caching the result in the blob store on first use
+func (app *application) hlsPlan(ctx context.Context, track *store.Track) (*hls.Plan, error) {
+	prefix := hlsPrefix(track)
+
+	rc, err := app.blobs.Get(ctx, prefix+"plan.json")
+	if err == nil {
+		defer rc.Close()
+		var plan hls.Plan
+		if err := json.NewDecoder(rc).Decode(&plan); err != nil {
+			return nil, err
+		}
+		return &plan, nil
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return nil, err
+	}
+
+	idx, err := audio.Packets(blob.NewReaderAt(ctx, app.blobs, track.AudioKey, track.Size), track.Size)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := hls.NewPlan(idx, app.config.hls.segmentDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	// Packets first: a plan is only ever read back with its packets in place
+	packets := hls.EncodePackets(idx.Packets)
+	if err := app.blobs.Put(ctx, prefix+"packets", bytes.NewReader(packets), int64(len(packets)), "application/octet-stream"); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(plan)
+	if err != nil {
+		return nil, err
+	}
+	if err := app.blobs.Put(ctx, prefix+"plan.json", bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// packagedSegment returns segment seq of the plan as packaged by write,
+// from the cache under name or built from the original and cached
+func (app *application) packagedSegment(ctx context.Context, track *store.Track, plan *hls.Plan, seq int, name, contentType string,
+	write func(w io.Writer, seg hls.Segment, packets []audio.Packet, src io.ReaderAt) error) ([]byte, error) {
+	key := hlsPrefix(track) + name
+
+	rc, err := app.blobs.Get(ctx, key)
+	if err == nil {
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	if !errors.Is(err, blob.ErrNotFound) {
+		return nil, err
+	}
+
+	seg := plan.Segments[seq]
+	off, n := hls.PacketRange(seg)
+	rc, err = app.blobs.GetRange(ctx, hlsPrefix(track)+"packets", off, n)
+	if err != nil {
+		return nil, err
+	}
+	records, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	packets, err := hls.DecodePackets(records)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	src := blob.NewReaderAt(ctx, app.blobs, track.AudioKey, track.Size)
+	if err := write(&buf, seg, packets, src); err != nil {
+		return nil, err
+	}
+	if err := app.blobs.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), contentType); err != nil {
+		app.logger.Warnw("failed to cache segment", "track_id", track.ID, "segment", name, "error", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// loadHLSPlan writes the error response when the track cannot be packaged
+func (app *application) loadHLSPlan(w http.ResponseWriter, r *http.Request, track *store.Track) (*hls.Plan, bool) {
+	if track.AudioKey == "" {
+		app.notFoundResponse(w, r, errNoAudio)
+		return nil, false
+	}
+	plan, err := app.hlsPlan(r.Context(), track)
+	switch {
+	case errors.Is(err, audio.ErrNotPacketizable), errors.Is(err, hls.ErrUnsupported):
+		app.notFoundResponse(w, r, err)
+		return nil, false
+	case err != nil:
+		app.internalServerError(w, r, err)
+		return nil, false
+	}
+	return plan, true
+}
+
+// signedQuery repeats the signature of a signed request on the URIs of a
+// playlist, so players can follow them
+func signedQuery(r *http.Request) string {
+	q := r.URL.Query()
+	if q.Get("sig") == "" {
+		return ""
+	}
+	signed := url.Values{}
+	for _, k := range []string{"uid", "exp", "sig"} {
+		signed.Set(k, q.Get(k))
+	}
+	return "?" + signed.Encode()
+}
+
+func writePlaylist(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", playlistContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// hlsMasterPlaylistHandler serves the master playlist of a track, with the
+// gains to normalize it by
+func (app *application) hlsMasterPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	plan, ok := app.loadHLSPlan(w, r, track)
+	if !ok {
+		return
+	}
+	setGainHeaders(w.Header(), track)
+	writePlaylist(w, plan.MasterPlaylist("media.m3u8"+signedQuery(r)))
+}
+
+// hlsMediaPlaylistHandler serves the media playlist, whose segments are
+// encrypted with the key at ./key
+func (app *application) hlsMediaPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	plan, ok := app.loadHLSPlan(w, r, getTrackFromContext(r))
+	if !ok {
+		return
+	}
+	writePlaylist(w, plan.MediaPlaylist("key", signedQuery(r)))
+}
+
+// hlsSegmentHandler serves an encrypted segment
+func (app *application) hlsSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	plan, ok := app.loadHLSPlan(w, r, track)
+	if !ok {
+		return
+	}
+	seq, ok := plan.ParseSegmentName(chi.URLParam(r, "segment"), plan.Ext())
+	if !ok {
+		app.notFoundResponse(w, r, errors.New("no such segment"))
+		return
+	}
+
+	name := fmt.Sprintf("segments/%d.%s", seq, plan.Ext())
+	data, err := app.packagedSegment(r.Context(), track, plan, seq, name, plan.ContentType(), plan.WriteSegment)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	data, err = hls.Encrypt(app.hlsKey(track), seq, data)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", plan.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// hlsKeyHandler delivers the segment key. Unlike playlists and segments it
+// requires a bearer token: a leaked signed URL alone does not decrypt.
+func (app *application) hlsKeyHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	if track.AudioKey == "" {
+		app.notFoundResponse(w, r, errNoAudio)
+		return
+	}
+	key := app.hlsKey(track)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(key)))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(key)
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"audio-go/internal/pagination"            // Importing the pagination package for list endpoints
 	"encoding/json"        // Importing the encoding/json package for JSON encoding/decoding
+	"errors"               // Importing the errors package for ETag parsing errors
+	"fmt"                  // Importing the fmt package for formatting ETags
 	"net/http"            // Importing the net/http package for HTTP server and client implementations
+	"strconv"             // Importing the strconv package for parsing ETag versions
+	"strings"             // Importing the strings package for parsing conditional headers
 	"github.com/go-playground/validator/v10" // Importing the validator package for struct validation
 )
 
@@ -59,4 +64,94 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	}
 	// Call writeJSON to send the data response
 	return writeJSON(w, status, &envelope{Data: data})
-}
\ No newline at end of file
+}
+
+// paginator builds a pagination.Paginator for a list endpoint using the
+// application-wide cursor secret and limits
+func (app *application) paginator(sorts map[string]string, defaultSort string, filters map[string]pagination.Filter) *pagination.Paginator {
+	return pagination.New(pagination.Config{
+		Secret:       []byte(app.config.pagination.secret),
+		DefaultLimit: app.config.pagination.defaultLimit,
+		MaxLimit:     app.config.pagination.maxLimit,
+		Sorts:        sorts,
+		DefaultSort:  defaultSort,
+		Filters:      filters,
+	})
+}
+
+var (
+	errMissingIfMatch = errors.New("missing If-Match header")
+	errInvalidETag    = errors.New("invalid ETag")
+	errETagMismatch   = errors.New("ETag does not match the current version")
+)
+
+// etag formats a resource version as a strong ETag, e.g. "v3"
+func etag(version int64) string {
+	return fmt.Sprintf(`"v%d"`, version)
+}
+
+// setETag sets the ETag header for a resource at the given version
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// notModified reports whether the request's If-None-Match already matches
+// the given version, in which case a 304 has been written.
+func notModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			setETag(w, version)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// readIfMatch returns the version the client expects from the If-Match header.
+// "*" matches any version and is reported as ok with version -1.
+func readIfMatch(r *http.Request) (int64, error) {
+	im := strings.TrimSpace(r.Header.Get("If-Match"))
+	if im == "" {
+		return 0, errMissingIfMatch
+	}
+	if im == "*" {
+		return -1, nil
+	}
+
+	// Weak tags never match for If-Match (RFC 9110 strong comparison)
+	if strings.HasPrefix(im, "W/") || !strings.HasPrefix(im, `"v`) || !strings.HasSuffix(im, `"`) {
+		return 0, errInvalidETag
+	}
+	version, err := strconv.ParseInt(im[2:len(im)-1], 10, 64)
+	if err != nil {
+		return 0, errInvalidETag
+	}
+	return version, nil
+}
+
+// checkIfMatch validates the If-Match header against the current version and
+// writes the appropriate 428/412 response when the precondition fails.
+// It returns the version the update should be conditioned on.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, current int64) (int64, bool) {
+	expected, err := readIfMatch(r)
+	switch {
+	case errors.Is(err, errMissingIfMatch):
+		app.preconditionRequiredResponse(w, r, err)
+		return 0, false
+	case err != nil:
+		app.preconditionFailedResponse(w, r, err)
+		return 0, false
+	case expected == -1:
+		return current, true
+	case expected != current:
+		app.preconditionFailedResponse(w, r, errETagMismatch)
+		return 0, false
+	}
+	return expected, true
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/db"
+	"audio-go/internal/jobs"
+	"audio-go/internal/loudness"
+	"audio-go/internal/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// maxAlbumTracks bounds the tracks an album measurement reads
+const maxAlbumTracks = 500
+
+// albumLoudnessJob measures an album from its tracks' gating blocks
+const albumLoudnessJob jobs.Kind[albumPayload] = "album_loudness"
+
+// albumPayload identifies an album: the owner's tracks tagged with the
+// same album and album artist
+type albumPayload struct {
+	OwnerID     int64  `json:"owner_id"`
+	Album       string `json:"album"`
+	AlbumArtist string `json:"album_artist"`
+}
+
+// albumOf returns the album of a track, false for a track without one
+func albumOf(track *store.Track) (albumPayload, bool) {
+	a := albumPayload{OwnerID: track.OwnerID, Album: track.Tags.Album, AlbumArtist: track.Tags.AlbumArtist}
+	return a, a.Album != ""
+}
+
+// loudnessKey is where the gating blocks of a track's current audio are
+// stored
+func loudnessKey(track *store.Track) string {
+	return fmt.Sprintf("tracks/%d/loudness/%s.json", track.ID, audioID(track))
+}
+
+// saveLoudness stores the gating blocks of a measurement and records it on
+// the track, then has its album measured again. Audio too short or quiet
+// to pass the gates has no loudness and is left unmeasured.
+func (app *application) saveLoudness(ctx context.Context, track *store.Track, meter *loudness.Meter) error {
+	r := meter.Result()
+	if math.IsInf(r.Integrated, -1) {
+		return nil
+	}
+
+	b, err := json.Marshal(meter.Histogram())
+	if err != nil {
+		return err
+	}
+	key := loudnessKey(track)
+	if err := app.blobs.Put(ctx, key, bytes.NewReader(b), int64(len(b)), "application/json"); err != nil {
+		return err
+	}
+
+	l := &store.Loudness{
+		Integrated: roundLU(r.Integrated),
+		Range:      roundLU(r.Range),
+		TruePeak:   roundLU(r.TruePeak),
+		SamplePeak: roundLU(r.SamplePeak),
+	}
+	err = app.store.Tracks.SetLoudness(ctx, track.ID, track.Checksum, l)
+	if errors.Is(err, store.ErrVersionConflict) || errors.Is(err, store.ErrNotFound) {
+		app.deleteBlob(ctx, key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if a, ok := albumOf(track); ok {
+		app.enqueueAlbumLoudness(ctx, a)
+	}
+	return nil
+}
+
+func roundLU(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// enqueueAlbumLoudness queues the measurement of an album
+func (app *application) enqueueAlbumLoudness(ctx context.Context, a albumPayload) {
+	if _, err := albumLoudnessJob.Enqueue(ctx, app.store.Jobs, a, jobs.Options{}); err != nil {
+		app.logger.Errorw("queueing album loudness failed", "owner_id", a.OwnerID, "album", a.Album, "error", err)
+	}
+}
+
+// albumLoudnessHandler runs album measurement jobs
+func (app *application) albumLoudnessHandler() jobs.Handler {
+	return albumLoudnessJob.Handle(func(ctx context.Context, job *jobs.Job, a albumPayload) error {
+		return app.measureAlbum(ctx, a)
+	})
+}
+
+// measureAlbum measures the album's measured tracks as if played in
+// sequence and records the result on each of them. Tracks measured later
+// queue the album again.
+func (app *application) measureAlbum(ctx context.Context, a albumPayload) error {
+	ctx = db.WithPrimary(ctx)
+
+	tracks, err := app.store.Tracks.ListByAlbum(ctx, a.OwnerID, a.Album, a.AlbumArtist, maxAlbumTracks)
+	if err != nil {
+		return err
+	}
+
+	var measured []*store.Track
+	blocks := &loudness.Histogram{}
+	peak := math.Inf(-1)
+	for _, track := range tracks {
+		if track.Loudness == nil {
+			continue
+		}
+		h, err := app.loadLoudnessBlocks(ctx, track)
+		if errors.Is(err, blob.ErrNotFound) {
+			continue // the audio was replaced, measured again soon
+		}
+		if err != nil {
+			return err
+		}
+		blocks.Add(h)
+		peak = max(peak, track.Loudness.TruePeak)
+		measured = append(measured, track)
+	}
+
+	var album *store.AlbumLoudness
+	if integrated := blocks.Integrated(); !math.IsInf(integrated, -1) {
+		album = &store.AlbumLoudness{
+			Integrated: roundLU(integrated),
+			Range:      roundLU(blocks.Range()),
+			TruePeak:   peak,
+			Tracks:     len(measured),
+		}
+	}
+
+	for _, track := range measured {
+		if current := track.Loudness.Album; current == album || current != nil && album != nil && *current == *album {
+			continue
+		}
+		err := app.store.Tracks.SetAlbumLoudness(ctx, track.ID, track.Checksum, album)
+		if err != nil && !errors.Is(err, store.ErrVersionConflict) && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *application) loadLoudnessBlocks(ctx context.Context, track *store.Track) (*loudness.Histogram, error) {
+	rc, err := app.blobs.Get(ctx, loudnessKey(track))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	var h loudness.Histogram
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// replayGain returns the ReplayGain values of the track's measurement, or
+// those its file was tagged with when it has none
+func replayGain(track *store.Track) *audio.ReplayGain {
+	l := track.Loudness
+	if l == nil {
+		return track.Tags.ReplayGain
+	}
+	value := func(v float64) *float64 { return &v }
+	rg := &audio.ReplayGain{
+		TrackGain: value(roundLU(loudness.ReplayGain(l.Integrated))),
+		TrackPeak: value(math.Pow(10, l.TruePeak/20)),
+	}
+	if l.Album != nil {
+		rg.AlbumGain = value(roundLU(loudness.ReplayGain(l.Album.Integrated)))
+		rg.AlbumPeak = value(math.Pow(10, l.Album.TruePeak/20))
+	}
+	return rg
+}
+
+// gainHeaders lists the headers setGainHeaders may set, for CORS
+const gainHeaders = "X-ReplayGain-Track-Gain, X-ReplayGain-Track-Peak, X-ReplayGain-Album-Gain, " +
+	"X-ReplayGain-Album-Peak, X-R128-Track-Gain, X-R128-Album-Gain"
+
+// setGainHeaders tells players how to normalize the track: ReplayGain 2.0
+// gains to -18 LUFS and peaks as tags carry them, and R128 gains to -23
+// LUFS in the Q7.8 dB of Opus's R128_*_GAIN tags
+func setGainHeaders(h http.Header, track *store.Track) {
+	rg := replayGain(track)
+	if rg == nil {
+		return
+	}
+	gain := func(name string, db *float64) {
+		if db != nil {
+			h.Set("X-ReplayGain-"+name+"-Gain", fmt.Sprintf("%.2f dB", *db))
+			h.Set("X-R128-"+name+"-Gain", strconv.Itoa(int(loudness.R128Gain(loudness.ReplayGainReference-*db))))
+		}
+	}
+	peak := func(name string, p *float64) {
+		if p != nil {
+			h.Set("X-ReplayGain-"+name+"-Peak", fmt.Sprintf("%.6f", *p))
+		}
+	}
+	gain("Track", rg.TrackGain)
+	peak("Track", rg.TrackPeak)
+	gain("Album", rg.AlbumGain)
+	peak("Album", rg.AlbumPeak)
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"audio-go/internal/auth"
+	"audio-go/internal/blob"
 	"audio-go/internal/db"
 	"audio-go/internal/env"
+	"audio-go/internal/events"
+	"audio-go/internal/jobs"
 	"audio-go/internal/store"
+	"audio-go/internal/transcode"
+	"context"
+	"strconv"
+	"strings"
 	"time"
 
 	// "time"
@@ -23,6 +30,11 @@ func main() {
 			maxOpenConns: env.GetInt("DB_MAX_OPEN_CONNS", 30),
 			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 30),
 			maxIdleTime:  env.GetString("DB_MAX_IDLE_TIME", "15m"),
+			replicas: replicaConfig{
+				addrs:         splitList(env.GetString("DB_REPLICA_ADDRS", "")),
+				maxLag:        env.GetString("DB_REPLICA_MAX_LAG", "10s"),
+				checkInterval: env.GetString("DB_REPLICA_CHECK_INTERVAL", "5s"),
+			},
 		},
 		env: env.GetString("ENV", "development"),
 
@@ -37,13 +49,116 @@ func main() {
 				iss:    "audio",
 			},
 		},
+
+		pagination: paginationConfig{
+			secret:       env.GetString("PAGINATION_CURSOR_SECRET", "example"),
+			defaultLimit: env.GetInt("PAGINATION_DEFAULT_LIMIT", 20),
+			maxLimit:     env.GetInt("PAGINATION_MAX_LIMIT", 100),
+		},
+
+		events: eventsConfig{
+			webhookURLs:   splitList(env.GetString("EVENTS_WEBHOOK_URLS", "")),
+			webhookSecret: env.GetString("EVENTS_WEBHOOK_SECRET", ""),
+			natsURL:       env.GetString("EVENTS_NATS_URL", ""),
+			natsSubject:   env.GetString("EVENTS_NATS_SUBJECT", "audio.events"),
+			pollInterval:  time.Second,
+			maxAttempts:   env.GetInt("EVENTS_MAX_ATTEMPTS", 10),
+		},
+
+		blob: blobConfig{
+			backend:  env.GetString("BLOB_BACKEND", "local"),
+			localDir: env.GetString("BLOB_LOCAL_DIR", "data/blobs"),
+			s3: blob.S3Config{
+				Endpoint:  env.GetString("BLOB_S3_ENDPOINT", "http://localhost:9000"),
+				Region:    env.GetString("BLOB_S3_REGION", "us-east-1"),
+				Bucket:    env.GetString("BLOB_S3_BUCKET", "audio"),
+				AccessKey: env.GetString("BLOB_S3_ACCESS_KEY", ""),
+				SecretKey: env.GetString("BLOB_S3_SECRET_KEY", ""),
+				PathStyle: env.GetBool("BLOB_S3_PATH_STYLE", true),
+			},
+		},
+
+		upload: uploadConfig{
+			maxBytes: int64(env.GetInt("UPLOAD_MAX_BYTES", 2<<30)), // 2 GiB
+			allowedTypes: splitList(env.GetString("UPLOAD_ALLOWED_TYPES",
+				"audio/wav,audio/x-wav,audio/wave,audio/vnd.wave,audio/aiff,audio/x-aiff,audio/flac,audio/x-flac,"+
+					"audio/mpeg,audio/mp3,audio/ogg,application/ogg,audio/opus,audio/mp4,audio/x-m4a,audio/m4a")),
+			timeout:        time.Hour,
+			expiry:         24 * time.Hour,
+			gcInterval:     10 * time.Minute,
+			verifyLossless: env.GetBool("UPLOAD_VERIFY_LOSSLESS", true),
+		},
+
+		download: downloadConfig{
+			timeout: time.Hour,
+		},
+
+		stream: streamConfig{
+			secret:  env.GetString("STREAM_URL_SECRET", "example"),
+			urlTTL:  6 * time.Hour,
+			timeout: 0, // players pause and read at playback speed
+		},
+
+		hls: hlsConfig{
+			keySecret:       env.GetString("HLS_KEY_SECRET", "example"),
+			segmentDuration: 6 * time.Second,
+		},
+
+		cors: corsConfig{
+			origins: splitList(env.GetString("CORS_ORIGINS", "*")),
+		},
+
+		artwork: artworkConfig{
+			maxBytes: int64(env.GetInt("ARTWORK_MAX_BYTES", 20<<20)), // 20 MiB
+			sizes:    splitInts(env.GetString("ARTWORK_SIZES", "64,300,1200")),
+		},
+
+		transcode: transcodeConfig{
+			commands:   map[string]transcode.Command{},
+			workers:    env.GetInt("TRANSCODE_WORKERS", 2),
+			attempts:   env.GetInt("TRANSCODE_ATTEMPTS", 4),
+			backoff:    10 * time.Second,
+			maxBackoff: 5 * time.Minute,
+		},
+
+		waveform: waveformConfig{
+			levels: splitInts(env.GetString("WAVEFORM_LEVELS", "256,1024,4096")),
+			bits:   env.GetInt("WAVEFORM_BITS", 8),
+		},
+
+		jobs: jobsConfig{
+			workers:      env.GetInt("JOBS_WORKERS", 2),
+			pollInterval: time.Second,
+			lease:        time.Minute,
+			drainTimeout: 30 * time.Second,
+		},
 	}
 
 	// Logger
 	logger := zap.Must(zap.NewProduction()).Sugar()
 	defer logger.Sync()
 
-	db, err := db.New(
+	// Rendition ladder; lossy formats need an encoder program, e.g.
+	// TRANSCODE_MP3_COMMAND="ffmpeg -v error -i pipe:0 -b:a {bitrate} -f mp3 pipe:1"
+	ladder, err := transcode.ParseLadder(env.GetString("TRANSCODE_LADDER",
+		"flac_16_44=flac/16bit/44.1khz,wav_24_48=wav/24bit/48khz,mp3_320=mp3/320kbps/44.1khz,aac_128=aac/128kbps/44.1khz"))
+	if err != nil {
+		logger.Fatal(err)
+	}
+	cfg.transcode.ladder = ladder
+	for _, format := range []string{"mp3", "aac", "opus"} {
+		line := env.GetString("TRANSCODE_"+strings.ToUpper(format)+"_COMMAND", "")
+		if line == "" {
+			continue
+		}
+		cmd, err := transcode.ParseCommand(line)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		cfg.transcode.commands[format] = cmd
+	}
+
+	primary, err := db.New(
 		cfg.db.addr,
 		cfg.db.maxOpenConns,
 		cfg.db.maxIdleConns,
@@ -54,8 +169,21 @@ func main() {
 		logger.Fatal(err)
 	}
 
-	defer db.Close()
-	logger.Info("database connection pool established")
+	// Read replicas (optional); reads fall back to the primary when none are usable
+	cluster, err := db.NewCluster(primary, db.ReplicaConfig{
+		Addrs:         cfg.db.replicas.addrs,
+		MaxOpenConns:  cfg.db.maxOpenConns,
+		MaxIdleConns:  cfg.db.maxIdleConns,
+		MaxIdleTime:   cfg.db.maxIdleTime,
+		MaxLag:        cfg.db.replicas.maxLag,
+		CheckInterval: cfg.db.replicas.checkInterval,
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	defer cluster.Close()
+	logger.Infow("database connection pool established", "replicas", len(cfg.db.replicas.addrs))
 
 	//Auth
 	jwtAuthenticator := auth.NewJWTAuthenticator(
@@ -64,19 +192,101 @@ func main() {
 		cfg.auth.token.iss,
 	)
 
-	store := store.NewStorage(db )
+	store := store.NewStorage(cluster, jwtAuthenticator)
+
+	// Domain events: the relay moves outbox rows to the in-process bus and any configured sinks
+	bus := events.NewBus()
+	sinks := []events.Sink{bus}
+	for _, url := range cfg.events.webhookURLs {
+		sinks = append(sinks, events.NewWebhookSink(url, cfg.events.webhookSecret))
+	}
+	if cfg.events.natsURL != "" {
+		nats, err := events.NewNATSSink(cfg.events.natsURL, cfg.events.natsSubject)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer nats.Close()
+		sinks = append(sinks, nats)
+	}
+
+	relay := events.NewRelay(store.Outbox, sinks, events.RelayConfig{PollInterval: cfg.events.pollInterval, MaxAttempts: cfg.events.maxAttempts}, logger)
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	go relay.Run(relayCtx)
+
+	// Blob storage for uploaded audio
+	var blobs blob.Store
+	switch cfg.blob.backend {
+	case "s3":
+		blobs, err = blob.NewS3Store(cfg.blob.s3)
+	default:
+		blobs, err = blob.NewLocalStore(cfg.blob.localDir)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
 
 	app := &application{
 		config:        cfg,
 		store:         store,
 		authenticator: jwtAuthenticator,
-		logger: logger,
+		blobs:         blobs,
+		db:            cluster,
+		events:        bus,
+		transcoder:    transcode.New(),
+		logger:        logger,
+	}
+	for format, cmd := range cfg.transcode.commands {
+		app.transcoder.Register(format, cmd)
 	}
 	mux := app.mount()
 
+	// Abandoned resumable uploads are collected in the background
+	go app.runUploadGC(relayCtx, cfg.upload.gcInterval)
+
+	// Background job pools; application.run drains them on shutdown
+	transcodePool := jobs.NewPool(store.Jobs, jobs.PoolConfig{
+		Workers:      cfg.transcode.workers,
+		PollInterval: cfg.jobs.pollInterval,
+		Lease:        cfg.jobs.lease,
+	}, logger, app.transcodeHandler())
+	ingestPool := jobs.NewPool(store.Jobs, jobs.PoolConfig{
+		Workers:      cfg.jobs.workers,
+		PollInterval: cfg.jobs.pollInterval,
+		Lease:        cfg.jobs.lease,
+	}, logger, app.analyzeHandler(), app.albumLoudnessHandler())
+	app.workers = append(app.workers, transcodePool, ingestPool)
+	for _, pool := range app.workers {
+		pool.Start()
+	}
+
 	err = app.run(mux)
+	stopRelay()
 
 	if err != nil {
 		logger.Fatal(err)
 	}
 }
+
+// splitList splits a comma separated env value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// splitInts parses a comma separated list of positive integers, skipping
+// anything else
+func splitInts(s string) []int {
+	var out []int
+	for _, part := range splitList(s) {
+		if n, err := strconv.Atoi(part); err == nil && n > 0 {
+			out = append(out, n)
+		}
+	}
+	return out
+}
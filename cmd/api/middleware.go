@@ -1,10 +1,12 @@
 package main
 
 import (
-	"audio-go/internal/auth"
+	"audio-go/internal/db"
 	"context"
 	"fmt"
 	"net/http"
+	"slices"
+	"time"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -15,7 +17,7 @@ const userContextKey contextKey = "user"
 
 // AuthMiddleware validates JWT tokens for protected routes
 // Bu fonksiyonun alıcı olarak *application türünü kullanıyoruz
-func (app *application) AuthMiddleware(jwtAuth *auth.JWTAuthenticator, next http.Handler) http.Handler {
+func (app *application) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the Authorization header
 		authHeader := r.Header.Get("Authorization")
@@ -33,7 +35,7 @@ func (app *application) AuthMiddleware(jwtAuth *auth.JWTAuthenticator, next http
 		}
 
 		// Validate the token
-		parsedToken, err := jwtAuth.ValidateToken(token)
+		parsedToken, err := app.authenticator.ValidateToken(token)
 		if err != nil || !parsedToken.Valid {
 			app.unauthorizedResponse(w, r, fmt.Errorf("invalid token"))
 			return
@@ -60,3 +62,79 @@ func (app *application) AuthMiddleware(jwtAuth *auth.JWTAuthenticator, next http
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// getUserIDFromContext returns the authenticated user's id set by AuthMiddleware
+func getUserIDFromContext(r *http.Request) (int64, bool) {
+	userID, ok := r.Context().Value(userContextKey).(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(userID), true
+}
+
+// dbSessionMiddleware gives every request its own read-your-writes session:
+// once a handler writes to the primary, its later reads skip the replicas
+func (app *application) dbSessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(db.WithSession(r.Context())))
+	})
+}
+
+// withDeadlines replaces the server-wide read/write timeouts for routes that
+// move large bodies (uploads, streams). A zero duration removes the deadline.
+func (app *application) withDeadlines(read, write time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc := http.NewResponseController(w)
+
+			var readDeadline, writeDeadline time.Time
+			if read > 0 {
+				readDeadline = time.Now().Add(read)
+			}
+			if write > 0 {
+				writeDeadline = time.Now().Add(write)
+			}
+
+			if err := rc.SetReadDeadline(readDeadline); err != nil {
+				app.logger.Warnw("cannot extend read deadline", "path", r.URL.Path, "error", err)
+			}
+			if err := rc.SetWriteDeadline(writeDeadline); err != nil {
+				app.logger.Warnw("cannot extend write deadline", "path", r.URL.Path, "error", err)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsMiddleware lets browser players on the configured origins read the
+// responses. Preflight requests are answered here, before authentication,
+// since browsers send them without credentials.
+func (app *application) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		origins := app.config.cors.origins
+		if origin == "" || !(slices.Contains(origins, "*") || slices.Contains(origins, origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Range")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, ETag, "+gainHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// preflightHandler answers the OPTIONS requests corsMiddleware passes on
+func preflightHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", "GET, OPTIONS")
+	w.WriteHeader(http.StatusNoContent)
+}
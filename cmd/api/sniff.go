@@ -0,0 +1,77 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+var errContentMismatch = errors.New("upload content does not match its declared type")
+
+// sniffedFormats lists the track formats each sniffed format may be declared as
+var sniffedFormats = map[string][]string{
+	"wav":  {"wav"},
+	"aiff": {"aiff"},
+	"flac": {"flac"},
+	"mp3":  {"mp3"},
+	"id3":  {"mp3", "flac"}, // the tag hides what follows
+	"ogg":  {"ogg"},
+	"opus": {"opus", "ogg"},
+	"mp4":  {"m4a"},
+}
+
+// extensionFormats lists the track formats each file name extension may be declared as
+var extensionFormats = map[string][]string{
+	".wav":  {"wav"},
+	".wave": {"wav"},
+	".bwf":  {"wav"},
+	".rf64": {"wav"},
+	".aif":  {"aiff"},
+	".aiff": {"aiff"},
+	".aifc": {"aiff"},
+	".flac": {"flac"},
+	".mp3":  {"mp3"},
+	".ogg":  {"ogg", "opus"},
+	".oga":  {"ogg", "opus"},
+	".opus": {"opus", "ogg"},
+	".m4a":  {"m4a"},
+	".m4b":  {"m4a"},
+	".mp4":  {"m4a"},
+}
+
+// peekUpload buffers the first bytes of an upload so they can be sniffed
+// before anything is stored. The returned reader still yields every byte.
+func peekUpload(body io.Reader) (io.Reader, []byte) {
+	br := bufio.NewReaderSize(body, audio.SniffLen)
+	// A short or failed read leaves less to look at; the error resurfaces
+	// when the body is read for real
+	head, _ := br.Peek(audio.SniffLen)
+	return br, head
+}
+
+// checkUploadContent rejects an upload whose first bytes are not the
+// declared format, or whose file name (if any) has an extension that does
+// not fit it
+func checkUploadContent(head []byte, format, filename string) error {
+	kind := audio.Sniff(head)
+	if !kind.IsAudio() {
+		return fmt.Errorf("%w: content is %s, not audio", errContentMismatch, kind.MIME)
+	}
+	if !slices.Contains(sniffedFormats[kind.Format], format) {
+		return fmt.Errorf("%w: content is %s, declared as %s", errContentMismatch, kind.MIME, format)
+	}
+
+	if filename == "" {
+		return nil
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	if !slices.Contains(extensionFormats[ext], format) {
+		return fmt.Errorf("%w: file name %q does not fit %s", errContentMismatch, path.Base(filename), format)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxStreamRanges bounds the parts of a multipart/byteranges response;
+// requests for more are answered with the whole file
+const maxStreamRanges = 16
+
+var (
+	errRangeInvalid       = errors.New("invalid range")
+	errRangeUnsatisfiable = errors.New("no requested range overlaps the file")
+	errInvalidSignature   = errors.New("invalid or expired signature")
+)
+
+// httpRange is a resolved byte range of the file
+type httpRange struct {
+	start, length int64
+}
+
+func (hr httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", hr.start, hr.start+hr.length-1, size)
+}
+
+// streamTrackHandler serves the uploaded original for playback. It answers
+// byte range requests with 206, a single range as is and several as
+// multipart/byteranges. If-Range falls back to the whole file once the audio
+// has been replaced.
+func (app *application) streamTrackHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	if track.AudioKey == "" {
+		app.notFoundResponse(w, r, errNoAudio)
+		return
+	}
+	size := track.Size
+	tag := streamETag(track.Checksum, track.Version)
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("ETag", tag)
+	h.Set("Cache-Control", "private")
+	setGainHeaders(h, track)
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimPrefix(strings.TrimSpace(t), "W/"); t == tag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	var ranges []httpRange
+	if rh := r.Header.Get("Range"); rh != "" && ifRangeMatches(r, tag) {
+		var err error
+		ranges, err = parseRange(rh, size)
+		switch {
+		case errors.Is(err, errRangeUnsatisfiable):
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			app.rangeNotSatisfiableResponse(w, r, err)
+			return
+		case err != nil:
+			// A Range header we can't make sense of is ignored
+			ranges = nil
+		}
+	}
+
+	contentType := audioContentType(track.Format)
+	ctx := r.Context()
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			app.streamRange(ctx, w, track.ID, track.AudioKey, httpRange{0, size})
+		}
+
+	case 1:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method != http.MethodHead {
+			app.streamRange(ctx, w, track.ID, track.AudioKey, ranges[0])
+		}
+
+	default:
+		// The length is that of a dry run of the same parts with the same boundary
+		var cw countingWriter
+		mw := multipart.NewWriter(&cw)
+		for _, hr := range ranges {
+			if _, err := mw.CreatePart(rangePartHeader(contentType, hr, size)); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			cw.n += hr.length
+		}
+		mw.Close()
+
+		h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		h.Set("Content-Length", strconv.FormatInt(cw.n, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		boundary := mw.Boundary()
+		mw = multipart.NewWriter(w)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return
+		}
+		for _, hr := range ranges {
+			pw, err := mw.CreatePart(rangePartHeader(contentType, hr, size))
+			if err != nil || !app.streamRange(ctx, pw, track.ID, track.AudioKey, hr) {
+				return
+			}
+		}
+		mw.Close()
+	}
+}
+
+// streamRange copies a range of the stored original to w. The status has
+// been sent, so failures can only be logged; it reports whether the whole
+// range was written.
+func (app *application) streamRange(ctx context.Context, w io.Writer, trackID int64, key string, hr httpRange) bool {
+	rc, err := app.blobs.GetRange(ctx, key, hr.start, hr.length)
+	if err != nil {
+		app.logger.Errorw("stream aborted", "track_id", trackID, "error", err)
+		return false
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		if ctx.Err() == nil {
+			app.logger.Warnw("stream aborted", "track_id", trackID, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+func rangePartHeader(contentType string, hr httpRange, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {hr.contentRange(size)},
+	}
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// parseRange resolves a "bytes=" Range header against a file of size bytes.
+// Ranges past the end are dropped; errRangeUnsatisfiable is returned when
+// none remain. Requests for many or overlapping ranges that add up to more
+// than the file yield no ranges, and the whole file is sent.
+func parseRange(s string, size int64) ([]httpRange, error) {
+	unit, set, ok := strings.Cut(s, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errRangeInvalid
+	}
+
+	var ranges []httpRange
+	var skipped bool
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errRangeInvalid
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// Suffix range, the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errRangeInvalid
+			}
+			if n == 0 || size == 0 {
+				skipped = true
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, httpRange{size - n, n})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, errRangeInvalid
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return nil, errRangeInvalid
+			}
+		}
+		if start >= size {
+			skipped = true
+			continue
+		}
+		end = min(end, size-1)
+		ranges = append(ranges, httpRange{start, end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		if skipped {
+			return nil, errRangeUnsatisfiable
+		}
+		return nil, errRangeInvalid
+	}
+	var total int64
+	for _, hr := range ranges {
+		total += hr.length
+	}
+	if len(ranges) > maxStreamRanges || total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the ranges of a request may be served: there
+// is no If-Range, or it carries the current entity tag. Dates are not strong
+// validators for the stream, so they always fall back to the whole file.
+func ifRangeMatches(r *http.Request, tag string) bool {
+	ir := strings.TrimSpace(r.Header.Get("If-Range"))
+	return ir == "" || ir == tag
+}
+
+// streamETag identifies the stored audio itself, so editing the catalog
+// doesn't invalidate players' caches. Tracks without a checksum fall back
+// to the version.
+func streamETag(checksum string, version int64) string {
+	if len(checksum) >= 32 {
+		return `"` + checksum[:32] + `"`
+	}
+	return etag(version)
+}
+
+// streamURLHandler issues a signed stream URL for players that cannot send
+// an Authorization header, such as <audio> elements. The URL carries the
+// signer's identity for this track until it expires.
+func (app *application) streamURLHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	userID, _ := getUserIDFromContext(r)
+	expires := time.Now().Add(app.config.stream.urlTTL).Truncate(time.Second)
+
+	q := url.Values{}
+	q.Set("uid", strconv.FormatInt(userID, 10))
+	q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", app.signTrackURL(track.ID, userID, expires.Unix()))
+
+	resp := struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{
+		URL:       fmt.Sprintf("/v1/tracks/%d/stream?%s", track.ID, q.Encode()),
+		ExpiresAt: expires,
+	}
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// signTrackURL signs a user's access to a track until a unix time
+func (app *application) signTrackURL(trackID, userID, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(app.config.stream.secret))
+	fmt.Fprintf(mac, "track:%d:%d:%d", trackID, userID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLMiddleware authenticates requests for a {trackID} route with
+// either a bearer token or the uid/exp/sig query of a signed URL, which
+// stands in for the signer's token
+func (app *application) signedURLMiddleware(next http.Handler) http.Handler {
+	authenticated := app.AuthMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("sig") == "" {
+			authenticated.ServeHTTP(w, r)
+			return
+		}
+
+		trackID, err1 := strconv.ParseInt(chi.URLParam(r, "trackID"), 10, 64)
+		userID, err2 := strconv.ParseInt(q.Get("uid"), 10, 64)
+		expires, err3 := strconv.ParseInt(q.Get("exp"), 10, 64)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			app.unauthorizedResponse(w, r, err)
+			return
+		}
+		sig := app.signTrackURL(trackID, userID, expires)
+		if !hmac.Equal([]byte(q.Get("sig")), []byte(sig)) || time.Now().Unix() > expires {
+			app.unauthorizedResponse(w, r, errInvalidSignature)
+			return
+		}
+
+		// Same shape as the claim AuthMiddleware stores
+		ctx := context.WithValue(r.Context(), userContextKey, float64(userID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
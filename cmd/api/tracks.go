@@ -0,0 +1,305 @@
+package main
+
+import (
+	"audio-go/internal/db"
+	"audio-go/internal/pagination"
+	"audio-go/internal/store"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const trackContextKey contextKey = "track"
+
+// CreateTrackRequest represents the expected payload for creating a track.
+// Technical fields are optional: they are normally filled in from the audio at ingest.
+type CreateTrackRequest struct {
+	Title      string `json:"title" validate:"required,max=255"`
+	Artist     string `json:"artist" validate:"required,max=255"`
+	DurationMs int64  `json:"duration_ms" validate:"gte=0"`
+	Format     string `json:"format" validate:"omitempty,oneof=wav aiff flac mp3 ogg opus m4a"`
+	SampleRate int    `json:"sample_rate" validate:"omitempty,gte=8000,lte=768000"`
+	Channels   int    `json:"channels" validate:"omitempty,gte=1,lte=32"`
+	Bitrate    int    `json:"bitrate" validate:"gte=0"`
+	Visibility string `json:"visibility" validate:"omitempty,oneof=private unlisted public"`
+}
+
+// UpdateTrackRequest represents the expected payload for updating a track
+type UpdateTrackRequest struct {
+	Title      *string `json:"title" validate:"omitempty,min=1,max=255"`
+	Artist     *string `json:"artist" validate:"omitempty,min=1,max=255"`
+	Visibility *string `json:"visibility" validate:"omitempty,oneof=private unlisted public"`
+}
+
+// createTrackHandler creates a track owned by the authenticated user
+func (app *application) createTrackHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		app.unauthorizedResponse(w, r, errors.New("missing user in context"))
+		return
+	}
+
+	var req CreateTrackRequest
+	if err := readJSON(w, r, &req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	track := &store.Track{
+		OwnerID:    userID,
+		Title:      req.Title,
+		Artist:     req.Artist,
+		DurationMs: req.DurationMs,
+		Format:     req.Format,
+		SampleRate: req.SampleRate,
+		Channels:   req.Channels,
+		Bitrate:    req.Bitrate,
+		Visibility: req.Visibility,
+	}
+	if track.Visibility == "" {
+		track.Visibility = store.VisibilityPrivate
+	}
+
+	if err := app.store.Tracks.Create(r.Context(), track); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusCreated, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getTrackHandler returns a single track
+func (app *application) getTrackHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+
+	if notModified(w, r, track.Version) {
+		return
+	}
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusOK, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// updateTrackHandler updates a track's editable metadata (If-Match required)
+func (app *application) updateTrackHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+
+	version, ok := app.checkIfMatch(w, r, track.Version)
+	if !ok {
+		return
+	}
+
+	var req UpdateTrackRequest
+	if err := readJSON(w, r, &req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if req.Title != nil {
+		track.Title = *req.Title
+	}
+	if req.Artist != nil {
+		track.Artist = *req.Artist
+	}
+	if req.Visibility != nil {
+		track.Visibility = *req.Visibility
+	}
+	track.Version = version
+
+	if err := app.store.Tracks.Update(r.Context(), track); err != nil {
+		switch {
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusOK, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// deleteTrackHandler deletes a track. If-Match is honoured when present. Its
+// album, if it was measured with it, is measured again.
+func (app *application) deleteTrackHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+
+	var version int64
+	if r.Header.Get("If-Match") != "" {
+		v, ok := app.checkIfMatch(w, r, track.Version)
+		if !ok {
+			return
+		}
+		version = v
+	}
+
+	if err := app.store.Tracks.Delete(r.Context(), track.ID, version); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+	if a, ok := albumOf(track); ok && track.Loudness != nil {
+		app.enqueueAlbumLoudness(r.Context(), a)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listTracksHandler lists the tracks of one owner (?owner_id=, defaults to the
+// authenticated user). Other users only see the owner's public tracks.
+func (app *application) listTracksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		app.unauthorizedResponse(w, r, errors.New("missing user in context"))
+		return
+	}
+
+	ownerID := userID
+	if v := r.URL.Query().Get("owner_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid owner_id"))
+			return
+		}
+		ownerID = id
+	}
+
+	req, err := app.trackPaginator().Parse(r.URL.Query())
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tracks, err := app.store.Tracks.ListByOwner(r.Context(), ownerID, ownerID != userID, req)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	page, err := pagination.NewPage(app.trackPaginator(), req, tracks, trackKey(req.Field))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, page); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// trackPaginator whitelists the sorts and filters of the track list
+func (app *application) trackPaginator() *pagination.Paginator {
+	return app.paginator(
+		map[string]string{
+			"created_at":  "created_at",
+			"title":       "title",
+			"duration_ms": "duration_ms",
+		},
+		"-created_at",
+		map[string]pagination.Filter{
+			"format":      {Column: "format", Ops: []pagination.Operator{pagination.OpEq, pagination.OpIn}},
+			"visibility":  {Column: "visibility", Ops: []pagination.Operator{pagination.OpEq}},
+			"title":       {Column: "title", Ops: []pagination.Operator{pagination.OpEq, pagination.OpContains}},
+			"artist":      {Column: "artist", Ops: []pagination.Operator{pagination.OpEq, pagination.OpContains}},
+			"duration_ms": {Column: "duration_ms", Type: pagination.Int, Ops: []pagination.Operator{pagination.OpGte, pagination.OpLte}},
+		},
+	)
+}
+
+// trackKey returns the cursor key extractor for the given sort field
+func trackKey(field string) func(*store.Track) pagination.Key {
+	return func(t *store.Track) pagination.Key {
+		switch field {
+		case "title":
+			return pagination.Key{Value: t.Title, ID: t.ID}
+		case "duration_ms":
+			return pagination.Key{Value: t.DurationMs, ID: t.ID}
+		default:
+			return pagination.Key{Value: t.CreatedAt, ID: t.ID}
+		}
+	}
+}
+
+// tracksContextMiddleware loads the {trackID} track into the request context,
+// hiding tracks the user may not see
+func (app *application) tracksContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "trackID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid track id"))
+			return
+		}
+
+		// Writes check If-Match against this read, which a lagging replica
+		// would answer with a version that already moved
+		ctx := r.Context()
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			ctx = db.WithPrimary(ctx)
+		}
+
+		track, err := app.store.Tracks.GetByID(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		// Private tracks of other users are reported as missing, not forbidden
+		userID, _ := getUserIDFromContext(r)
+		if !track.VisibleTo(userID) {
+			app.notFoundResponse(w, r, store.ErrNotFound)
+			return
+		}
+
+		ctx = context.WithValue(ctx, trackContextKey, track)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireTrackOwner only lets the track's owner through
+func (app *application) requireTrackOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := getUserIDFromContext(r)
+		if getTrackFromContext(r).OwnerID != userID {
+			app.forbiddenResponse(w, r, errors.New("not the track owner"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getTrackFromContext(r *http.Request) *store.Track {
+	track, _ := r.Context().Value(trackContextKey).(*store.Track)
+	return track
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/db"
+	"audio-go/internal/jobs"
+	"audio-go/internal/pcm"
+	"audio-go/internal/store"
+	"audio-go/internal/transcode"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errLossyMaster       = errors.New("lossless rendition of a lossy master")
+	errRenditionNotFound = errors.New("rendition not found")
+)
+
+// renditionPrefix is where the renditions of a track's current audio are stored
+func renditionPrefix(track *store.Track) string {
+	return fmt.Sprintf("tracks/%d/renditions/%s/", track.ID, audioID(track))
+}
+
+func renditionKey(track *store.Track, spec transcode.Spec) string {
+	return renditionPrefix(track) + spec.Name + "." + spec.Ext()
+}
+
+// ladderSpec returns the rendition of the configured ladder with the given name
+func (app *application) ladderSpec(name string) (transcode.Spec, bool) {
+	for _, spec := range app.config.transcode.ladder {
+		if spec.Name == name {
+			return spec, true
+		}
+	}
+	return transcode.Spec{}, false
+}
+
+// pendingRenditions lists every rendition of the ladder as still to be made
+func (app *application) pendingRenditions() map[string]*store.Rendition {
+	renditions := make(map[string]*store.Rendition, len(app.config.transcode.ladder))
+	for _, spec := range app.config.transcode.ladder {
+		renditions[spec.Name] = newRendition(spec, store.RenditionPending)
+	}
+	return renditions
+}
+
+func newRendition(spec transcode.Spec, status string) *store.Rendition {
+	return &store.Rendition{
+		Format:     spec.Format,
+		SampleRate: spec.SampleRate,
+		BitDepth:   spec.BitDepth,
+		Channels:   spec.Channels,
+		Bitrate:    spec.Bitrate,
+		Status:     status,
+		UpdatedAt:  time.Now(),
+	}
+}
+
+// transcodeJob makes the pending renditions of a track
+const transcodeJob jobs.Kind[trackPayload] = "transcode_track"
+
+// trackPayload is the payload of jobs about one track
+type trackPayload struct {
+	TrackID int64 `json:"track_id"`
+}
+
+// enqueueTranscode queues a job for a track with pending renditions. If
+// that fails the renditions stay pending until the next upload.
+func (app *application) enqueueTranscode(ctx context.Context, track *store.Track) {
+	if len(track.Renditions) == 0 {
+		return
+	}
+	if _, err := transcodeJob.Enqueue(ctx, app.store.Jobs, trackPayload{TrackID: track.ID}, jobs.Options{}); err != nil {
+		app.logger.Errorw("queueing transcode failed", "track_id", track.ID, "error", err)
+	}
+}
+
+// transcodeHandler runs transcode jobs
+func (app *application) transcodeHandler() jobs.Handler {
+	return transcodeJob.Handle(func(ctx context.Context, job *jobs.Job, p trackPayload) error {
+		return app.transcodeTrack(ctx, p.TrackID)
+	})
+}
+
+// transcodeTrack makes the track's pending renditions one after another,
+// stopping early once its audio is replaced or it is deleted. Renditions
+// left processing by a job that lost its worker are made again.
+func (app *application) transcodeTrack(ctx context.Context, trackID int64) error {
+	// The upload that queued the track may not have reached the replicas yet
+	ctx = db.WithPrimary(ctx)
+
+	track, err := app.store.Tracks.GetByID(ctx, trackID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for _, spec := range app.config.transcode.ladder {
+		r := track.Renditions[spec.Name]
+		if r == nil || r.Status != store.RenditionPending && r.Status != store.RenditionProcessing {
+			continue
+		}
+		err := app.makeRendition(ctx, track, spec)
+		switch {
+		case errors.Is(err, store.ErrVersionConflict), errors.Is(err, store.ErrNotFound):
+			return nil
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
+
+// makeRendition encodes one rendition, retrying with backoff, and records
+// its progress and outcome on the track. The error is that of saving the
+// status, not of the encoding.
+func (app *application) makeRendition(ctx context.Context, track *store.Track, spec transcode.Spec) error {
+	r := newRendition(spec, store.RenditionProcessing)
+	if err := app.setRendition(ctx, track, spec.Name, r); err != nil {
+		return err
+	}
+
+	var size int64
+	backoff := transcode.Backoff{
+		Attempts: app.config.transcode.attempts,
+		Base:     app.config.transcode.backoff,
+		Max:      app.config.transcode.maxBackoff,
+	}
+	err := backoff.Retry(ctx, func(attempt int) error {
+		r.Attempts = attempt
+		n, err := app.encodeRendition(ctx, track, spec)
+		if err == nil {
+			size = n
+			return nil
+		}
+		if transcode.IsPermanent(err) || attempt >= backoff.Attempts || ctx.Err() != nil {
+			return err
+		}
+
+		app.logger.Warnw("rendition attempt failed", "track_id", track.ID, "rendition", spec.Name, "attempt", attempt, "error", err)
+		r.Error = err.Error()
+		if err := app.setRendition(ctx, track, spec.Name, r); err != nil {
+			return transcode.Permanent(err)
+		}
+		return err
+	})
+
+	switch {
+	case err == nil:
+		r.Status, r.Error, r.Size = store.RenditionReady, "", size
+	case errors.Is(err, store.ErrVersionConflict), errors.Is(err, store.ErrNotFound):
+		return err
+	case ctx.Err() != nil:
+		// Shutting down: the job is queued again
+		r.Status = store.RenditionPending
+		ctx = context.WithoutCancel(ctx)
+	case errors.Is(err, errLossyMaster), errors.Is(err, transcode.ErrNoEncoder), errors.Is(err, pcm.ErrUnsupported),
+		errors.Is(err, audio.ErrUnknownFormat):
+		r.Status, r.Error = store.RenditionSkipped, err.Error()
+	default:
+		r.Status, r.Error = store.RenditionFailed, err.Error()
+		app.logger.Errorw("rendition failed", "track_id", track.ID, "rendition", spec.Name, "attempts", r.Attempts, "error", err)
+	}
+	return app.setRendition(ctx, track, spec.Name, r)
+}
+
+// encodeRendition decodes the track's original, encodes the rendition to a
+// temporary file and stores it, returning its size. Errors retrying
+// cannot fix are marked transcode.Permanent.
+func (app *application) encodeRendition(ctx context.Context, track *store.Track, spec transcode.Spec) (int64, error) {
+	if track.BitDepth == 0 && spec.Lossless() {
+		return 0, transcode.Permanent(errLossyMaster)
+	}
+
+	src := blob.NewReaderAt(ctx, app.blobs, track.AudioKey, track.Size)
+	info, err := audio.Probe(src, track.Size)
+	if err != nil {
+		var parseErr *audio.ParseError
+		if errors.Is(err, audio.ErrUnknownFormat) || errors.As(err, &parseErr) {
+			err = transcode.Permanent(err)
+		}
+		return 0, err
+	}
+	master, err := pcm.NewDecoder(src, info)
+	if err != nil {
+		return 0, transcode.Permanent(err)
+	}
+
+	f, err := os.CreateTemp("", "rendition-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := app.transcoder.Transcode(ctx, f, master, info.BitDepth, spec); err != nil {
+		return 0, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := app.blobs.Put(ctx, renditionKey(track, spec), f, size, spec.ContentType()); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// setRendition saves a rendition's state while the track's audio is unchanged
+func (app *application) setRendition(ctx context.Context, track *store.Track, name string, r *store.Rendition) error {
+	r.UpdatedAt = time.Now()
+	return app.store.Tracks.SetRendition(ctx, track.ID, track.Checksum, name, r)
+}
+
+// getRenditionHandler sends a ready rendition of the track's audio
+func (app *application) getRenditionHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	name := chi.URLParam(r, "name")
+
+	spec, ok := app.ladderSpec(name)
+	rendition := track.Renditions[name]
+	if !ok || rendition == nil {
+		app.notFoundResponse(w, r, errRenditionNotFound)
+		return
+	}
+	if rendition.Status != store.RenditionReady {
+		app.notFoundResponse(w, r, fmt.Errorf("rendition %q is %s", name, rendition.Status))
+		return
+	}
+
+	rc, err := app.blobs.Get(r.Context(), renditionKey(track, spec))
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			app.notFoundResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", spec.ContentType())
+	w.Header().Set("Content-Length", strconv.FormatInt(rendition.Size, 10))
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment", map[string]string{"filename": downloadFilename(track) + "." + spec.Ext()}))
+	setETag(w, track.Version)
+	setGainHeaders(w.Header(), track)
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, rc); err != nil && r.Context().Err() == nil {
+		app.logger.Warnw("rendition download aborted", "track_id", track.ID, "rendition", name, "error", err)
+	}
+}
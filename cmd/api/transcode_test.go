@@ -0,0 +1,303 @@
+package main
+
+import (
+	"audio-go/internal/blob"
+	"audio-go/internal/jobs"
+	"audio-go/internal/pagination"
+	"audio-go/internal/pcm"
+	"audio-go/internal/store"
+	"audio-go/internal/transcode"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errNotImplemented = errors.New("not implemented by memTracks")
+
+// memTracks keeps tracks in memory and records every rendition state saved
+type memTracks struct {
+	mu      sync.Mutex
+	tracks  map[int64]*store.Track
+	history map[string][]store.Rendition // by rendition name
+}
+
+func newMemTracks(tracks ...*store.Track) *memTracks {
+	m := &memTracks{tracks: map[int64]*store.Track{}, history: map[string][]store.Rendition{}}
+	for _, t := range tracks {
+		m.tracks[t.ID] = t
+	}
+	return m
+}
+
+func (m *memTracks) GetByID(ctx context.Context, id int64) (*store.Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tracks[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *t
+	copied.Renditions = map[string]*store.Rendition{}
+	for name, r := range t.Renditions {
+		r := *r
+		copied.Renditions[name] = &r
+	}
+	return &copied, nil
+}
+
+func (m *memTracks) SetRendition(ctx context.Context, id int64, checksum, name string, r *store.Rendition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tracks[id]
+	switch {
+	case !ok:
+		return store.ErrNotFound
+	case t.Checksum != checksum:
+		return store.ErrVersionConflict
+	}
+	saved := *r
+	if t.Renditions == nil {
+		t.Renditions = map[string]*store.Rendition{}
+	}
+	t.Renditions[name] = &saved
+	m.history[name] = append(m.history[name], saved)
+	return nil
+}
+
+// statuses lists the states a rendition went through
+func (m *memTracks) statuses(name string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var s []string
+	for _, r := range m.history[name] {
+		s = append(s, r.Status)
+	}
+	return s
+}
+
+func (m *memTracks) last(name string) store.Rendition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[name]
+	if len(h) == 0 {
+		return store.Rendition{}
+	}
+	return h[len(h)-1]
+}
+
+func (m *memTracks) Create(context.Context, *store.Track) error { return errNotImplemented }
+func (m *memTracks) Update(context.Context, *store.Track) error { return errNotImplemented }
+func (m *memTracks) Delete(context.Context, int64, int64) error { return errNotImplemented }
+func (m *memTracks) SetWaveform(context.Context, int64, string, *store.Waveform) error {
+	return errNotImplemented
+}
+func (m *memTracks) SetLoudness(context.Context, int64, string, *store.Loudness) error {
+	return errNotImplemented
+}
+func (m *memTracks) SetAlbumLoudness(context.Context, int64, string, *store.AlbumLoudness) error {
+	return errNotImplemented
+}
+func (m *memTracks) ListByOwner(context.Context, int64, bool, *pagination.Request) ([]*store.Track, error) {
+	return nil, errNotImplemented
+}
+func (m *memTracks) ListByAlbum(context.Context, int64, string, string, int) ([]*store.Track, error) {
+	return nil, errNotImplemented
+}
+
+// sineSource is a 1 kHz sine at half scale on every channel
+type sineSource struct {
+	format pcm.Format
+	pos    int64
+}
+
+func (s *sineSource) Format() pcm.Format { return s.format }
+
+func (s *sineSource) ReadFrames(buf []float32) (int, error) {
+	n := 0
+	for ; (n+1)*s.format.Channels <= len(buf) && s.pos < s.format.Frames; n++ {
+		v := float32(0.5 * math.Sin(2*math.Pi*1000*float64(s.pos)/float64(s.format.SampleRate)))
+		for c := 0; c < s.format.Channels; c++ {
+			buf[n*s.format.Channels+c] = v
+		}
+		s.pos++
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// newTranscodeApp returns an application with a 16-bit WAV track stored as
+// track 1 and the given encoder making mp3 renditions
+func newTranscodeApp(t *testing.T, encoder transcode.Encoder, ladder ...transcode.Spec) (*application, *memTracks, *store.Track) {
+	t.Helper()
+	blobs, err := blob.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wav bytes.Buffer
+	src := &sineSource{format: pcm.Format{SampleRate: 44100, Channels: 2, Frames: 4410}}
+	if _, err := pcm.EncodeWAV(&wav, src, pcm.WAVOptions{BitDepth: 16}); err != nil {
+		t.Fatal(err)
+	}
+	track := &store.Track{
+		ID:         1,
+		Format:     "wav",
+		SampleRate: 44100,
+		Channels:   2,
+		BitDepth:   16,
+		Size:       int64(wav.Len()),
+		Checksum:   "0123456789abcdef0123",
+		AudioKey:   "tracks/1/original",
+	}
+	if err := blobs.Put(context.Background(), track.AudioKey, &wav, track.Size, "audio/wav"); err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := newMemTracks(track)
+	app := &application{
+		config: config{transcode: transcodeConfig{
+			ladder:     ladder,
+			attempts:   3,
+			backoff:    time.Millisecond,
+			maxBackoff: 2 * time.Millisecond,
+		}},
+		store:      store.Storage{Tracks: tracks, Jobs: jobs.NewMemoryQueue()},
+		blobs:      blobs,
+		transcoder: transcode.New(),
+		logger:     zap.NewNop().Sugar(),
+	}
+	app.transcoder.Register("mp3", encoder)
+
+	saved, _ := tracks.GetByID(context.Background(), track.ID)
+	return app, tracks, saved
+}
+
+func TestMakeRendition(t *testing.T) {
+	mp3 := transcode.Spec{Name: "mp3_128", Format: "mp3", Bitrate: 128000}
+	tests := []struct {
+		name         string
+		spec         transcode.Spec
+		failures     int  // of the fake mp3 encoder
+		lossyMaster  bool // the track has no bit depth
+		wantStatuses []string
+		wantAttempts int
+		wantError    string // of the last state saved
+	}{
+		{"ready", mp3, 0, false, []string{"processing", "ready"}, 1, ""},
+		{"ready after a retry", mp3, 1, false, []string{"processing", "processing", "ready"}, 2, ""},
+		{"failed once out of attempts", mp3, 10, false, []string{"processing", "processing", "processing", "failed"}, 3, transcode.ErrFakeFailure.Error()},
+		{"lossless made by the pure Go encoder", transcode.Spec{Name: "flac", Format: "flac"}, 0, false, []string{"processing", "ready"}, 1, ""},
+		{"skipped lossless of a lossy master", transcode.Spec{Name: "flac", Format: "flac"}, 0, true, []string{"processing", "skipped"}, 1, errLossyMaster.Error()},
+		{"skipped without an encoder", transcode.Spec{Name: "opus", Format: "opus"}, 0, false, []string{"processing", "skipped"}, 1, transcode.ErrNoEncoder.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &transcode.FakeEncoder{Failures: tt.failures}
+			app, tracks, track := newTranscodeApp(t, fake, tt.spec)
+			if tt.lossyMaster {
+				track.BitDepth = 0
+			}
+
+			if err := app.makeRendition(context.Background(), track, tt.spec); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Join(tracks.statuses(tt.spec.Name), " "); got != strings.Join(tt.wantStatuses, " ") {
+				t.Fatalf("statuses = %s, want %s", got, strings.Join(tt.wantStatuses, " "))
+			}
+			r := tracks.last(tt.spec.Name)
+			if r.Attempts != tt.wantAttempts {
+				t.Fatalf("attempts = %d, want %d", r.Attempts, tt.wantAttempts)
+			}
+			if !strings.Contains(r.Error, tt.wantError) || (tt.wantError == "") != (r.Error == "") {
+				t.Fatalf("error = %q, want %q", r.Error, tt.wantError)
+			}
+
+			_, err := app.blobs.Stat(context.Background(), renditionKey(track, tt.spec))
+			if ready := r.Status == store.RenditionReady; ready != (err == nil) {
+				t.Fatalf("rendition stored: %v, status %s", err, r.Status)
+			}
+			if r.Status == store.RenditionReady && r.Size <= 0 {
+				t.Fatalf("ready rendition has size %d", r.Size)
+			}
+		})
+	}
+}
+
+func TestMakeRenditionShutdown(t *testing.T) {
+	spec := transcode.Spec{Name: "mp3_128", Format: "mp3", Bitrate: 128000}
+	app, tracks, track := newTranscodeApp(t, &transcode.FakeEncoder{Failures: 1}, spec)
+	app.config.transcode.backoff = time.Hour
+	app.config.transcode.maxBackoff = time.Hour
+
+	// The deadline passes while waiting to retry
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := app.makeRendition(ctx, track, spec); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tracks.statuses(spec.Name), " "); got != "processing processing pending" {
+		t.Fatalf("statuses = %s, want processing processing pending", got)
+	}
+}
+
+func TestMakeRenditionReplacedAudio(t *testing.T) {
+	spec := transcode.Spec{Name: "mp3_128", Format: "mp3", Bitrate: 128000}
+	fake := &transcode.FakeEncoder{}
+	app, tracks, track := newTranscodeApp(t, fake, spec)
+	track.Checksum = "replaced"
+
+	if err := app.makeRendition(context.Background(), track, spec); !errors.Is(err, store.ErrVersionConflict) {
+		t.Fatalf("makeRendition = %v, want ErrVersionConflict", err)
+	}
+	if fake.Calls() != 0 || len(tracks.statuses(spec.Name)) != 0 {
+		t.Fatalf("encoded %d times and saved %v for replaced audio", fake.Calls(), tracks.statuses(spec.Name))
+	}
+}
+
+func TestTranscodeJob(t *testing.T) {
+	mp3 := transcode.Spec{Name: "mp3_128", Format: "mp3", Bitrate: 128000}
+	wav := transcode.Spec{Name: "wav_16", Format: "wav", BitDepth: 16}
+	app, tracks, track := newTranscodeApp(t, &transcode.FakeEncoder{}, mp3, wav)
+	track.Renditions = app.pendingRenditions()
+	for name, r := range track.Renditions {
+		if err := tracks.SetRendition(context.Background(), track.ID, track.Checksum, name, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app.enqueueTranscode(context.Background(), track)
+	queue := app.store.Jobs.(*jobs.MemoryQueue)
+	if got := queue.Jobs(); len(got) != 1 || got[0].Kind != string(transcodeJob) {
+		t.Fatalf("queued %+v, want one %s job", got, transcodeJob)
+	}
+
+	pool := jobs.NewPool(queue, jobs.PoolConfig{PollInterval: time.Millisecond}, app.logger, app.transcodeHandler())
+	pool.Start()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(queue.Jobs()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if left := queue.Jobs(); len(left) != 0 {
+		t.Fatalf("jobs left: %+v", left)
+	}
+
+	for _, spec := range []transcode.Spec{mp3, wav} {
+		if got := strings.Join(tracks.statuses(spec.Name), " "); got != "pending processing ready" {
+			t.Fatalf("%s statuses = %s, want pending processing ready", spec.Name, got)
+		}
+	}
+}
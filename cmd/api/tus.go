@@ -0,0 +1,668 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/store"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// tus 1.0 resumable uploads: core protocol plus the creation, expiration,
+// checksum and termination extensions. Every PATCH is stored as its own
+// chunk object under uploads/<id>/; once the last byte arrives the chunks
+// are concatenated into the track's original and removed.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,checksum,termination"
+	tusChecksums  = "sha1,sha256,md5"
+
+	uploadContextKey contextKey = "upload"
+
+	// statusChecksumMismatch is the tus checksum extension's 460 status
+	statusChecksumMismatch = 460
+)
+
+var (
+	errUploadOffsetMismatch = errors.New("Upload-Offset does not match the current offset")
+	errUploadExpired        = errors.New("upload expired")
+	errUploadCompleted      = errors.New("upload already completed")
+	errEmptyUpload          = errors.New("an empty file is not audio")
+)
+
+// tusOptionsHandler advertises the server's tus capabilities
+func (app *application) tusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Tus-Version", tusVersion)
+	h.Set("Tus-Extension", tusExtensions)
+	h.Set("Tus-Max-Size", strconv.FormatInt(app.config.upload.maxBytes, 10))
+	h.Set("Tus-Checksum-Algorithm", tusChecksums)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createUploadHandler starts a resumable upload (creation extension).
+// Upload-Metadata may carry track_id to replace an existing track's audio,
+// otherwise a track is created on completion, titled from title/artist or
+// else the file's own tags.
+// filetype must be an allowed audio content type, and the first chunk must
+// look like it (as must the extension of filename, if given).
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		app.unauthorizedResponse(w, r, errors.New("missing user in context"))
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		app.badRequestResponse(w, r, errors.New("missing or invalid Upload-Length"))
+		return
+	}
+	// tus allows empty uploads, but no audio file is empty
+	if length == 0 {
+		app.unsupportedMediaTypeResponse(w, r, errEmptyUpload)
+		return
+	}
+	if length > app.config.upload.maxBytes {
+		app.payloadTooLargeResponse(w, r, fmt.Errorf("Upload-Length %d exceeds %d", length, app.config.upload.maxBytes))
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if _, err := app.allowedAudioFormat(metadata["filetype"]); err != nil {
+		app.unsupportedMediaTypeResponse(w, r, err)
+		return
+	}
+
+	upload := &store.Upload{
+		ID:        newUploadID(),
+		OwnerID:   userID,
+		Length:    length,
+		Metadata:  metadata,
+		ExpiresAt: time.Now().Add(app.config.upload.expiry),
+	}
+
+	if v, ok := metadata["track_id"]; ok {
+		trackID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid track_id metadata"))
+			return
+		}
+		track, err := app.store.Tracks.GetByID(r.Context(), trackID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		if track.OwnerID != userID {
+			app.forbiddenResponse(w, r, errors.New("not the track owner"))
+			return
+		}
+		upload.TrackID = &track.ID
+	}
+
+	if err := app.store.Uploads.Create(r.Context(), upload); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Location", "/v1/uploads/"+upload.ID)
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headUploadHandler reports how much of the upload the server has
+func (app *application) headUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload := getUploadFromContext(r)
+
+	h := w.Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Cache-Control", "no-store")
+	h.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	h.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if len(upload.Metadata) > 0 {
+		h.Set("Upload-Metadata", formatUploadMetadata(upload.Metadata))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUploadHandler appends a chunk at Upload-Offset. Without a checksum,
+// whatever arrived before a dropped connection is kept so the client can
+// resume from there; with Upload-Checksum the chunk is all or nothing.
+func (app *application) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload := getUploadFromContext(r)
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if ct := r.Header.Get("Content-Type"); ct != "application/offset+octet-stream" {
+		app.unsupportedMediaTypeResponse(w, r, fmt.Errorf("unexpected Content-Type %q", ct))
+		return
+	}
+	if upload.CompletedAt != nil {
+		app.conflictResponse(w, r, errUploadCompleted)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.badRequestResponse(w, r, errors.New("missing or invalid Upload-Offset"))
+		return
+	}
+	if offset != upload.Offset {
+		app.conflictResponse(w, r, errUploadOffsetMismatch)
+		return
+	}
+
+	var (
+		checksum hash.Hash
+		expected []byte
+	)
+	if v := r.Header.Get("Upload-Checksum"); v != "" {
+		checksum, expected, err = parseUploadChecksum(v)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	// Never accept more than the declared length
+	remaining := upload.Length - upload.Offset
+	body := io.Reader(http.MaxBytesReader(w, r.Body, remaining))
+	if checksum != nil {
+		body = io.TeeReader(body, checksum)
+	} else {
+		body = &partialReader{r: body}
+	}
+
+	// Keep going if the client disconnects: what arrived is still persisted
+	ctx := context.WithoutCancel(r.Context())
+
+	if offset == 0 {
+		// The first chunk carries the file's signature: check it before
+		// storing anything and drop the upload if it is not what was declared
+		var head []byte
+		body, head = peekUpload(body)
+		if len(head) > 0 {
+			format, _ := app.allowedAudioFormat(upload.Metadata["filetype"])
+			if err := checkUploadContent(head, format, upload.Metadata["filename"]); err != nil {
+				if err := app.removeUpload(ctx, upload); err != nil {
+					app.logger.Warnw("failed to remove rejected upload", "upload", upload.ID, "error", err)
+				}
+				app.unsupportedMediaTypeResponse(w, r, err)
+				return
+			}
+		}
+	}
+	counter := &countingReader{r: body}
+
+	key := chunkKey(upload.ID, offset)
+	if err := app.blobs.Put(ctx, key, counter, -1, "application/octet-stream"); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			app.payloadTooLargeResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if checksum != nil && !bytes.Equal(checksum.Sum(nil), expected) {
+		app.deleteBlob(ctx, key)
+		app.logger.Warnw("upload checksum mismatch", "upload", upload.ID, "offset", offset)
+		writeJSONError(w, statusChecksumMismatch, "checksum mismatch")
+		return
+	}
+
+	if counter.n == 0 {
+		// Nothing new; a retried final PATCH still gets to finalize below
+		app.deleteBlob(ctx, key)
+	} else {
+		newOffset := upload.Offset + counter.n
+		expiresAt := time.Now().Add(app.config.upload.expiry)
+		if err := app.store.Uploads.Advance(ctx, upload.ID, upload.Offset, newOffset, expiresAt); err != nil {
+			app.deleteBlob(ctx, key)
+			switch {
+			case errors.Is(err, store.ErrVersionConflict):
+				app.conflictResponse(w, r, errUploadOffsetMismatch)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		upload.Offset = newOffset
+		upload.ExpiresAt = expiresAt
+	}
+
+	if upload.Offset == upload.Length {
+		if err := app.finalizeUpload(ctx, upload); err != nil {
+			var parseErr *audio.ParseError
+			switch {
+			case errors.As(err, &parseErr):
+				app.badRequestResponse(w, r, err)
+			case errors.Is(err, store.ErrVersionConflict):
+				app.conflictResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUploadHandler terminates an upload (termination extension)
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	upload := getUploadFromContext(r)
+
+	if err := app.removeUpload(r.Context(), upload); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finalizeUpload concatenates the chunks into the track's original and
+// attaches it to the track, creating the track if the upload named none.
+// A track created here is deleted again when finalizing fails, since the
+// retried PATCH creates another. Audio that fails validation also
+// discards the upload.
+func (app *application) finalizeUpload(ctx context.Context, upload *store.Upload) (err error) {
+	contentType := upload.Metadata["filetype"]
+	format, err := app.allowedAudioFormat(contentType)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := app.uploadChunks(ctx, upload)
+	if err != nil {
+		return err
+	}
+
+	var track *store.Track
+	if upload.TrackID != nil {
+		if track, err = app.store.Tracks.GetByID(ctx, *upload.TrackID); err != nil {
+			return err
+		}
+	} else {
+		track = trackFromUploadMetadata(upload)
+		if err := app.store.Tracks.Create(ctx, track); err != nil {
+			return err
+		}
+		defer func() {
+			if err == nil {
+				return
+			}
+			if err := app.store.Tracks.Delete(ctx, track.ID, 0); err != nil {
+				app.logger.Warnw("failed to delete track of failed upload", "track", track.ID, "upload", upload.ID, "error", err)
+			}
+		}()
+	}
+
+	key := fmt.Sprintf("tracks/%d/original-%d", track.ID, time.Now().UnixNano())
+	hr := newHashingReader(&chunkReader{ctx: ctx, blobs: app.blobs, chunks: chunks})
+	if err := app.blobs.Put(ctx, key, hr, upload.Length, contentType); err != nil {
+		return err
+	}
+	if hr.n != upload.Length {
+		app.deleteBlob(ctx, key)
+		return fmt.Errorf("upload %s: assembled %d of %d bytes", upload.ID, hr.n, upload.Length)
+	}
+
+	if err := app.attachTrackAudio(ctx, track, key, format, hr.n, hr.Sum()); err != nil {
+		var parseErr *audio.ParseError
+		if errors.As(err, &parseErr) {
+			if err := app.removeUpload(ctx, upload); err != nil {
+				app.logger.Warnw("failed to remove rejected upload", "upload", upload.ID, "error", err)
+			}
+		}
+		return err
+	}
+	if err := app.store.Uploads.Complete(ctx, upload.ID, track.ID); err != nil {
+		return err
+	}
+	upload.TrackID = &track.ID
+
+	for _, c := range chunks {
+		app.deleteBlob(ctx, c.Key)
+	}
+	return nil
+}
+
+// uploadChunks returns the chunks that cover the upload from its first to
+// its last byte, in order, and deletes any others: chunks a PATCH stored
+// before it failed to advance the offset
+func (app *application) uploadChunks(ctx context.Context, upload *store.Upload) ([]blob.Info, error) {
+	all, err := app.blobs.List(ctx, "uploads/"+upload.ID+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	byOffset := map[int64][]blob.Info{}
+	for _, c := range all {
+		if offset, ok := chunkOffset(c.Key); ok && c.Size > 0 {
+			byOffset[offset] = append(byOffset[offset], c)
+		}
+	}
+	// Of chunks racing at one offset, the latest is most likely the one kept
+	for _, cs := range byOffset {
+		sort.Slice(cs, func(i, j int) bool { return cs[i].LastModified.After(cs[j].LastModified) })
+	}
+
+	// Depth first from offset 0, remembering offsets that lead nowhere
+	dead := map[int64]bool{}
+	var walk func(offset int64) ([]blob.Info, bool)
+	walk = func(offset int64) ([]blob.Info, bool) {
+		if offset == upload.Length {
+			return nil, true
+		}
+		if dead[offset] {
+			return nil, false
+		}
+		for _, c := range byOffset[offset] {
+			if offset+c.Size > upload.Length {
+				continue
+			}
+			if rest, ok := walk(offset + c.Size); ok {
+				return append([]blob.Info{c}, rest...), true
+			}
+		}
+		dead[offset] = true
+		return nil, false
+	}
+	chunks, ok := walk(0)
+	if !ok {
+		return nil, fmt.Errorf("upload %s: chunks don't cover %d bytes", upload.ID, upload.Length)
+	}
+
+	used := map[string]bool{}
+	for _, c := range chunks {
+		used[c.Key] = true
+	}
+	for _, c := range all {
+		if !used[c.Key] {
+			app.deleteBlob(ctx, c.Key)
+		}
+	}
+	return chunks, nil
+}
+
+// removeUpload deletes an upload's chunks and state
+func (app *application) removeUpload(ctx context.Context, upload *store.Upload) error {
+	chunks, err := app.blobs.List(ctx, "uploads/"+upload.ID+"/")
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := app.blobs.Delete(ctx, c.Key); err != nil {
+			return err
+		}
+	}
+	return app.store.Uploads.Delete(ctx, upload.ID)
+}
+
+// runUploadGC periodically removes expired uploads and their chunks until ctx is done
+func (app *application) runUploadGC(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := app.store.Uploads.ListExpired(ctx, time.Now(), 100)
+		if err != nil {
+			app.logger.Errorw("listing expired uploads failed", "error", err)
+			continue
+		}
+		for _, upload := range expired {
+			if err := app.removeUpload(ctx, upload); err != nil {
+				app.logger.Errorw("removing expired upload failed", "upload", upload.ID, "error", err)
+				continue
+			}
+			app.logger.Infow("expired upload removed", "upload", upload.ID, "offset", upload.Offset, "length", upload.Length)
+		}
+	}
+}
+
+// tusResumableMiddleware rejects requests for other tus protocol versions
+func (app *application) tusResumableMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			app.preconditionFailedResponse(w, r, fmt.Errorf("unsupported Tus-Resumable %q", r.Header.Get("Tus-Resumable")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uploadsContextMiddleware loads the {uploadID} upload owned by the current user
+func (app *application) uploadsContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := getUserIDFromContext(r)
+
+		upload, err := app.store.Uploads.GetByID(r.Context(), chi.URLParam(r, "uploadID"))
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		// Other users' uploads don't exist as far as this user is concerned
+		if upload.OwnerID != userID {
+			app.notFoundResponse(w, r, store.ErrNotFound)
+			return
+		}
+		if upload.CompletedAt == nil && time.Now().After(upload.ExpiresAt) {
+			w.Header().Set("Tus-Resumable", tusVersion)
+			writeJSONError(w, http.StatusGone, errUploadExpired.Error())
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), uploadContextKey, upload)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getUploadFromContext(r *http.Request) *store.Upload {
+	upload, _ := r.Context().Value(uploadContextKey).(*store.Upload)
+	return upload
+}
+
+// trackFromUploadMetadata builds a new private track from the upload's
+// metadata. Title and artist may be left empty for attachTrackAudio to fill.
+func trackFromUploadMetadata(upload *store.Upload) *store.Track {
+	return &store.Track{
+		OwnerID:    upload.OwnerID,
+		Title:      upload.Metadata["title"],
+		Artist:     upload.Metadata["artist"],
+		Visibility: store.VisibilityPrivate,
+	}
+}
+
+// chunkKey names a chunk by its zero-padded offset so keys sort in upload
+// order. The random suffix keeps racing PATCHes at the same offset apart.
+func chunkKey(uploadID string, offset int64) string {
+	return fmt.Sprintf("uploads/%s/chunk-%020d-%s", uploadID, offset, newUploadID()[:8])
+}
+
+// chunkOffset parses the offset back out of a chunk key
+func chunkOffset(key string) (int64, bool) {
+	name, ok := strings.CutPrefix(path.Base(key), "chunk-")
+	if !ok {
+		return 0, false
+	}
+	digits, _, _ := strings.Cut(name, "-")
+	offset, err := strconv.ParseInt(digits, 10, 64)
+	return offset, err == nil
+}
+
+func newUploadID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata, nil
+}
+
+func formatUploadMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// parseUploadChecksum parses "<algorithm> <base64 digest>"
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algo, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, nil, errors.New("invalid Upload-Checksum")
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("invalid Upload-Checksum digest")
+	}
+
+	switch algo {
+	case "sha1":
+		return sha1.New(), digest, nil
+	case "sha256":
+		return sha256.New(), digest, nil
+	case "md5":
+		return md5.New(), digest, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+}
+
+// partialReader ends the stream cleanly when the client goes away, so the
+// bytes received so far are kept. Size limit errors still propagate.
+type partialReader struct {
+	r io.Reader
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if !errors.As(err, &maxErr) {
+			return n, io.EOF
+		}
+	}
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// chunkReader reads a list of blobs back to back, opening one at a time
+type chunkReader struct {
+	ctx     context.Context
+	blobs   blob.Store
+	chunks  []blob.Info
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := c.blobs.Get(c.ctx, c.chunks[0].Key)
+			if err != nil {
+				return 0, err
+			}
+			c.current = rc
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
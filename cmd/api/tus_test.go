@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestCreateUploadLength(t *testing.T) {
+	app := &application{
+		config: config{upload: uploadConfig{maxBytes: 1 << 20}},
+		logger: zap.NewNop().Sugar(),
+	}
+	tests := []struct {
+		length string
+		want   int
+	}{
+		{"", http.StatusBadRequest},
+		{"abc", http.StatusBadRequest},
+		{"-1", http.StatusBadRequest},
+		{"0", http.StatusUnsupportedMediaType},
+		{"1048577", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/v1/uploads", nil)
+		r = r.WithContext(context.WithValue(r.Context(), userContextKey, float64(1)))
+		if tt.length != "" {
+			r.Header.Set("Upload-Length", tt.length)
+		}
+		w := httptest.NewRecorder()
+		app.createUploadHandler(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Upload-Length %q: status %d, want %d", tt.length, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/blob"
+	"audio-go/internal/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported audio content type")
+	errNoFilePart           = errors.New("multipart body has no file part")
+)
+
+// audioFormats maps accepted upload content types to track formats
+var audioFormats = map[string]string{
+	"audio/wav":       "wav",
+	"audio/x-wav":     "wav",
+	"audio/wave":      "wav",
+	"audio/vnd.wave":  "wav",
+	"audio/aiff":      "aiff",
+	"audio/x-aiff":    "aiff",
+	"audio/flac":      "flac",
+	"audio/x-flac":    "flac",
+	"audio/mpeg":      "mp3",
+	"audio/mp3":       "mp3",
+	"audio/ogg":       "ogg",
+	"application/ogg": "ogg",
+	"audio/opus":      "opus",
+	"audio/mp4":       "m4a",
+	"audio/x-m4a":     "m4a",
+	"audio/m4a":       "m4a",
+}
+
+// uploadTrackAudioHandler streams the track's audio to the blob store. The
+// body is either raw audio (Content-Type: audio/...) or multipart/form-data
+// with a "file" part. Nothing is buffered: size and SHA-256 are computed
+// while the bytes flow through to storage.
+func (app *application) uploadTrackAudioHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.upload.maxBytes)
+
+	body, contentType, filename, err := uploadBody(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	format, err := app.allowedAudioFormat(contentType)
+	if err != nil {
+		app.unsupportedMediaTypeResponse(w, r, err)
+		return
+	}
+
+	// Nothing is stored unless the first bytes agree with what was declared
+	content, head := peekUpload(body)
+	if err := checkUploadContent(head, format, filename); err != nil {
+		app.unsupportedMediaTypeResponse(w, r, err)
+		return
+	}
+
+	// A fresh key per upload: the previous original stays readable until the
+	// track points at the new one
+	key := fmt.Sprintf("tracks/%d/original-%d", track.ID, time.Now().UnixNano())
+	hr := newHashingReader(content)
+
+	if err := app.blobs.Put(r.Context(), key, hr, -1, contentType); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			app.payloadTooLargeResponse(w, r, err)
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.attachTrackAudio(r.Context(), track, key, format, hr.n, hr.Sum()); err != nil {
+		var parseErr *audio.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	setETag(w, track.Version)
+	if err := app.jsonResponse(w, http.StatusOK, track); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// attachTrackAudio points the track at a newly stored original and removes
+// the one it replaces. The file's properties and tags are read, a missing
+// title or artist is taken from the tags and an embedded cover replaces art
+// taken from an earlier file. On failure the new object is deleted again;
+// audio that fails validation yields an *audio.ParseError.
+func (app *application) attachTrackAudio(ctx context.Context, track *store.Track, key, format string, size int64, checksum string) error {
+	previousArt := track.Artwork
+	previousAlbum, hadAlbum := albumOf(track)
+
+	// Probe: formats we can't inspect yet are kept as they are
+	info, err := audio.Probe(blob.NewReaderAt(ctx, app.blobs, key, size), size)
+	if errors.Is(err, audio.ErrUnknownFormat) {
+		info, err = nil, nil
+	}
+	if err != nil {
+		app.deleteBlob(ctx, key)
+		return err
+	}
+
+	// Verify: decoding a FLAC in full also fills in a length its header lacks
+	if info != nil {
+		if err := app.verifyLossless(ctx, key, info); err != nil {
+			app.deleteBlob(ctx, key)
+			return err
+		}
+	}
+	applyAudioInfo(track, info)
+
+	// Artwork: the embedded cover, unless the owner uploaded their own
+	if info != nil {
+		if pic := audio.FrontCover(info.Pictures); pic != nil {
+			app.ingestEmbeddedArtwork(ctx, track, pic.Data)
+		}
+	}
+
+	previous, previousHLS, previousRenditions := track.AudioKey, hlsPrefix(track), renditionPrefix(track)
+	previousWaveform, previousLoudness := waveformPrefix(track), loudnessKey(track)
+	track.AudioKey = key
+	track.Format = format
+	track.Size = size
+	track.Checksum = checksum
+	track.Renditions = app.pendingRenditions()
+	track.Waveform = nil
+	track.Loudness = nil
+
+	if err := app.store.Tracks.Update(ctx, track); err != nil {
+		app.deleteBlob(ctx, key)
+		app.deleteArtwork(ctx, track.ID, track.Artwork, previousArt)
+		return err
+	}
+	if previous != "" {
+		app.deleteBlob(ctx, previous)
+		if previousHLS != hlsPrefix(track) {
+			app.deletePrefix(ctx, previousHLS)
+		}
+		if previousRenditions != renditionPrefix(track) {
+			app.deletePrefix(ctx, previousRenditions)
+		}
+		if previousWaveform != waveformPrefix(track) {
+			app.deletePrefix(ctx, previousWaveform)
+		}
+		if previousLoudness != loudnessKey(track) {
+			app.deleteBlob(ctx, previousLoudness)
+		}
+	}
+	app.deleteArtwork(ctx, track.ID, previousArt, track.Artwork)
+
+	// Enqueue: every rendition of the ladder, the analysis of the audio,
+	// and the album the track left if its tags moved it
+	app.enqueueTranscode(ctx, track)
+	app.enqueueAnalysis(ctx, track)
+	if album, _ := albumOf(track); hadAlbum && album != previousAlbum {
+		app.enqueueAlbumLoudness(ctx, previousAlbum)
+	}
+	return nil
+}
+
+// applyAudioInfo copies the probed properties and tags to the track, nil
+// info clearing the tags of a file we can't inspect. Title and artist fall
+// back to the tags, then to placeholders.
+func applyAudioInfo(track *store.Track, info *audio.Info) {
+	if info == nil {
+		track.Tags, track.RawTags = audio.Tags{}, nil
+	} else {
+		track.DurationMs = info.DurationMs()
+		track.SampleRate = info.SampleRate
+		track.Channels = info.Channels
+		track.ChannelLayout = info.Layout
+		track.BitDepth = info.BitDepth
+		track.Bitrate = info.Bitrate
+		track.Integrity = info.Integrity
+		track.RawTags = info.Tags
+		track.Tags = audio.NormalizeTags(info.Format, info.Tags)
+		if track.Title == "" {
+			track.Title = track.Tags.Title
+		}
+		if track.Artist == "" {
+			track.Artist = track.Tags.MainArtist()
+		}
+	}
+	if track.Title == "" {
+		track.Title = "Untitled"
+	}
+	if track.Artist == "" {
+		track.Artist = "Unknown Artist"
+	}
+}
+
+// verifyLossless decodes a FLAC upload in full, checking its frame CRCs and
+// the MD5 of the decoded audio. Other formats are left as probed.
+func (app *application) verifyLossless(ctx context.Context, key string, info *audio.Info) error {
+	if info.Format != "flac" || !app.config.upload.verifyLossless {
+		return nil
+	}
+
+	rc, err := app.blobs.GetRange(ctx, key, info.DataOffset, info.DataSize)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return audio.VerifyFLAC(rc, info)
+}
+
+// uploadBody returns the file stream of the request, its declared content
+// type and the client's file name, if it sent one
+func uploadBody(r *http.Request) (io.ReadCloser, string, string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid Content-Type: %w", err)
+	}
+
+	if mediaType != "multipart/form-data" {
+		var filename string
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+		return r.Body, mediaType, filename, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", "", err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", "", errNoFilePart
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		partType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			part.Close()
+			return nil, "", "", fmt.Errorf("invalid file Content-Type: %w", err)
+		}
+		return part, partType, part.FileName(), nil
+	}
+}
+
+// allowedAudioFormat checks the content type against the configured allow list
+func (app *application) allowedAudioFormat(contentType string) (string, error) {
+	format, ok := audioFormats[strings.ToLower(contentType)]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", errUnsupportedMediaType, contentType)
+	}
+	for _, allowed := range app.config.upload.allowedTypes {
+		if strings.EqualFold(allowed, contentType) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", errUnsupportedMediaType, contentType)
+}
+
+// deleteBlob removes an object, logging rather than failing the request
+func (app *application) deleteBlob(ctx context.Context, key string) {
+	if err := app.blobs.Delete(ctx, key); err != nil {
+		app.logger.Warnw("failed to delete blob", "key", key, "error", err)
+	}
+}
+
+// deletePrefix removes every object under prefix, logging rather than
+// failing the request
+func (app *application) deletePrefix(ctx context.Context, prefix string) {
+	objects, err := app.blobs.List(ctx, prefix)
+	if err != nil {
+		app.logger.Warnw("failed to list blobs", "prefix", prefix, "error", err)
+		return
+	}
+	for _, obj := range objects {
+		app.deleteBlob(ctx, obj.Key)
+	}
+}
+
+// hashingReader counts and hashes everything read through it
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	hr.n += int64(n)
+	return n, err
+}
+
+// Sum returns the hex SHA-256 of the bytes read so far
+func (hr *hashingReader) Sum() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
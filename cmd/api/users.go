@@ -0,0 +1,104 @@
+package main
+
+import (
+	"audio-go/internal/db"
+	"audio-go/internal/store"
+	"errors"
+	"net/http"
+)
+
+// UpdateUserRequest represents the expected payload for updating the current user
+type UpdateUserRequest struct {
+	Email *string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+// getCurrentUserHandler returns the authenticated user, with its ETag
+func (app *application) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if notModified(w, r, user.Version) {
+		return
+	}
+
+	setETag(w, user.Version)
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// updateCurrentUserHandler updates the authenticated user.
+// The request must carry an If-Match header with the ETag it last read.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// If-Match is checked against this read, so it must not lag behind
+	r = r.WithContext(db.WithPrimary(r.Context()))
+
+	user, ok := app.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	version, ok := app.checkIfMatch(w, r, user.Version)
+	if !ok {
+		return
+	}
+
+	var req UpdateUserRequest
+	if err := readJSON(w, r, &req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(req); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	user.Version = version
+
+	if err := app.store.Users.Update(r.Context(), user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrVersionConflict):
+			app.conflictResponse(w, r, err)
+		case errors.Is(err, store.ErrUserNotFound):
+			app.notFoundResponse(w, r, err)
+		case errors.Is(err, store.ErrEmailTaken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	setETag(w, user.Version)
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// currentUser loads the authenticated user, writing an error response on failure
+func (app *application) currentUser(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		app.unauthorizedResponse(w, r, errors.New("missing user in context"))
+		return nil, false
+	}
+
+	user, err := app.store.Users.GetByID(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"audio-go/internal/store"
+	"audio-go/internal/waveform"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errNoWaveform      = errors.New("track has no waveform")
+	errWaveformZoom    = errors.New("pixels_per_second must be a positive number")
+	errWaveformSize    = errors.New("width and height must be between 1 and 4096")
+	errWaveformFormat  = errors.New("format must be json or dat")
+	defaultWaveColor   = color.NRGBA{R: 0x1f, G: 0x29, B: 0x37, A: 0xff}
+	defaultWaveBgColor = color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+)
+
+// maxWaveformImageSize bounds the sides of rendered waveform images
+const maxWaveformImageSize = 4096
+
+// waveformPrefix is where the waveform of a track's current audio is stored
+func waveformPrefix(track *store.Track) string {
+	return fmt.Sprintf("tracks/%d/waveform/%s/", track.ID, audioID(track))
+}
+
+func waveformKey(track *store.Track, samplesPerPixel int) string {
+	return waveformPrefix(track) + strconv.Itoa(samplesPerPixel) + ".dat"
+}
+
+// loadWaveform returns the track's waveform at samplesPerPixel, rescaled
+// from the nearest finer stored level, or the finest level when even that
+// is coarser
+func (app *application) loadWaveform(ctx context.Context, track *store.Track, samplesPerPixel int) (*waveform.Data, error) {
+	wf := track.Waveform
+	level := wf.Levels[0]
+	for _, l := range wf.Levels {
+		if l <= samplesPerPixel {
+			level = l
+		}
+	}
+
+	rc, err := app.blobs.Get(ctx, waveformKey(track, level))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var data waveform.Data
+	if err := data.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return data.Rescale(max(samplesPerPixel, level))
+}
+
+// waveformHandler sends the track's peaks in the audiowaveform JSON layout,
+// or its binary .dat layout with ?format=dat. ?pixels_per_second= sets the
+// zoom, by default the finest stored.
+func (app *application) waveformHandler(w http.ResponseWriter, r *http.Request) {
+	track := getTrackFromContext(r)
+	if track.Waveform == nil || len(track.Waveform.Levels) == 0 {
+		app.notFoundResponse(w, r, errNoWaveform)
+		return
+	}
+
+	q := r.URL.Query()
+	spp := track.Waveform.Levels[0]
+	if v := q.Get("pixels_per_second"); v != "" {
+		pps, err := strconv.ParseFloat(v, 64)
+		if err != nil || pps <= 0 || math.IsInf(pps, 0) {
+			app.badRequestResponse(w, r, errWaveformZoom)
+			return
+		}
+		spp = max(int(math.Round(float64(track.Waveform.SampleRate)/pps)), 1)
+	}
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = "json"
+	case "json", "dat":
+	default:
+		app.badRequestResponse(w, r, errWaveformFormat)
+		return
+	}
+
+	if derivedNotModified(w, r, track, fmt.Sprintf(`"%s-%d-%s"`, audioID(track), spp, format)) {
+		return
+	}
+	data, err := app.loadWaveform(r.Context(), track, spp)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	var body []byte
+	contentType := waveform.JSONContentType
+	if format == "dat" {
+		body, err = data.MarshalBinary()
+		contentType = waveform.DatContentType
+	} else {
+		body, err = data.MarshalJSON()
+	}
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// waveformImageHandler renders the whole track's waveform as an SVG or PNG
+// image, e.g. for social previews. ?width= and ?height= default to
+// 1200x630, ?color= and ?background= take "#rrggbb[aa]", "none" for no
+// background.
+func (app *application) waveformImageHandler(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		track := getTrackFromContext(r)
+		if track.Waveform == nil || len(track.Waveform.Levels) == 0 {
+			app.notFoundResponse(w, r, errNoWaveform)
+			return
+		}
+
+		style, err := waveformStyle(r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		q := r.URL.Query()
+		tag := fmt.Sprintf(`"%s-%dx%d-%s-%s-%s"`, audioID(track), style.Width, style.Height, q.Get("color"), q.Get("background"), format)
+		if derivedNotModified(w, r, track, tag) {
+			return
+		}
+
+		// The level with at least a pixel per column
+		samples := track.DurationMs * int64(track.Waveform.SampleRate) / 1000
+		data, err := app.loadWaveform(r.Context(), track, max(int(samples/int64(style.Width)), 1))
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		var buf bytes.Buffer
+		contentType := "image/svg+xml"
+		if format == "png" {
+			err = waveform.WritePNG(&buf, data, style)
+			contentType = "image/png"
+		} else {
+			err = waveform.WriteSVG(&buf, data, style)
+		}
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+}
+
+// waveformStyle reads the image options of a waveform request
+func waveformStyle(r *http.Request) (waveform.Style, error) {
+	q := r.URL.Query()
+	style := waveform.Style{Width: 1200, Height: 630, Color: defaultWaveColor, Background: defaultWaveBgColor}
+
+	for _, side := range []struct {
+		name string
+		v    *int
+	}{{"width", &style.Width}, {"height", &style.Height}} {
+		if s := q.Get(side.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > maxWaveformImageSize {
+				return style, errWaveformSize
+			}
+			*side.v = n
+		}
+	}
+
+	var err error
+	if s := q.Get("color"); s != "" {
+		if style.Color, err = waveform.ParseColor(s); err != nil {
+			return style, err
+		}
+	}
+	switch s := q.Get("background"); s {
+	case "":
+	case "none":
+		style.Background = color.NRGBA{}
+	default:
+		if style.Background, err = waveform.ParseColor(s); err != nil {
+			return style, err
+		}
+	}
+	return style, nil
+}
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    event_id uuid UNIQUE NOT NULL,
+    event_type varchar(255) NOT NULL,
+    aggregate_type varchar(255) NOT NULL,
+    aggregate_id varchar(255) NOT NULL,
+    payload jsonb NOT NULL,
+    occurred_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    published_at timestamp(0) with time zone,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error text,
+    available_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (available_at, id) WHERE published_at IS NULL;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS tracks (
+    id bigserial PRIMARY KEY,
+    owner_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title varchar(255) NOT NULL,
+    artist varchar(255) NOT NULL,
+    duration_ms bigint NOT NULL DEFAULT 0,
+    format varchar(16) NOT NULL DEFAULT '',
+    sample_rate INT NOT NULL DEFAULT 0,
+    channels INT NOT NULL DEFAULT 0,
+    bitrate INT NOT NULL DEFAULT 0,
+    size bigint NOT NULL DEFAULT 0,
+    checksum varchar(64) NOT NULL DEFAULT '',
+    visibility varchar(16) NOT NULL DEFAULT 'private',
+    version INT NOT NULL DEFAULT 1,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_tracks_owner_created ON tracks (owner_id, created_at, id);
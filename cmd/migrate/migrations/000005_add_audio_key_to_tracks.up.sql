@@ -0,0 +1,2 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS audio_key varchar(512) NOT NULL DEFAULT '';
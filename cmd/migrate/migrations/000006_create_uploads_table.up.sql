@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS uploads (
+    id varchar(64) PRIMARY KEY,
+    owner_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    track_id bigint REFERENCES tracks (id) ON DELETE SET NULL,
+    upload_length bigint NOT NULL,
+    upload_offset bigint NOT NULL DEFAULT 0,
+    metadata jsonb NOT NULL DEFAULT '{}',
+    expires_at timestamp(0) with time zone NOT NULL,
+    completed_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires ON uploads (expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE tracks
+DROP COLUMN IF EXISTS bit_depth,
+DROP COLUMN IF EXISTS channel_layout;
@@ -0,0 +1,3 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS bit_depth INT NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS channel_layout varchar(32) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE tracks
+DROP COLUMN IF EXISTS raw_tags,
+DROP COLUMN IF EXISTS tags;
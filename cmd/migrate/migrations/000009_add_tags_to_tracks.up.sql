@@ -0,0 +1,3 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS tags jsonb NOT NULL DEFAULT '{}',
+ADD COLUMN IF NOT EXISTS raw_tags jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE tracks
+DROP COLUMN IF EXISTS artwork;
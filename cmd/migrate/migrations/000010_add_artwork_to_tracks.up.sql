@@ -0,0 +1,2 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS artwork jsonb;
@@ -0,0 +1,2 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS renditions jsonb NOT NULL DEFAULT '{}';
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    kind varchar(255) NOT NULL,
+    payload jsonb NOT NULL,
+    priority INT NOT NULL DEFAULT 0,
+    state varchar(16) NOT NULL DEFAULT 'queued',
+    run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    last_error text,
+    locked_by varchar(255),
+    locked_until timestamp with time zone,
+    heartbeat_at timestamp with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs (kind, priority DESC, run_at, id) WHERE state = 'queued';
+CREATE INDEX IF NOT EXISTS idx_jobs_leased ON jobs (locked_until) WHERE state = 'running';
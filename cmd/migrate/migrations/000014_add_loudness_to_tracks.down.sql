@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_tracks_owner_album;
+
+ALTER TABLE tracks
+DROP COLUMN IF EXISTS loudness;
@@ -0,0 +1,4 @@
+ALTER TABLE tracks
+ADD COLUMN IF NOT EXISTS loudness jsonb;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_owner_album ON tracks (owner_id, lower(tags->>'album'));
@@ -0,0 +1,6 @@
+ALTER TABLE outbox
+ADD COLUMN IF NOT EXISTS dead_at timestamp(0) with time zone;
+
+DROP INDEX IF EXISTS idx_outbox_pending;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (available_at, id) WHERE published_at IS NULL AND dead_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_dead ON outbox (id) WHERE dead_at IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS role varchar(16) NOT NULL DEFAULT 'listener'
+    CHECK (role IN ('listener', 'artist', 'admin'));
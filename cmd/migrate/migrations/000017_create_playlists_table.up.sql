@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS playlists (
+    id bigserial PRIMARY KEY,
+    owner_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    title varchar(255) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (owner_id, title)
+);
+
+CREATE TABLE IF NOT EXISTS playlist_tracks (
+    playlist_id bigint NOT NULL REFERENCES playlists (id) ON DELETE CASCADE,
+    position INT NOT NULL,
+    track_id bigint NOT NULL REFERENCES tracks (id) ON DELETE CASCADE,
+    PRIMARY KEY (playlist_id, position)
+);
+
+CREATE INDEX IF NOT EXISTS idx_playlist_tracks_track ON playlist_tracks (track_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    followee_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_follows_followee ON follows (followee_id);
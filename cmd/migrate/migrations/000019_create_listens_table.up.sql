@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS listens (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    track_id bigint NOT NULL REFERENCES tracks (id) ON DELETE CASCADE,
+    played_at timestamp with time zone NOT NULL,
+    seconds_played double precision NOT NULL,
+    UNIQUE (user_id, track_id, played_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_listens_user ON listens (user_id, played_at DESC);
+CREATE INDEX IF NOT EXISTS idx_listens_track ON listens (track_id);
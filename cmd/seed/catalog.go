@@ -0,0 +1,276 @@
+package main
+
+import (
+	"audio-go/internal/store"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fixture entities. Artists and albums have no tables of their own: they
+// are persisted as the owner and tags of their tracks.
+
+type seedUser struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Role     string `json:"role"` // listener, artist or admin
+	ID       int64  `json:"id,omitempty"`
+}
+
+type seedArtist struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"` // email of the artist account
+}
+
+type seedAlbum struct {
+	ID       int    `json:"id"`
+	ArtistID int    `json:"artist_id"`
+	Title    string `json:"title"`
+	Year     int    `json:"year"`
+	Tracks   int    `json:"tracks"`
+}
+
+type seedTrack struct {
+	ID       int       `json:"id"`
+	AlbumID  int       `json:"album_id"`
+	ArtistID int       `json:"artist_id"`
+	Number   int       `json:"track_number"`
+	Title    string    `json:"title"`
+	Seconds  float64   `json:"duration_seconds"`
+	File     string    `json:"file"`
+	Audio    audioSpec `json:"audio"`
+}
+
+type seedPlaylist struct {
+	ID       int    `json:"id"`
+	Owner    string `json:"owner"`
+	Title    string `json:"title"`
+	TrackIDs []int  `json:"track_ids"`
+}
+
+type seedFollow struct {
+	Follower string `json:"follower"`
+	ArtistID int    `json:"artist_id"`
+}
+
+type seedListen struct {
+	User     string    `json:"user"`
+	TrackID  int       `json:"track_id"`
+	PlayedAt time.Time `json:"played_at"`
+	Seconds  float64   `json:"seconds_played"`
+}
+
+type catalog struct {
+	Seed      int64          `json:"seed"`
+	Users     []seedUser     `json:"users"`
+	Artists   []seedArtist   `json:"artists"`
+	Albums    []seedAlbum    `json:"albums"`
+	Tracks    []seedTrack    `json:"tracks"`
+	Playlists []seedPlaylist `json:"playlists"`
+	Follows   []seedFollow   `json:"follows"`
+	Listens   []seedListen   `json:"listens"`
+}
+
+type catalogOptions struct {
+	seed        int64
+	listeners   int
+	artists     int
+	admins      int
+	maxDuration float64   // cap for synthesized audio length, in seconds
+	historyEnd  time.Time // listening history covers the 90 days before
+}
+
+var (
+	adjectives = []string{"Silent", "Electric", "Golden", "Broken", "Midnight", "Velvet", "Hollow", "Neon", "Paper", "Crimson", "Distant", "Wild", "Glass", "Lunar", "Restless", "Amber"}
+	nouns      = []string{"Harbor", "Echo", "Garden", "Signal", "River", "Machine", "Avenue", "Orchard", "Static", "Horizon", "Lantern", "Tide", "Circuit", "Meadow", "Mirror", "Atlas"}
+	moods      = []string{"Morning", "Focus", "Workout", "Chill", "Late Night", "Road Trip", "Rainy Day", "Party"}
+)
+
+// generateCatalog builds the whole fixture deterministically from opts.seed
+func generateCatalog(opts catalogOptions) *catalog {
+	rng := rand.New(rand.NewSource(opts.seed))
+	c := &catalog{Seed: opts.seed}
+
+	// Users of each role: artist accounts own the tracks
+	for i := 1; i <= opts.admins; i++ {
+		c.Users = append(c.Users, seedUser{Email: fmt.Sprintf("admin%02d@seed.local", i), Password: "password", Role: store.RoleAdmin})
+	}
+	for i := 1; i <= opts.artists; i++ {
+		email := fmt.Sprintf("artist%03d@seed.local", i)
+		c.Users = append(c.Users, seedUser{Email: email, Password: "password", Role: store.RoleArtist})
+		c.Artists = append(c.Artists, seedArtist{ID: i, Name: name(rng, 2), Owner: email})
+	}
+	var listeners []string
+	for i := 1; i <= opts.listeners; i++ {
+		email := fmt.Sprintf("listener%04d@seed.local", i)
+		c.Users = append(c.Users, seedUser{Email: email, Password: "password", Role: store.RoleListener})
+		listeners = append(listeners, email)
+	}
+
+	// Albums: most artists have one or two releases, a few have a long discography
+	for _, artist := range c.Artists {
+		releases := 1 + geometric(rng, 0.5)
+		year := 1995 + rng.Intn(25)
+		titles := map[string]bool{} // an artist's tracks are found again by album title
+		for r := 0; r < releases; r++ {
+			title := name(rng, 2)
+			for titles[title] {
+				title = name(rng, 2)
+			}
+			titles[title] = true
+
+			// Singles happen, otherwise a typical album length
+			album := seedAlbum{ID: len(c.Albums) + 1, ArtistID: artist.ID, Title: title, Year: year, Tracks: 1}
+			if rng.Float64() > 0.2 {
+				album.Tracks = 6 + rng.Intn(9)
+			}
+			c.Albums = append(c.Albums, album)
+			year += 1 + rng.Intn(3)
+
+			for n := 1; n <= album.Tracks; n++ {
+				// Track lengths cluster around 3.5 minutes
+				seconds := math.Max(45, math.Min(600, rng.NormFloat64()*60+210))
+				seconds = math.Min(seconds, opts.maxDuration)
+
+				channels := 2
+				if rng.Float64() < 0.15 {
+					channels = 1
+				}
+				sampleRate := 44100
+				if rng.Float64() < 0.3 {
+					sampleRate = 48000
+				}
+
+				id := len(c.Tracks) + 1
+				c.Tracks = append(c.Tracks, seedTrack{
+					ID:       id,
+					AlbumID:  album.ID,
+					ArtistID: artist.ID,
+					Number:   n,
+					Title:    name(rng, 1+rng.Intn(2)),
+					Seconds:  seconds,
+					File:     fmt.Sprintf("track-%05d.wav", id),
+					Audio:    planAudio(rng, sampleRate, channels, seconds),
+				})
+			}
+		}
+	}
+
+	if len(c.Tracks) == 0 || len(listeners) == 0 {
+		return c
+	}
+
+	// Popularity follows a power law: a handful of hits get most plays
+	trackZipf := rand.NewZipf(rng, 1.2, 2, uint64(len(c.Tracks)-1))
+	artistZipf := rand.NewZipf(rng, 1.1, 2, uint64(len(c.Artists)-1))
+	trackRank := rng.Perm(len(c.Tracks))
+	artistRank := rng.Perm(len(c.Artists))
+
+	for _, user := range listeners {
+		// Follows: a few artists each, biased towards popular ones
+		followed := map[int]bool{}
+		for k := poisson(rng, 4); k > 0; k-- {
+			followed[c.Artists[artistRank[artistZipf.Uint64()]].ID] = true
+		}
+		ids := make([]int, 0, len(followed))
+		for id := range followed {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			c.Follows = append(c.Follows, seedFollow{Follower: user, ArtistID: id})
+		}
+
+		// Listening history: heavy-tailed per-user activity over 90 days
+		plays := int(math.Exp(rng.NormFloat64()*0.9 + 3))
+		for p := 0; p < plays; p++ {
+			track := c.Tracks[trackRank[trackZipf.Uint64()]]
+			c.Listens = append(c.Listens, seedListen{
+				User:     user,
+				TrackID:  track.ID,
+				PlayedAt: playedAt(rng, opts.historyEnd),
+				Seconds:  listened(rng, track.Seconds),
+			})
+		}
+
+		// Playlists: about a third of listeners curate one or more, titled
+		// uniquely per owner
+		if rng.Float64() < 0.33 {
+			titles := map[string]bool{}
+			for n := 1 + geometric(rng, 0.6); n > 0; n-- {
+				title := fmt.Sprintf("%s %s", moods[rng.Intn(len(moods))], nouns[rng.Intn(len(nouns))])
+				if titles[title] {
+					continue
+				}
+				titles[title] = true
+
+				pl := seedPlaylist{ID: len(c.Playlists) + 1, Owner: user, Title: title}
+				seen := map[int]bool{}
+				for k := 5 + rng.Intn(30); k > 0; k-- {
+					id := c.Tracks[trackRank[trackZipf.Uint64()]].ID
+					if !seen[id] {
+						seen[id] = true
+						pl.TrackIDs = append(pl.TrackIDs, id)
+					}
+				}
+				c.Playlists = append(c.Playlists, pl)
+			}
+		}
+	}
+
+	sort.SliceStable(c.Listens, func(i, j int) bool { return c.Listens[i].PlayedAt.Before(c.Listens[j].PlayedAt) })
+
+	return c
+}
+
+func name(rng *rand.Rand, words int) string {
+	parts := []string{adjectives[rng.Intn(len(adjectives))]}
+	for i := 1; i < words; i++ {
+		parts = append(parts, nouns[rng.Intn(len(nouns))])
+	}
+	if words == 1 {
+		parts = append(parts, nouns[rng.Intn(len(nouns))])
+	}
+	return strings.Join(parts, " ")
+}
+
+// playedAt picks a time in the 90 days before end, weighted towards evenings
+func playedAt(rng *rand.Rand, end time.Time) time.Time {
+	day := end.AddDate(0, 0, -1-rng.Intn(90))
+	hour := math.Mod(rng.NormFloat64()*4+20, 24)
+	if hour < 0 {
+		hour += 24
+	}
+	return day.Add(time.Duration(hour * float64(time.Hour))).Truncate(time.Second)
+}
+
+// listened models skips: a quarter of plays stop within 30 seconds
+func listened(rng *rand.Rand, duration float64) float64 {
+	if rng.Float64() < 0.25 {
+		return math.Round(math.Min(duration, rng.Float64()*30)*10) / 10
+	}
+	return math.Round(duration*(0.7+rng.Float64()*0.3)*10) / 10
+}
+
+func geometric(rng *rand.Rand, p float64) int {
+	n := 0
+	for rng.Float64() > p {
+		n++
+	}
+	return n
+}
+
+func poisson(rng *rand.Rand, lambda float64) int {
+	l, k, p := math.Exp(-lambda), 0, 1.0
+	for {
+		p *= rng.Float64()
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGenerateCatalog(t *testing.T) {
+	opts := catalogOptions{
+		seed:        7,
+		listeners:   40,
+		artists:     6,
+		admins:      2,
+		maxDuration: 5,
+		historyEnd:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	c := generateCatalog(opts)
+	if !reflect.DeepEqual(c, generateCatalog(opts)) {
+		t.Fatal("the same seed generated two different catalogs")
+	}
+
+	roles := map[string]int{}
+	for _, u := range c.Users {
+		roles[u.Role]++
+	}
+	if roles["admin"] != 2 || roles["artist"] != 6 || roles["listener"] != 40 {
+		t.Fatalf("roles = %v", roles)
+	}
+	if len(c.Playlists) == 0 || len(c.Follows) == 0 || len(c.Listens) == 0 {
+		t.Fatalf("%d playlists, %d follows, %d listens", len(c.Playlists), len(c.Follows), len(c.Listens))
+	}
+
+	// Re-runs find albums and playlists again by title, so titles can't repeat
+	albums := map[[2]any]bool{}
+	for _, a := range c.Albums {
+		key := [2]any{a.ArtistID, a.Title}
+		if albums[key] {
+			t.Fatalf("artist %d has two albums titled %q", a.ArtistID, a.Title)
+		}
+		albums[key] = true
+	}
+	playlists := map[[2]string]bool{}
+	for _, pl := range c.Playlists {
+		key := [2]string{pl.Owner, pl.Title}
+		if playlists[key] {
+			t.Fatalf("%s has two playlists titled %q", pl.Owner, pl.Title)
+		}
+		playlists[key] = true
+	}
+
+	start := opts.historyEnd.AddDate(0, 0, -90)
+	for i, l := range c.Listens {
+		if l.PlayedAt.Before(start) || !l.PlayedAt.Before(opts.historyEnd) {
+			t.Fatalf("listen at %v outside the 90 days before %v", l.PlayedAt, opts.historyEnd)
+		}
+		if i > 0 && l.PlayedAt.Before(c.Listens[i-1].PlayedAt) {
+			t.Fatal("listens are not in play order")
+		}
+	}
+}
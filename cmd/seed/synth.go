@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+// segmentKind is one building block of a synthesized track
+type segmentKind int
+
+const (
+	sweep   segmentKind = iota // logarithmic sine sweep between two frequencies
+	tone                       // steady sine tone with a slow tremolo
+	noise                      // white or pink noise burst
+	silence                    // digital silence gap
+)
+
+type segment struct {
+	kind     segmentKind
+	frames   int
+	f0, f1   float64 // start/end frequency in Hz (sweep, tone)
+	gain     float64 // linear amplitude, 0..1
+	pink     bool    // pink instead of white noise
+	panRight float64 // 0 = hard left, 1 = hard right (stereo only)
+}
+
+// audioSpec describes a synthesized WAV file
+type audioSpec struct {
+	SampleRate int `json:"sample_rate"`
+	Channels   int `json:"channels"`
+	BitDepth   int `json:"bit_depth"`
+	Frames     int `json:"frames"`
+
+	segments []segment
+}
+
+// planAudio lays out a deterministic sequence of segments filling the given duration
+func planAudio(rng *rand.Rand, sampleRate, channels int, seconds float64) audioSpec {
+	spec := audioSpec{
+		SampleRate: sampleRate,
+		Channels:   channels,
+		BitDepth:   16,
+		Frames:     int(seconds * float64(sampleRate)),
+	}
+
+	remaining := spec.Frames
+	for remaining > 0 {
+		seg := segment{
+			gain:     0.2 + rng.Float64()*0.6,
+			panRight: rng.Float64(),
+		}
+
+		// Mostly musical content, with the occasional gap so silence detection has something to find
+		switch r := rng.Float64(); {
+		case r < 0.35:
+			seg.kind = sweep
+			seg.f0 = 40 + rng.Float64()*400
+			seg.f1 = 1000 + rng.Float64()*15000
+		case r < 0.65:
+			seg.kind = tone
+			seg.f0 = 110 * math.Pow(2, float64(rng.Intn(36))/12) // notes from A2 upwards
+		case r < 0.9:
+			seg.kind = noise
+			seg.pink = rng.Intn(2) == 0
+			seg.gain *= 0.5
+		default:
+			seg.kind = silence
+		}
+
+		length := 0.5 + rng.Float64()*4 // seconds
+		if seg.kind == silence {
+			length = 0.2 + rng.Float64()*1.5
+		}
+		seg.frames = min(int(length*float64(sampleRate)), remaining)
+		remaining -= seg.frames
+
+		spec.segments = append(spec.segments, seg)
+	}
+
+	return spec
+}
+
+// writeWAV renders spec as a 16-bit PCM WAV file at path
+func writeWAV(path string, spec audioSpec, rng *rand.Rand) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := writeWAVHeader(w, spec); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2*spec.Channels)
+	var pinkState [7]float64
+	for _, seg := range spec.segments {
+		phase := 0.0
+		fade := min(seg.frames/2, spec.SampleRate/100) // 10 ms fades avoid clicks between segments
+
+		for i := 0; i < seg.frames; i++ {
+			var v float64
+			t := float64(i) / float64(seg.frames)
+
+			switch seg.kind {
+			case sweep:
+				freq := seg.f0 * math.Pow(seg.f1/seg.f0, t)
+				phase += 2 * math.Pi * freq / float64(spec.SampleRate)
+				v = math.Sin(phase)
+			case tone:
+				phase += 2 * math.Pi * seg.f0 / float64(spec.SampleRate)
+				v = math.Sin(phase) * (0.8 + 0.2*math.Sin(2*math.Pi*4*float64(i)/float64(spec.SampleRate)))
+			case noise:
+				white := rng.Float64()*2 - 1
+				if seg.pink {
+					v = pinkFilter(&pinkState, white)
+				} else {
+					v = white
+				}
+			case silence:
+				v = 0
+			}
+
+			env := 1.0
+			if i < fade {
+				env = float64(i) / float64(fade)
+			} else if seg.frames-i < fade {
+				env = float64(seg.frames-i) / float64(fade)
+			}
+			v *= seg.gain * env
+
+			for ch := 0; ch < spec.Channels; ch++ {
+				cv := v
+				if spec.Channels == 2 {
+					if ch == 0 {
+						cv *= math.Sqrt(1 - seg.panRight)
+					} else {
+						cv *= math.Sqrt(seg.panRight)
+					}
+				}
+				sample := int16(math.Max(-1, math.Min(1, cv)) * math.MaxInt16)
+				binary.LittleEndian.PutUint16(buf[2*ch:], uint16(sample))
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func writeWAVHeader(w io.Writer, spec audioSpec) error {
+	blockAlign := spec.Channels * spec.BitDepth / 8
+	dataSize := spec.Frames * blockAlign
+
+	hdr := make([]byte, 44)
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(36+dataSize))
+	copy(hdr[8:], "WAVE")
+	copy(hdr[12:], "fmt ")
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(hdr[22:], uint16(spec.Channels))
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(spec.SampleRate))
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(spec.SampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(hdr[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(hdr[34:], uint16(spec.BitDepth))
+	copy(hdr[36:], "data")
+	binary.LittleEndian.PutUint32(hdr[40:], uint32(dataSize))
+
+	_, err := w.Write(hdr)
+	return err
+}
+
+// pinkFilter turns white noise into (approximately) pink noise using
+// Paul Kellet's economy filter
+func pinkFilter(b *[7]float64, white float64) float64 {
+	b[0] = 0.99886*b[0] + white*0.0555179
+	b[1] = 0.99332*b[1] + white*0.0750759
+	b[2] = 0.96900*b[2] + white*0.1538520
+	b[3] = 0.86650*b[3] + white*0.3104856
+	b[4] = 0.55000*b[4] + white*0.5329522
+	b[5] = -0.7616*b[5] - white*0.0168980
+	pink := b[0] + b[1] + b[2] + b[3] + b[4] + b[5] + b[6] + white*0.5362
+	b[6] = white * 0.115926
+	return pink * 0.11
+}
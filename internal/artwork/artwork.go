@@ -0,0 +1,192 @@
+// Package artwork validates cover art and renders the square variants
+// served to players.
+package artwork
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"slices"
+)
+
+var (
+	// ErrUnsupported is returned for data that is not a JPEG or PNG image
+	ErrUnsupported = errors.New("artwork must be a JPEG or PNG image")
+	// ErrInvalid is returned for images that fail to decode or have unusable dimensions
+	ErrInvalid = errors.New("invalid artwork")
+)
+
+const (
+	MinDimension = 64
+	MaxDimension = 8192
+
+	jpegQuality = 85
+)
+
+// Image is a decoded cover
+type Image struct {
+	Format string // "jpeg" or "png"
+	Width  int
+	Height int
+
+	img    image.Image
+	opaque bool
+}
+
+// Variant is one rendition of a cover
+type Variant struct {
+	Size     int // width and height in pixels
+	Format   string
+	MIMEType string
+	Data     []byte
+}
+
+// MIMEType returns the content type of an image format
+func MIMEType(format string) string {
+	return "image/" + format
+}
+
+// Decode validates and decodes a JPEG or PNG image. The dimensions are
+// checked before decoding so oversized images are never expanded.
+func Decode(data []byte) (*Image, error) {
+	var format string
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		format = "jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1A\n")):
+		format = "png"
+	default:
+		return nil, ErrUnsupported
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if cfg.Width < MinDimension || cfg.Height < MinDimension {
+		return nil, fmt.Errorf("%w: %dx%d is smaller than %dx%d", ErrInvalid, cfg.Width, cfg.Height, MinDimension, MinDimension)
+	}
+	if cfg.Width > MaxDimension || cfg.Height > MaxDimension {
+		return nil, fmt.Errorf("%w: %dx%d is larger than %dx%d", ErrInvalid, cfg.Width, cfg.Height, MaxDimension, MaxDimension)
+	}
+
+	var img image.Image
+	if format == "jpeg" {
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	} else {
+		img, err = png.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	im := &Image{Format: format, Width: cfg.Width, Height: cfg.Height, img: img, opaque: true}
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		im.opaque = o.Opaque()
+	}
+	return im, nil
+}
+
+// Sizes returns the variant sizes that can be made from the image: those
+// larger than its shorter side are replaced by that side
+func (im *Image) Sizes(sizes []int) []int {
+	side := min(im.Width, im.Height)
+	var out []int
+	for _, s := range sizes {
+		s = min(s, side)
+		if s > 0 && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// VariantFormat is the format of the non-WebP variants: JPEG, or PNG when
+// the image has transparency
+func (im *Image) VariantFormat() string {
+	if im.opaque {
+		return "jpeg"
+	}
+	return "png"
+}
+
+// Variants renders the image as centred squares of each size, both in
+// VariantFormat and as lossless WebP
+func (im *Image) Variants(sizes []int) ([]Variant, error) {
+	var out []Variant
+	for _, size := range im.Sizes(sizes) {
+		sq := im.Square(size)
+
+		var buf bytes.Buffer
+		format := im.VariantFormat()
+		var err error
+		if format == "jpeg" {
+			err = jpeg.Encode(&buf, sq, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, sq)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Variant{Size: size, Format: format, MIMEType: MIMEType(format), Data: buf.Bytes()})
+
+		var webp bytes.Buffer
+		if err := EncodeWebP(&webp, sq); err != nil {
+			return nil, err
+		}
+		out = append(out, Variant{Size: size, Format: "webp", MIMEType: MIMEType("webp"), Data: webp.Bytes()})
+	}
+	return out, nil
+}
+
+// Square crops the image to its centred square and resamples it to size
+func (im *Image) Square(size int) *image.RGBA {
+	b := im.img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(b.Min).Add(image.Pt((b.Dx()-side)/2, (b.Dy()-side)/2))
+
+	src := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(src, src.Bounds(), im.img, crop.Min, draw.Src)
+	if side == size {
+		return src
+	}
+	return resample(src, size, size)
+}
+
+// DominantColor returns the most common color of the image as "#rrggbb".
+// Colors are grouped coarsely so gradients and JPEG noise count as one,
+// then the pixels of the largest group are averaged.
+func (im *Image) DominantColor() string {
+	small := im.Square(min(64, im.Width, im.Height))
+
+	type bucket struct{ n, r, g, b int }
+	var buckets [512]bucket
+	best := -1
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		r, g, b, a := int(small.Pix[i]), int(small.Pix[i+1]), int(small.Pix[i+2]), int(small.Pix[i+3])
+		if a < 128 {
+			continue // mostly transparent
+		}
+		// un-premultiply
+		r, g, b = r*255/a, g*255/a, b*255/a
+		k := r>>5<<6 | g>>5<<3 | b>>5
+		bk := &buckets[k]
+		bk.n++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+		if best < 0 || bk.n > buckets[best].n {
+			best = k
+		}
+	}
+	if best < 0 {
+		return "#000000"
+	}
+	bk := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", bk.r/bk.n, bk.g/bk.n, bk.b/bk.n)
+}
@@ -0,0 +1,110 @@
+package artwork
+
+import (
+	"image"
+	"math"
+)
+
+// tap is one source pixel's contribution to an output pixel
+type tap struct {
+	index  int
+	weight float32
+}
+
+// catmullRom is the Catmull-Rom cubic, a sharp filter with support 2
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+	return 0
+}
+
+// resampleTaps computes the filter taps mapping in pixels onto out pixels.
+// When shrinking the filter is widened by the scale so every source pixel
+// contributes.
+func resampleTaps(in, out int) [][]tap {
+	scale := float64(in) / float64(out)
+	width := math.Max(scale, 1)
+	support := 2 * width
+
+	taps := make([][]tap, out)
+	for i := range taps {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - support))
+		hi := int(math.Floor(center + support))
+		var sum float64
+		row := make([]tap, 0, hi-lo+1)
+		for j := lo; j <= hi; j++ {
+			w := catmullRom((float64(j) - center) / width)
+			if w == 0 {
+				continue
+			}
+			row = append(row, tap{index: min(max(j, 0), in-1), weight: float32(w)})
+			sum += w
+		}
+		for k := range row {
+			row[k].weight /= float32(sum)
+		}
+		taps[i] = row
+	}
+	return taps
+}
+
+// resample scales premultiplied RGBA pixels to w x h, one axis at a time
+func resample(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Horizontal pass into floats, w x sh
+	xt := resampleTaps(sw, w)
+	tmp := make([]float32, w*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, taps := range xt {
+			var c [4]float32
+			for _, t := range taps {
+				p := row[t.index*4:]
+				for k := range c {
+					c[k] += float32(p[k]) * t.weight
+				}
+			}
+			copy(tmp[(y*w+x)*4:], c[:])
+		}
+	}
+
+	// Vertical pass, w x h
+	yt := resampleTaps(sh, h)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, taps := range yt {
+		for x := 0; x < w; x++ {
+			var c [4]float32
+			for _, t := range taps {
+				p := tmp[(t.index*w+x)*4:]
+				for k := range c {
+					c[k] += p[k] * t.weight
+				}
+			}
+			// The cubic overshoots, and premultiplied color may not exceed alpha
+			a := clamp8(c[3])
+			o := dst.PixOffset(x, y)
+			dst.Pix[o] = min(clamp8(c[0]), a)
+			dst.Pix[o+1] = min(clamp8(c[1]), a)
+			dst.Pix[o+2] = min(clamp8(c[2]), a)
+			dst.Pix[o+3] = a
+		}
+	}
+	return dst
+}
+
+func clamp8(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,664 @@
+package artwork
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Lossless WebP (VP8L) encoder. It applies the subtract-green and predictor
+// transforms, then codes the residuals with LZ77 backward references and
+// one set of prefix codes. There is no color cache and no meta prefix codes,
+// which keeps it short at some cost in size.
+
+const (
+	vp8lSignature   = 0x2F
+	vp8lMaxSize     = 1 << 14
+	vp8lPredBits    = 4 // predictor blocks of 16x16 pixels
+	vp8lMaxLength   = 4096
+	vp8lMaxDistance = 1<<20 - 120
+	vp8lHashBits    = 16
+	vp8lChainDepth  = 16
+
+	// alphabets: green with length prefixes, red, blue, alpha, distance
+	vp8lGreenSize    = 256 + 24
+	vp8lDistanceSize = 40
+
+	// transform types
+	vp8lPredictor     = 0
+	vp8lSubtractGreen = 2
+)
+
+// order in which code length code lengths are written
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+var errWebPSize = errors.New("webp: image is too large for lossless WebP")
+
+// EncodeWebP writes img as a lossless WebP file
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return errWebPSize
+	}
+
+	argb := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// WebP is not premultiplied
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			argb[y*width+x] = uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+			alpha = alpha || c.A != 0xFF
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Transforms are undone by the decoder in reverse: the predictor sees
+	// the green-subtracted image
+	bw.write(1, 1)
+	bw.write(vp8lSubtractGreen, 2)
+	subtractGreen(argb)
+
+	bw.write(1, 1)
+	bw.write(vp8lPredictor, 2)
+	bw.write(vp8lPredBits-2, 3)
+	modes, mw := predict(argb, width, height)
+	writeEntropyImage(bw, modes, mw, false)
+
+	bw.write(0, 1) // no more transforms
+	writeEntropyImage(bw, argb, width, true)
+
+	data := bw.bytes()
+	var hdr [20]byte
+	copy(hdr[0:4], "RIFF")
+	pad := len(data) & 1
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(12+len(data)+pad))
+	copy(hdr[8:16], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(len(data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if pad == 1 {
+		data = append(data, 0)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := p >> 8 & 0xFF
+		r := (p>>16 - g) & 0xFF
+		b := (p - g) & 0xFF
+		argb[i] = p&0xFF00FF00 | r<<16 | b
+	}
+}
+
+// predict replaces argb by its residuals after the predictor transform and
+// returns the sub-image of per-block modes. Each block gets the mode with the
+// smallest absolute residuals.
+func predict(argb []uint32, width, height int) ([]uint32, int) {
+	const block = 1 << vp8lPredBits
+	mw := (width + block - 1) / block
+	mh := (height + block - 1) / block
+	modes := make([]uint32, mw*mh)
+
+	// Predictions use the original neighbours, so work from a copy
+	src := append([]uint32(nil), argb...)
+	for by := 0; by < mh; by++ {
+		for bx := 0; bx < mw; bx++ {
+			best, bestCost := 0, -1
+			for mode := 0; mode < 14; mode++ {
+				cost := 0
+				for y := by * block; y < min((by+1)*block, height); y++ {
+					for x := bx * block; x < min((bx+1)*block, width); x++ {
+						res := residual(src[y*width+x], predictPixel(src, width, x, y, mode))
+						cost += channelCost(res)
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[by*mw+bx] = 0xFF000000 | uint32(best)<<8
+			for y := by * block; y < min((by+1)*block, height); y++ {
+				for x := bx * block; x < min((bx+1)*block, width); x++ {
+					argb[y*width+x] = residual(src[y*width+x], predictPixel(src, width, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, mw
+}
+
+func channelCost(p uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		c := int(int8(p >> shift))
+		if c < 0 {
+			c = -c
+		}
+		cost += c
+	}
+	return cost
+}
+
+// residual subtracts per channel, modulo 256
+func residual(p, pred uint32) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		out |= ((p>>shift - pred>>shift) & 0xFF) << shift
+	}
+	return out
+}
+
+// predictPixel applies the predictor of the given mode at (x, y). The first
+// row and column have fixed predictors.
+func predictPixel(p []uint32, width, x, y, mode int) uint32 {
+	i := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xFF000000
+	case y == 0:
+		return p[i-1]
+	case x == 0:
+		return p[i-width]
+	}
+	// TR of the last column is the first pixel of the current row, which
+	// is where i-width+1 lands
+	l, t, tl, tr := p[i-1], p[i-width], p[i-width-1], p[i-width+1]
+	switch mode {
+	case 0:
+		return 0xFF000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return average2(average2(l, tr), t)
+	case 6:
+		return average2(l, tl)
+	case 7:
+		return average2(l, t)
+	case 8:
+		return average2(tl, t)
+	case 9:
+		return average2(t, tr)
+	case 10:
+		return average2(average2(l, tl), average2(t, tr))
+	case 11:
+		return selectPredictor(l, t, tl)
+	case 12:
+		return clampAddSubtract(l, t, tl, false)
+	default:
+		return clampAddSubtract(average2(l, t), tl, 0, true)
+	}
+}
+
+func average2(a, b uint32) uint32 {
+	return ((a^b)&0xFEFEFEFE)>>1 + a&b
+}
+
+func selectPredictor(l, t, tl uint32) uint32 {
+	var pl, pt int
+	for shift := 0; shift < 32; shift += 8 {
+		lc, tc, tlc := int(l>>shift&0xFF), int(t>>shift&0xFF), int(tl>>shift&0xFF)
+		p := lc + tc - tlc
+		pl += abs(p - lc)
+		pt += abs(p - tc)
+	}
+	if pl < pt {
+		return l
+	}
+	return t
+}
+
+// clampAddSubtract is ClampAddSubtractFull(a, b, c), or with half set
+// ClampAddSubtractHalf(a, b)
+func clampAddSubtract(a, b, c uint32, half bool) uint32 {
+	var out uint32
+	for shift := 0; shift < 32; shift += 8 {
+		ac, bc, cc := int(a>>shift&0xFF), int(b>>shift&0xFF), int(c>>shift&0xFF)
+		var v int
+		if half {
+			v = ac + (ac-bc)/2
+		} else {
+			v = ac + bc - cc
+		}
+		out |= uint32(min(max(v, 0), 255)) << shift
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// vp8lSymbol is a literal pixel or a backward reference
+type vp8lSymbol struct {
+	pixel            uint32
+	length, distance int // distance is the coded value, 0 for a literal
+}
+
+// writeEntropyImage codes an image with LZ77 and five prefix codes. Only the
+// main image has the meta prefix codes bit.
+func writeEntropyImage(bw *bitWriter, argb []uint32, width int, main bool) {
+	symbols := backwardRefs(argb, width)
+
+	var green [vp8lGreenSize]int
+	var red, blue, alpha [256]int
+	var dist [vp8lDistanceSize]int
+	for _, s := range symbols {
+		if s.distance == 0 {
+			green[s.pixel>>8&0xFF]++
+			red[s.pixel>>16&0xFF]++
+			blue[s.pixel&0xFF]++
+			alpha[s.pixel>>24]++
+			continue
+		}
+		lc, _, _ := prefixEncode(s.length)
+		green[256+lc]++
+		dc, _, _ := prefixEncode(s.distance)
+		dist[dc]++
+	}
+
+	bw.write(0, 1) // no color cache
+	if main {
+		bw.write(0, 1) // no meta prefix codes
+	}
+	codes := [5]*prefixCode{
+		writePrefixCode(bw, green[:]),
+		writePrefixCode(bw, red[:]),
+		writePrefixCode(bw, blue[:]),
+		writePrefixCode(bw, alpha[:]),
+		writePrefixCode(bw, dist[:]),
+	}
+
+	for _, s := range symbols {
+		if s.distance == 0 {
+			codes[0].write(bw, int(s.pixel>>8&0xFF))
+			codes[1].write(bw, int(s.pixel>>16&0xFF))
+			codes[2].write(bw, int(s.pixel&0xFF))
+			codes[3].write(bw, int(s.pixel>>24))
+			continue
+		}
+		lc, n, extra := prefixEncode(s.length)
+		codes[0].write(bw, 256+lc)
+		bw.write(extra, n)
+		dc, n, extra := prefixEncode(s.distance)
+		codes[4].write(bw, dc)
+		bw.write(extra, n)
+	}
+}
+
+// backwardRefs finds repeats with a hash chain over pixel pairs. Distances
+// of one pixel and one row use their short two-dimensional codes.
+func backwardRefs(argb []uint32, width int) []vp8lSymbol {
+	n := len(argb)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (argb[i]*0x1E35A7BD + argb[i+1]*0x9E3779B1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+	matchLen := func(i, j int) int {
+		l := 0
+		for i+l < n && l < vp8lMaxLength && argb[i+l] == argb[j+l] {
+			l++
+		}
+		return l
+	}
+
+	var out []vp8lSymbol
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		for _, d := range []int{1, width} {
+			if d <= i {
+				if l := matchLen(i, i-d); l > bestLen {
+					bestLen, bestDist = l, d
+				}
+			}
+		}
+		if i+1 < n {
+			j := head[hash(i)]
+			for depth := 0; j >= 0 && depth < vp8lChainDepth; depth++ {
+				d := i - int(j)
+				if d > vp8lMaxDistance {
+					break
+				}
+				if l := matchLen(i, int(j)); l > bestLen {
+					bestLen, bestDist = l, d
+				}
+				j = chain[j]
+			}
+		}
+
+		if bestLen < 3 {
+			out = append(out, vp8lSymbol{pixel: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		code := bestDist + 120
+		switch bestDist {
+		case width:
+			code = 1 // (0, 1)
+		case 1:
+			code = 2 // (1, 0)
+		}
+		out = append(out, vp8lSymbol{length: bestLen, distance: code})
+		for k := 0; k < bestLen; k++ {
+			insert(i + k)
+		}
+		i += bestLen
+	}
+	return out
+}
+
+// prefixEncode splits a length or distance value (from 1) into its prefix
+// symbol and extra bits
+func prefixEncode(v int) (code int, nbits uint, extra uint32) {
+	d := v - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	h := bits.Len(uint(d)) - 1
+	second := d >> (h - 1) & 1
+	nbits = uint(h - 1)
+	return 2*h + second, nbits, uint32(d) & (1<<nbits - 1)
+}
+
+// prefixCode is a canonical prefix code, with codes stored bit reversed as
+// they are written least significant bit first
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c *prefixCode) write(bw *bitWriter, sym int) {
+	bw.write(c.codes[sym], uint(c.lengths[sym]))
+}
+
+// writePrefixCode builds a code from the histogram and writes it, as a
+// simple code when at most two symbols below 256 are used
+func writePrefixCode(bw *bitWriter, hist []int) *prefixCode {
+	var used []int
+	for sym, n := range hist {
+		if n > 0 {
+			used = append(used, sym)
+		}
+	}
+
+	code := &prefixCode{lengths: make([]int, len(hist)), codes: make([]uint32, len(hist))}
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1) // simple
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	code.lengths = huffmanLengths(hist, 15)
+	code.codes = canonicalCodes(code.lengths)
+
+	bw.write(0, 1) // normal
+	writeCodeLengths(bw, code.lengths)
+	return code
+}
+
+// writeCodeLengths writes code lengths with the code length code, using the
+// repeat symbols 16 (previous length), 17 and 18 (zeros)
+func writeCodeLengths(bw *bitWriter, lengths []int) {
+	type token struct{ sym, extra int }
+	var tokens []token
+	prev := 8
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+		switch {
+		case l == 0:
+			for run >= 11 {
+				n := min(run, 138)
+				tokens = append(tokens, token{18, n - 11})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, run - 3})
+				run = 0
+			}
+		default:
+			if l != prev {
+				tokens = append(tokens, token{l, 0})
+				run--
+				prev = l
+			}
+			for run >= 3 {
+				n := min(run, 6)
+				tokens = append(tokens, token{16, n - 3})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{l, 0})
+		}
+	}
+
+	var hist [19]int
+	for _, t := range tokens {
+		hist[t.sym]++
+	}
+	// A code needs two symbols to be a complete tree
+	used := 0
+	for _, n := range hist {
+		if n > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		for sym := range hist {
+			if hist[sym] == 0 {
+				hist[sym] = 1
+				break
+			}
+		}
+	}
+	clLengths := huffmanLengths(hist[:], 7)
+	clCodes := canonicalCodes(clLengths)
+
+	count := 19
+	for count > 4 && clLengths[vp8lCodeLengthOrder[count-1]] == 0 {
+		count--
+	}
+	bw.write(uint32(count-4), 4)
+	for _, sym := range vp8lCodeLengthOrder[:count] {
+		bw.write(uint32(clLengths[sym]), 3)
+	}
+	bw.write(0, 1) // max_symbol is the alphabet size
+
+	for _, t := range tokens {
+		bw.write(clCodes[t.sym], uint(clLengths[t.sym]))
+		switch t.sym {
+		case 16:
+			bw.write(uint32(t.extra), 2)
+		case 17:
+			bw.write(uint32(t.extra), 3)
+		case 18:
+			bw.write(uint32(t.extra), 7)
+		}
+	}
+}
+
+// huffmanLengths returns Huffman code lengths no longer than limit. When
+// the tree is too deep the counts are flattened and it is built again.
+func huffmanLengths(hist []int, limit int) []int {
+	counts := append([]int(nil), hist...)
+	for {
+		lengths := buildHuffman(counts)
+		longest := 0
+		for _, l := range lengths {
+			longest = max(longest, l)
+		}
+		if longest <= limit {
+			return lengths
+		}
+		for i, n := range counts {
+			if n > 0 {
+				counts[i] = (n + 1) / 2
+			}
+		}
+	}
+}
+
+type huffNode struct {
+	count       int
+	sym         int // -1 for internal nodes
+	left, right *huffNode
+}
+
+type huffHeap []*huffNode
+
+func (h huffHeap) Len() int { return len(h) }
+func (h huffHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].sym > h[j].sym
+}
+func (h huffHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffHeap) Push(x any)   { *h = append(*h, x.(*huffNode)) }
+func (h *huffHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func buildHuffman(counts []int) []int {
+	lengths := make([]int, len(counts))
+	h := &huffHeap{}
+	for sym, n := range counts {
+		if n > 0 {
+			*h = append(*h, &huffNode{count: n, sym: sym})
+		}
+	}
+	if h.Len() == 1 {
+		lengths[(*h)[0].sym] = 1
+		return lengths
+	}
+	heap.Init(h)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(*huffNode)
+		b := heap.Pop(h).(*huffNode)
+		heap.Push(h, &huffNode{count: a.count + b.count, sym: -1, left: a, right: b})
+	}
+	var walk func(n *huffNode, depth int)
+	walk = func(n *huffNode, depth int) {
+		if n.sym >= 0 {
+			lengths[n.sym] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	if h.Len() == 1 {
+		walk((*h)[0], 0)
+	}
+	return lengths
+}
+
+// canonicalCodes assigns codes in order of length then symbol, bit reversed
+func canonicalCodes(lengths []int) []uint32 {
+	syms := make([]int, 0, len(lengths))
+	for sym, l := range lengths {
+		if l > 0 {
+			syms = append(syms, sym)
+		}
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return lengths[syms[i]] < lengths[syms[j]] })
+
+	codes := make([]uint32, len(lengths))
+	code, prevLen := uint32(0), 0
+	for _, sym := range syms {
+		l := lengths[sym]
+		code <<= uint(l - prevLen)
+		prevLen = l
+		codes[sym] = bits.Reverse32(code) >> (32 - uint(l))
+		code++
+	}
+	return codes
+}
+
+// bitWriter packs bits least significant first
+type bitWriter struct {
+	buf   bytes.Buffer
+	acc   uint64
+	nbits uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v&(1<<n-1)) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf.WriteByte(byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf.WriteByte(byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+	return bw.buf.Bytes()
+}
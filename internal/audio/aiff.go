@@ -0,0 +1,272 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// aiffTextChunks are the text chunks kept as tags
+var aiffTextChunks = map[string]bool{
+	"NAME": true,
+	"AUTH": true,
+	"(c) ": true,
+	"ANNO": true,
+}
+
+// aiffComm is the COMM chunk
+type aiffComm struct {
+	channels    int
+	frames      int64
+	sampleSize  int
+	sampleRate  float64
+	compression string // AIFF-C compression type, "NONE" for plain AIFF
+}
+
+func parseAIFF(r io.ReaderAt, size int64) (*Info, error) {
+	hdr, err := readAt(r, 0, 12)
+	if err != nil {
+		return nil, truncated("aiff", 0, "FORM header: %v", err)
+	}
+
+	aifc := string(hdr[8:12]) == "AIFC"
+	format := "aiff"
+	if aifc {
+		format = "aifc"
+	}
+	info := &Info{Format: format, Tags: map[string]string{}}
+
+	formSize := int64(be.Uint32(hdr[4:8]))
+	end := 8 + formSize
+	if end > size {
+		if end-size > 1 {
+			return nil, truncated(format, 4, "FORM header declares %d bytes but the file has %d", end, size)
+		}
+		end = size
+	}
+	if formSize < 4 {
+		return nil, malformed(format, 4, "FORM size %d is too small", formSize)
+	}
+
+	var (
+		comm      *aiffComm
+		commOff   int64
+		soundOff  int64 = -1
+		soundSize int64
+	)
+
+	off := int64(12)
+	for off+8 <= end {
+		ch, err := readAt(r, off, 8)
+		if err != nil {
+			return nil, truncated(format, off, "chunk header: %v", err)
+		}
+		id := string(ch[:4])
+		csize := int64(be.Uint32(ch[4:8]))
+		body := off + 8
+
+		if body+csize > end {
+			return nil, truncated(format, off, "chunk %q declares %d bytes but only %d remain", id, csize, end-body)
+		}
+
+		switch {
+		case id == "COMM":
+			if comm != nil {
+				return nil, malformed(format, off, "duplicate COMM chunk")
+			}
+			commOff = off
+			if comm, err = parseAIFFComm(r, body, csize, aifc); err != nil {
+				return nil, err
+			}
+		case id == "SSND":
+			if soundOff >= 0 {
+				return nil, malformed(format, off, "duplicate SSND chunk")
+			}
+			if csize < 8 {
+				return nil, malformed(format, off, "SSND chunk is %d bytes, need at least 8", csize)
+			}
+			b, err := readAt(r, body, 8)
+			if err != nil {
+				return nil, truncated(format, body, "SSND chunk: %v", err)
+			}
+			dataOffset := int64(be.Uint32(b[0:4]))
+			if 8+dataOffset > csize {
+				return nil, malformed(format, body, "SSND data offset %d lies beyond the %d byte chunk", dataOffset, csize)
+			}
+			soundOff = body + 8 + dataOffset
+			soundSize = csize - 8 - dataOffset
+		case id == "ID3 ":
+			if _, err := parseID3v2(r, body, body+csize, info); err != nil {
+				return nil, err
+			}
+		case aiffTextChunks[id]:
+			v, err := readAt(r, body, int(min(csize, maxTextSize)))
+			if err != nil {
+				return nil, truncated(format, body, "%q chunk: %v", id, err)
+			}
+			if s := cString(v); s != "" {
+				// ANNO may repeat
+				if prev, ok := info.Tags[id]; ok {
+					s = prev + "\n" + s
+				}
+				info.Tags[id] = s
+			}
+		}
+
+		off = body + csize + csize&1
+	}
+
+	if comm == nil {
+		return nil, malformed(format, 12, "no COMM chunk")
+	}
+	if soundOff < 0 {
+		if comm.frames > 0 {
+			return nil, malformed(format, 12, "COMM declares %d frames but there is no SSND chunk", comm.frames)
+		}
+		soundOff, soundSize = end, 0
+	}
+
+	info.SampleRate = int(math.Round(comm.sampleRate))
+	info.Channels = comm.channels
+	info.Layout = ChannelLayout(comm.channels, 0)
+	info.DataOffset = soundOff
+	info.DataSize = soundSize
+	info.Codec = aiffCodec(comm)
+
+	frameBytes := int64(0)
+	switch strings.ToUpper(comm.compression) {
+	case "NONE", "SOWT", "TWOS", "RAW ", "IN24", "IN32", "42NI", "23NI":
+		info.BitDepth = comm.sampleSize
+		frameBytes = int64(comm.channels * ((comm.sampleSize + 7) / 8))
+	case "FL32":
+		info.BitDepth = 32
+		frameBytes = int64(comm.channels * 4)
+	case "FL64":
+		info.BitDepth = 64
+		frameBytes = int64(comm.channels * 8)
+	case "ALAW", "ULAW":
+		frameBytes = int64(comm.channels)
+	}
+
+	frames := comm.frames
+	if strings.EqualFold(comm.compression, "ima4") {
+		// COMM counts 34 byte packets of 64 samples per channel
+		frames *= 64
+	}
+	info.setFrames(frames)
+
+	if frameBytes > 0 {
+		if need := comm.frames * frameBytes; need > soundSize {
+			return nil, truncated(format, commOff, "COMM declares %d frames (%d bytes) but SSND holds %d bytes",
+				comm.frames, need, soundSize)
+		}
+		info.Bitrate = info.SampleRate * int(frameBytes) * 8
+	} else if info.Duration > 0 {
+		info.Bitrate = int(float64(soundSize*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+func parseAIFFComm(r io.ReaderAt, off, size int64, aifc bool) (*aiffComm, error) {
+	format := "aiff"
+	need := int64(18)
+	if aifc {
+		format = "aifc"
+		need = 22
+	}
+	if size < need {
+		return nil, malformed(format, off-8, "COMM chunk is %d bytes, need at least %d", size, need)
+	}
+
+	b, err := readAt(r, off, int(min(size, 18+4+256)))
+	if err != nil {
+		return nil, truncated(format, off, "COMM chunk: %v", err)
+	}
+
+	c := &aiffComm{
+		channels:    int(be.Uint16(b[0:2])),
+		frames:      int64(be.Uint32(b[2:6])),
+		sampleSize:  int(be.Uint16(b[6:8])),
+		sampleRate:  extendedToFloat(b[8:18]),
+		compression: "NONE",
+	}
+	if aifc {
+		c.compression = string(b[18:22])
+	}
+
+	if c.channels == 0 {
+		return nil, malformed(format, off, "zero channels")
+	}
+	if math.IsNaN(c.sampleRate) || c.sampleRate < 1 || c.sampleRate > math.MaxUint32 {
+		return nil, malformed(format, off+8, "invalid sample rate %v", c.sampleRate)
+	}
+	if c.compression == "NONE" || c.compression == "twos" || c.compression == "sowt" {
+		if c.sampleSize < 1 || c.sampleSize > 32 {
+			return nil, malformed(format, off+6, "unsupported PCM sample size of %d bits", c.sampleSize)
+		}
+	}
+	return c, nil
+}
+
+func aiffCodec(c *aiffComm) string {
+	bits := (c.sampleSize + 7) / 8 * 8
+	switch c.compression {
+	case "NONE", "twos":
+		if bits == 8 {
+			return "pcm_s8"
+		}
+		return fmt.Sprintf("pcm_s%dbe", bits)
+	case "sowt":
+		if bits == 8 {
+			return "pcm_s8"
+		}
+		return fmt.Sprintf("pcm_s%dle", bits)
+	case "raw ":
+		return "pcm_u8"
+	case "in24":
+		return "pcm_s24be"
+	case "42ni":
+		return "pcm_s24le"
+	case "in32":
+		return "pcm_s32be"
+	case "23ni":
+		return "pcm_s32le"
+	case "fl32", "FL32":
+		return "pcm_f32be"
+	case "fl64", "FL64":
+		return "pcm_f64be"
+	case "alaw", "ALAW":
+		return "pcm_alaw"
+	case "ulaw", "ULAW":
+		return "pcm_mulaw"
+	case "ima4":
+		return "adpcm_ima_qt"
+	}
+	return strings.TrimSpace(strings.ToLower(c.compression))
+}
+
+// extendedToFloat converts an 80-bit IEEE 754 extended precision number
+// (the AIFF sample rate) to a float64
+func extendedToFloat(b []byte) float64 {
+	sign := b[0] >> 7
+	exp := int(be.Uint16(b[0:2]) & 0x7FFF)
+	mant := be.Uint64(b[2:10])
+
+	var f float64
+	switch {
+	case exp == 0 && mant == 0:
+		f = 0
+	case exp == 0x7FFF:
+		f = math.Inf(1)
+		if mant<<1 != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(float64(mant), exp-16383-63)
+	}
+	if sign != 0 {
+		f = -f
+	}
+	return f
+}
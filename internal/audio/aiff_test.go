@@ -0,0 +1,185 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// aiffChunk returns an IFF chunk, padded to an even length
+func aiffChunk(id string, body []byte) []byte {
+	b := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// aiffFile returns a FORM of the given type ("AIFF" or "AIFC") holding the chunks
+func aiffFile(form string, chunks ...[]byte) []byte {
+	body := []byte(form)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return aiffChunk("FORM", body)
+}
+
+// extended encodes a positive number as an 80-bit IEEE 754 extended float
+func extended(v float64) []byte {
+	frac, exp := math.Frexp(v) // v = frac * 2^exp, frac in [0.5, 1)
+	b := binary.BigEndian.AppendUint16(nil, uint16(exp-1+16383))
+	return binary.BigEndian.AppendUint64(b, uint64(math.Ldexp(frac, 64)))
+}
+
+// aiffCommChunk returns a COMM chunk; a compression type makes it AIFC's
+func aiffCommChunk(channels, frames, sampleSize int, sampleRate float64, compression string) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(channels))
+	b = binary.BigEndian.AppendUint32(b, uint32(frames))
+	b = binary.BigEndian.AppendUint16(b, uint16(sampleSize))
+	b = append(b, extended(sampleRate)...)
+	if compression != "" {
+		b = append(b, compression...)
+		b = append(b, 0, 0) // empty Pascal string name, padded
+	}
+	return aiffChunk("COMM", b)
+}
+
+// aiffSoundChunk returns an SSND chunk with no offset
+func aiffSoundChunk(samples []byte) []byte {
+	return aiffChunk("SSND", append(make([]byte, 8), samples...))
+}
+
+func TestProbeAIFF(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "16-bit stereo with text chunks",
+			data: aiffFile("AIFF",
+				aiffChunk("NAME", []byte("Song")),
+				aiffCommChunk(2, 100, 16, 44100, ""),
+				aiffChunk("ANNO", []byte("first")),
+				aiffChunk("ANNO", []byte("second")),
+				aiffSoundChunk(make([]byte, 400))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Format != "aiff" || info.Codec != "pcm_s16be" || info.SampleRate != 44100 || info.Channels != 2 ||
+					info.BitDepth != 16 || info.Frames != 100 || info.Layout != "stereo" {
+					t.Fatalf("%+v, want 100 frames of 16-bit stereo big-endian PCM at 44.1 kHz", info)
+				}
+				if info.DataSize != 400 || info.Bitrate != 44100*4*8 {
+					t.Fatalf("%d bytes at %d bps, want 400 at %d", info.DataSize, info.Bitrate, 44100*4*8)
+				}
+				if info.Tags["NAME"] != "Song" || info.Tags["ANNO"] != "first\nsecond" {
+					t.Fatalf("tags %v, want NAME Song and both annotations", info.Tags)
+				}
+			},
+		},
+		{
+			name: "SSND offset skips the padding",
+			data: aiffFile("AIFF",
+				aiffCommChunk(1, 4, 8, 8000, ""),
+				aiffChunk("SSND", append([]byte{0, 0, 0, 4, 0, 0, 0, 0, 9, 9, 9, 9}, 1, 2, 3, 4))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "pcm_s8" || info.DataOffset != 12+8+18+8+8+4 || info.DataSize != 4 {
+					t.Fatalf("%s at [%d, +%d), want pcm_s8 at [58, +4)", info.Codec, info.DataOffset, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "little-endian AIFC",
+			data: aiffFile("AIFC", aiffCommChunk(1, 4, 16, 48000, "sowt"), aiffSoundChunk(make([]byte, 8))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Format != "aifc" || info.Codec != "pcm_s16le" || info.Frames != 4 || info.SampleRate != 48000 {
+					t.Fatalf("%s %s with %d frames at %d Hz, want aifc pcm_s16le with 4 at 48000", info.Format, info.Codec, info.Frames, info.SampleRate)
+				}
+			},
+		},
+		{
+			name: "float AIFC",
+			data: aiffFile("AIFC", aiffCommChunk(2, 2, 32, 96000, "fl32"), aiffSoundChunk(make([]byte, 16))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "pcm_f32be" || info.BitDepth != 32 || info.Frames != 2 {
+					t.Fatalf("%s of %d bits with %d frames, want pcm_f32be of 32 with 2", info.Codec, info.BitDepth, info.Frames)
+				}
+			},
+		},
+		{
+			name: "IMA ADPCM counts packets",
+			data: aiffFile("AIFC", aiffCommChunk(1, 10, 16, 22050, "ima4"), aiffSoundChunk(make([]byte, 340))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "adpcm_ima_qt" || info.Frames != 640 || info.BitDepth != 0 || info.Bitrate == 0 {
+					t.Fatalf("%s with %d frames of %d bits at %d bps, want adpcm_ima_qt with 640 and a bitrate",
+						info.Codec, info.Frames, info.BitDepth, info.Bitrate)
+				}
+			},
+		},
+		{
+			name: "no sound data and no frames",
+			data: aiffFile("AIFF", aiffCommChunk(2, 0, 16, 44100, "")),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 0 || info.DataSize != 0 {
+					t.Fatalf("%d frames in %d bytes, want none", info.Frames, info.DataSize)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestProbeAIFFInvalid(t *testing.T) {
+	comm := aiffCommChunk(2, 4, 16, 44100, "")
+	sound := aiffSoundChunk(make([]byte, 16))
+	nan := aiffChunk("COMM", append([]byte{0, 1, 0, 0, 0, 4, 0, 16}, 0x7F, 0xFF, 0xC0, 0, 0, 0, 0, 0, 0, 0))
+
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		want   error
+	}{
+		{"header only", "aiff", []byte("FORM\x00\x00\x01\x00AIFF"), audio.ErrTruncated},
+		{"sound shorter than COMM says", "aiff", aiffFile("AIFF", aiffCommChunk(2, 5, 16, 44100, ""), sound), audio.ErrTruncated},
+		{"chunk overruns the form", "aiff", aiffFile("AIFF", comm, []byte("SSND\x00\x00\x01\x00")), audio.ErrTruncated},
+		{"FORM size too small", "aiff", []byte("FORM\x00\x00\x00\x02AIFF"), audio.ErrMalformed},
+		{"no COMM", "aiff", aiffFile("AIFF", sound), audio.ErrMalformed},
+		{"duplicate COMM", "aiff", aiffFile("AIFF", comm, comm, sound), audio.ErrMalformed},
+		{"duplicate SSND", "aiff", aiffFile("AIFF", comm, sound, sound), audio.ErrMalformed},
+		{"frames without SSND", "aiff", aiffFile("AIFF", comm), audio.ErrMalformed},
+		{"short COMM", "aiff", aiffFile("AIFF", aiffChunk("COMM", make([]byte, 10)), sound), audio.ErrMalformed},
+		{"short AIFC COMM", "aifc", aiffFile("AIFC", comm, sound), audio.ErrMalformed},
+		{"zero channels", "aiff", aiffFile("AIFF", aiffCommChunk(0, 4, 16, 44100, ""), sound), audio.ErrMalformed},
+		{"zero sample size", "aiff", aiffFile("AIFF", aiffCommChunk(2, 4, 0, 44100, ""), sound), audio.ErrMalformed},
+		{"NaN sample rate", "aiff", aiffFile("AIFF", nan, sound), audio.ErrMalformed},
+		{"sample rate beyond 32 bits", "aiff", aiffFile("AIFF", aiffCommChunk(2, 4, 16, 1e300, ""), sound), audio.ErrMalformed},
+		{"sub-hertz sample rate", "aiff", aiffFile("AIFF", aiffCommChunk(2, 4, 16, 0.5, ""), sound), audio.ErrMalformed},
+		{"SSND offset past the chunk", "aiff", aiffFile("AIFF", comm, aiffChunk("SSND", []byte{0, 0, 0, 9, 0, 0, 0, 0})), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, tt.format, tt.want)
+		})
+	}
+}
+
+func TestProbeAIFFTruncated(t *testing.T) {
+	data := aiffFile("AIFC", aiffCommChunk(2, 32, 16, 44100, "NONE"), aiffChunk("NAME", []byte("Song")), aiffSoundChunk(make([]byte, 128)))
+	for n := 12; n < len(data); n++ {
+		if _, err := probe(data[:n]); !errors.Is(err, audio.ErrTruncated) {
+			t.Fatalf("cut to %d of %d bytes: error %v, want ErrTruncated", n, len(data), err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package audio inspects audio files: it identifies the container, validates
+// its structure and extracts stream properties and embedded metadata.
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnknownFormat is returned by Probe for data it does not recognize
+	ErrUnknownFormat = errors.New("unrecognized audio format")
+
+	// ParseError causes: the structure is invalid, or the file ends early
+	ErrMalformed = errors.New("malformed file")
+	ErrTruncated = errors.New("truncated file")
+)
+
+// ParseError describes why a recognized file was rejected
+type ParseError struct {
+	Format string // container being parsed, e.g. "wav"
+	Offset int64  // byte offset of the offending structure
+	Err    error  // ErrMalformed, ErrTruncated or ErrCorrupt
+	Msg    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v at offset %d: %s", e.Format, e.Err, e.Offset, e.Msg)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func malformed(format string, offset int64, msg string, args ...any) error {
+	return &ParseError{Format: format, Offset: offset, Err: ErrMalformed, Msg: fmt.Sprintf(msg, args...)}
+}
+
+func truncated(format string, offset int64, msg string, args ...any) error {
+	return &ParseError{Format: format, Offset: offset, Err: ErrTruncated, Msg: fmt.Sprintf(msg, args...)}
+}
+
+// Info describes an audio file
+type Info struct {
+	Format      string        `json:"format"` // container, e.g. "wav", "rf64", "aiff", "ogg", "mp4"
+	Codec       string        `json:"codec"`  // e.g. "pcm_s16le", "pcm_f32be", "pcm_alaw", "opus"
+	SampleRate  int           `json:"sample_rate"`
+	BitDepth    int           `json:"bit_depth,omitempty"` // bits per sample, 0 for lossy codecs
+	Channels    int           `json:"channels"`
+	ChannelMask uint32        `json:"channel_mask,omitempty"` // WAVE_FORMAT_EXTENSIBLE speaker bits
+	Layout      string        `json:"channel_layout"`
+	Frames      int64         `json:"frames"` // samples per channel
+	Duration    time.Duration `json:"duration"`
+	Bitrate     int           `json:"bitrate"` // bits per second, averaged for VBR
+
+	// Lossy streams
+	BitrateMode    string `json:"bitrate_mode,omitempty"` // "CBR", "VBR" or "ABR"
+	Encoder        string `json:"encoder,omitempty"`
+	EncoderDelay   int    `json:"encoder_delay,omitempty"`   // priming samples to skip for gapless playback
+	EncoderPadding int    `json:"encoder_padding,omitempty"` // samples to drop at the end
+
+	// Opus streams always decode at 48 kHz
+	InputSampleRate int     `json:"input_sample_rate,omitempty"` // of the audio before encoding
+	OutputGain      float64 `json:"output_gain,omitempty"`       // dB to apply on playback
+
+	// MP4
+	Brand      string `json:"brand,omitempty"`      // ftyp major brand, e.g. "M4A"
+	Fragmented bool   `json:"fragmented,omitempty"` // audio is in movie fragments
+
+	// Location of the encoded audio within the file
+	DataOffset int64 `json:"-"`
+	DataSize   int64 `json:"-"`
+
+	// Tags holds text metadata keyed by the container's own field names
+	// (e.g. "INAM" for RIFF INFO, "NAME" for AIFF)
+	Tags      map[string]string   `json:"tags,omitempty"`
+	Pictures  []Picture           `json:"pictures,omitempty"`
+	Chapters  []Chapter           `json:"chapters,omitempty"`
+	Broadcast *BroadcastExtension `json:"broadcast,omitempty"`
+
+	// Lossless streams
+	MD5       string      `json:"md5,omitempty"`       // of the decoded audio, as stored by the encoder
+	Integrity string      `json:"integrity,omitempty"` // one of the Integrity constants
+	SeekTable []SeekPoint `json:"seek_table,omitempty"`
+	CueSheet  *CueSheet   `json:"cue_sheet,omitempty"`
+
+	flac *flacStreamParams // set for FLAC, needed to decode
+}
+
+// setFrames sets the frame count and the exact duration derived from it
+func (info *Info) setFrames(frames int64) {
+	info.Frames = frames
+	info.Duration = FramesDuration(frames, info.SampleRate)
+}
+
+// DurationMs returns the duration in whole milliseconds
+func (info *Info) DurationMs() int64 {
+	return info.Duration.Milliseconds()
+}
+
+// FramesDuration converts a frame count to a duration without overflowing
+// for long files at high sample rates
+func FramesDuration(frames int64, sampleRate int) time.Duration {
+	if sampleRate <= 0 || frames <= 0 {
+		return 0
+	}
+	sr := int64(sampleRate)
+	return time.Duration(frames/sr)*time.Second + time.Duration(frames%sr*int64(time.Second)/sr)
+}
+
+// Probe identifies the file and parses it. size is the length of the file.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	magic := make([]byte, 12)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case n >= 12 && isRIFF(magic):
+		return parseWAV(r, size)
+	case n >= 12 && string(magic[:4]) == "FORM" && (string(magic[8:12]) == "AIFF" || string(magic[8:12]) == "AIFC"):
+		return parseAIFF(r, size)
+	case n >= 4 && string(magic[:4]) == "OggS":
+		return parseOgg(r, size)
+	case isMP4(magic):
+		return parseMP4(r, size)
+	}
+
+	// An ID3v2 tag may precede an MPEG or FLAC stream
+	start := int64(0)
+	if tagLen, ok := id3v2Length(magic); ok {
+		start = tagLen
+	}
+	if start > size {
+		return nil, truncated("id3", 0, "ID3v2 tag declares %d bytes but the file has %d", start, size)
+	}
+
+	if b, err := readAt(r, start, 4); err == nil && string(b) == "fLaC" {
+		var info *Info
+		if start > 0 {
+			info = &Info{}
+			if _, err := parseID3v2(r, 0, size, info); err != nil {
+				return nil, err
+			}
+		}
+		return parseFLAC(r, size, start, info)
+	}
+	if isMPEGStream(r, start, size) || (start > 0 && hasMPEGFrame(r, start, size)) {
+		return parseMP3(r, size)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// maxTextSize caps how much of a text field is read
+const maxTextSize = 64 << 10
+
+// readAt reads exactly n bytes at off, reporting a short file as io.ErrUnexpectedEOF
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	m, err := r.ReadAt(buf, off)
+	if m == n {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf[:m], err
+}
+
+var (
+	le = binary.LittleEndian
+	be = binary.BigEndian
+)
+
+// cString returns b up to the first NUL, without surrounding spaces
+func cString(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Speaker positions used in channel masks (WAVE_FORMAT_EXTENSIBLE dwChannelMask)
+const (
+	SpeakerFrontLeft          = 0x1
+	SpeakerFrontRight         = 0x2
+	SpeakerFrontCenter        = 0x4
+	SpeakerLowFrequency       = 0x8
+	SpeakerBackLeft           = 0x10
+	SpeakerBackRight          = 0x20
+	SpeakerFrontLeftOfCenter  = 0x40
+	SpeakerFrontRightOfCenter = 0x80
+	SpeakerBackCenter         = 0x100
+	SpeakerSideLeft           = 0x200
+	SpeakerSideRight          = 0x400
+)
+
+// channelLayouts names the common speaker masks
+var channelLayouts = map[uint32]string{
+	SpeakerFrontCenter:                                                            "mono",
+	SpeakerFrontLeft | SpeakerFrontRight:                                          "stereo",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerLowFrequency:                    "2.1",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter:                     "3.0",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerBackLeft | SpeakerBackRight:     "quad",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerBackCenter: "4.0",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+		SpeakerBackLeft | SpeakerBackRight: "5.1(back)",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+		SpeakerSideLeft | SpeakerSideRight: "5.1",
+	SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+		SpeakerBackLeft | SpeakerBackRight | SpeakerSideLeft | SpeakerSideRight: "7.1",
+}
+
+// defaultMasks is the layout assumed when a file only gives a channel count
+var defaultMasks = map[int]uint32{
+	1: SpeakerFrontCenter,
+	2: SpeakerFrontLeft | SpeakerFrontRight,
+	3: SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter,
+	4: SpeakerFrontLeft | SpeakerFrontRight | SpeakerBackLeft | SpeakerBackRight,
+	6: SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency | SpeakerSideLeft | SpeakerSideRight,
+	8: SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+		SpeakerBackLeft | SpeakerBackRight | SpeakerSideLeft | SpeakerSideRight,
+}
+
+// DefaultChannelMask returns the speaker mask assumed for a channel count,
+// or 0 if there is none
+func DefaultChannelMask(channels int) uint32 {
+	return defaultMasks[channels]
+}
+
+// ChannelLayout names the layout of the given channel count and speaker
+// mask. A zero mask means the default layout for the count.
+func ChannelLayout(channels int, mask uint32) string {
+	if mask == 0 {
+		mask = defaultMasks[channels]
+	}
+	if name, ok := channelLayouts[mask]; ok && bits.OnesCount32(mask) == channels {
+		return name
+	}
+	return fmt.Sprintf("%d channels", channels)
+}
@@ -0,0 +1,173 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// sineSource is a 1 kHz sine at half scale on every channel
+type sineSource struct {
+	format pcm.Format
+	pos    int64
+}
+
+func newSine(sampleRate, channels int, frames int64) *sineSource {
+	return &sineSource{format: pcm.Format{SampleRate: sampleRate, Channels: channels, Frames: frames}}
+}
+
+func (s *sineSource) Format() pcm.Format { return s.format }
+
+func (s *sineSource) ReadFrames(buf []float32) (int, error) {
+	n := 0
+	for ; (n+1)*s.format.Channels <= len(buf) && s.pos < s.format.Frames; n++ {
+		v := float32(0.5 * math.Sin(2*math.Pi*1000*float64(s.pos)/float64(s.format.SampleRate)))
+		for c := 0; c < s.format.Channels; c++ {
+			buf[n*s.format.Channels+c] = v
+		}
+		s.pos++
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// encodeWAV returns a sine of the given shape as a WAV file
+func encodeWAV(t testing.TB, sampleRate, channels int, frames int64, opts pcm.WAVOptions) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := pcm.EncodeWAV(&buf, newSine(sampleRate, channels, frames), opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func probe(b []byte) (*audio.Info, error) {
+	return audio.Probe(bytes.NewReader(b), int64(len(b)))
+}
+
+// checkParseError fails unless err is a ParseError of the format with the cause
+func checkParseError(t *testing.T, err error, format string, cause error) {
+	t.Helper()
+	var pe *audio.ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("error = %v, want a %s ParseError", err, format)
+	}
+	if pe.Format != format || !errors.Is(err, cause) {
+		t.Fatalf("error = %v, want %s with %v", err, format, cause)
+	}
+}
+
+func TestProbeUnknown(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", []byte("RI")},
+		{"text", []byte("hello, this is not audio at all")},
+		{"riff of another kind", append([]byte("RIFF\x04\x00\x00\x00AVI "), make([]byte, 64)...)},
+		{"zeros", make([]byte, 4096)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if info, err := probe(tt.data); !errors.Is(err, audio.ErrUnknownFormat) {
+				t.Fatalf("Probe = %+v, %v, want ErrUnknownFormat", info, err)
+			}
+		})
+	}
+}
+
+func TestFramesDuration(t *testing.T) {
+	tests := []struct {
+		frames     int64
+		sampleRate int
+		want       time.Duration
+	}{
+		{0, 44100, 0},
+		{44100, 0, 0},
+		{-1, 44100, 0},
+		{44100, 44100, time.Second},
+		{1, 48000, 20833 * time.Nanosecond},
+		{22050, 44100, 500 * time.Millisecond},
+		// Ten days at 384 kHz, where frames*time.Second overflows
+		{10 * 24 * 3600 * 384000, 384000, 10 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := audio.FramesDuration(tt.frames, tt.sampleRate); got != tt.want {
+			t.Errorf("FramesDuration(%d, %d) = %v, want %v", tt.frames, tt.sampleRate, got, tt.want)
+		}
+	}
+}
+
+func TestChannelLayout(t *testing.T) {
+	tests := []struct {
+		channels int
+		mask     uint32
+		want     string
+	}{
+		{1, 0, "mono"},
+		{2, 0, "stereo"},
+		{6, 0, "5.1"},
+		{8, 0, "7.1"},
+		{5, 0, "5 channels"},
+		{6, 0x3F, "5.1(back)"},
+		{3, audio.SpeakerFrontLeft | audio.SpeakerFrontRight | audio.SpeakerLowFrequency, "2.1"},
+		// A mask naming fewer speakers than there are channels is not the layout
+		{4, audio.SpeakerFrontLeft | audio.SpeakerFrontRight, "4 channels"},
+	}
+	for _, tt := range tests {
+		if got := audio.ChannelLayout(tt.channels, tt.mask); got != tt.want {
+			t.Errorf("ChannelLayout(%d, %#x) = %q, want %q", tt.channels, tt.mask, got, tt.want)
+		}
+	}
+}
+
+// probeSeeds are valid files of every format for the fuzz corpus
+func probeSeeds(t testing.TB) [][]byte {
+	return [][]byte{
+		encodeWAV(t, 8000, 1, 16, pcm.WAVOptions{BitDepth: 16}),
+		encodeWAV(t, 8000, 2, 16, pcm.WAVOptions{BitDepth: 24}),
+		riffFile("WAVE", wavFmtChunk(1, 1, 8000, 8), riffList("INFO", riffChunk("INAM", []byte("Title\x00"))), riffChunk("data", make([]byte, 4))),
+		aiffFile("AIFF", aiffCommChunk(2, 4, 16, 44100, ""), aiffChunk("NAME", []byte("Song")), aiffSoundChunk(make([]byte, 16))),
+		aiffFile("AIFC", aiffCommChunk(1, 4, 16, 48000, "sowt"), aiffSoundChunk(make([]byte, 8))),
+		append(xingFrame(2, 4, 576, 100), mp3Frames(2)...),
+		append(id3v2Tag(3, 0, id3v2TextFrame(3, "TIT2", 1, "Title"), id3v2Frame(3, "COMM", 0, []byte("\x00eng\x00Note"))), mp3Frames(2)...),
+		append(mp3Frames(2), id3v1Tag("Title", "Artist", 1, 17)...),
+		withFLACBlocks(encodeFLAC(t, 8000, 2, 64, 16), flacBlock(4, vorbisComment("v", "TITLE=Song")), flacBlock(3, make([]byte, 18))),
+		vorbisFile().out,
+		opusFile(),
+		m4aFile(false, aacEntry(160000, 128000, ascHE)),
+		m4aFile(true, mp4AudioEntry("alac", 0, 2, 16, 44100), mp4Udta(ilstItem("©nam", 1, []byte("Song")), ilstItem("trkn", 0, []byte{0, 0, 0, 3, 0, 12, 0, 0}))),
+	}
+}
+
+// FuzzProbe checks that Probe never panics and that whatever it accepts
+// points inside the file
+func FuzzProbe(f *testing.F) {
+	for _, seed := range probeSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := probe(data)
+		if err != nil {
+			var pe *audio.ParseError
+			if !errors.Is(err, audio.ErrUnknownFormat) && !errors.As(err, &pe) {
+				t.Fatalf("Probe error %v is neither ErrUnknownFormat nor a ParseError", err)
+			}
+			return
+		}
+		if info.DataOffset < 0 || info.DataSize < 0 || info.DataOffset+info.DataSize > int64(len(data)) {
+			t.Fatalf("audio at [%d, +%d) lies outside the %d byte file", info.DataOffset, info.DataSize, len(data))
+		}
+		if info.Frames < 0 || info.Duration < 0 || info.Channels < 0 || info.SampleRate < 0 {
+			t.Fatalf("negative stream properties in %+v", info)
+		}
+	})
+}
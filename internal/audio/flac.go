@@ -0,0 +1,394 @@
+package audio
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+var errVorbisCommentLength = errors.New("comment length overruns the block")
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacApplication   = 2
+	flacSeekTable     = 3
+	flacVorbisComment = 4
+	flacCueSheet      = 5
+	flacPicture       = 6
+)
+
+// Integrity of the decoded audio, as far as the file lets us check it
+const (
+	IntegrityVerified   = "verified"    // decoded and matched the embedded MD5
+	IntegrityUnverified = "unverified"  // an MD5 is embedded but was not checked
+	IntegrityNoChecksum = "no_checksum" // nothing to check against
+)
+
+// SeekPoint is a FLAC SEEKTABLE entry
+type SeekPoint struct {
+	Sample int64 `json:"sample"` // first sample of the target frame
+	Offset int64 `json:"offset"` // of the target frame, from the first frame
+	Frames int   `json:"frames"` // samples in the target frame
+}
+
+// CueSheet is a FLAC CUESHEET block
+type CueSheet struct {
+	CatalogNumber string     `json:"catalog_number,omitempty"`
+	LeadIn        int64      `json:"lead_in"`
+	IsCD          bool       `json:"is_cd"`
+	Tracks        []CueTrack `json:"tracks"`
+}
+
+// CueTrack is a track of a cue sheet. Offsets are in samples.
+type CueTrack struct {
+	Number  int        `json:"number"` // 170 (CD) or 255 is the lead-out
+	Offset  int64      `json:"offset"`
+	ISRC    string     `json:"isrc,omitempty"`
+	Audio   bool       `json:"audio"`
+	Indices []CueIndex `json:"indices,omitempty"`
+}
+
+// CueIndex is an index point relative to its track's offset
+type CueIndex struct {
+	Number int   `json:"number"`
+	Offset int64 `json:"offset"`
+}
+
+// flacStreamParams is what decoding needs from STREAMINFO
+type flacStreamParams struct {
+	minBlock, maxBlock int
+	sampleRate         int
+	channels           int
+	bitsPerSample      int
+	totalSamples       int64 // 0 if unknown
+	md5                [16]byte
+}
+
+func parseFLAC(r io.ReaderAt, size int64, start int64, info *Info) (*Info, error) {
+	const format = "flac"
+
+	if info == nil {
+		info = &Info{}
+	}
+	info.Format = "flac"
+	info.Codec = "flac"
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+
+	off := start + 4 // "fLaC"
+	var params *flacStreamParams
+	for {
+		h, err := readAt(r, off, 4)
+		if err != nil {
+			return nil, truncated(format, off, "metadata block header: %v", err)
+		}
+		last := h[0]&0x80 != 0
+		blockType := int(h[0] & 0x7F)
+		length := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		body := off + 4
+
+		if body+length > size {
+			return nil, truncated(format, off, "metadata block %d declares %d bytes but only %d remain", blockType, length, size-body)
+		}
+		if params == nil && blockType != flacStreamInfo {
+			return nil, malformed(format, off, "first metadata block is type %d, not STREAMINFO", blockType)
+		}
+
+		switch blockType {
+		case flacStreamInfo:
+			if params != nil {
+				return nil, malformed(format, off, "duplicate STREAMINFO block")
+			}
+			if length < 34 {
+				return nil, malformed(format, off, "STREAMINFO is %d bytes, need 34", length)
+			}
+			b, err := readAt(r, body, 34)
+			if err != nil {
+				return nil, truncated(format, body, "STREAMINFO: %v", err)
+			}
+			if params, err = parseStreamInfo(b, body); err != nil {
+				return nil, err
+			}
+		case flacSeekTable:
+			b, err := readAt(r, body, int(length))
+			if err != nil {
+				return nil, truncated(format, body, "SEEKTABLE: %v", err)
+			}
+			if length%18 != 0 {
+				return nil, malformed(format, off, "SEEKTABLE of %d bytes is not a whole number of points", length)
+			}
+			info.SeekTable = parseSeekTable(b)
+		case flacVorbisComment:
+			b, err := readAt(r, body, int(length))
+			if err != nil {
+				return nil, truncated(format, body, "VORBIS_COMMENT: %v", err)
+			}
+			if err := parseVorbisComment(b, info); err != nil {
+				return nil, malformed(format, body, "VORBIS_COMMENT: %v", err)
+			}
+		case flacCueSheet:
+			b, err := readAt(r, body, int(length))
+			if err != nil {
+				return nil, truncated(format, body, "CUESHEET: %v", err)
+			}
+			if info.CueSheet, err = parseCueSheet(b); err != nil {
+				return nil, malformed(format, body, "CUESHEET: %v", err)
+			}
+		case flacPicture:
+			b, err := readAt(r, body, int(length))
+			if err != nil {
+				return nil, truncated(format, body, "PICTURE: %v", err)
+			}
+			pic, err := parseFLACPicture(b)
+			if err != nil {
+				return nil, malformed(format, body, "PICTURE: %v", err)
+			}
+			info.Pictures = append(info.Pictures, pic)
+		case 127:
+			return nil, malformed(format, off, "invalid metadata block type 127")
+		}
+
+		off = body + length
+		if last {
+			break
+		}
+	}
+
+	// Audio frames follow the metadata, each starting with a sync code
+	sync, err := readAt(r, off, 2)
+	if err != nil {
+		return nil, truncated(format, off, "no audio frames after metadata")
+	}
+	if sync[0] != 0xFF || sync[1]&0xFE != 0xF8 {
+		return nil, malformed(format, off, "no frame sync after metadata")
+	}
+
+	// Some taggers append an ID3v1 tag, which is not part of the stream
+	end := size
+	if size-off >= 128 {
+		if b, err := readAt(r, size-128, 128); err == nil && string(b[:3]) == "TAG" {
+			info.parseID3v1(b)
+			end -= 128
+		}
+	}
+
+	info.SampleRate = params.sampleRate
+	info.Channels = params.channels
+	info.BitDepth = params.bitsPerSample
+	info.Layout = ChannelLayout(params.channels, 0)
+	info.DataOffset = off
+	info.DataSize = end - off
+	info.setFrames(params.totalSamples)
+	if info.Duration > 0 {
+		info.Bitrate = int(float64(info.DataSize*8) / info.Duration.Seconds())
+	}
+
+	info.Integrity = IntegrityNoChecksum
+	if params.md5 != ([16]byte{}) {
+		info.MD5 = hex.EncodeToString(params.md5[:])
+		info.Integrity = IntegrityUnverified
+	}
+	info.flac = params
+	return info, nil
+}
+
+func parseStreamInfo(b []byte, off int64) (*flacStreamParams, error) {
+	const format = "flac"
+
+	p := &flacStreamParams{
+		minBlock: int(be.Uint16(b[0:2])),
+		maxBlock: int(be.Uint16(b[2:4])),
+	}
+	// 20 bits rate, 3 bits channels-1, 5 bits bps-1, 36 bits total samples
+	v := be.Uint64(b[10:18])
+	p.sampleRate = int(v >> 44)
+	p.channels = int(v>>41&0x7) + 1
+	p.bitsPerSample = int(v>>36&0x1F) + 1
+	p.totalSamples = int64(v & 0xFFFFFFFFF)
+	copy(p.md5[:], b[18:34])
+
+	switch {
+	case p.minBlock < 16:
+		return nil, malformed(format, off, "minimum block size %d is below 16", p.minBlock)
+	case p.maxBlock < p.minBlock:
+		return nil, malformed(format, off+2, "maximum block size %d is below the minimum %d", p.maxBlock, p.minBlock)
+	case p.sampleRate == 0:
+		return nil, malformed(format, off+10, "zero sample rate")
+	case p.bitsPerSample < 4:
+		return nil, malformed(format, off+10, "%d bits per sample is below the minimum of 4", p.bitsPerSample)
+	}
+	return p, nil
+}
+
+func parseSeekTable(b []byte) []SeekPoint {
+	var points []SeekPoint
+	for i := 0; i+18 <= len(b); i += 18 {
+		sample := be.Uint64(b[i:])
+		if sample == 0xFFFFFFFFFFFFFFFF {
+			continue // placeholder
+		}
+		points = append(points, SeekPoint{
+			Sample: int64(sample),
+			Offset: int64(be.Uint64(b[i+8:])),
+			Frames: int(be.Uint16(b[i+16:])),
+		})
+	}
+	return points
+}
+
+// parseVorbisComment reads a Vorbis comment block (shared by FLAC, Vorbis
+// and Opus) into info.Tags under upper-cased field names. Repeated fields
+// are joined with "; ". Embedded METADATA_BLOCK_PICTUREs become pictures.
+func parseVorbisComment(b []byte, info *Info) error {
+	next := func() ([]byte, error) {
+		if len(b) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := int64(le.Uint32(b))
+		if n > int64(len(b)-4) {
+			return nil, errVorbisCommentLength
+		}
+		s := b[4 : 4+n]
+		b = b[4+n:]
+		return s, nil
+	}
+
+	vendor, err := next()
+	if err != nil {
+		return err
+	}
+	info.Encoder = string(vendor)
+
+	if len(b) < 4 {
+		return io.ErrUnexpectedEOF
+	}
+	count := int(le.Uint32(b))
+	b = b[4:]
+
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+	for i := 0; i < count; i++ {
+		c, err := next()
+		if err != nil {
+			return err
+		}
+		key, value, ok := strings.Cut(string(c), "=")
+		if !ok || key == "" {
+			continue
+		}
+		key = strings.ToUpper(key)
+
+		if key == "METADATA_BLOCK_PICTURE" {
+			if raw, err := base64.StdEncoding.DecodeString(value); err == nil {
+				if pic, err := parseFLACPicture(raw); err == nil {
+					info.Pictures = append(info.Pictures, pic)
+				}
+			}
+			continue
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		if prev, ok := info.Tags[key]; ok {
+			value = prev + "; " + value
+		}
+		info.Tags[key] = value
+	}
+	return nil
+}
+
+func parseFLACPicture(b []byte) (Picture, error) {
+	var pic Picture
+	next := func(n int) ([]byte, error) {
+		if n > len(b) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		s := b[:n]
+		b = b[n:]
+		return s, nil
+	}
+	u32 := func() (int, error) {
+		s, err := next(4)
+		if err != nil {
+			return 0, err
+		}
+		return int(be.Uint32(s)), nil
+	}
+
+	var err error
+	if pic.Type, err = u32(); err != nil {
+		return pic, err
+	}
+	n, err := u32()
+	if err != nil {
+		return pic, err
+	}
+	mimeType, err := next(n)
+	if err != nil {
+		return pic, err
+	}
+	pic.MIMEType = strings.ToLower(string(mimeType))
+
+	if n, err = u32(); err != nil {
+		return pic, err
+	}
+	desc, err := next(n)
+	if err != nil {
+		return pic, err
+	}
+	pic.Description = string(desc)
+
+	// width, height, depth and palette size
+	if _, err := next(16); err != nil {
+		return pic, err
+	}
+	if n, err = u32(); err != nil {
+		return pic, err
+	}
+	if pic.Data, err = next(n); err != nil {
+		return pic, err
+	}
+	return pic, nil
+}
+
+func parseCueSheet(b []byte) (*CueSheet, error) {
+	if len(b) < 396 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	cs := &CueSheet{
+		CatalogNumber: cString(b[0:128]),
+		LeadIn:        int64(be.Uint64(b[128:136])),
+		IsCD:          b[136]&0x80 != 0,
+	}
+	tracks := int(b[395])
+	b = b[396:]
+
+	for i := 0; i < tracks; i++ {
+		if len(b) < 36 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		t := CueTrack{
+			Offset: int64(be.Uint64(b[0:8])),
+			Number: int(b[8]),
+			ISRC:   cString(b[9:21]),
+			Audio:  b[21]&0x80 == 0,
+		}
+		indices := int(b[35])
+		b = b[36:]
+		for j := 0; j < indices; j++ {
+			if len(b) < 12 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			t.Indices = append(t.Indices, CueIndex{Offset: int64(be.Uint64(b[0:8])), Number: int(b[8])})
+			b = b[12:]
+		}
+		cs.Tracks = append(cs.Tracks, t)
+	}
+	return cs, nil
+}
@@ -0,0 +1,412 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker, so EncodeFLAC completes the
+// STREAMINFO with the sample count and MD5
+type seekBuffer struct {
+	b   []byte
+	pos int
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if end := s.pos + len(p); end > len(s.b) {
+		s.b = append(s.b, make([]byte, end-len(s.b))...)
+	}
+	s.pos += copy(s.b[s.pos:], p)
+	return len(p), nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.pos = int(offset)
+	case io.SeekCurrent:
+		s.pos += int(offset)
+	case io.SeekEnd:
+		s.pos = len(s.b) + int(offset)
+	}
+	return int64(s.pos), nil
+}
+
+// encodeFLAC returns a sine of the given shape as a FLAC file with only a
+// STREAMINFO block, which ends at flacStreamInfoEnd
+func encodeFLAC(t testing.TB, sampleRate, channels int, frames int64, bitDepth int) []byte {
+	t.Helper()
+	var buf seekBuffer
+	if _, err := pcm.EncodeFLAC(&buf, newSine(sampleRate, channels, frames), pcm.FLACOptions{BitDepth: bitDepth}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.b
+}
+
+const flacStreamInfoEnd = 4 + 4 + 34
+
+// flacBlock returns a metadata block, flagged last by withFLACBlocks
+func flacBlock(blockType byte, body []byte) []byte {
+	return append([]byte{blockType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// withFLACBlocks inserts metadata blocks after the STREAMINFO of an encoded file
+func withFLACBlocks(file []byte, blocks ...[]byte) []byte {
+	if len(blocks) == 0 {
+		return file
+	}
+	b := append([]byte{}, file[:flacStreamInfoEnd]...)
+	b[4] &^= 0x80
+	for i, block := range blocks {
+		block = append([]byte{}, block...)
+		if i == len(blocks)-1 {
+			block[0] |= 0x80
+		}
+		b = append(b, block...)
+	}
+	return append(b, file[flacStreamInfoEnd:]...)
+}
+
+// vorbisComment returns a Vorbis comment body
+func vorbisComment(vendor string, comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	b = append(b, vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// flacPicture returns a PICTURE block body
+func flacPicture(picType int, mimeType, description string, data []byte) []byte {
+	be := binary.BigEndian
+	b := be.AppendUint32(nil, uint32(picType))
+	b = be.AppendUint32(b, uint32(len(mimeType)))
+	b = append(b, mimeType...)
+	b = be.AppendUint32(b, uint32(len(description)))
+	b = append(b, description...)
+	b = append(b, make([]byte, 16)...) // width, height, depth, colours
+	b = be.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// cueSheet returns a CUESHEET body of one audio track and the lead-out
+func cueSheet(catalog string) []byte {
+	b := make([]byte, 396)
+	copy(b, catalog)
+	binary.BigEndian.PutUint64(b[128:], 88200)
+	b[136] = 0x80
+	b[395] = 2
+
+	track := make([]byte, 36)
+	track[8] = 1
+	copy(track[9:], "USRC17607839")
+	track[35] = 1
+	index := make([]byte, 12)
+	index[8] = 1
+	b = append(append(b, track...), index...)
+
+	leadOut := make([]byte, 36)
+	binary.BigEndian.PutUint64(leadOut, 44100)
+	leadOut[8] = 170
+	return append(b, leadOut...)
+}
+
+// quantize is the sine sample at pos as EncodeFLAC stores it
+func quantize(pos int64, sampleRate, bitDepth int) int64 {
+	v := float32(0.5 * math.Sin(2*math.Pi*1000*float64(pos)/float64(sampleRate)))
+	steps := float64(int64(1) << (bitDepth - 1))
+	return int64(math.Round(float64(v) * steps))
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate int
+		channels   int
+		frames     int64
+		bitDepth   int
+	}{
+		{"16-bit stereo", 44100, 2, 10000, 16},
+		{"24-bit mono", 96000, 1, 5000, 24},
+		{"8-bit 5.1", 48000, 6, 4096, 8},
+		{"12-bit stereo, one partial block", 22050, 2, 100, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeFLAC(t, tt.sampleRate, tt.channels, tt.frames, tt.bitDepth)
+			info, err := probe(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != "flac" || info.Codec != "flac" || info.SampleRate != tt.sampleRate ||
+				info.Channels != tt.channels || info.BitDepth != tt.bitDepth || info.Frames != tt.frames {
+				t.Fatalf("%+v, want %d frames of %d-bit %d channel FLAC at %d Hz", info, tt.frames, tt.bitDepth, tt.channels, tt.sampleRate)
+			}
+			if info.DataOffset != flacStreamInfoEnd || info.DataSize != int64(len(data)-flacStreamInfoEnd) {
+				t.Fatalf("audio at [%d, +%d), want everything after STREAMINFO", info.DataOffset, info.DataSize)
+			}
+			if info.MD5 == "" || info.Integrity != audio.IntegrityUnverified {
+				t.Fatalf("MD5 %q with integrity %q, want an unverified MD5", info.MD5, info.Integrity)
+			}
+
+			if err := audio.VerifyFLAC(bytes.NewReader(data[info.DataOffset:]), info); err != nil {
+				t.Fatal(err)
+			}
+			if info.Integrity != audio.IntegrityVerified {
+				t.Fatalf("integrity %q after VerifyFLAC, want verified", info.Integrity)
+			}
+
+			// The decoded samples are the quantized sine
+			blocks, err := audio.NewFLACBlocks(bytes.NewReader(data[info.DataOffset:]), info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pos int64
+			for {
+				samples, err := blocks.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := range samples[0] {
+					want := quantize(pos, tt.sampleRate, tt.bitDepth)
+					for ch := range samples {
+						if samples[ch][i] != want {
+							t.Fatalf("sample %d of channel %d is %d, want %d", pos, ch, samples[ch][i], want)
+						}
+					}
+					pos++
+				}
+			}
+			if pos != tt.frames {
+				t.Fatalf("decoded %d frames, want %d", pos, tt.frames)
+			}
+		})
+	}
+}
+
+func TestProbeFLACMetadata(t *testing.T) {
+	file := encodeFLAC(t, 44100, 2, 4096, 16)
+	picture := flacPicture(3, "image/PNG", "Front", []byte{0x89, 'P', 'N', 'G'})
+	seekTable := make([]byte, 36)
+	binary.BigEndian.PutUint64(seekTable[8:], 0)
+	binary.BigEndian.PutUint16(seekTable[16:], 4096)
+	binary.BigEndian.PutUint64(seekTable[18:], math.MaxUint64) // placeholder
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "Vorbis comment",
+			data: withFLACBlocks(file, flacBlock(4, vorbisComment("reference libFLAC 1.4.3",
+				"TITLE=Song", "artist=A", "ARTIST=B", "EMPTY=", "novalue",
+				"METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(picture)))),
+			check: func(t *testing.T, info *audio.Info) {
+				want := map[string]string{"TITLE": "Song", "ARTIST": "A; B"}
+				if !reflect.DeepEqual(info.Tags, want) || info.Encoder != "reference libFLAC 1.4.3" {
+					t.Fatalf("tags %v by %q, want %v by the reference encoder", info.Tags, info.Encoder, want)
+				}
+				if len(info.Pictures) != 1 || info.Pictures[0].MIMEType != "image/png" || info.Pictures[0].Description != "Front" {
+					t.Fatalf("pictures %+v, want the front PNG", info.Pictures)
+				}
+			},
+		},
+		{
+			name: "picture, seek table, cue sheet and padding",
+			data: withFLACBlocks(file,
+				flacBlock(6, picture),
+				flacBlock(3, seekTable),
+				flacBlock(5, cueSheet("1234567890123")),
+				flacBlock(1, make([]byte, 100))),
+			check: func(t *testing.T, info *audio.Info) {
+				if len(info.Pictures) != 1 || info.Pictures[0].Type != 3 || !bytes.Equal(info.Pictures[0].Data, []byte{0x89, 'P', 'N', 'G'}) {
+					t.Fatalf("pictures %+v, want the front PNG", info.Pictures)
+				}
+				if want := []audio.SeekPoint{{Sample: 0, Offset: 0, Frames: 4096}}; !reflect.DeepEqual(info.SeekTable, want) {
+					t.Fatalf("seek table %+v, want %+v", info.SeekTable, want)
+				}
+				want := &audio.CueSheet{CatalogNumber: "1234567890123", LeadIn: 88200, IsCD: true, Tracks: []audio.CueTrack{
+					{Number: 1, ISRC: "USRC17607839", Audio: true, Indices: []audio.CueIndex{{Number: 1}}},
+					{Number: 170, Offset: 44100, Audio: true},
+				}}
+				if !reflect.DeepEqual(info.CueSheet, want) {
+					t.Fatalf("cue sheet %+v, want %+v", info.CueSheet, want)
+				}
+			},
+		},
+		{
+			name: "ID3 tags around the stream",
+			data: append(append(id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title")), file...), id3v1Tag("Old", "Artist", 0, 255)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Tags["TIT2"] != "Title" || info.Tags["TPE1"] != "Artist" {
+					t.Fatalf("tags %v, want TIT2 from ID3v2 and TPE1 from ID3v1", info.Tags)
+				}
+				if info.DataSize != int64(len(file)-flacStreamInfoEnd) {
+					t.Fatalf("%d bytes of audio, want %d without the ID3v1 tag", info.DataSize, len(file)-flacStreamInfoEnd)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+			if err := audio.VerifyFLAC(bytes.NewReader(tt.data[info.DataOffset:]), info); err != nil {
+				t.Fatalf("VerifyFLAC: %v", err)
+			}
+		})
+	}
+}
+
+func TestProbeFLACInvalid(t *testing.T) {
+	file := encodeFLAC(t, 44100, 1, 100, 16)
+	// streamInfo returns file's STREAMINFO changed by edit
+	streamInfo := func(edit func(b []byte)) []byte {
+		b := append([]byte{}, file...)
+		edit(b[8:flacStreamInfoEnd])
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"STREAMINFO not first", append([]byte("fLaC"), append(flacBlock(0x81, make([]byte, 10)), file[4:]...)...), audio.ErrMalformed},
+		{"short STREAMINFO", append([]byte("fLaC\x80\x00\x00\x10"), make([]byte, 16)...), audio.ErrMalformed},
+		{"duplicate STREAMINFO", withFLACBlocks(file, file[4:flacStreamInfoEnd]), audio.ErrMalformed},
+		{"tiny minimum block", streamInfo(func(b []byte) { b[0], b[1] = 0, 8 }), audio.ErrMalformed},
+		{"maximum block below minimum", streamInfo(func(b []byte) { b[2], b[3] = 0, 16 }), audio.ErrMalformed},
+		{"zero sample rate", streamInfo(func(b []byte) { b[10], b[11], b[12] = 0, 0, b[12]&0x0F }), audio.ErrMalformed},
+		{"3 bits per sample", streamInfo(func(b []byte) { b[12] &^= 0x01; b[13] = b[13]&0x0F | 0x20 }), audio.ErrMalformed},
+		{"ragged seek table", withFLACBlocks(file, flacBlock(3, make([]byte, 20))), audio.ErrMalformed},
+		{"comment overruns its block", withFLACBlocks(file, flacBlock(4, vorbisComment("v", "TITLE=x")[:14])), audio.ErrMalformed},
+		{"short picture", withFLACBlocks(file, flacBlock(6, flacPicture(3, "image/png", "", []byte{1, 2, 3})[:30])), audio.ErrMalformed},
+		{"short cue sheet", withFLACBlocks(file, flacBlock(5, cueSheet("")[:400])), audio.ErrMalformed},
+		{"block type 127", withFLACBlocks(file, flacBlock(127, nil)), audio.ErrMalformed},
+		{"no frame sync", append(append([]byte{}, file[:flacStreamInfoEnd]...), "not a frame"...), audio.ErrMalformed},
+		{"block overruns the file", withFLACBlocks(file, flacBlock(1, make([]byte, 100)))[:flacStreamInfoEnd+50], audio.ErrTruncated},
+		{"no audio", file[:flacStreamInfoEnd], audio.ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, "flac", tt.want)
+		})
+	}
+}
+
+func TestVerifyFLACInvalid(t *testing.T) {
+	file := encodeFLAC(t, 44100, 2, 3*4096+100, 16)
+	info, err := probe(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioStart := int(info.DataOffset)
+
+	// edited returns file changed by edit
+	edited := func(edit func(b []byte)) []byte {
+		b := append([]byte{}, file...)
+		edit(b)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"frame header CRC", edited(func(b []byte) { b[audioStart+4] ^= 0x01 }), audio.ErrCorrupt},
+		{"frame CRC", edited(func(b []byte) { b[len(b)-1] ^= 0xFF }), audio.ErrCorrupt},
+		{"MD5", edited(func(b []byte) { b[flacStreamInfoEnd-1] ^= 0xFF }), audio.ErrCorrupt},
+		{"lost sync", edited(func(b []byte) { b[audioStart] = 0 }), audio.ErrMalformed},
+		{"missing frames", file[:audioStart], audio.ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				// Probe checks the first frame's sync itself
+				checkParseError(t, err, "flac", tt.want)
+				return
+			}
+			err = audio.VerifyFLAC(bytes.NewReader(tt.data[info.DataOffset:]), info)
+			checkParseError(t, err, "flac", tt.want)
+		})
+	}
+
+	// Every cut of the audio is reported as truncation
+	for n := audioStart + 2; n < len(file); n += 97 {
+		info, err := probe(file[:n])
+		if err != nil {
+			t.Fatalf("Probe of %d bytes: %v", n, err)
+		}
+		if err := audio.VerifyFLAC(bytes.NewReader(file[info.DataOffset:n]), info); !errors.Is(err, audio.ErrTruncated) {
+			t.Fatalf("VerifyFLAC of %d of %d bytes: %v, want ErrTruncated", n, len(file), err)
+		}
+	}
+}
+
+func TestVerifyFLACUnknownLength(t *testing.T) {
+	data := encodeFLAC(t, 48000, 2, 5000, 16)
+	// Zero the 36-bit sample count
+	data[8+13] &^= 0x0F
+	clear(data[8+14 : 8+18])
+
+	info, err := probe(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Frames != 0 {
+		t.Fatalf("%d frames before decoding, want unknown", info.Frames)
+	}
+	if err := audio.VerifyFLAC(bytes.NewReader(data[info.DataOffset:]), info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Frames != 5000 || info.Duration != audio.FramesDuration(5000, 48000) || info.Bitrate == 0 {
+		t.Fatalf("%d frames lasting %v at %d bps, want 5000 and a bitrate", info.Frames, info.Duration, info.Bitrate)
+	}
+}
+
+func TestVerifyFLACNeedsProbe(t *testing.T) {
+	if err := audio.VerifyFLAC(bytes.NewReader(nil), &audio.Info{Format: "flac"}); err == nil {
+		t.Fatal("VerifyFLAC accepted an Info that Probe did not make")
+	}
+}
+
+// FuzzVerifyFLAC checks that decoding never panics and only fails with a
+// ParseError, whatever the frames hold
+func FuzzVerifyFLAC(f *testing.F) {
+	f.Add(encodeFLAC(f, 8000, 1, 300, 16))
+	f.Add(encodeFLAC(f, 8000, 2, 300, 24))
+	f.Add(encodeFLAC(f, 8000, 3, 20, 8))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := probe(data)
+		if err != nil || info.Format != "flac" {
+			return
+		}
+		err = audio.VerifyFLAC(bytes.NewReader(data[info.DataOffset:]), info)
+		var pe *audio.ParseError
+		if err != nil && !errors.As(err, &pe) {
+			t.Fatalf("VerifyFLAC error %v is not a ParseError", err)
+		}
+	})
+}
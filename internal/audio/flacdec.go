@@ -0,0 +1,581 @@
+package audio
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// ErrCorrupt is the ParseError cause for audio data that fails a CRC or
+// checksum: the structure is fine but the content was damaged
+var ErrCorrupt = errors.New("corrupt audio data")
+
+// VerifyFLAC decodes every frame of a FLAC stream and checks the frame CRCs
+// and the MD5 of the decoded audio against info, which must come from
+// Probe. r is the file from info.DataOffset on. On success info.Integrity
+// is IntegrityVerified (or IntegrityNoChecksum if the encoder stored no MD5)
+// and an unknown length is filled in from the decoded sample count.
+func VerifyFLAC(r io.Reader, info *Info) error {
+	if info.flac == nil {
+		return errors.New("audio: VerifyFLAC needs the Info of a probed FLAC file")
+	}
+	p := info.flac
+
+	dec := newFLACDecoder(io.LimitReader(r, info.DataSize), p)
+	sum := md5.New()
+	buf := make([]byte, 0, 4096*p.channels*4)
+	bytesPerSample := (p.bitsPerSample + 7) / 8
+
+	var total int64
+	for {
+		n, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// MD5 is over interleaved little-endian samples of ceil(bps/8) bytes
+		buf = buf[:0]
+		for i := 0; i < n; i++ {
+			for ch := 0; ch < p.channels; ch++ {
+				s := dec.samples[ch][i]
+				for b := 0; b < bytesPerSample; b++ {
+					buf = append(buf, byte(s>>(8*b)))
+				}
+			}
+		}
+		sum.Write(buf)
+		total += int64(n)
+	}
+
+	if p.totalSamples != 0 && total != p.totalSamples {
+		return &ParseError{Format: "flac", Offset: info.DataOffset, Err: ErrTruncated,
+			Msg: fmt.Sprintf("STREAMINFO declares %d samples but %d were decoded", p.totalSamples, total)}
+	}
+	if p.totalSamples == 0 {
+		info.setFrames(total)
+		if info.Duration > 0 {
+			info.Bitrate = int(float64(info.DataSize*8) / info.Duration.Seconds())
+		}
+	}
+
+	if p.md5 == ([16]byte{}) {
+		info.Integrity = IntegrityNoChecksum
+		return nil
+	}
+	if got := sum.Sum(nil); string(got) != string(p.md5[:]) {
+		return &ParseError{Format: "flac", Offset: info.DataOffset, Err: ErrCorrupt,
+			Msg: fmt.Sprintf("MD5 of decoded audio is %s, STREAMINFO says %s", hex.EncodeToString(got), info.MD5)}
+	}
+	info.Integrity = IntegrityVerified
+	return nil
+}
+
+// FLACBlocks decodes a FLAC stream one block at a time
+type FLACBlocks struct {
+	dec *flacDecoder
+}
+
+// NewFLACBlocks starts decoding the stream of info, which must come from
+// Probe. r is the file from info.DataOffset on.
+func NewFLACBlocks(r io.Reader, info *Info) (*FLACBlocks, error) {
+	if info.flac == nil {
+		return nil, errors.New("audio: NewFLACBlocks needs the Info of a probed FLAC file")
+	}
+	return &FLACBlocks{dec: newFLACDecoder(io.LimitReader(r, info.DataSize), info.flac)}, nil
+}
+
+// Next decodes the next block and returns its samples, one slice per
+// channel, valid until the following call. It returns io.EOF after the
+// last block.
+func (b *FLACBlocks) Next() ([][]int64, error) {
+	n, err := b.dec.next()
+	if err != nil {
+		return nil, err
+	}
+	samples := b.dec.samples
+	for ch := range samples {
+		samples[ch] = samples[ch][:n]
+	}
+	return samples, nil
+}
+
+// flacDecoder decodes FLAC frames into per-channel samples
+type flacDecoder struct {
+	br      *bitReader
+	params  *flacStreamParams
+	samples [][]int64 // per channel, valid up to the last block size
+	offset  int64     // byte offset of the current frame from the first frame
+}
+
+func newFLACDecoder(r io.Reader, p *flacStreamParams) *flacDecoder {
+	d := &flacDecoder{
+		br:      &bitReader{r: bufio.NewReaderSize(r, 64<<10)},
+		params:  p,
+		samples: make([][]int64, p.channels),
+	}
+	return d
+}
+
+func (d *flacDecoder) corrupt(msg string, args ...any) error {
+	return &ParseError{Format: "flac", Offset: d.offset, Err: ErrCorrupt, Msg: fmt.Sprintf(msg, args...)}
+}
+
+func (d *flacDecoder) malformed(msg string, args ...any) error {
+	return &ParseError{Format: "flac", Offset: d.offset, Err: ErrMalformed, Msg: fmt.Sprintf(msg, args...)}
+}
+
+// next decodes one frame and returns its block size, or io.EOF after the last frame
+func (d *flacDecoder) next() (int, error) {
+	br := d.br
+	d.offset = br.consumed
+	br.resetCRC()
+
+	// Sync code, or a clean end of stream
+	first, err := br.r.Peek(1)
+	if err == io.EOF || (err == nil && len(first) == 0) {
+		return 0, io.EOF
+	}
+
+	h, err := d.readHeader()
+	if err != nil {
+		return 0, err
+	}
+
+	for ch := 0; ch < h.channels; ch++ {
+		if cap(d.samples[ch]) < h.blockSize {
+			d.samples[ch] = make([]int64, h.blockSize)
+		}
+		d.samples[ch] = d.samples[ch][:h.blockSize]
+
+		bps := h.bitsPerSample
+		switch {
+		case h.assignment == 8 && ch == 1, h.assignment == 9 && ch == 0, h.assignment == 10 && ch == 1:
+			bps++ // side channel
+		}
+		if err := d.readSubframe(d.samples[ch], bps, h.blockSize); err != nil {
+			return 0, err
+		}
+	}
+
+	// Zero padding to a byte boundary, then the CRC-16 of everything before it
+	br.align()
+	want := br.crc16
+	got := uint16(br.bits(16))
+	if br.err != nil {
+		return 0, d.truncatedErr()
+	}
+	if got != want {
+		return 0, d.corrupt("frame CRC-16 is %04x, computed %04x", got, want)
+	}
+
+	d.decorrelate(h.assignment, h.blockSize)
+	return h.blockSize, nil
+}
+
+func (d *flacDecoder) truncatedErr() error {
+	if errors.Is(d.br.err, io.EOF) || errors.Is(d.br.err, io.ErrUnexpectedEOF) {
+		return &ParseError{Format: "flac", Offset: d.offset, Err: ErrTruncated, Msg: "stream ends inside a frame"}
+	}
+	return d.br.err
+}
+
+type flacFrameHeader struct {
+	blockSize     int
+	sampleRate    int
+	channels      int
+	assignment    int
+	bitsPerSample int
+}
+
+var flacBitsPerSample = [8]int{0, 8, 12, -1, 16, 20, 24, 32}
+
+func (d *flacDecoder) readHeader() (flacFrameHeader, error) {
+	br := d.br
+	p := d.params
+	var h flacFrameHeader
+
+	if sync := br.bits(15); sync != 0x7FFC {
+		if br.err != nil {
+			return h, d.truncatedErr()
+		}
+		return h, d.malformed("lost frame sync")
+	}
+	br.bits(1) // blocking strategy
+	blockCode := int(br.bits(4))
+	rateCode := int(br.bits(4))
+	h.assignment = int(br.bits(4))
+	sizeCode := int(br.bits(3))
+	br.bits(1)
+
+	// Frame or sample number, UTF-8 style coded
+	first := br.bits(8)
+	for extra := bits.LeadingZeros8(^uint8(first)) - 1; extra > 0; extra-- {
+		br.bits(8)
+	}
+	if br.err != nil {
+		return h, d.truncatedErr()
+	}
+
+	switch {
+	case blockCode == 0:
+		return h, d.malformed("reserved block size code")
+	case blockCode == 1:
+		h.blockSize = 192
+	case blockCode <= 5:
+		h.blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		h.blockSize = int(br.bits(8)) + 1
+	case blockCode == 7:
+		h.blockSize = int(br.bits(16)) + 1
+	default:
+		h.blockSize = 256 << (blockCode - 8)
+	}
+
+	switch rateCode {
+	case 12:
+		h.sampleRate = int(br.bits(8)) * 1000
+	case 13:
+		h.sampleRate = int(br.bits(16))
+	case 14:
+		h.sampleRate = int(br.bits(16)) * 10
+	case 15:
+		return h, d.malformed("invalid sample rate code")
+	}
+
+	// CRC-8 covers the header up to here
+	want := br.crc8
+	got := uint8(br.bits(8))
+	if br.err != nil {
+		return h, d.truncatedErr()
+	}
+	if got != want {
+		return h, d.corrupt("frame header CRC-8 is %02x, computed %02x", got, want)
+	}
+
+	switch {
+	case h.assignment < 8:
+		h.channels = h.assignment + 1
+	case h.assignment <= 10:
+		h.channels = 2
+	default:
+		return h, d.malformed("reserved channel assignment %d", h.assignment)
+	}
+	if h.channels != p.channels {
+		return h, d.malformed("frame has %d channels, STREAMINFO says %d", h.channels, p.channels)
+	}
+
+	h.bitsPerSample = flacBitsPerSample[sizeCode]
+	switch {
+	case sizeCode == 0:
+		h.bitsPerSample = p.bitsPerSample
+	case h.bitsPerSample < 0:
+		return h, d.malformed("reserved sample size code")
+	case h.bitsPerSample != p.bitsPerSample:
+		return h, d.malformed("frame has %d bit samples, STREAMINFO says %d", h.bitsPerSample, p.bitsPerSample)
+	}
+	return h, nil
+}
+
+func (d *flacDecoder) readSubframe(out []int64, bps, n int) error {
+	br := d.br
+
+	if br.bits(1) != 0 {
+		return d.malformed("subframe padding bit set")
+	}
+	kind := int(br.bits(6))
+	wasted := 0
+	if br.bits(1) == 1 {
+		wasted = int(br.unary()) + 1
+		bps -= wasted
+	}
+	if br.err != nil {
+		return d.truncatedErr()
+	}
+	if bps <= 0 {
+		return d.malformed("%d wasted bits leave no sample bits", wasted)
+	}
+
+	switch {
+	case kind == 0:
+		v := br.signed(uint(bps))
+		for i := range out[:n] {
+			out[i] = v
+		}
+	case kind == 1:
+		for i := range out[:n] {
+			out[i] = br.signed(uint(bps))
+		}
+	case kind >= 8 && kind <= 12:
+		order := kind - 8
+		if order > n {
+			return d.malformed("fixed predictor order %d exceeds the block size %d", order, n)
+		}
+		for i := 0; i < order; i++ {
+			out[i] = br.signed(uint(bps))
+		}
+		if err := d.readResidual(out, order, n); err != nil {
+			return err
+		}
+		fixedPredict(out[:n], order)
+	case kind >= 32:
+		order := kind - 31
+		if order > n {
+			return d.malformed("LPC order %d exceeds the block size %d", order, n)
+		}
+		for i := 0; i < order; i++ {
+			out[i] = br.signed(uint(bps))
+		}
+		precision := int(br.bits(4)) + 1
+		if precision == 16 {
+			return d.malformed("invalid LPC coefficient precision")
+		}
+		shift := int(br.signed(5))
+		if shift < 0 {
+			return d.malformed("negative LPC shift %d", shift)
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			coeffs[i] = br.signed(uint(precision))
+		}
+		if err := d.readResidual(out, order, n); err != nil {
+			return err
+		}
+		lpcPredict(out[:n], coeffs, uint(shift))
+	default:
+		return d.malformed("reserved subframe type %d", kind)
+	}
+
+	if br.err != nil {
+		return d.truncatedErr()
+	}
+	if wasted > 0 {
+		for i := range out[:n] {
+			out[i] <<= uint(wasted)
+		}
+	}
+	return nil
+}
+
+// readResidual decodes the partitioned Rice residual into out[order:n]
+func (d *flacDecoder) readResidual(out []int64, order, n int) error {
+	br := d.br
+
+	method := br.bits(2)
+	if method > 1 {
+		return d.malformed("reserved residual coding method %d", method)
+	}
+	paramBits, escape := uint(4), uint64(0xF)
+	if method == 1 {
+		paramBits, escape = 5, 0x1F
+	}
+
+	partitionOrder := uint(br.bits(4))
+	partitions := 1 << partitionOrder
+	if n%partitions != 0 || n>>partitionOrder < order {
+		return d.malformed("partition order %d does not fit a block of %d samples", partitionOrder, n)
+	}
+
+	i := order
+	for part := 0; part < partitions; part++ {
+		count := n >> partitionOrder
+		if part == 0 {
+			count -= order
+		}
+
+		k := br.bits(paramBits)
+		if k == escape {
+			raw := uint(br.bits(5))
+			for j := 0; j < count; j++ {
+				if raw == 0 {
+					out[i] = 0
+				} else {
+					out[i] = br.signed(raw)
+				}
+				i++
+			}
+			continue
+		}
+		for j := 0; j < count; j++ {
+			q := uint64(br.unary())
+			v := q<<k | br.bits(uint(k))
+			out[i] = int64(v>>1) ^ -int64(v&1)
+			i++
+		}
+		if br.err != nil {
+			return d.truncatedErr()
+		}
+	}
+	return nil
+}
+
+func fixedPredict(s []int64, order int) {
+	switch order {
+	case 1:
+		for i := 1; i < len(s); i++ {
+			s[i] += s[i-1]
+		}
+	case 2:
+		for i := 2; i < len(s); i++ {
+			s[i] += 2*s[i-1] - s[i-2]
+		}
+	case 3:
+		for i := 3; i < len(s); i++ {
+			s[i] += 3*s[i-1] - 3*s[i-2] + s[i-3]
+		}
+	case 4:
+		for i := 4; i < len(s); i++ {
+			s[i] += 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+		}
+	}
+}
+
+func lpcPredict(s, coeffs []int64, shift uint) {
+	order := len(coeffs)
+	for i := order; i < len(s); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += c * s[i-1-j]
+		}
+		s[i] += sum >> shift
+	}
+}
+
+func (d *flacDecoder) decorrelate(assignment, n int) {
+	if assignment < 8 {
+		return
+	}
+	a, b := d.samples[0][:n], d.samples[1][:n]
+	switch assignment {
+	case 8: // left, side
+		for i := range a {
+			b[i] = a[i] - b[i]
+		}
+	case 9: // side, right
+		for i := range a {
+			a[i] += b[i]
+		}
+	case 10: // mid, side
+		for i := range a {
+			mid := a[i]<<1 | b[i]&1
+			side := b[i]
+			a[i] = (mid + side) >> 1
+			b[i] = (mid - side) >> 1
+		}
+	}
+}
+
+// bitReader reads big-endian bit fields, keeping the FLAC CRC-8 and CRC-16
+// of every byte it consumes. Errors are sticky: once one occurs every read
+// returns zero and err is set.
+type bitReader struct {
+	r        *bufio.Reader
+	x        uint64 // cached bits, the low n are unread
+	n        uint
+	consumed int64
+	crc8     uint8
+	crc16    uint16
+	err      error
+}
+
+func (br *bitReader) resetCRC() {
+	br.crc8, br.crc16 = 0, 0
+}
+
+func (br *bitReader) fill() bool {
+	if br.err != nil {
+		return false
+	}
+	c, err := br.r.ReadByte()
+	if err != nil {
+		br.err = err
+		return false
+	}
+	br.x = br.x<<8 | uint64(c)
+	br.n += 8
+	br.consumed++
+	br.crc8 = crc8Table[br.crc8^c]
+	br.crc16 = br.crc16<<8 ^ crc16Table[byte(br.crc16>>8)^c]
+	return true
+}
+
+// bits reads an n bit unsigned value, n <= 56
+func (br *bitReader) bits(n uint) uint64 {
+	if n == 0 || br.err != nil {
+		return 0
+	}
+	for br.n < n {
+		if !br.fill() {
+			return 0
+		}
+	}
+	br.n -= n
+	return br.x >> br.n & (1<<n - 1)
+}
+
+// signed reads an n bit two's complement value
+func (br *bitReader) signed(n uint) int64 {
+	v := br.bits(n)
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+// unary counts zero bits up to the next one bit
+func (br *bitReader) unary() uint32 {
+	var q uint32
+	if br.err != nil {
+		return 0
+	}
+	for {
+		if br.n == 0 && !br.fill() {
+			return 0
+		}
+		v := br.x & (1<<br.n - 1)
+		if v == 0 {
+			q += uint32(br.n)
+			br.n = 0
+			continue
+		}
+		zeros := uint(bits.LeadingZeros64(v)) - (64 - br.n)
+		q += uint32(zeros)
+		br.n -= zeros + 1
+		return q
+	}
+}
+
+// align drops the bits left in the current byte
+func (br *bitReader) align() {
+	br.n -= br.n % 8
+}
+
+var (
+	crc8Table  [256]uint8
+	crc16Table [256]uint16
+)
+
+func init() {
+	// FLAC uses CRC-8 (poly 0x07) and CRC-16 (poly 0x8005), MSB first
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8Table[i] = c8
+		crc16Table[i] = c16
+	}
+}
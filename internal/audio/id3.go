@@ -0,0 +1,505 @@
+package audio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxTagSize caps how much of an ID3v2 tag is read; larger tags are skipped
+const maxTagSize = 64 << 20
+
+// ID3 text encodings
+const (
+	id3Latin1  = 0
+	id3UTF16   = 1 // with BOM
+	id3UTF16BE = 2
+	id3UTF8    = 3
+)
+
+// Picture is embedded artwork
+type Picture struct {
+	Type        int    `json:"type"` // ID3 APIC picture type, 3 is the front cover
+	MIMEType    string `json:"mime_type"`
+	Description string `json:"description,omitempty"`
+	Data        []byte `json:"-"`
+}
+
+// FrontCover returns the picture marked as the front cover, else the first
+// one, or nil if there are none
+func FrontCover(pictures []Picture) *Picture {
+	for i := range pictures {
+		if pictures[i].Type == 3 {
+			return &pictures[i]
+		}
+	}
+	if len(pictures) > 0 {
+		return &pictures[0]
+	}
+	return nil
+}
+
+// Chapter is a named section of the audio
+type Chapter struct {
+	ID    string        `json:"id,omitempty"`
+	Title string        `json:"title"`
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+}
+
+// id3v2Length returns the total size of the ID3v2 tag whose 10 byte header
+// is hdr, including the footer, or false if hdr is not an ID3v2 header
+func id3v2Length(hdr []byte) (int64, bool) {
+	if len(hdr) < 10 || string(hdr[:3]) != "ID3" || hdr[3] == 0xFF || hdr[4] == 0xFF {
+		return 0, false
+	}
+	size, ok := synchsafe(hdr[6:10])
+	if !ok {
+		return 0, false
+	}
+	n := 10 + size
+	if hdr[5]&0x10 != 0 {
+		n += 10
+	}
+	return n, true
+}
+
+// synchsafe decodes a 28-bit integer stored 7 bits per byte
+func synchsafe(b []byte) (int64, bool) {
+	var n int64
+	for _, c := range b {
+		if c&0x80 != 0 {
+			return 0, false
+		}
+		n = n<<7 | int64(c)
+	}
+	return n, true
+}
+
+// parseID3v2 reads the ID3v2 tag at off into info and returns its total length
+func parseID3v2(r io.ReaderAt, off, size int64, info *Info) (int64, error) {
+	const format = "id3"
+
+	hdr, err := readAt(r, off, 10)
+	if err != nil {
+		return 0, truncated(format, off, "ID3v2 header: %v", err)
+	}
+	total, ok := id3v2Length(hdr)
+	if !ok {
+		return 0, malformed(format, off, "invalid ID3v2 header")
+	}
+	if off+total > size {
+		return 0, truncated(format, off, "ID3v2 tag declares %d bytes but only %d remain", total, size-off)
+	}
+
+	major := int(hdr[3])
+	flags := hdr[5]
+	tagSize := total - 10
+	if flags&0x10 != 0 {
+		tagSize -= 10
+	}
+	// v2.2 and anything unknown is skipped rather than misread
+	if (major != 3 && major != 4) || tagSize > maxTagSize {
+		return total, nil
+	}
+
+	body, err := readAt(r, off+10, int(tagSize))
+	if err != nil {
+		return 0, truncated(format, off+10, "ID3v2 tag: %v", err)
+	}
+	if major == 3 && flags&0x80 != 0 {
+		body = removeUnsync(body)
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Extended header: v2.3 gives its size excluding the size field, v2.4 including it
+		if major == 3 {
+			pos = 4 + int(be.Uint32(body[0:4]))
+		} else if n, ok := synchsafe(body[0:4]); ok {
+			pos = int(n)
+		}
+	}
+
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+	for _, f := range id3Frames(body, pos, major) {
+		info.addID3Frame(f.id, f.data, major)
+	}
+	return total, nil
+}
+
+type id3Frame struct {
+	id   string
+	data []byte
+}
+
+// id3Frames splits a tag body (or a CHAP's sub-frames) into decoded frames.
+// Parsing stops at padding or at the first frame that does not fit.
+func id3Frames(body []byte, pos, major int) []id3Frame {
+	var frames []id3Frame
+	for pos+10 <= len(body) {
+		id := body[pos : pos+4]
+		if !validFrameID(id) {
+			break
+		}
+
+		var size int64
+		if major == 4 {
+			// Some writers use plain sizes in v2.4, those aren't synchsafe
+			n, ok := synchsafe(body[pos+4 : pos+8])
+			if !ok {
+				n = int64(be.Uint32(body[pos+4 : pos+8]))
+			}
+			size = n
+		} else {
+			size = int64(be.Uint32(body[pos+4 : pos+8]))
+		}
+		flags := be.Uint16(body[pos+8 : pos+10])
+		pos += 10
+		if size > int64(len(body)-pos) {
+			break
+		}
+
+		data := body[pos : pos+int(size)]
+		pos += int(size)
+
+		if data, ok := id3FrameData(data, flags, major); ok {
+			frames = append(frames, id3Frame{id: string(id), data: data})
+		}
+	}
+	return frames
+}
+
+// id3FrameData undoes per-frame unsynchronisation and compression. Encrypted
+// frames are dropped.
+func id3FrameData(data []byte, flags uint16, major int) ([]byte, bool) {
+	var grouped, compressed, encrypted, unsync, lengthIndicator bool
+	if major == 4 {
+		grouped = flags&0x0040 != 0
+		compressed = flags&0x0008 != 0
+		encrypted = flags&0x0004 != 0
+		unsync = flags&0x0002 != 0
+		lengthIndicator = flags&0x0001 != 0
+	} else {
+		compressed = flags&0x0080 != 0
+		encrypted = flags&0x0040 != 0
+		grouped = flags&0x0020 != 0
+		lengthIndicator = compressed // v2.3 prefixes compressed frames with their size
+	}
+	if encrypted {
+		return nil, false
+	}
+
+	skip := 0
+	if grouped {
+		skip++
+	}
+	if lengthIndicator {
+		skip += 4
+	}
+	if skip > len(data) {
+		return nil, false
+	}
+	data = data[skip:]
+
+	if unsync {
+		data = removeUnsync(data)
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(io.LimitReader(zr, maxTagSize))
+		if err != nil {
+			return nil, false
+		}
+		data = out
+	}
+	return data, true
+}
+
+func validFrameID(id []byte) bool {
+	for _, c := range id {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// removeUnsync reverts unsynchronisation: every 0xFF 0x00 becomes 0xFF
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+// addID3Frame records a decoded frame. Text frames are kept under their
+// frame id, TXXX and described comments under "TXXX:desc" / "COMM:desc".
+func (info *Info) addID3Frame(id string, data []byte, major int) {
+	if len(data) == 0 {
+		return
+	}
+
+	switch {
+	case id == "TXXX":
+		enc := data[0]
+		desc, value := splitID3String(enc, data[1:])
+		if key := id3Text(enc, desc); key != "" {
+			info.setTag("TXXX:"+key, id3TextList(enc, value))
+		}
+	case id == "TCON":
+		info.setTag(id, resolveGenres(id3TextList(data[0], data[1:])))
+	case id[0] == 'T':
+		info.setTag(id, id3TextList(data[0], data[1:]))
+	case id == "COMM" || id == "USLT":
+		if len(data) < 4 {
+			return
+		}
+		enc := data[0]
+		desc, text := splitID3String(enc, data[4:])
+		key := id
+		if d := id3Text(enc, desc); d != "" && id == "COMM" {
+			key = "COMM:" + d
+		}
+		info.setTag(key, id3Text(enc, text))
+	case id == "UFID":
+		// owner, then a binary identifier that is text for MusicBrainz
+		owner, ident := splitID3String(id3Latin1, data)
+		if len(owner) > 0 && utf8.Valid(ident) {
+			info.setTag("UFID:"+latin1(owner), strings.TrimSpace(string(ident)))
+		}
+	case id == "APIC":
+		if pic, ok := parseAPIC(data); ok {
+			info.Pictures = append(info.Pictures, pic)
+		}
+	case id == "CHAP":
+		if ch, ok := parseCHAP(data, major); ok {
+			info.Chapters = append(info.Chapters, ch)
+		}
+	}
+}
+
+// setTag stores a value unless the key already has one: the first tag
+// (ID3v2 before ID3v1) wins
+func (info *Info) setTag(key, value string) {
+	if value == "" {
+		return
+	}
+	if info.Tags == nil {
+		info.Tags = map[string]string{}
+	}
+	if _, ok := info.Tags[key]; !ok {
+		info.Tags[key] = value
+	}
+}
+
+func parseAPIC(data []byte) (Picture, bool) {
+	enc := data[0]
+	i := bytes.IndexByte(data[1:], 0)
+	if i < 0 || 1+i+2 > len(data) {
+		return Picture{}, false
+	}
+	mimeType := strings.ToLower(latin1(data[1 : 1+i]))
+	rest := data[1+i+1:]
+	picType := int(rest[0])
+	desc, img := splitID3String(enc, rest[1:])
+
+	// v2.3 taggers sometimes write bare "jpg"/"png"
+	switch mimeType {
+	case "", "jpg", "jpeg", "image/jpg":
+		mimeType = "image/jpeg"
+	case "png":
+		mimeType = "image/png"
+	}
+	return Picture{Type: picType, MIMEType: mimeType, Description: id3Text(enc, desc), Data: img}, len(img) > 0
+}
+
+func parseCHAP(data []byte, major int) (Chapter, bool) {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 || i+1+16 > len(data) {
+		return Chapter{}, false
+	}
+	ch := Chapter{ID: latin1(data[:i])}
+	times := data[i+1:]
+	ch.Start = time.Duration(be.Uint32(times[0:4])) * time.Millisecond
+	ch.End = time.Duration(be.Uint32(times[4:8])) * time.Millisecond
+
+	for _, f := range id3Frames(times[16:], 0, major) {
+		if f.id == "TIT2" && len(f.data) > 0 {
+			ch.Title = id3Text(f.data[0], f.data[1:])
+		}
+	}
+	return ch, true
+}
+
+// splitID3String splits b after the first string terminator of the encoding
+func splitID3String(enc byte, b []byte) (head, tail []byte) {
+	if enc == id3UTF16 || enc == id3UTF16BE {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:]
+			}
+		}
+		return b, nil
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+// id3TextList decodes a text frame; v2.4 separates multiple values with
+// terminators, which are joined with "; "
+func id3TextList(enc byte, b []byte) string {
+	var values []string
+	for len(b) > 0 {
+		var v []byte
+		v, b = splitID3String(enc, b)
+		if s := id3Text(enc, v); s != "" {
+			values = append(values, s)
+		}
+	}
+	return strings.Join(values, "; ")
+}
+
+// id3Text decodes a single string in the given ID3 encoding
+func id3Text(enc byte, b []byte) string {
+	var s string
+	switch enc {
+	case id3UTF16, id3UTF16BE:
+		s = decodeUTF16(b, enc == id3UTF16BE)
+	case id3UTF8:
+		s = string(b)
+	default:
+		s = latin1(b)
+	}
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// decodeUTF16 honours a BOM, falling back to big or little endian
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = be.Uint16(b[2*i:])
+		} else {
+			units[i] = le.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// parseID3v1 reads a 128 byte ID3v1 (or v1.1) tag into the ID3v2 frame ids
+// it corresponds to. Existing values take precedence.
+func (info *Info) parseID3v1(b []byte) {
+	text := func(f []byte) string { return cString(bytes.TrimRight([]byte(latin1(f)), " ")) }
+
+	info.setTag("TIT2", text(b[3:33]))
+	info.setTag("TPE1", text(b[33:63]))
+	info.setTag("TALB", text(b[63:93]))
+	info.setTag("TYER", text(b[93:97]))
+
+	comment := b[97:127]
+	if comment[28] == 0 && comment[29] != 0 {
+		// ID3v1.1: the last comment byte is the track number
+		info.setTag("TRCK", strconv.Itoa(int(comment[29])))
+		comment = comment[:28]
+	}
+	info.setTag("COMM", text(comment))
+
+	if g := int(b[127]); g < len(id3Genres) {
+		info.setTag("TCON", id3Genres[g])
+	}
+}
+
+// resolveGenres replaces ID3v1 genre references ("(17)", "17", "(17)Rock")
+// in a TCON value by their names
+func resolveGenres(s string) string {
+	var out []string
+	for _, v := range strings.Split(s, "; ") {
+		for strings.HasPrefix(v, "(") {
+			end := strings.IndexByte(v, ')')
+			if end < 0 {
+				break
+			}
+			ref := v[1:end]
+			v = v[end+1:]
+			switch ref {
+			case "RX":
+				out = append(out, "Remix")
+			case "CR":
+				out = append(out, "Cover")
+			default:
+				if n, err := strconv.Atoi(ref); err == nil && n < len(id3Genres) && v == "" {
+					out = append(out, id3Genres[n])
+				}
+			}
+		}
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < len(id3Genres) {
+			v = id3Genres[n]
+		}
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return strings.Join(out, "; ")
+}
+
+// id3Genres is the ID3v1 genre list including the Winamp extensions
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore", "Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop", "Abstract", "Art Rock", "Baroque", "Bhangra",
+	"Big Beat", "Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth",
+	"Jam Band", "Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk",
+	"Post-Rock", "Psytrance", "Shoegaze", "Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
@@ -0,0 +1,227 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// synchsafeBytes encodes n 7 bits per byte
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// id3v2Tag returns an ID3v2 tag of the given version and header flags
+func id3v2Tag(major int, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	b := append([]byte{'I', 'D', '3', byte(major), 0, flags}, synchsafeBytes(len(body))...)
+	return append(b, body...)
+}
+
+// id3v2Frame returns a frame; v2.4 sizes are synchsafe, v2.3 ones are not
+func id3v2Frame(major int, id string, flags uint16, data []byte) []byte {
+	b := []byte(id)
+	if major == 4 {
+		b = append(b, synchsafeBytes(len(data))...)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	}
+	b = binary.BigEndian.AppendUint16(b, flags)
+	return append(b, data...)
+}
+
+// id3v2String encodes s in an ID3 text encoding
+func id3v2String(enc byte, s string) []byte {
+	switch enc {
+	case 0:
+		var b []byte
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		return b
+	case 1, 2:
+		var b []byte
+		if enc == 1 {
+			b = []byte{0xFF, 0xFE}
+		}
+		for _, u := range utf16.Encode([]rune(s)) {
+			if enc == 1 {
+				b = binary.LittleEndian.AppendUint16(b, u)
+			} else {
+				b = binary.BigEndian.AppendUint16(b, u)
+			}
+		}
+		return b
+	}
+	return []byte(s)
+}
+
+// id3v2TextFrame returns a text frame; NULs in text separate values
+func id3v2TextFrame(major int, id string, enc byte, text string) []byte {
+	return id3v2Frame(major, id, 0, append([]byte{enc}, id3v2String(enc, text)...))
+}
+
+func zlibBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// unsynchronise inserts a zero after every 0xFF
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF}, []byte{0xFF, 0x00})
+}
+
+func TestProbeID3v2(t *testing.T) {
+	chapter := append([]byte("ch1\x00"), 0, 0, 0, 0, 0, 0, 0x13, 0x88, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	chapter = append(chapter, id3v2TextFrame(4, "TIT2", 3, "Intro")...)
+	cover := append([]byte("\x00jpg\x00\x03Cover\x00"), 0xFF, 0xD8, 0xFF, 0xE0)
+	compressed := append(synchsafeBytes(11), zlibBytes([]byte("\x03Compressed"))...)
+
+	tests := []struct {
+		name     string
+		tag      []byte
+		want     map[string]string
+		pictures []audio.Picture
+		chapters []audio.Chapter
+	}{
+		{
+			name: "Latin-1",
+			tag:  id3v2Tag(3, 0, id3v2TextFrame(3, "TIT2", 0, "Café")),
+			want: map[string]string{"TIT2": "Café"},
+		},
+		{
+			name: "UTF-16 with BOM",
+			tag:  id3v2Tag(3, 0, id3v2TextFrame(3, "TPE1", 1, "Björk")),
+			want: map[string]string{"TPE1": "Björk"},
+		},
+		{
+			name: "UTF-16BE",
+			tag:  id3v2Tag(4, 0, id3v2TextFrame(4, "TALB", 2, "日本")),
+			want: map[string]string{"TALB": "日本"},
+		},
+		{
+			name: "multiple values",
+			tag: id3v2Tag(4, 0,
+				id3v2TextFrame(4, "TPE1", 3, "A\x00B"),
+				id3v2TextFrame(4, "TCOM", 1, "C\x00D")),
+			want: map[string]string{"TPE1": "A; B", "TCOM": "C; D"},
+		},
+		{
+			name: "described frames",
+			tag: id3v2Tag(3, 0,
+				id3v2Frame(3, "TXXX", 0, []byte("\x03ORIGINALYEAR\x001987")),
+				id3v2Frame(3, "COMM", 0, []byte("\x00eng\x00A comment")),
+				id3v2Frame(3, "COMM", 0, []byte("\x00engiTunNORM\x00 0000")),
+				id3v2Frame(3, "UFID", 0, []byte("http://musicbrainz.org\x00f1b4c1e6"))),
+			want: map[string]string{
+				"TXXX:ORIGINALYEAR":           "1987",
+				"COMM":                        "A comment",
+				"COMM:iTunNORM":               "0000",
+				"UFID:http://musicbrainz.org": "f1b4c1e6",
+			},
+		},
+		{
+			name: "genre references",
+			tag: id3v2Tag(4, 0,
+				id3v2TextFrame(4, "TCON", 0, "(17)\x00(RX)\x0020\x00Trip-Hop")),
+			want: map[string]string{"TCON": "Rock; Remix; Alternative; Trip-Hop"},
+		},
+		{
+			name:     "picture",
+			tag:      id3v2Tag(3, 0, id3v2Frame(3, "APIC", 0, cover)),
+			want:     map[string]string{},
+			pictures: []audio.Picture{{Type: 3, MIMEType: "image/jpeg", Description: "Cover", Data: []byte{0xFF, 0xD8, 0xFF, 0xE0}}},
+		},
+		{
+			name:     "chapter",
+			tag:      id3v2Tag(4, 0, id3v2Frame(4, "CHAP", 0, chapter)),
+			want:     map[string]string{},
+			chapters: []audio.Chapter{{ID: "ch1", Title: "Intro", Start: 0, End: 5 * time.Second}},
+		},
+		{
+			name: "unsynchronised v2.3 tag",
+			tag:  id3v2Tag(3, 0x80, unsynchronise(id3v2TextFrame(3, "TIT2", 0, "ÿÿ"))),
+			want: map[string]string{"TIT2": "ÿÿ"},
+		},
+		{
+			name: "compressed, grouped and encrypted v2.4 frames",
+			tag: id3v2Tag(4, 0,
+				id3v2Frame(4, "TIT2", 0x0008|0x0001, compressed),
+				id3v2Frame(4, "TPE1", 0x0040, []byte("\x07\x03Grouped")),
+				id3v2Frame(4, "TALB", 0x0004, []byte("\x01\x03Secret"))),
+			want: map[string]string{"TIT2": "Compressed", "TPE1": "Grouped"},
+		},
+		{
+			name: "v2.3 extended header",
+			tag:  id3v2Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, id3v2TextFrame(3, "TIT2", 0, "Title")),
+			want: map[string]string{"TIT2": "Title"},
+		},
+		{
+			name: "plain frame size in v2.4",
+			tag: id3v2Tag(4, 0, append([]byte("TIT2\x00\x00\x00\x80\x00\x00\x03"), strings.Repeat("x", 127)...),
+				id3v2TextFrame(4, "TPE1", 3, "After")),
+			want: map[string]string{"TIT2": strings.Repeat("x", 127), "TPE1": "After"},
+		},
+		{
+			name: "v2.2 is skipped",
+			tag:  id3v2Tag(2, 0, []byte("TT2\x00\x00\x06\x00Title")),
+			want: map[string]string{},
+		},
+		{
+			name: "footer",
+			tag:  append(id3v2Tag(4, 0x10, id3v2TextFrame(4, "TIT2", 3, "Title")), "3DI\x04\x00\x10\x00\x00\x00\x10"...),
+			want: map[string]string{"TIT2": "Title"},
+		},
+		{
+			name: "padding",
+			tag:  id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title"), make([]byte, 64)),
+			want: map[string]string{"TIT2": "Title"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append([]byte{}, tt.tag...), mp3Frames(2)...)
+			info, err := probe(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.DataOffset != int64(len(tt.tag)) {
+				t.Fatalf("audio at %d, want it after the %d byte tag", info.DataOffset, len(tt.tag))
+			}
+			if !reflect.DeepEqual(info.Tags, tt.want) {
+				t.Fatalf("tags %q, want %q", info.Tags, tt.want)
+			}
+			if !reflect.DeepEqual(info.Pictures, tt.pictures) || !reflect.DeepEqual(info.Chapters, tt.chapters) {
+				t.Fatalf("pictures %+v and chapters %+v, want %+v and %+v", info.Pictures, info.Chapters, tt.pictures, tt.chapters)
+			}
+		})
+	}
+}
+
+func TestFrontCover(t *testing.T) {
+	back := audio.Picture{Type: 4}
+	front := audio.Picture{Type: 3}
+	tests := []struct {
+		pictures []audio.Picture
+		want     *audio.Picture
+	}{
+		{nil, nil},
+		{[]audio.Picture{back}, &back},
+		{[]audio.Picture{back, front}, &front},
+	}
+	for _, tt := range tests {
+		got := audio.FrontCover(tt.pictures)
+		if (got == nil) != (tt.want == nil) || got != nil && got.Type != tt.want.Type {
+			t.Errorf("FrontCover(%+v) = %+v, want %+v", tt.pictures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package audio
+
+import (
+	"io"
+	"strings"
+)
+
+// MPEG audio versions as coded in the frame header
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+)
+
+var mpegBitrates = [2][3][15]int{
+	{ // MPEG-1, layers I, II, III (kbit/s)
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{ // MPEG-2 and 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+var mpegSampleRates = map[int][3]int{
+	mpeg1:  {44100, 48000, 32000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg25: {11025, 12000, 8000},
+}
+
+// mpegHeader is a decoded MPEG audio frame header
+type mpegHeader struct {
+	version     int
+	layer       int // 1, 2 or 3
+	crc         bool
+	bitrate     int // bits per second
+	sampleRate  int
+	padding     bool
+	channelMode int // 3 is mono
+
+	samples int // per frame
+	length  int // bytes, header included
+}
+
+// parseMPEGHeader decodes a 4 byte frame header. Free-format bitrates are
+// not supported as the frame length cannot be derived from the header.
+func parseMPEGHeader(b []byte) (mpegHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mpegHeader{}, false
+	}
+
+	h := mpegHeader{
+		version:     int(b[1]>>3) & 3,
+		layer:       4 - int(b[1]>>1)&3,
+		crc:         b[1]&1 == 0,
+		padding:     b[2]&2 != 0,
+		channelMode: int(b[3] >> 6),
+	}
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2]>>2) & 3
+	if h.version == 1 || h.layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 || b[3]&3 == 2 {
+		return mpegHeader{}, false
+	}
+
+	table := 1
+	if h.version == mpeg1 {
+		table = 0
+	}
+	h.bitrate = mpegBitrates[table][h.layer-1][bitrateIndex] * 1000
+	h.sampleRate = mpegSampleRates[h.version][rateIndex]
+
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+	case h.layer == 3 && h.version != mpeg1:
+		h.samples = 576
+	default:
+		h.samples = 1152
+	}
+
+	pad := 0
+	if h.padding {
+		pad = 1
+	}
+	if h.layer == 1 {
+		h.length = (12*h.bitrate/h.sampleRate + pad) * 4
+	} else {
+		h.length = h.samples/8*h.bitrate/h.sampleRate + pad
+	}
+	return h, true
+}
+
+// compatible reports whether h can belong to the same stream as first
+func (h mpegHeader) compatible(first mpegHeader) bool {
+	return h.version == first.version && h.layer == first.layer && h.sampleRate == first.sampleRate
+}
+
+func (h mpegHeader) channels() int {
+	if h.channelMode == 3 {
+		return 1
+	}
+	return 2
+}
+
+// sideInfoSize is the layer III side information that precedes a Xing header
+func (h mpegHeader) sideInfoSize() int {
+	mono := h.channelMode == 3
+	switch {
+	case h.version == mpeg1 && mono:
+		return 17
+	case h.version == mpeg1:
+		return 32
+	case mono:
+		return 9
+	}
+	return 17
+}
+
+// isMPEGStream reports whether a frame header at off is followed by another
+// valid one (or the end of the data), which rules out most false syncs
+func isMPEGStream(r io.ReaderAt, off, end int64) bool {
+	b, err := readAt(r, off, 4)
+	if err != nil {
+		return false
+	}
+	h, ok := parseMPEGHeader(b)
+	if !ok {
+		return false
+	}
+	next := off + int64(h.length)
+	if next >= end {
+		return next == end
+	}
+	b, err = readAt(r, next, 4)
+	if err != nil {
+		return false
+	}
+	h2, ok := parseMPEGHeader(b)
+	return ok && h2.compatible(h)
+}
+
+// vbrHeader is what the first frame's Xing/Info or VBRI header declares
+type vbrHeader struct {
+	kind    string // "Xing", "Info" or "VBRI"
+	frames  int64  // audio frames, the header frame excluded; 0 if absent
+	bytes   int64
+	encoder string
+	method  int // LAME VBR method, 0 if unknown
+	delay   int
+	padding int
+}
+
+// parseVBRHeader looks for a Xing/Info or VBRI header in the first frame
+func parseVBRHeader(frame []byte, h mpegHeader) *vbrHeader {
+	xingOff := 4 + h.sideInfoSize()
+	if h.crc {
+		xingOff += 2
+	}
+	if xingOff+8 <= len(frame) {
+		if tag := string(frame[xingOff : xingOff+4]); tag == "Xing" || tag == "Info" {
+			return parseXing(frame[xingOff:], tag)
+		}
+	}
+
+	// VBRI always sits 32 bytes after the header
+	if len(frame) >= 36+26 && string(frame[36:40]) == "VBRI" {
+		v := frame[36:]
+		return &vbrHeader{
+			kind:    "VBRI",
+			bytes:   int64(be.Uint32(v[10:14])),
+			frames:  int64(be.Uint32(v[14:18])),
+			encoder: "Fraunhofer",
+		}
+	}
+	return nil
+}
+
+func parseXing(x []byte, tag string) *vbrHeader {
+	v := &vbrHeader{kind: tag}
+	flags := be.Uint32(x[4:8])
+	pos := 8
+	if flags&1 != 0 && pos+4 <= len(x) {
+		v.frames = int64(be.Uint32(x[pos:]))
+		pos += 4
+	}
+	if flags&2 != 0 && pos+4 <= len(x) {
+		v.bytes = int64(be.Uint32(x[pos:]))
+		pos += 4
+	}
+	if flags&4 != 0 {
+		pos += 100 // seek table
+	}
+	if flags&8 != 0 {
+		pos += 4 // quality
+	}
+
+	// LAME extension: encoder string, VBR method, ..., delay and padding
+	// packed as two 12-bit values 21 bytes in
+	if pos+24 > len(x) {
+		return v
+	}
+	lame := x[pos:]
+	encoder := string(lame[:4])
+	if encoder != "LAME" && encoder != "Lavf" && encoder != "Lavc" {
+		return v
+	}
+	v.encoder = strings.TrimRight(cString(lame[:9]), ".")
+	v.method = int(lame[9] & 0x0F)
+	d := lame[21:24]
+	v.delay = int(d[0])<<4 | int(d[1]>>4)
+	v.padding = int(d[1]&0x0F)<<8 | int(d[2])
+	return v
+}
+
+// lameBitrateModes maps the LAME VBR method to a bitrate mode
+var lameBitrateModes = map[int]string{
+	1: "CBR", 8: "CBR",
+	2: "ABR", 9: "ABR",
+	3: "VBR", 4: "VBR", 5: "VBR", 6: "VBR",
+}
+
+func parseMP3(r io.ReaderAt, size int64) (*Info, error) {
+	const format = "mp3"
+
+	info := &Info{Format: "mp3", Tags: map[string]string{}}
+
+	start := int64(0)
+	if hdr, err := readAt(r, 0, 10); err == nil {
+		if _, ok := id3v2Length(hdr); ok {
+			n, err := parseID3v2(r, 0, size, info)
+			if err != nil {
+				return nil, err
+			}
+			start = n
+		}
+	}
+
+	end := trailingTagsStart(r, start, size, info)
+
+	// Writers sometimes pad past the declared ID3v2 size
+	first, ok := findMPEGFrame(r, start, end, nil)
+	if !ok {
+		return nil, malformed(format, start, "no MPEG audio frame found")
+	}
+	start = first
+
+	var (
+		head     mpegHeader
+		sawFirst bool
+		vbr      *vbrHeader
+		frames   int64
+		samples  int64
+		bytes    int64
+		bitrates = map[int]bool{}
+		hdr      = make([]byte, 4)
+		pos      = start
+	)
+	for pos+4 <= end {
+		if n, err := r.ReadAt(hdr, pos); n < len(hdr) {
+			return nil, err
+		}
+		h, ok := parseMPEGHeader(hdr)
+		if ok && sawFirst {
+			ok = h.compatible(head)
+		}
+		if !ok {
+			var compat *mpegHeader
+			if sawFirst {
+				compat = &head
+			}
+			next, found := findMPEGFrame(r, pos+1, end, compat)
+			if !found {
+				break
+			}
+			pos = next
+			continue
+		}
+		if pos+int64(h.length) > end {
+			// A partial last frame is common and not worth rejecting
+			break
+		}
+
+		if !sawFirst {
+			sawFirst = true
+			head = h
+			frame, err := readAt(r, pos, h.length)
+			if err != nil {
+				return nil, truncated(format, pos, "first frame: %v", err)
+			}
+			if vbr = parseVBRHeader(frame, h); vbr != nil {
+				// The header frame carries no audio
+				pos += int64(h.length)
+				continue
+			}
+		}
+
+		frames++
+		samples += int64(h.samples)
+		bytes += int64(h.length)
+		bitrates[h.bitrate] = true
+		pos += int64(h.length)
+	}
+	if frames == 0 {
+		return nil, malformed(format, start, "no complete MPEG audio frames")
+	}
+	if vbr != nil && vbr.frames > 0 && vbr.frames-frames > 1 {
+		return nil, truncated(format, start, "%s header declares %d frames but only %d are present", vbr.kind, vbr.frames, frames)
+	}
+
+	info.Codec = []string{"", "mp1", "mp2", "mp3"}[head.layer]
+	info.SampleRate = head.sampleRate
+	info.Channels = head.channels()
+	info.Layout = ChannelLayout(info.Channels, 0)
+	info.DataOffset = start
+	info.DataSize = pos - start
+
+	info.BitrateMode = "CBR"
+	if len(bitrates) > 1 {
+		info.BitrateMode = "VBR"
+	}
+	if vbr != nil {
+		info.Encoder = vbr.encoder
+		if mode, ok := lameBitrateModes[vbr.method]; ok {
+			info.BitrateMode = mode
+		}
+		info.EncoderDelay = vbr.delay
+		info.EncoderPadding = vbr.padding
+	}
+
+	// Gapless: the encoder delay and padding are not part of the programme
+	total := samples - int64(info.EncoderDelay) - int64(info.EncoderPadding)
+	if total < 0 {
+		total = 0
+	}
+	info.setFrames(total)
+
+	if len(bitrates) == 1 {
+		info.Bitrate = head.bitrate
+	} else if playing := FramesDuration(samples, info.SampleRate); playing > 0 {
+		info.Bitrate = int(float64(bytes*8) / playing.Seconds())
+	}
+	return info, nil
+}
+
+// findMPEGFrame scans [from, end) for the next frame header that is followed
+// by another, optionally requiring it to match an established stream
+func findMPEGFrame(r io.ReaderAt, from, end int64, compat *mpegHeader) (int64, bool) {
+	buf := make([]byte, 4096)
+	for pos := from; pos+4 <= end; {
+		n, err := r.ReadAt(buf, pos)
+		if n < 4 {
+			return 0, false
+		}
+		for i := 0; i+1 < n; i++ {
+			if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+				continue
+			}
+			off := pos + int64(i)
+			if off+4 > end {
+				return 0, false
+			}
+			b, err := readAt(r, off, 4)
+			if err != nil {
+				return 0, false
+			}
+			h, ok := parseMPEGHeader(b)
+			if !ok || (compat != nil && !h.compatible(*compat)) {
+				continue
+			}
+			if isMPEGStream(r, off, end) {
+				return off, true
+			}
+		}
+		if err != nil {
+			return 0, false
+		}
+		pos += int64(n) - 1
+	}
+	return 0, false
+}
+
+// trailingTagsStart finds where the audio ends: before a trailing ID3v1
+// tag and APEv2 tag, reading the ID3v1 into info
+func trailingTagsStart(r io.ReaderAt, start, size int64, info *Info) int64 {
+	end := size
+	if end-start >= 128 {
+		if b, err := readAt(r, end-128, 128); err == nil && string(b[:3]) == "TAG" {
+			info.parseID3v1(b)
+			end -= 128
+		}
+	}
+	if end-start >= 32 {
+		if b, err := readAt(r, end-32, 32); err == nil && string(b[:8]) == "APETAGEX" {
+			n := int64(le.Uint32(b[12:16])) // items and footer
+			if le.Uint32(b[20:24])&(1<<31) != 0 {
+				n += 32 // header
+			}
+			if n <= end-start {
+				end -= n
+			}
+		}
+	}
+	return end
+}
+
+// hasMPEGFrame reports whether an MPEG stream starts shortly after off,
+// tolerating padding written past the end of an ID3v2 tag
+func hasMPEGFrame(r io.ReaderAt, off, size int64) bool {
+	_, ok := findMPEGFrame(r, off, min(size, off+4096), nil)
+	return ok
+}
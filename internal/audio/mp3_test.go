@@ -0,0 +1,236 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// MPEG-1 layer III frame headers at 44.1 kHz: 128 kbit/s stereo frames are
+// 417 bytes, 160 kbit/s ones 522
+var (
+	mp3Header128 = []byte{0xFF, 0xFB, 0x90, 0x00}
+	mp3Header160 = []byte{0xFF, 0xFB, 0xA0, 0x00}
+)
+
+// mpegFrame returns a silent frame of the given header and length
+func mpegFrame(header []byte, length int) []byte {
+	return append(append([]byte{}, header...), make([]byte, length-4)...)
+}
+
+// mp3Frames returns n 128 kbit/s stereo frames
+func mp3Frames(n int) []byte {
+	return bytes.Repeat(mpegFrame(mp3Header128, 417), n)
+}
+
+// xingFrame returns a 128 kbit/s frame holding an Info header with a LAME
+// extension declaring the frame count, VBR method, delay and padding
+func xingFrame(frames, method, delay, padding int) []byte {
+	f := mpegFrame(mp3Header128, 417)
+	x := f[4+32:] // after the MPEG-1 stereo side information
+	copy(x, "Info")
+	binary.BigEndian.PutUint32(x[4:], 1|2) // frames and bytes
+	binary.BigEndian.PutUint32(x[8:], uint32(frames))
+	binary.BigEndian.PutUint32(x[12:], uint32(frames*417))
+	lame := x[16:]
+	copy(lame, "LAME3.100")
+	lame[9] = byte(method)
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay<<4) | byte(padding>>8)
+	lame[23] = byte(padding)
+	return f
+}
+
+// id3v1Tag returns an ID3v1.1 tag
+func id3v1Tag(title, artist string, track, genre byte) []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	copy(b[3:33], title)
+	copy(b[33:63], artist)
+	copy(b[93:97], "1999")
+	b[126] = track
+	b[127] = genre
+	return b
+}
+
+// apeFooter returns an APEv2 tag of no items, footer only
+func apeFooter() []byte {
+	b := append([]byte("APETAGEX"), make([]byte, 24)...)
+	binary.LittleEndian.PutUint32(b[8:], 2000)
+	binary.LittleEndian.PutUint32(b[12:], 32)
+	return b
+}
+
+func TestProbeMP3(t *testing.T) {
+	title := id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title"))
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "CBR",
+			data: mp3Frames(10),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Format != "mp3" || info.Codec != "mp3" || info.SampleRate != 44100 || info.Channels != 2 || info.Layout != "stereo" {
+					t.Fatalf("%+v, want 44.1 kHz stereo mp3", info)
+				}
+				if info.BitrateMode != "CBR" || info.Bitrate != 128000 || info.Frames != 11520 || info.BitDepth != 0 {
+					t.Fatalf("%s at %d bps with %d frames, want CBR at 128000 with 11520", info.BitrateMode, info.Bitrate, info.Frames)
+				}
+				if info.DataOffset != 0 || info.DataSize != 4170 {
+					t.Fatalf("audio at [%d, +%d), want [0, +4170)", info.DataOffset, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "MPEG-2 mono",
+			data: bytes.Repeat(mpegFrame([]byte{0xFF, 0xF3, 0x80, 0xC0}, 208), 5),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.SampleRate != 22050 || info.Channels != 1 || info.Bitrate != 64000 || info.Frames != 5*576 {
+					t.Fatalf("%d Hz, %d channels at %d bps with %d frames, want 22050 mono at 64000 with 2880",
+						info.SampleRate, info.Channels, info.Bitrate, info.Frames)
+				}
+			},
+		},
+		{
+			name: "mixed bitrates are VBR",
+			data: bytes.Repeat(append(mpegFrame(mp3Header128, 417), mpegFrame(mp3Header160, 522)...), 4),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.BitrateMode != "VBR" || info.Bitrate < 128000 || info.Bitrate > 160000 {
+					t.Fatalf("%s at %d bps, want VBR between 128 and 160 kbit/s", info.BitrateMode, info.Bitrate)
+				}
+			},
+		},
+		{
+			name: "LAME gapless info",
+			data: append(xingFrame(10, 4, 576, 1000), mp3Frames(10)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Encoder != "LAME3.100" || info.BitrateMode != "VBR" || info.EncoderDelay != 576 || info.EncoderPadding != 1000 {
+					t.Fatalf("encoder %q, %s, delay %d, padding %d, want LAME3.100, VBR, 576, 1000",
+						info.Encoder, info.BitrateMode, info.EncoderDelay, info.EncoderPadding)
+				}
+				// The header frame belongs to the stream even though it is silent
+				if info.Frames != 11520-576-1000 || info.DataOffset != 0 || info.DataSize != 11*417 {
+					t.Fatalf("%d frames at [%d, +%d), want 9944 at [0, +4587)", info.Frames, info.DataOffset, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "ID3v2 before the audio",
+			data: append(append([]byte{}, title...), mp3Frames(3)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Tags["TIT2"] != "Title" || info.DataOffset != int64(len(title)) || info.Frames != 3*1152 {
+					t.Fatalf("tags %v, %d frames at %d, want TIT2 Title, 3456 frames at %d", info.Tags, info.Frames, info.DataOffset, len(title))
+				}
+			},
+		},
+		{
+			name: "padding past the ID3v2 size",
+			data: append(append(append([]byte{}, title...), make([]byte, 100)...), mp3Frames(3)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.DataOffset != int64(len(title)+100) || info.Frames != 3*1152 {
+					t.Fatalf("%d frames at %d, want 3456 at %d", info.Frames, info.DataOffset, len(title)+100)
+				}
+			},
+		},
+		{
+			name: "ID3v1 and APEv2 trailers",
+			data: append(append(mp3Frames(3), apeFooter()...), id3v1Tag("Old Title", "Old Artist", 7, 17)...),
+			check: func(t *testing.T, info *audio.Info) {
+				want := map[string]string{"TIT2": "Old Title", "TPE1": "Old Artist", "TYER": "1999", "TRCK": "7", "TCON": "Rock"}
+				for k, v := range want {
+					if info.Tags[k] != v {
+						t.Fatalf("tags %v, want %v", info.Tags, want)
+					}
+				}
+				if info.DataSize != 3*417 || info.Frames != 3*1152 {
+					t.Fatalf("%d frames in %d bytes, want 3456 in 1251", info.Frames, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "ID3v2 wins over ID3v1",
+			data: append(append(append([]byte{}, title...), mp3Frames(3)...), id3v1Tag("Old Title", "", 0, 255)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Tags["TIT2"] != "Title" {
+					t.Fatalf("TIT2 = %q, want the ID3v2 title", info.Tags["TIT2"])
+				}
+			},
+		},
+		{
+			name: "partial last frame",
+			data: append(mp3Frames(4), mpegFrame(mp3Header128, 417)[:200]...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 4*1152 || info.DataSize != 4*417 {
+					t.Fatalf("%d frames in %d bytes, want 4608 in 1668", info.Frames, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "junk between frames",
+			data: append(append(mp3Frames(3), "junk!"...), mp3Frames(3)...),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 6*1152 {
+					t.Fatalf("%d frames, want 6912", info.Frames)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestProbeMP3Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+		want   error
+	}{
+		{"Info header frame alone", "mp3", xingFrame(10, 4, 0, 0), audio.ErrMalformed},
+		{"fewer frames than the Info header", "mp3", append(xingFrame(10, 4, 0, 0), mp3Frames(5)...), audio.ErrTruncated},
+		{"ID3v2 tag longer than the file", "id3", id3v2Tag(4, 0, make([]byte, 1000))[:500], audio.ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, tt.format, tt.want)
+		})
+	}
+
+	// Neither an ID3v2 tag on its own nor a lone frame header is taken for MP3
+	for _, data := range [][]byte{
+		append(id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title")), "no audio here"...),
+		mpegFrame(mp3Header128, 417)[:300],
+	} {
+		if _, err := probe(data); !errors.Is(err, audio.ErrUnknownFormat) {
+			t.Fatalf("Probe error = %v, want ErrUnknownFormat", err)
+		}
+	}
+}
+
+func TestProbeMP3Truncated(t *testing.T) {
+	data := append(xingFrame(10, 4, 0, 0), mp3Frames(10)...)
+	// Losing more than the last frame is reported, one partial frame is not
+	for n := 2 * 417; n < len(data); n++ {
+		_, err := probe(data[:n])
+		if complete := n/417 - 1; complete < 9 {
+			if !errors.Is(err, audio.ErrTruncated) {
+				t.Fatalf("cut to %d bytes with %d frames: error %v, want ErrTruncated", n, complete, err)
+			}
+		} else if err != nil {
+			t.Fatalf("cut to %d bytes with %d frames: %v", n, complete, err)
+		}
+	}
+}
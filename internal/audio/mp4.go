@@ -0,0 +1,1015 @@
+package audio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mp4MaxBox      = 16 << 20 // largest leaf box read into memory
+	mp4MaxChapters = 4096
+)
+
+// mp4Box is a box header. Offsets are absolute.
+type mp4Box struct {
+	typ  string
+	off  int64 // of the header
+	body int64 // of the payload
+	end  int64
+}
+
+// mp4Track is what the parser keeps of a trak box
+type mp4Track struct {
+	id        uint32
+	handler   string
+	timescale uint32
+	duration  uint64
+	chapters  []uint32 // track ids referenced by tref/chap
+	stbl      mp4Box
+	entry     *mp4SampleEntry
+}
+
+// mp4SampleEntry is the first stsd entry of an audio track
+type mp4SampleEntry struct {
+	codec      string
+	channels   int
+	sampleRate int
+	bitDepth   int
+	avgBitrate int
+	maxBitrate int
+	config     []byte // AAC AudioSpecificConfig
+}
+
+type mp4Parser struct {
+	r    io.ReaderAt
+	size int64
+	info *Info
+
+	movieTimescale uint32
+	movieDuration  uint64
+	fragDuration   uint64            // mehd, in the movie timescale
+	trexDurations  map[uint32]uint32 // default sample duration per track
+	tracks         []*mp4Track
+	moofs          []mp4Box
+}
+
+// parseMP4 reads an ISO base media file (MP4, M4A, M4B or QuickTime). Only
+// the boxes needed are read, so the file's layout (moov before or after
+// mdat) does not matter.
+func parseMP4(r io.ReaderAt, size int64) (*Info, error) {
+	const format = "mp4"
+
+	p := &mp4Parser{
+		r:             r,
+		size:          size,
+		info:          &Info{Format: format, Tags: map[string]string{}},
+		trexDurations: map[uint32]uint32{},
+	}
+	info := p.info
+
+	var moov *mp4Box
+	err := p.children(0, size, func(b mp4Box) error {
+		switch b.typ {
+		case "ftyp":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 8 {
+				return malformed(format, b.off, "ftyp box is %d bytes, need 8", len(body))
+			}
+			info.Brand = strings.TrimSpace(string(body[:4]))
+		case "moov":
+			if moov != nil {
+				return malformed(format, b.off, "duplicate moov box")
+			}
+			moov = &b
+		case "moof":
+			info.Fragmented = true
+			p.moofs = append(p.moofs, b)
+		case "mdat":
+			if info.DataSize == 0 {
+				info.DataOffset = b.body
+			}
+			info.DataSize += b.end - b.body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, truncated(format, size, "no moov box")
+	}
+	if err := p.parseMoov(*moov); err != nil {
+		return nil, err
+	}
+
+	var audio *mp4Track
+	for _, t := range p.tracks {
+		if t.handler == "soun" && t.entry != nil {
+			audio = t
+			break
+		}
+	}
+	if audio == nil {
+		return nil, malformed(format, moov.off, "no audio track")
+	}
+
+	e := audio.entry
+	info.Codec = e.codec
+	info.Channels = e.channels
+	info.SampleRate = e.sampleRate
+	info.BitDepth = e.bitDepth
+	info.Layout = ChannelLayout(e.channels, 0)
+	if e.codec == "aac" || e.codec == "he_aac" || e.codec == "he_aac_v2" {
+		if e.avgBitrate > 0 && e.avgBitrate == e.maxBitrate {
+			info.BitrateMode = "CBR"
+		} else {
+			info.BitrateMode = "VBR"
+		}
+	}
+	info.Encoder = info.Tags["©too"]
+
+	duration, timescale := audio.duration, audio.timescale
+	if duration == 0 || duration == 0xFFFFFFFF || duration == 1<<64-1 {
+		duration, timescale = p.movieDuration, p.movieTimescale
+	}
+	if info.Fragmented && (duration == 0 || duration == 0xFFFFFFFF) {
+		if p.fragDuration > 0 {
+			duration, timescale = p.fragDuration, p.movieTimescale
+		} else if duration, err = p.fragmentsDuration(audio.id); err != nil {
+			return nil, err
+		} else {
+			timescale = audio.timescale
+		}
+	}
+	if timescale > 0 && info.SampleRate > 0 {
+		info.setFrames(int64(float64(duration) * float64(info.SampleRate) / float64(timescale)))
+	}
+	p.applyITunSMPB()
+
+	switch {
+	case info.Duration > 0 && info.DataSize > 0:
+		info.Bitrate = int(float64(info.DataSize*8) / info.Duration.Seconds())
+	case e.avgBitrate > 0:
+		info.Bitrate = e.avgBitrate
+	}
+
+	for _, id := range audio.chapters {
+		for _, t := range p.tracks {
+			if t.id == id && t.handler == "text" {
+				if info.Chapters, err = p.readChapters(t, info.Duration); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return info, nil
+}
+
+// children calls fn for each box between start and end
+func (p *mp4Parser) children(start, end int64, fn func(mp4Box) error) error {
+	const format = "mp4"
+
+	for off := start; off+8 <= end; {
+		h, err := readAt(p.r, off, 8)
+		if err != nil {
+			return truncated(format, off, "box header: %v", err)
+		}
+		b := mp4Box{typ: latin1(h[4:8]), off: off, body: off + 8}
+		size := int64(be.Uint32(h[0:4]))
+		switch size {
+		case 0: // to the end of the enclosing box
+			size = end - off
+		case 1:
+			ext, err := readAt(p.r, off+8, 8)
+			if err != nil {
+				return truncated(format, off, "box header: %v", err)
+			}
+			size = int64(be.Uint64(ext))
+			b.body += 8
+		}
+		if size < b.body-off {
+			return malformed(format, off, "%q box size %d is smaller than its header", b.typ, size)
+		}
+		b.end = off + size
+		if b.end > end || b.end < off {
+			if end == p.size {
+				return truncated(format, off, "%q box declares %d bytes but only %d remain", b.typ, size, end-off)
+			}
+			return malformed(format, off, "%q box overruns its parent", b.typ)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		off = b.end
+	}
+	return nil
+}
+
+// read returns the payload of a leaf box
+func (p *mp4Parser) read(b mp4Box) ([]byte, error) {
+	if n := b.end - b.body; n > mp4MaxBox {
+		return nil, malformed("mp4", b.off, "%q box of %d bytes is too large", b.typ, n)
+	}
+	body, err := readAt(p.r, b.body, int(b.end-b.body))
+	if err != nil {
+		return nil, truncated("mp4", b.body, "%q box: %v", b.typ, err)
+	}
+	return body, nil
+}
+
+// find returns the first child of b of the given type
+func (p *mp4Parser) find(b mp4Box, typ string) (*mp4Box, error) {
+	var found *mp4Box
+	err := p.children(b.body, b.end, func(c mp4Box) error {
+		if found == nil && c.typ == typ {
+			found = &c
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (p *mp4Parser) parseMoov(moov mp4Box) error {
+	const format = "mp4"
+
+	return p.children(moov.body, moov.end, func(b mp4Box) error {
+		switch b.typ {
+		case "mvhd":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 20 || (body[0] == 1 && len(body) < 32) {
+				return malformed(format, b.off, "mvhd box is too short")
+			}
+			if body[0] == 1 {
+				p.movieTimescale = be.Uint32(body[20:24])
+				p.movieDuration = be.Uint64(body[24:32])
+			} else {
+				p.movieTimescale = be.Uint32(body[12:16])
+				p.movieDuration = uint64(be.Uint32(body[16:20]))
+			}
+		case "trak":
+			t, err := p.parseTrak(b)
+			if err != nil {
+				return err
+			}
+			p.tracks = append(p.tracks, t)
+		case "mvex":
+			p.info.Fragmented = true
+			return p.parseMvex(b)
+		case "udta":
+			meta, err := p.find(b, "meta")
+			if err != nil || meta == nil {
+				return err
+			}
+			return p.parseMeta(*meta)
+		case "meta":
+			return p.parseMeta(b)
+		}
+		return nil
+	})
+}
+
+func (p *mp4Parser) parseTrak(trak mp4Box) (*mp4Track, error) {
+	const format = "mp4"
+
+	t := &mp4Track{}
+	err := p.children(trak.body, trak.end, func(b mp4Box) error {
+		switch b.typ {
+		case "tkhd":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			idOff := 12
+			if len(body) > 0 && body[0] == 1 {
+				idOff = 20
+			}
+			if len(body) < idOff+4 {
+				return malformed(format, b.off, "tkhd box is too short")
+			}
+			t.id = be.Uint32(body[idOff:])
+		case "tref":
+			chap, err := p.find(b, "chap")
+			if err != nil || chap == nil {
+				return err
+			}
+			body, err := p.read(*chap)
+			if err != nil {
+				return err
+			}
+			for i := 0; i+4 <= len(body); i += 4 {
+				t.chapters = append(t.chapters, be.Uint32(body[i:]))
+			}
+		case "mdia":
+			return p.parseMdia(b, t)
+		}
+		return nil
+	})
+	return t, err
+}
+
+func (p *mp4Parser) parseMdia(mdia mp4Box, t *mp4Track) error {
+	const format = "mp4"
+
+	var minf *mp4Box
+	err := p.children(mdia.body, mdia.end, func(b mp4Box) error {
+		switch b.typ {
+		case "mdhd":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 20 || (body[0] == 1 && len(body) < 32) {
+				return malformed(format, b.off, "mdhd box is too short")
+			}
+			if body[0] == 1 {
+				t.timescale = be.Uint32(body[20:24])
+				t.duration = be.Uint64(body[24:32])
+			} else {
+				t.timescale = be.Uint32(body[12:16])
+				t.duration = uint64(be.Uint32(body[16:20]))
+			}
+		case "hdlr":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 12 {
+				return malformed(format, b.off, "hdlr box is too short")
+			}
+			t.handler = string(body[8:12])
+		case "minf":
+			minf = &b
+		}
+		return nil
+	})
+	if err != nil || minf == nil {
+		return err
+	}
+
+	stbl, err := p.find(*minf, "stbl")
+	if err != nil || stbl == nil {
+		return err
+	}
+	t.stbl = *stbl
+	if t.handler != "soun" {
+		return nil
+	}
+
+	stsd, err := p.find(*stbl, "stsd")
+	if err != nil || stsd == nil {
+		return err
+	}
+	// A full box with an entry count, then the sample entries
+	if stsd.end-stsd.body < 8 {
+		return malformed(format, stsd.off, "stsd box is too short")
+	}
+	return p.children(stsd.body+8, stsd.end, func(b mp4Box) error {
+		if t.entry == nil {
+			t.entry, err = p.parseAudioSampleEntry(b)
+		}
+		return err
+	})
+}
+
+// parseAudioSampleEntry reads an AudioSampleEntry (QuickTime sound sample
+// description versions 0 to 2) and the codec configuration boxes within it
+func (p *mp4Parser) parseAudioSampleEntry(b mp4Box) (*mp4SampleEntry, error) {
+	const format = "mp4"
+
+	if b.end-b.body < 28 {
+		return nil, malformed(format, b.off, "%q sample entry is too short", b.typ)
+	}
+	h, err := readAt(p.r, b.body, 28)
+	if err != nil {
+		return nil, truncated(format, b.body, "sample entry: %v", err)
+	}
+
+	e := &mp4SampleEntry{
+		codec:      mp4Codec(b.typ),
+		channels:   int(be.Uint16(h[16:18])),
+		bitDepth:   int(be.Uint16(h[18:20])),
+		sampleRate: int(be.Uint32(h[24:28]) >> 16), // 16.16 fixed point
+	}
+	children := b.body + 28
+	switch version := be.Uint16(h[8:10]); version {
+	case 1:
+		children += 16
+	case 2:
+		children += 36
+		v2, err := readAt(p.r, b.body+28, 36)
+		if err != nil {
+			return nil, truncated(format, b.body, "sample entry: %v", err)
+		}
+		e.sampleRate = int(math.Float64frombits(be.Uint64(v2[4:12])))
+		e.channels = int(be.Uint32(v2[12:16]))
+		e.bitDepth = int(be.Uint32(v2[20:24]))
+	}
+	if children > b.end {
+		return nil, malformed(format, b.off, "%q sample entry is too short", b.typ)
+	}
+
+	// Lossy codecs have no meaningful sample size
+	switch e.codec {
+	case "alac", "flac", "pcm":
+	default:
+		e.bitDepth = 0
+	}
+
+	err = p.children(children, b.end, func(c mp4Box) error {
+		switch c.typ {
+		case "wave":
+			// QuickTime wraps the codec configuration
+			esds, err := p.find(c, "esds")
+			if err != nil || esds == nil {
+				return err
+			}
+			return p.parseESDS(*esds, e)
+		case "esds":
+			return p.parseESDS(c, e)
+		case "alac":
+			body, err := p.read(c)
+			if err != nil {
+				return err
+			}
+			if len(body) < 28 {
+				return malformed(format, c.off, "alac box is too short")
+			}
+			cfg := body[4:] // after version and flags
+			e.bitDepth = int(cfg[5])
+			e.channels = int(cfg[9])
+			e.maxBitrate = 0
+			e.avgBitrate = int(be.Uint32(cfg[16:20]))
+			e.sampleRate = int(be.Uint32(cfg[20:24]))
+		}
+		return nil
+	})
+	return e, err
+}
+
+// parseESDS reads the MPEG-4 elementary stream descriptor
+func (p *mp4Parser) parseESDS(b mp4Box, e *mp4SampleEntry) error {
+	const format = "mp4"
+
+	body, err := p.read(b)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4 {
+		return malformed(format, b.off, "esds box is too short")
+	}
+
+	tag, es, _, ok := mp4Descriptor(body[4:])
+	if !ok || tag != 0x03 || len(es) < 3 {
+		return malformed(format, b.off, "esds box lacks an ES descriptor")
+	}
+	flags := es[2]
+	es = es[3:]
+	if flags&0x80 != 0 { // stream dependence
+		es = es[min(2, len(es)):]
+	}
+	if flags&0x40 != 0 && len(es) > 0 { // URL
+		es = es[min(1+int(es[0]), len(es)):]
+	}
+	if flags&0x20 != 0 { // OCR stream
+		es = es[min(2, len(es)):]
+	}
+
+	for len(es) > 0 {
+		tag, dc, rest, ok := mp4Descriptor(es)
+		if !ok {
+			return malformed(format, b.off, "esds descriptor overruns the box")
+		}
+		es = rest
+		if tag != 0x04 {
+			continue
+		}
+		if len(dc) < 13 {
+			return malformed(format, b.off, "decoder config descriptor is too short")
+		}
+		switch dc[0] {
+		case 0x69, 0x6B:
+			e.codec = "mp3"
+		case 0x66, 0x67, 0x68:
+			e.codec = "aac"
+		}
+		e.maxBitrate = int(be.Uint32(dc[5:9]))
+		e.avgBitrate = int(be.Uint32(dc[9:13]))
+
+		if tag, asc, _, ok := mp4Descriptor(dc[13:]); ok && tag == 0x05 && dc[0] == 0x40 {
+			e.config = asc
+			parseAudioSpecificConfig(asc, e)
+		}
+	}
+	return nil
+}
+
+// mp4Descriptor splits off an MPEG-4 descriptor: a tag, a length of up to
+// four 7-bit bytes, and the payload
+func mp4Descriptor(b []byte) (tag byte, payload, rest []byte, ok bool) {
+	if len(b) < 2 {
+		return 0, nil, nil, false
+	}
+	tag = b[0]
+	n, i := 0, 1
+	for ; i < len(b) && i <= 4; i++ {
+		n = n<<7 | int(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			break
+		}
+	}
+	i++
+	if i+n > len(b) {
+		return 0, nil, nil, false
+	}
+	return tag, b[i : i+n], b[i+n:], true
+}
+
+var (
+	aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+	aacChannels    = []int{0, 1, 2, 3, 4, 5, 6, 8}
+)
+
+// parseAudioSpecificConfig reads the object type, output sample rate and
+// channel count from an AAC AudioSpecificConfig
+func parseAudioSpecificConfig(b []byte, e *mp4SampleEntry) {
+	pos, short := 0, false
+	bits := func(n int) int {
+		v := 0
+		for ; n > 0; n-- {
+			if pos >= len(b)*8 {
+				short = true
+				return 0
+			}
+			v = v<<1 | int(b[pos/8]>>(7-pos%8)&1)
+			pos++
+		}
+		return v
+	}
+	objectType := func() int {
+		t := bits(5)
+		if t == 31 {
+			t = 32 + bits(6)
+		}
+		return t
+	}
+	rate := func() int {
+		i := bits(4)
+		if i == 15 {
+			return bits(24)
+		}
+		if i < len(aacSampleRates) {
+			return aacSampleRates[i]
+		}
+		return 0
+	}
+
+	aot := objectType()
+	sampleRate := rate()
+	channelConfig := bits(4)
+	switch aot {
+	case 5, 29: // explicit SBR, and PS on top of it
+		if ext := rate(); ext > 0 {
+			sampleRate = ext
+		}
+		objectType()
+	}
+	if short {
+		return
+	}
+
+	switch aot {
+	case 5:
+		e.codec = "he_aac"
+	case 29:
+		e.codec = "he_aac_v2"
+	case 23:
+		e.codec = "aac_ld"
+	case 39:
+		e.codec = "aac_eld"
+	default:
+		e.codec = "aac"
+	}
+	if sampleRate > 0 {
+		e.sampleRate = sampleRate
+	}
+	if channelConfig > 0 && channelConfig < len(aacChannels) {
+		e.channels = aacChannels[channelConfig]
+	}
+	if aot == 29 {
+		e.channels = 2 // parametric stereo from a mono core
+	}
+}
+
+func mp4Codec(fourcc string) string {
+	switch fourcc {
+	case "mp4a":
+		return "aac"
+	case "alac":
+		return "alac"
+	case "fLaC":
+		return "flac"
+	case "Opus":
+		return "opus"
+	case "ac-3":
+		return "ac3"
+	case "ec-3":
+		return "eac3"
+	case ".mp3":
+		return "mp3"
+	case "lpcm", "sowt", "twos", "in24", "in32", "fl32", "fl64":
+		return "pcm"
+	}
+	return strings.TrimSpace(strings.ToLower(fourcc))
+}
+
+func (p *mp4Parser) parseMvex(mvex mp4Box) error {
+	const format = "mp4"
+
+	return p.children(mvex.body, mvex.end, func(b mp4Box) error {
+		switch b.typ {
+		case "mehd":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			switch {
+			case len(body) >= 12 && body[0] == 1:
+				p.fragDuration = be.Uint64(body[4:12])
+			case len(body) >= 8:
+				p.fragDuration = uint64(be.Uint32(body[4:8]))
+			default:
+				return malformed(format, b.off, "mehd box is too short")
+			}
+		case "trex":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 16 {
+				return malformed(format, b.off, "trex box is too short")
+			}
+			p.trexDurations[be.Uint32(body[4:8])] = be.Uint32(body[12:16])
+		}
+		return nil
+	})
+}
+
+// fragmentsDuration adds up the sample durations of a track over all movie
+// fragments, for fragmented files that declare no total
+func (p *mp4Parser) fragmentsDuration(trackID uint32) (uint64, error) {
+	const format = "mp4"
+
+	var total uint64
+	for _, moof := range p.moofs {
+		err := p.children(moof.body, moof.end, func(traf mp4Box) error {
+			if traf.typ != "traf" {
+				return nil
+			}
+			defaultDuration, ours := p.trexDurations[trackID], false
+			return p.children(traf.body, traf.end, func(b mp4Box) error {
+				body, err := p.read(b)
+				if err != nil {
+					return err
+				}
+				switch b.typ {
+				case "tfhd":
+					if len(body) < 8 {
+						return malformed(format, b.off, "tfhd box is too short")
+					}
+					flags := be.Uint32(body[0:4]) & 0xFFFFFF
+					ours = be.Uint32(body[4:8]) == trackID
+					i := 8
+					if flags&0x01 != 0 { // base data offset
+						i += 8
+					}
+					if flags&0x02 != 0 { // sample description index
+						i += 4
+					}
+					if flags&0x08 != 0 && i+4 <= len(body) {
+						defaultDuration = be.Uint32(body[i:])
+					}
+				case "trun":
+					if !ours {
+						return nil
+					}
+					if len(body) < 8 {
+						return malformed(format, b.off, "trun box is too short")
+					}
+					flags := be.Uint32(body[0:4]) & 0xFFFFFF
+					count := int(be.Uint32(body[4:8]))
+					i := 8
+					if flags&0x01 != 0 { // data offset
+						i += 4
+					}
+					if flags&0x04 != 0 { // first sample flags
+						i += 4
+					}
+					if flags&0x100 == 0 {
+						total += uint64(count) * uint64(defaultDuration)
+						return nil
+					}
+					stride := 4
+					for _, f := range []uint32{0x200, 0x400, 0x800} {
+						if flags&f != 0 {
+							stride += 4
+						}
+					}
+					if i+count*stride > len(body) {
+						return malformed(format, b.off, "trun box is too short for %d samples", count)
+					}
+					for j := 0; j < count; j++ {
+						total += uint64(be.Uint32(body[i+j*stride:]))
+					}
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// parseMeta reads the iTunes metadata list
+func (p *mp4Parser) parseMeta(meta mp4Box) error {
+	// ISO meta is a full box, QuickTime's is not: look for the hdlr child
+	start := meta.body
+	if h, err := readAt(p.r, meta.body+4, 4); err == nil && string(h) != "hdlr" {
+		start += 4
+	}
+	return p.children(start, meta.end, func(b mp4Box) error {
+		if b.typ != "ilst" {
+			return nil
+		}
+		return p.children(b.body, b.end, p.parseIlstItem)
+	})
+}
+
+func (p *mp4Parser) parseIlstItem(item mp4Box) error {
+	info := p.info
+	key := item.typ
+
+	var values []string
+	err := p.children(item.body, item.end, func(b mp4Box) error {
+		body, err := p.read(b)
+		if err != nil {
+			return err
+		}
+		switch b.typ {
+		case "mean", "name":
+			// freeform "----" items are keyed by mean and name
+			if len(body) >= 4 {
+				if b.typ == "mean" {
+					key = "----:" + string(body[4:])
+				} else {
+					key += ":" + string(body[4:])
+				}
+			}
+		case "data":
+			if len(body) < 8 {
+				return nil
+			}
+			typ, payload := be.Uint32(body[0:4])&0xFFFFFF, body[8:]
+			if item.typ == "covr" {
+				if mime := mp4ImageTypes[typ]; mime != "" {
+					info.Pictures = append(info.Pictures, Picture{Type: 3, MIMEType: mime, Data: payload})
+				}
+				return nil
+			}
+			if v := mp4Value(item.typ, typ, payload); v != "" {
+				values = append(values, v)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(values) == 0 {
+		return err
+	}
+
+	if key == "gnre" {
+		key = "©gen"
+	}
+	if prev, ok := info.Tags[key]; ok {
+		values = append([]string{prev}, values...)
+	}
+	info.Tags[key] = strings.Join(values, "; ")
+	return nil
+}
+
+// mp4ImageTypes maps the well-known data types of cover art to MIME types
+var mp4ImageTypes = map[uint32]string{
+	13: "image/jpeg",
+	14: "image/png",
+	27: "image/bmp",
+}
+
+// mp4Value renders an ilst data payload as text
+func mp4Value(item string, typ uint32, b []byte) string {
+	switch typ {
+	case 1: // UTF-8
+		return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+	case 2: // UTF-16
+		return strings.TrimSpace(decodeUTF16(b, true))
+	case 21, 22: // big-endian signed or unsigned integer
+		var v uint64
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		if typ == 21 && len(b) > 0 && len(b) < 8 && b[0]&0x80 != 0 {
+			return strconv.FormatInt(int64(v)-1<<(8*len(b)), 10)
+		}
+		return strconv.FormatUint(v, 10)
+	case 0: // implicit, known by the item
+		switch item {
+		case "trkn", "disk":
+			if len(b) < 6 {
+				return ""
+			}
+			n, total := be.Uint16(b[2:4]), be.Uint16(b[4:6])
+			switch {
+			case n == 0:
+				return ""
+			case total == 0:
+				return strconv.Itoa(int(n))
+			}
+			return fmt.Sprintf("%d/%d", n, total)
+		case "gnre":
+			// ID3v1 genre number plus one
+			if len(b) >= 2 {
+				if g := int(be.Uint16(b)) - 1; g >= 0 && g < len(id3Genres) {
+					return id3Genres[g]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// applyITunSMPB takes the gapless playback info iTunes stores as hex
+// fields: zero, encoder delay, padding and the original sample count
+func (p *mp4Parser) applyITunSMPB() {
+	info := p.info
+	fields := strings.Fields(info.Tags["----:com.apple.iTunes:iTunSMPB"])
+	if len(fields) < 4 {
+		return
+	}
+	delay, err1 := strconv.ParseInt(fields[1], 16, 64)
+	padding, err2 := strconv.ParseInt(fields[2], 16, 64)
+	samples, err3 := strconv.ParseInt(fields[3], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil || samples <= 0 {
+		return
+	}
+	info.EncoderDelay = int(delay)
+	info.EncoderPadding = int(padding)
+	info.setFrames(samples)
+}
+
+// readChapters reads the titles of a QuickTime chapter track. Each sample
+// is a 16-bit length followed by the text; the samples' durations give the
+// chapter boundaries.
+func (p *mp4Parser) readChapters(t *mp4Track, total time.Duration) ([]Chapter, error) {
+	const format = "mp4"
+
+	if t.timescale == 0 {
+		return nil, malformed(format, t.stbl.off, "chapter track has no timescale")
+	}
+	tables := map[string][]byte{}
+	err := p.children(t.stbl.body, t.stbl.end, func(b mp4Box) error {
+		switch b.typ {
+		case "stts", "stsz", "stsc", "stco", "co64":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 8 {
+				return malformed(format, b.off, "%q box is too short", b.typ)
+			}
+			tables[b.typ] = body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Start time of each sample
+	var starts []uint64
+	if stts := tables["stts"]; stts != nil {
+		n := int(be.Uint32(stts[4:8]))
+		var ts uint64
+		for i := 0; i < n && 8+i*8+8 <= len(stts) && len(starts) < mp4MaxChapters; i++ {
+			count, delta := be.Uint32(stts[8+i*8:]), be.Uint32(stts[12+i*8:])
+			for j := uint32(0); j < count && len(starts) < mp4MaxChapters; j++ {
+				starts = append(starts, ts)
+				ts += uint64(delta)
+			}
+		}
+	}
+
+	offsets, sizes := mp4SampleLocations(tables, len(starts))
+	toDuration := func(ts uint64) time.Duration {
+		return time.Duration(float64(ts) / float64(t.timescale) * float64(time.Second))
+	}
+
+	var chapters []Chapter
+	for i := 0; i < len(starts) && i < len(offsets); i++ {
+		ch := Chapter{Start: toDuration(starts[i]), End: total}
+		if i+1 < len(starts) {
+			ch.End = toDuration(starts[i+1])
+		}
+		if sizes[i] >= 2 && sizes[i] <= maxTextSize {
+			b, err := readAt(p.r, offsets[i], sizes[i])
+			if err != nil {
+				return nil, truncated(format, offsets[i], "chapter sample: %v", err)
+			}
+			n := int(be.Uint16(b))
+			if text := b[2:]; n <= len(text) {
+				text = text[:n]
+				if len(text) >= 2 && (text[0] == 0xFE && text[1] == 0xFF || text[0] == 0xFF && text[1] == 0xFE) {
+					ch.Title = decodeUTF16(text, true)
+				} else {
+					ch.Title = string(text)
+				}
+			}
+		}
+		chapters = append(chapters, ch)
+	}
+	return chapters, nil
+}
+
+// mp4SampleLocations resolves the file offset and size of the first n
+// samples from the sample size, sample-to-chunk and chunk offset tables
+func mp4SampleLocations(tables map[string][]byte, n int) ([]int64, []int) {
+	stsz, stsc := tables["stsz"], tables["stsc"]
+	if stsz == nil || stsc == nil || len(stsz) < 12 {
+		return nil, nil
+	}
+
+	var chunks []int64
+	if stco := tables["stco"]; stco != nil {
+		for i := 0; i < int(be.Uint32(stco[4:8])) && 8+i*4+4 <= len(stco); i++ {
+			chunks = append(chunks, int64(be.Uint32(stco[8+i*4:])))
+		}
+	} else if co64 := tables["co64"]; co64 != nil {
+		for i := 0; i < int(be.Uint32(co64[4:8])) && 8+i*8+8 <= len(co64); i++ {
+			chunks = append(chunks, int64(be.Uint64(co64[8+i*8:])))
+		}
+	}
+
+	fixed := int(be.Uint32(stsz[4:8]))
+	sizeOf := func(i int) int {
+		if fixed != 0 {
+			return fixed
+		}
+		if 12+i*4+4 > len(stsz) {
+			return 0
+		}
+		return int(be.Uint32(stsz[12+i*4:]))
+	}
+
+	// stsc entries: first chunk (1-based), samples per chunk, description
+	entries := int(be.Uint32(stsc[4:8]))
+	var offsets []int64
+	var sizes []int
+	for e := 0; e < entries && 8+e*12+12 <= len(stsc) && len(offsets) < n; e++ {
+		first := int(be.Uint32(stsc[8+e*12:])) - 1
+		perChunk := int(be.Uint32(stsc[12+e*12:]))
+		last := len(chunks)
+		if e+1 < entries && 8+(e+1)*12+4 <= len(stsc) {
+			last = int(be.Uint32(stsc[8+(e+1)*12:])) - 1
+		}
+		for c := max(first, 0); c < last && c < len(chunks) && len(offsets) < n; c++ {
+			off := chunks[c]
+			for s := 0; s < perChunk && len(offsets) < n; s++ {
+				size := sizeOf(len(offsets))
+				offsets = append(offsets, off)
+				sizes = append(sizes, size)
+				off += int64(size)
+			}
+		}
+	}
+	return offsets, sizes
+}
+
+// isMP4 reports whether the file starts like an ISO base media file
+func isMP4(magic []byte) bool {
+	if len(magic) < 8 {
+		return false
+	}
+	switch string(magic[4:8]) {
+	case "ftyp", "moov", "mdat", "wide":
+		return true
+	}
+	return false
+}
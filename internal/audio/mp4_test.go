@@ -0,0 +1,492 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// u32s encodes big-endian 32-bit fields
+func u32s(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// mp4Box returns a box; the type is Latin-1, as in "©nam"
+func mp4Box(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	b := append(u32s(uint32(8+len(body))), id3v2String(0, typ)...)
+	return append(b, body...)
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, children ...[]byte) []byte {
+	return mp4Box(typ, append([][]byte{u32s(uint32(version)<<24 | flags)}, children...)...)
+}
+
+func mvhdBox(timescale, duration uint32) []byte {
+	return mp4FullBox("mvhd", 0, 0, u32s(0, 0, timescale, duration), make([]byte, 80))
+}
+
+func hdlrBox(handler string) []byte {
+	return mp4FullBox("hdlr", 0, 0, u32s(0), []byte(handler), make([]byte, 13))
+}
+
+// mp4Trak returns a track; extra boxes such as tref go before mdia
+func mp4Trak(id uint32, handler string, timescale, duration uint32, stbl []byte, extra ...[]byte) []byte {
+	tkhd := mp4FullBox("tkhd", 0, 3, u32s(0, 0, id, 0, 0), make([]byte, 60))
+	mdhd := mp4FullBox("mdhd", 0, 0, u32s(0, 0, timescale, duration), []byte{0x55, 0xC4, 0, 0})
+	mdia := mp4Box("mdia", mdhd, hdlrBox(handler), mp4Box("minf", stbl))
+	return mp4Box("trak", append(append([][]byte{tkhd}, extra...), mdia)...)
+}
+
+// mp4Stbl returns sample tables of one chunk at chunkOffset holding samples
+// of the given sizes, each delta ticks long
+func mp4Stbl(entry []byte, delta uint32, sizes []int, chunkOffset uint32) []byte {
+	stsd := mp4FullBox("stsd", 0, 0, u32s(1), entry)
+	if entry == nil {
+		stsd = mp4FullBox("stsd", 0, 0, u32s(0))
+	}
+	stsz := u32s(0, uint32(len(sizes)))
+	for _, s := range sizes {
+		stsz = append(stsz, u32s(uint32(s))...)
+	}
+	return mp4Box("stbl", stsd,
+		mp4FullBox("stts", 0, 0, u32s(1, uint32(len(sizes)), delta)),
+		mp4FullBox("stsc", 0, 0, u32s(1, 1, uint32(len(sizes)), 1)),
+		mp4FullBox("stsz", 0, 0, stsz),
+		mp4FullBox("stco", 0, 0, u32s(1, chunkOffset)))
+}
+
+// mp4AudioEntry returns a sound sample entry of QuickTime version 0, 1 or 2;
+// version 2 carries the format in its extension
+func mp4AudioEntry(fourcc string, version, channels, bitDepth, sampleRate int, children ...[]byte) []byte {
+	h := make([]byte, 28)
+	binary.BigEndian.PutUint16(h[6:], 1) // data reference index
+	binary.BigEndian.PutUint16(h[8:], uint16(version))
+	binary.BigEndian.PutUint16(h[16:], uint16(channels))
+	binary.BigEndian.PutUint16(h[18:], uint16(bitDepth))
+	binary.BigEndian.PutUint32(h[24:], uint32(sampleRate)<<16)
+	switch version {
+	case 1:
+		h = append(h, make([]byte, 16)...)
+	case 2:
+		binary.BigEndian.PutUint16(h[16:], 3)
+		binary.BigEndian.PutUint16(h[18:], 16)
+		binary.BigEndian.PutUint32(h[24:], 1<<16)
+		h = append(h, u32s(72)...)
+		h = binary.BigEndian.AppendUint64(h, math.Float64bits(float64(sampleRate)))
+		h = append(h, u32s(uint32(channels), 0x7F000000, uint32(bitDepth), 0, 0, 1)...)
+	}
+	return mp4Box(fourcc, append([][]byte{h}, children...)...)
+}
+
+// mp4Descriptor returns an MPEG-4 descriptor with a four byte length
+func mp4Descriptor(tag byte, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	return append([]byte{tag, 0x80, 0x80, 0x80, byte(len(body))}, body...)
+}
+
+// esdsBox returns an elementary stream descriptor of the object type
+func esdsBox(objectType byte, maxBitrate, avgBitrate uint32, asc []byte) []byte {
+	config := append([]byte{objectType, 0x15, 0, 0x18, 0}, u32s(maxBitrate, avgBitrate)...)
+	es := mp4Descriptor(0x03, []byte{0, 1, 0},
+		mp4Descriptor(0x04, config, mp4Descriptor(0x05, asc)),
+		mp4Descriptor(0x06, []byte{2}))
+	return mp4FullBox("esds", 0, 0, es)
+}
+
+// AudioSpecificConfigs: AAC LC at 44.1 kHz stereo, and HE-AAC and HE-AAC v2
+// with a 22.05 kHz core
+var (
+	ascLC   = []byte{0x12, 0x10}
+	ascHE   = []byte{0x2B, 0x92, 0x08}
+	ascHEv2 = []byte{0xEB, 0x8A, 0x08}
+)
+
+func aacEntry(maxBitrate, avgBitrate uint32, asc []byte) []byte {
+	return mp4AudioEntry("mp4a", 0, 2, 16, 44100, esdsBox(0x40, maxBitrate, avgBitrate, asc))
+}
+
+// mp4Udta returns iTunes metadata holding the ilst items
+func mp4Udta(items ...[]byte) []byte {
+	return mp4Box("udta", mp4FullBox("meta", 0, 0, hdlrBox("mdir"), mp4Box("ilst", items...)))
+}
+
+func ilstItem(key string, typ uint32, payload []byte) []byte {
+	return mp4Box(key, mp4Box("data", u32s(typ, 0), payload))
+}
+
+func ilstFreeform(mean, name, value string) []byte {
+	return mp4Box("----", mp4FullBox("mean", 0, 0, []byte(mean)), mp4FullBox("name", 0, 0, []byte(name)),
+		mp4Box("data", u32s(1, 0), []byte(value)))
+}
+
+// mp4File lays out ftyp, moov and an mdat holding data, with moov before or
+// after mdat; moov is given where data starts so chunk offsets point at it
+func mp4File(moovLast bool, data []byte, moov func(dataOffset uint32) []byte) []byte {
+	ftyp := mp4Box("ftyp", []byte("M4A "), u32s(0x200), []byte("M4A isommp42"))
+	mdat := mp4Box("mdat", data)
+	if moovLast {
+		return bytes.Join([][]byte{ftyp, mdat, moov(uint32(len(ftyp) + 8))}, nil)
+	}
+	offset := len(ftyp) + len(moov(0)) + 8
+	return bytes.Join([][]byte{ftyp, moov(uint32(offset)), mdat}, nil)
+}
+
+// m4aSamples are ten 100 byte AAC packets, each filled with its number
+func m4aSamples() ([]byte, []int) {
+	var data []byte
+	sizes := make([]int, 10)
+	for i := range sizes {
+		sizes[i] = 100
+		data = append(data, bytes.Repeat([]byte{byte(i + 1)}, 100)...)
+	}
+	return data, sizes
+}
+
+// m4aMoov returns a movie of one audio track of ten 1024 sample packets
+// at 44.1 kHz; extra boxes such as udta follow the track
+func m4aMoov(dataOffset uint32, entry []byte, extra ...[]byte) []byte {
+	_, sizes := m4aSamples()
+	trak := mp4Trak(1, "soun", 44100, 10240, mp4Stbl(entry, 1024, sizes, dataOffset))
+	return mp4Box("moov", append([][]byte{mvhdBox(1000, 232), trak}, extra...)...)
+}
+
+// m4aFile returns an M4A of m4aSamples in the given sample entry
+func m4aFile(moovLast bool, entry []byte, extra ...[]byte) []byte {
+	data, _ := m4aSamples()
+	return mp4File(moovLast, data, func(off uint32) []byte { return m4aMoov(off, entry, extra...) })
+}
+
+func TestProbeMP4(t *testing.T) {
+	aac := aacEntry(160000, 128000, ascLC)
+	data, sizes := m4aSamples()
+
+	// mdat with a 64-bit size, and one running to the end of the file
+	ftyp := mp4Box("ftyp", []byte("M4A "), u32s(0))
+	moovLen := len(m4aMoov(0, aac))
+	largeMdat := bytes.Join([][]byte{ftyp, m4aMoov(uint32(len(ftyp)+moovLen+16), aac), u32s(1), []byte("mdat"),
+		binary.BigEndian.AppendUint64(nil, uint64(16+len(data))), data}, nil)
+	openMdat := bytes.Join([][]byte{ftyp, m4aMoov(uint32(len(ftyp)+moovLen+8), aac), u32s(0), []byte("mdat"), data}, nil)
+
+	// A chapter track whose two samples follow the audio in mdat
+	chapterText := append(append(binary.BigEndian.AppendUint16(nil, 5), "Intro"...),
+		append(binary.BigEndian.AppendUint16(nil, 8), "\xFE\xFF\x00F\x00i\x00n"...)...)
+	chapters := mp4File(false, append(append([]byte{}, data...), chapterText...), func(off uint32) []byte {
+		audioTrak := mp4Trak(1, "soun", 44100, 10240, mp4Stbl(aac, 1024, sizes, off), mp4Box("tref", mp4Box("chap", u32s(2))))
+		text := mp4Box("stbl", mp4FullBox("stsd", 0, 0, u32s(0)),
+			mp4FullBox("stts", 0, 0, u32s(2, 1, 100, 1, 132)),
+			mp4FullBox("stsc", 0, 0, u32s(1, 1, 2, 1)),
+			mp4FullBox("stsz", 0, 0, u32s(0, 2, 7, 10)),
+			mp4FullBox("stco", 0, 0, u32s(1, off+uint32(len(data)))))
+		return mp4Box("moov", mvhdBox(1000, 232), audioTrak, mp4Trak(2, "text", 1000, 232, text))
+	})
+
+	// Fragments declare their samples in trun boxes, defaulting to trex
+	trex := mp4FullBox("trex", 0, 0, u32s(1, 1, 1024, 0, 0))
+	fragmented := bytes.Join([][]byte{
+		ftyp,
+		mp4Box("moov", mvhdBox(1000, 0), mp4Trak(1, "soun", 44100, 0, mp4Stbl(aac, 1024, nil, 0)), mp4Box("mvex", trex)),
+		mp4Box("moof", mp4Box("traf", mp4FullBox("tfhd", 0, 0, u32s(1)), mp4FullBox("trun", 0, 0, u32s(5)))),
+		mp4Box("mdat", data[:500]),
+		mp4Box("moof", mp4Box("traf", mp4FullBox("tfhd", 0, 0x08, u32s(1, 2048)), mp4FullBox("trun", 0, 0x100, u32s(2, 1024, 512)))),
+		mp4Box("mdat", data[500:]),
+	}, nil)
+	withMehd := bytes.Join([][]byte{
+		ftyp,
+		mp4Box("moov", mvhdBox(1000, 0), mp4Trak(1, "soun", 44100, 0, mp4Stbl(aac, 1024, nil, 0)),
+			mp4Box("mvex", mp4FullBox("mehd", 0, 0, u32s(2000)), trex)),
+		mp4Box("moof", mp4Box("traf", mp4FullBox("tfhd", 0, 0, u32s(1)), mp4FullBox("trun", 0, 0, u32s(5)))),
+		mp4Box("mdat", data),
+	}, nil)
+
+	alacConfig := append(u32s(4096), 0, 24, 40, 10, 14, 2, 0, 255)
+	alacConfig = append(alacConfig, u32s(0, 2116800, 96000)...)
+
+	smpb := " 00000000 00000840 000001CA 0000000000002576 00000000 00000000"
+
+	wantAAC := func(t *testing.T, info *audio.Info) {
+		if info.Format != "mp4" || info.Brand != "M4A" || info.Codec != "aac" || info.SampleRate != 44100 || info.Channels != 2 || info.Layout != "stereo" {
+			t.Fatalf("%+v, want stereo AAC at 44.1 kHz in an M4A", info)
+		}
+		if info.Frames != 10240 || info.DataSize != 1000 || info.BitrateMode != "VBR" || info.BitDepth != 0 {
+			t.Fatalf("%d frames in %d bytes, %s, %d bits, want 10240 in 1000, VBR and no bit depth",
+				info.Frames, info.DataSize, info.BitrateMode, info.BitDepth)
+		}
+		if want := int(float64(info.DataSize*8) / info.Duration.Seconds()); info.Bitrate != want {
+			t.Fatalf("%d bps, want %d averaged over the duration", info.Bitrate, want)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "moov before mdat",
+			data: m4aFile(false, aac),
+			check: func(t *testing.T, info *audio.Info) {
+				wantAAC(t, info)
+				if info.DataOffset != int64(len(m4aFile(false, aac))-1000) {
+					t.Fatalf("audio at %d, want the mdat payload at the end", info.DataOffset)
+				}
+			},
+		},
+		{
+			name: "moov after mdat",
+			data: m4aFile(true, aac),
+			check: func(t *testing.T, info *audio.Info) {
+				wantAAC(t, info)
+				if info.DataOffset != 20+8+8 {
+					t.Fatalf("audio at %d, want the mdat payload at 36", info.DataOffset)
+				}
+			},
+		},
+		{
+			name:  "64-bit mdat size",
+			data:  largeMdat,
+			check: wantAAC,
+		},
+		{
+			name:  "mdat to the end of the file",
+			data:  openMdat,
+			check: wantAAC,
+		},
+		{
+			name: "equal average and peak bitrates are CBR",
+			data: m4aFile(false, aacEntry(128000, 128000, ascLC)),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.BitrateMode != "CBR" {
+					t.Fatalf("%s, want CBR", info.BitrateMode)
+				}
+			},
+		},
+		{
+			name: "HE-AAC",
+			data: m4aFile(false, mp4AudioEntry("mp4a", 0, 2, 16, 22050, esdsBox(0x40, 0, 64000, ascHE))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "he_aac" || info.SampleRate != 44100 || info.Channels != 2 {
+					t.Fatalf("%s at %d Hz, %d channels, want he_aac at the 44100 Hz output rate in stereo", info.Codec, info.SampleRate, info.Channels)
+				}
+			},
+		},
+		{
+			name: "HE-AAC v2",
+			data: m4aFile(false, mp4AudioEntry("mp4a", 0, 1, 16, 22050, esdsBox(0x40, 0, 32000, ascHEv2))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "he_aac_v2" || info.SampleRate != 44100 || info.Channels != 2 {
+					t.Fatalf("%s at %d Hz, %d channels, want he_aac_v2 at 44100 Hz with parametric stereo", info.Codec, info.SampleRate, info.Channels)
+				}
+			},
+		},
+		{
+			name: "MP3 in MP4",
+			data: m4aFile(false, mp4AudioEntry("mp4a", 0, 2, 16, 44100, esdsBox(0x6B, 128000, 128000, nil))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "mp3" || info.BitrateMode != "" {
+					t.Fatalf("%s, %q, want mp3 with no bitrate mode", info.Codec, info.BitrateMode)
+				}
+			},
+		},
+		{
+			name: "QuickTime esds in a wave box",
+			data: m4aFile(false, mp4AudioEntry("mp4a", 1, 2, 16, 44100,
+				mp4Box("wave", mp4Box("frma", []byte("mp4a")), esdsBox(0x40, 128000, 128000, ascLC)))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "aac" || info.BitrateMode != "CBR" || info.Channels != 2 {
+					t.Fatalf("%s, %s, %d channels, want CBR stereo aac", info.Codec, info.BitrateMode, info.Channels)
+				}
+			},
+		},
+		{
+			name: "ALAC",
+			data: m4aFile(false, mp4AudioEntry("alac", 0, 2, 16, 44100, mp4FullBox("alac", 0, 0, alacConfig))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "alac" || info.BitDepth != 24 || info.SampleRate != 96000 || info.Channels != 2 || info.BitrateMode != "" {
+					t.Fatalf("%s of %d bits at %d Hz, %d channels, %q, want 24-bit stereo alac at 96000 with no bitrate mode",
+						info.Codec, info.BitDepth, info.SampleRate, info.Channels, info.BitrateMode)
+				}
+			},
+		},
+		{
+			name: "QuickTime version 2 PCM",
+			data: m4aFile(false, mp4AudioEntry("lpcm", 2, 6, 24, 96000)),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "pcm" || info.SampleRate != 96000 || info.Channels != 6 || info.Layout != "5.1" || info.BitDepth != 24 {
+					t.Fatalf("%s of %d bits at %d Hz, %d channels as %s, want 24-bit 5.1 pcm at 96000",
+						info.Codec, info.BitDepth, info.SampleRate, info.Channels, info.Layout)
+				}
+			},
+		},
+		{
+			name: "iTunes metadata",
+			data: m4aFile(true, aac, mp4Udta(
+				ilstItem("©nam", 1, []byte("Song")),
+				ilstItem("©ART", 1, []byte("A")),
+				ilstItem("©ART", 1, []byte("B")),
+				ilstItem("©too", 1, []byte("Lavf60.3.100")),
+				ilstItem("trkn", 0, []byte{0, 0, 0, 3, 0, 12, 0, 0}),
+				ilstItem("disk", 0, []byte{0, 0, 0, 1, 0, 0}),
+				ilstItem("gnre", 0, []byte{0, 18}),
+				ilstItem("tmpo", 21, []byte{0, 120}),
+				ilstItem("rtng", 21, []byte{0xFF}),
+				ilstItem("covr", 14, []byte("\x89PNG")),
+				ilstFreeform("com.apple.iTunes", "iTunSMPB", smpb))),
+			check: func(t *testing.T, info *audio.Info) {
+				want := map[string]string{
+					"©nam": "Song", "©ART": "A; B", "©too": "Lavf60.3.100", "trkn": "3/12", "disk": "1", "©gen": "Rock",
+					"tmpo": "120", "rtng": "-1", "----:com.apple.iTunes:iTunSMPB": "00000000 00000840 000001CA 0000000000002576 00000000 00000000",
+				}
+				if !reflect.DeepEqual(info.Tags, want) {
+					t.Fatalf("tags %q, want %q", info.Tags, want)
+				}
+				if len(info.Pictures) != 1 || info.Pictures[0].MIMEType != "image/png" || info.Pictures[0].Type != 3 {
+					t.Fatalf("pictures %+v, want the PNG front cover", info.Pictures)
+				}
+				if info.Encoder != "Lavf60.3.100" || info.EncoderDelay != 2112 || info.EncoderPadding != 458 || info.Frames != 9590 {
+					t.Fatalf("encoder %q, delay %d, padding %d, %d frames, want iTunSMPB's 2112, 458 and 9590",
+						info.Encoder, info.EncoderDelay, info.EncoderPadding, info.Frames)
+				}
+			},
+		},
+		{
+			name: "chapter track",
+			data: chapters,
+			check: func(t *testing.T, info *audio.Info) {
+				want := []audio.Chapter{
+					{Title: "Intro", Start: 0, End: 100 * time.Millisecond},
+					{Title: "Fin", Start: 100 * time.Millisecond, End: info.Duration},
+				}
+				if !reflect.DeepEqual(info.Chapters, want) {
+					t.Fatalf("chapters %+v, want %+v", info.Chapters, want)
+				}
+			},
+		},
+		{
+			name: "movie duration when the track has none",
+			data: mp4File(false, data, func(off uint32) []byte {
+				return mp4Box("moov", mvhdBox(1000, 500), mp4Trak(1, "soun", 44100, 0, mp4Stbl(aac, 1024, sizes, off)))
+			}),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 22050 {
+					t.Fatalf("%d frames, want the movie's 500 ms", info.Frames)
+				}
+			},
+		},
+		{
+			name: "fragments summed",
+			data: fragmented,
+			check: func(t *testing.T, info *audio.Info) {
+				if !info.Fragmented || info.Frames != 5*1024+1024+512 || info.DataSize != 1000 {
+					t.Fatalf("fragmented %v, %d frames in %d bytes, want 6656 in 1000", info.Fragmented, info.Frames, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "fragment duration from mehd",
+			data: withMehd,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 88200 {
+					t.Fatalf("%d frames, want mehd's 2 s", info.Frames)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestProbeMP4Invalid(t *testing.T) {
+	aac := aacEntry(128000, 128000, ascLC)
+	data, sizes := m4aSamples()
+	ftyp := mp4Box("ftyp", []byte("M4A "), u32s(0))
+	// movie returns a file of ftyp and the given moov children
+	movie := func(children ...[]byte) []byte {
+		return append(append([]byte{}, ftyp...), mp4Box("moov", children...)...)
+	}
+	soun := func(entry []byte) []byte {
+		return mp4Trak(1, "soun", 44100, 10240, mp4Stbl(entry, 1024, sizes, 0))
+	}
+	trak := soun(aac)
+	fragment := func(traf ...[]byte) []byte {
+		return append(movie(mvhdBox(1000, 0), mp4Trak(1, "soun", 44100, 0, mp4Stbl(aac, 1024, nil, 0))), mp4Box("moof", mp4Box("traf", traf...))...)
+	}
+	esdsWith := func(es []byte) []byte {
+		return soun(mp4AudioEntry("mp4a", 0, 2, 16, 44100, mp4FullBox("esds", 0, 0, es)))
+	}
+	chapterTrak := func(timescale uint32) []byte {
+		return mp4Trak(2, "text", timescale, 0, mp4Stbl(nil, 1, []int{2}, 0))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"no moov", append(append([]byte{}, ftyp...), mp4Box("mdat", data)...), audio.ErrTruncated},
+		{"moov cut short", movie(mvhdBox(1000, 232), trak)[:200], audio.ErrTruncated},
+		{"duplicate moov", append(movie(trak), mp4Box("moov", trak)...), audio.ErrMalformed},
+		{"no audio track", movie(mvhdBox(1000, 232), chapterTrak(1000)), audio.ErrMalformed},
+		{"box smaller than its header", append(movie(trak), append(u32s(4), "free"...)...), audio.ErrMalformed},
+		{"child overruns its parent", append(movie(trak, u32s(64), []byte("free")), mp4Box("mdat", data)...), audio.ErrMalformed},
+		{"short ftyp", mp4Box("ftyp", []byte("M4A ")), audio.ErrMalformed},
+		{"short mvhd", movie(mp4FullBox("mvhd", 0, 0, u32s(0, 0)), trak), audio.ErrMalformed},
+		{"short tkhd", movie(mp4Box("trak", mp4FullBox("tkhd", 0, 0, u32s(0)))), audio.ErrMalformed},
+		{"short mdhd", movie(mp4Box("trak", mp4Box("mdia", mp4FullBox("mdhd", 1, 0, u32s(0, 0, 0, 0, 0))))), audio.ErrMalformed},
+		{"short hdlr", movie(mp4Box("trak", mp4Box("mdia", mp4FullBox("hdlr", 0, 0)))), audio.ErrMalformed},
+		{"short stsd", movie(mp4Box("trak", mp4Box("mdia", hdlrBox("soun"), mp4Box("minf", mp4Box("stbl", mp4Box("stsd")))))), audio.ErrMalformed},
+		{"short sample entry", movie(soun(mp4Box("mp4a", make([]byte, 20)))), audio.ErrMalformed},
+		{"version 2 entry cut short", movie(soun(mp4Box("lpcm", mp4AudioEntry("lpcm", 2, 2, 16, 44100)[8:8+40]))), audio.ErrMalformed},
+		{"esds without ES descriptor", movie(esdsWith(mp4Descriptor(0x04, make([]byte, 13)))), audio.ErrMalformed},
+		{"short decoder config", movie(esdsWith(mp4Descriptor(0x03, []byte{0, 1, 0}, mp4Descriptor(0x04, make([]byte, 5))))), audio.ErrMalformed},
+		{"descriptor overruns the esds", movie(esdsWith(mp4Descriptor(0x03, []byte{0, 1, 0, 0x04, 0x7F}))), audio.ErrMalformed},
+		{"short alac config", movie(soun(mp4AudioEntry("alac", 0, 2, 16, 44100, mp4FullBox("alac", 0, 0, make([]byte, 10))))), audio.ErrMalformed},
+		{"short mehd", movie(trak, mp4Box("mvex", mp4Box("mehd", []byte{0}))), audio.ErrMalformed},
+		{"short trex", movie(trak, mp4Box("mvex", mp4FullBox("trex", 0, 0, u32s(1)))), audio.ErrMalformed},
+		{"short tfhd", fragment(mp4FullBox("tfhd", 0, 0)), audio.ErrMalformed},
+		{"trun shorter than its samples", fragment(mp4FullBox("tfhd", 0, 0, u32s(1)), mp4FullBox("trun", 0, 0x100, u32s(3, 1024))), audio.ErrMalformed},
+		{"chapter track without timescale", movie(mvhdBox(1000, 232),
+			mp4Trak(1, "soun", 44100, 10240, mp4Stbl(aac, 1024, sizes, 0), mp4Box("tref", mp4Box("chap", u32s(2)))),
+			chapterTrak(0)), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, "mp4", tt.want)
+		})
+	}
+}
+
+func TestProbeMP4Truncated(t *testing.T) {
+	// With moov last every cut loses it or part of it
+	data := m4aFile(true, aacEntry(128000, 128000, ascLC), mp4Udta(ilstItem("©nam", 1, []byte("Song"))))
+	for n := 8; n < len(data); n++ {
+		if _, err := probe(data[:n]); !errors.Is(err, audio.ErrTruncated) {
+			t.Fatalf("cut to %d of %d bytes: error %v, want ErrTruncated", n, len(data), err)
+		}
+	}
+
+	// With moov first, cutting into mdat is caught by its size
+	data = m4aFile(false, aacEntry(128000, 128000, ascLC))
+	for n := len(data) - 1000; n < len(data); n++ {
+		if _, err := probe(data[:n]); !errors.Is(err, audio.ErrTruncated) {
+			t.Fatalf("cut to %d of %d bytes: error %v, want ErrTruncated", n, len(data), err)
+		}
+	}
+}
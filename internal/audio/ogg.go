@@ -0,0 +1,338 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	oggHeaderSize = 27
+	oggMaxPacket  = 16 << 20 // header packets may carry artwork, but not this much
+
+	// page header_type flags
+	oggContinued = 0x01
+	oggBOS       = 0x02
+	oggEOS       = 0x04
+
+	// opusRate is the rate Opus granule positions count in, whatever the input rate
+	opusRate = 48000
+)
+
+// oggPage is a page header. The page body is verified but not kept.
+type oggPage struct {
+	offset     int64
+	headerType byte
+	granule    int64 // -1 if no packet ends on this page
+	serial     uint32
+	sequence   uint32
+	lacing     []byte
+	size       int64 // header, lacing and body
+}
+
+// readOggPage reads the page at off and checks its CRC. body receives the
+// page data when it is not nil.
+func readOggPage(r io.ReaderAt, off, size int64, body *[]byte) (*oggPage, error) {
+	const format = "ogg"
+
+	h, err := readAt(r, off, oggHeaderSize)
+	if err != nil {
+		return nil, truncated(format, off, "page header: %v", err)
+	}
+	if string(h[:4]) != "OggS" {
+		return nil, malformed(format, off, "missing page capture pattern")
+	}
+	if h[4] != 0 {
+		return nil, malformed(format, off, "unsupported stream structure version %d", h[4])
+	}
+
+	p := &oggPage{
+		offset:     off,
+		headerType: h[5],
+		granule:    int64(le.Uint64(h[6:14])),
+		serial:     le.Uint32(h[14:18]),
+		sequence:   le.Uint32(h[18:22]),
+	}
+	nsegs := int(h[26])
+	if p.lacing, err = readAt(r, off+oggHeaderSize, nsegs); err != nil {
+		return nil, truncated(format, off, "page lacing: %v", err)
+	}
+	bodySize := 0
+	for _, l := range p.lacing {
+		bodySize += int(l)
+	}
+	p.size = int64(oggHeaderSize+nsegs) + int64(bodySize)
+	if off+p.size > size {
+		return nil, truncated(format, off, "page declares %d bytes but only %d remain", p.size, size-off)
+	}
+	b, err := readAt(r, off+oggHeaderSize+int64(nsegs), bodySize)
+	if err != nil {
+		return nil, truncated(format, off, "page body: %v", err)
+	}
+
+	// The CRC covers the whole page with its own field zeroed
+	want := le.Uint32(h[22:26])
+	clear(h[22:26])
+	crc := oggCRC(0, h)
+	crc = oggCRC(crc, p.lacing)
+	crc = oggCRC(crc, b)
+	if crc != want {
+		return nil, &ParseError{Format: format, Offset: off, Err: ErrCorrupt,
+			Msg: fmt.Sprintf("page CRC is %08x, header says %08x", crc, want)}
+	}
+
+	if body != nil {
+		*body = b
+	}
+	return p, nil
+}
+
+// oggStream is what parseOgg gathers about the first logical stream
+type oggStream struct {
+	serial     uint32
+	sequence   uint32
+	packets    [][]byte // complete header packets
+	partial    []byte   // header packet continued on the next page
+	continuing bool     // the last page ended within a packet
+	granule    int64    // last known granule position
+	eos        bool
+}
+
+// addPage splits the page body into packets, keeping the first want packets
+func (s *oggStream) addPage(p *oggPage, body []byte, want int) error {
+	const format = "ogg"
+
+	if continued := p.headerType&oggContinued != 0; continued != s.continuing {
+		if continued {
+			return malformed(format, p.offset, "page continues a packet that never started")
+		}
+		return malformed(format, p.offset, "page does not continue the unfinished packet")
+	}
+
+	start, end := 0, 0
+	for _, l := range p.lacing {
+		end += int(l)
+		if l == 255 {
+			continue // the packet goes on
+		}
+		if len(s.packets) < want {
+			s.packets = append(s.packets, append(s.partial, body[start:end]...))
+		}
+		s.partial = nil
+		start = end
+	}
+
+	s.continuing = len(p.lacing) > 0 && p.lacing[len(p.lacing)-1] == 255
+	if s.continuing && len(s.packets) < want {
+		if len(s.partial)+end-start > oggMaxPacket {
+			return malformed(format, p.offset, "header packet exceeds %d bytes", oggMaxPacket)
+		}
+		s.partial = append(s.partial, body[start:end]...)
+	}
+	return nil
+}
+
+func parseOgg(r io.ReaderAt, size int64) (*Info, error) {
+	const format = "ogg"
+
+	var (
+		stream *oggStream
+		want   = 1 // header packets needed, known once the first one is read
+		body   []byte
+		codec  string
+	)
+
+	off := int64(0)
+	for off < size {
+		p, err := readOggPage(r, off, size, &body)
+		if err != nil {
+			return nil, err
+		}
+
+		if stream == nil {
+			if p.headerType&oggBOS == 0 {
+				return nil, malformed(format, off, "first page does not start a stream")
+			}
+			stream = &oggStream{serial: p.serial, sequence: p.sequence - 1, granule: -1}
+		}
+		if p.serial != stream.serial || stream.eos {
+			// Another multiplexed or chained stream, only the first is described
+			off += p.size
+			continue
+		}
+
+		if p.sequence != stream.sequence+1 {
+			return nil, &ParseError{Format: format, Offset: off, Err: ErrCorrupt,
+				Msg: fmt.Sprintf("page sequence jumps from %d to %d", stream.sequence, p.sequence)}
+		}
+		stream.sequence = p.sequence
+		if err := stream.addPage(p, body, want); err != nil {
+			return nil, err
+		}
+		if codec == "" && len(stream.packets) > 0 {
+			switch id := stream.packets[0]; {
+			case bytes.HasPrefix(id, []byte("\x01vorbis")):
+				codec, want = "vorbis", 3
+			case bytes.HasPrefix(id, []byte("OpusHead")):
+				codec, want = "opus", 2
+			default:
+				// Speex, Ogg FLAC, Theora...
+				return nil, ErrUnknownFormat
+			}
+		}
+		if p.granule != -1 {
+			stream.granule = p.granule
+		}
+		stream.eos = p.headerType&oggEOS != 0
+		off += p.size
+	}
+
+	if stream == nil {
+		return nil, truncated(format, 0, "no pages")
+	}
+	if len(stream.packets) < want {
+		return nil, truncated(format, size, "stream ends within its headers")
+	}
+
+	info := &Info{Format: format, Codec: codec, Tags: map[string]string{}}
+	switch codec {
+	case "vorbis":
+		if err := parseVorbisHeaders(stream.packets, info); err != nil {
+			return nil, err
+		}
+	case "opus":
+		if err := parseOpusHeaders(stream.packets, info); err != nil {
+			return nil, err
+		}
+	}
+
+	info.Layout = ChannelLayout(info.Channels, 0)
+	info.DataSize = size
+	if stream.granule > 0 {
+		frames := stream.granule
+		if codec == "opus" {
+			frames -= int64(info.EncoderDelay)
+		}
+		info.setFrames(max(frames, 0))
+	}
+	// The nominal Vorbis bitrate is only kept when there is no duration to average over
+	if info.Duration > 0 {
+		info.Bitrate = int(float64(size*8) / info.Duration.Seconds())
+	}
+	return info, nil
+}
+
+// parseVorbisHeaders reads the identification and comment headers
+func parseVorbisHeaders(packets [][]byte, info *Info) error {
+	const format = "ogg"
+
+	id := packets[0]
+	if len(id) < 30 {
+		return malformed(format, 0, "Vorbis identification header is %d bytes, need 30", len(id))
+	}
+	if v := le.Uint32(id[7:11]); v != 0 {
+		return malformed(format, 0, "unsupported Vorbis version %d", v)
+	}
+	info.Channels = int(id[11])
+	info.SampleRate = int(le.Uint32(id[12:16]))
+	if info.Channels == 0 || info.SampleRate == 0 {
+		return malformed(format, 0, "Vorbis header has %d channels at %d Hz", info.Channels, info.SampleRate)
+	}
+	if id[29]&1 == 0 {
+		return malformed(format, 0, "Vorbis identification header lacks its framing bit")
+	}
+	if nominal := int32(le.Uint32(id[20:24])); nominal > 0 {
+		info.Bitrate = int(nominal)
+	}
+	upper, lower := int32(le.Uint32(id[16:20])), int32(le.Uint32(id[24:28]))
+	if upper > 0 && upper == lower {
+		info.BitrateMode = "CBR"
+	} else {
+		info.BitrateMode = "VBR"
+	}
+
+	comment := packets[1]
+	if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+		return malformed(format, 0, "second Vorbis packet is not the comment header")
+	}
+	if err := parseVorbisComment(comment[7:], info); err != nil {
+		return malformed(format, 0, "Vorbis comment header: %v", err)
+	}
+	if !bytes.HasPrefix(packets[2], []byte("\x05vorbis")) {
+		return malformed(format, 0, "third Vorbis packet is not the setup header")
+	}
+	return nil
+}
+
+// parseOpusHeaders reads OpusHead and OpusTags
+func parseOpusHeaders(packets [][]byte, info *Info) error {
+	const format = "ogg"
+
+	head := packets[0]
+	if len(head) < 19 {
+		return malformed(format, 0, "OpusHead is %d bytes, need 19", len(head))
+	}
+	if version := head[8]; version>>4 != 0 {
+		return malformed(format, 0, "unsupported Opus version %d.%d", version>>4, version&0x0F)
+	}
+	info.Channels = int(head[9])
+	info.EncoderDelay = int(le.Uint16(head[10:12]))
+	info.SampleRate = opusRate
+	info.InputSampleRate = int(le.Uint32(head[12:16]))
+	info.OutputGain = float64(int16(le.Uint16(head[16:18]))) / 256 // Q7.8 dB
+	info.BitrateMode = "VBR"
+	if info.Channels == 0 {
+		return malformed(format, 0, "OpusHead declares zero channels")
+	}
+
+	switch family := head[18]; {
+	case family == 0 && info.Channels > 2:
+		return malformed(format, 0, "mapping family 0 allows 2 channels, header has %d", info.Channels)
+	case family != 0:
+		if len(head) < 21+info.Channels {
+			return malformed(format, 0, "OpusHead too short for its channel mapping table")
+		}
+		streams, coupled := int(head[19]), int(head[20])
+		if streams == 0 || coupled > streams {
+			return malformed(format, 0, "invalid channel mapping with %d streams, %d coupled", streams, coupled)
+		}
+		for _, m := range head[21 : 21+info.Channels] {
+			if m != 255 && int(m) >= streams+coupled {
+				return malformed(format, 0, "channel mapping references stream %d of %d", m, streams+coupled)
+			}
+		}
+	}
+
+	tags := packets[1]
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		return malformed(format, 0, "second Opus packet is not OpusTags")
+	}
+	if err := parseVorbisComment(tags[8:], info); err != nil {
+		return malformed(format, 0, "OpusTags: %v", err)
+	}
+	return nil
+}
+
+var oggCRCTable [256]uint32
+
+func init() {
+	// CRC-32 with polynomial 0x04C11DB7, MSB first, no reflection
+	for i := range oggCRCTable {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		oggCRCTable[i] = c
+	}
+}
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
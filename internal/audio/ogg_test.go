@@ -0,0 +1,327 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// oggCRC is the page checksum: CRC-32, polynomial 0x04C11DB7, unreflected
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// oggPageBytes returns a page with a valid CRC
+func oggPageBytes(headerType byte, granule int64, serial, sequence uint32, lacing, body []byte) []byte {
+	le := binary.LittleEndian
+	b := append([]byte("OggS"), 0, headerType)
+	b = le.AppendUint64(b, uint64(granule))
+	b = le.AppendUint32(b, serial)
+	b = le.AppendUint32(b, sequence)
+	b = append(b, 0, 0, 0, 0, byte(len(lacing)))
+	b = append(append(b, lacing...), body...)
+	le.PutUint32(b[22:], oggCRC(b))
+	return b
+}
+
+// oggWriter pages the packets of one logical stream
+type oggWriter struct {
+	serial   uint32
+	sequence uint32
+	out      []byte
+	offsets  []int // where each page ends
+}
+
+// page writes whole packets on one page
+func (w *oggWriter) page(headerType byte, granule int64, packets ...[]byte) {
+	var lacing, body []byte
+	for _, p := range packets {
+		lacing = append(lacing, bytes.Repeat([]byte{255}, len(p)/255)...)
+		lacing = append(lacing, byte(len(p)%255))
+		body = append(body, p...)
+	}
+	w.raw(headerType, granule, lacing, body)
+}
+
+// raw writes a page of the given lacing
+func (w *oggWriter) raw(headerType byte, granule int64, lacing, body []byte) {
+	w.out = append(w.out, oggPageBytes(headerType, granule, w.serial, w.sequence, lacing, body)...)
+	w.offsets = append(w.offsets, len(w.out))
+	w.sequence++
+}
+
+// vorbisID returns a Vorbis identification header
+func vorbisID(channels, sampleRate int, upper, nominal, lower int32) []byte {
+	le := binary.LittleEndian
+	b := append([]byte("\x01vorbis"), 0, 0, 0, 0, byte(channels))
+	b = le.AppendUint32(b, uint32(sampleRate))
+	b = le.AppendUint32(b, uint32(upper))
+	b = le.AppendUint32(b, uint32(nominal))
+	b = le.AppendUint32(b, uint32(lower))
+	return append(b, 0xB8, 1)
+}
+
+func vorbisCommentPacket(vendor string, comments ...string) []byte {
+	return append(append([]byte("\x03vorbis"), vorbisComment(vendor, comments...)...), 1)
+}
+
+var vorbisSetup = []byte("\x05vorbis\x00setup")
+
+// opusHead returns an OpusHead of mapping family 0, or 1 with a mapping table
+func opusHead(channels, preSkip, inputRate int, gain int16, mapping ...byte) []byte {
+	le := binary.LittleEndian
+	b := append([]byte("OpusHead"), 1, byte(channels))
+	b = le.AppendUint16(b, uint16(preSkip))
+	b = le.AppendUint32(b, uint32(inputRate))
+	b = le.AppendUint16(b, uint16(gain))
+	if len(mapping) == 0 {
+		return append(b, 0)
+	}
+	return append(append(b, 1), mapping...)
+}
+
+func opusTags(vendor string, comments ...string) []byte {
+	return append([]byte("OpusTags"), vorbisComment(vendor, comments...)...)
+}
+
+// vorbisFile returns a Vorbis stream of the three headers and two audio pages
+func vorbisFile() *oggWriter {
+	w := &oggWriter{serial: 7}
+	w.page(0x02, 0, vorbisID(2, 44100, 0, 128000, 0))
+	w.page(0, 0, vorbisCommentPacket("Xiph.Org libVorbis I 20200704", "TITLE=Song", "ARTIST=Band"), vorbisSetup)
+	w.page(0, 22050, make([]byte, 300))
+	w.page(0x04, 44100, make([]byte, 300))
+	return w
+}
+
+// opusFile returns an Opus stream of its headers and one audio page
+func opusFile() []byte {
+	w := &oggWriter{serial: 1}
+	w.page(0x02, 0, opusHead(2, 312, 44100, 256))
+	w.page(0, 0, opusTags("libopus 1.4", "TITLE=Talk"))
+	w.page(0x04, 48000+312, make([]byte, 100))
+	return w.out
+}
+
+func TestProbeOgg(t *testing.T) {
+	surround := &oggWriter{serial: 1}
+	surround.page(0x02, 0, opusHead(6, 0, 48000, 0, 4, 2, 0, 4, 1, 2, 3, 5))
+	surround.page(0, 0, opusTags("libopus"))
+
+	cbr := &oggWriter{serial: 1}
+	cbr.page(0x02, 0, vorbisID(1, 22050, 64000, 64000, 64000))
+	cbr.page(0, 0, vorbisCommentPacket("v"), vorbisSetup)
+
+	// The comment header spans three pages
+	long := vorbisCommentPacket("v", "LYRICS="+strings.Repeat("la ", 200))
+	spanning := &oggWriter{serial: 1}
+	spanning.page(0x02, 0, vorbisID(2, 48000, 0, 0, 0))
+	spanning.raw(0, -1, []byte{255}, long[:255])
+	spanning.raw(0x01, -1, []byte{255}, long[255:510])
+	spanning.raw(0x01, 0, []byte{byte(len(long) - 510), byte(len(vorbisSetup))}, append(long[510:len(long):len(long)], vorbisSetup...))
+	spanning.page(0x04, 4800, make([]byte, 10))
+
+	// A second, chained stream after the first one ends is not described
+	chained := vorbisFile()
+	other := &oggWriter{serial: 8}
+	other.page(0x02, 0, opusHead(1, 0, 16000, 0))
+	other.page(0x04, 96000, opusTags("x"))
+	chained.out = append(chained.out, other.out...)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "Vorbis",
+			data: vorbisFile().out,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Format != "ogg" || info.Codec != "vorbis" || info.SampleRate != 44100 || info.Channels != 2 || info.Layout != "stereo" {
+					t.Fatalf("%+v, want stereo Vorbis at 44.1 kHz", info)
+				}
+				if info.Frames != 44100 || info.BitrateMode != "VBR" || info.Bitrate != int(info.DataSize*8) {
+					t.Fatalf("%d frames, %s at %d bps, want 1 s of VBR averaged over the file", info.Frames, info.BitrateMode, info.Bitrate)
+				}
+				if info.Tags["TITLE"] != "Song" || info.Tags["ARTIST"] != "Band" || info.Encoder != "Xiph.Org libVorbis I 20200704" {
+					t.Fatalf("tags %v by %q, want the title and artist", info.Tags, info.Encoder)
+				}
+			},
+		},
+		{
+			name: "headers only keep the nominal bitrate",
+			data: cbr.out,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 0 || info.BitrateMode != "CBR" || info.Bitrate != 64000 || info.Layout != "mono" {
+					t.Fatalf("%d frames, %s at %d bps, %s, want no frames, mono CBR at 64000", info.Frames, info.BitrateMode, info.Bitrate, info.Layout)
+				}
+			},
+		},
+		{
+			name: "Opus",
+			data: opusFile(),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "opus" || info.SampleRate != 48000 || info.InputSampleRate != 44100 || info.Channels != 2 {
+					t.Fatalf("%s at %d Hz from %d Hz, %d channels, want stereo opus at 48000 from 44100", info.Codec, info.SampleRate, info.InputSampleRate, info.Channels)
+				}
+				if info.EncoderDelay != 312 || info.Frames != 48000 || info.OutputGain != 1 || info.Tags["TITLE"] != "Talk" {
+					t.Fatalf("delay %d, %d frames, gain %v dB, tags %v, want 312, 48000, 1 dB and the title",
+						info.EncoderDelay, info.Frames, info.OutputGain, info.Tags)
+				}
+			},
+		},
+		{
+			name: "Opus 5.1",
+			data: surround.out,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Channels != 6 || info.Layout != "5.1" {
+					t.Fatalf("%d channels as %s, want 5.1", info.Channels, info.Layout)
+				}
+			},
+		},
+		{
+			name: "header packet spanning pages",
+			data: spanning.out,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Tags["LYRICS"] != strings.TrimSpace(strings.Repeat("la ", 200)) || info.Frames != 4800 {
+					t.Fatalf("%d frames, lyrics of %d bytes, want 4800 frames and all 599", info.Frames, len(info.Tags["LYRICS"]))
+				}
+			},
+		},
+		{
+			name: "chained stream",
+			data: chained.out,
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "vorbis" || info.Frames != 44100 {
+					t.Fatalf("%s with %d frames, want the first stream's 44100 Vorbis frames", info.Codec, info.Frames)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestProbeOggInvalid(t *testing.T) {
+	// headers returns a stream of the given header packets, one per page
+	headers := func(packets ...[]byte) []byte {
+		w := &oggWriter{serial: 1}
+		for i, p := range packets {
+			headerType := byte(0)
+			if i == 0 {
+				headerType = 0x02
+			}
+			w.page(headerType, 0, p)
+		}
+		return w.out
+	}
+	vorbisTags := vorbisCommentPacket("v")
+	tags := opusTags("v")
+
+	badCRC := vorbisFile().out
+	badCRC[len(badCRC)-1] ^= 0xFF
+
+	skipped := vorbisFile()
+	skipped.out = skipped.out[:skipped.offsets[1]]
+	skipped.sequence++
+	skipped.page(0x04, 100, make([]byte, 10))
+
+	version := vorbisFile().out
+	version[4] = 1
+	binary.LittleEndian.PutUint32(version[22:], 0)
+	binary.LittleEndian.PutUint32(version[22:], oggCRC(version[:version[26]+27+30]))
+
+	noBOS := &oggWriter{serial: 1}
+	noBOS.page(0, 0, vorbisID(2, 44100, 0, 0, 0))
+
+	orphan := &oggWriter{serial: 1}
+	orphan.page(0x02, 0, vorbisID(2, 44100, 0, 0, 0))
+	orphan.page(0x01, 0, vorbisTags, vorbisSetup)
+
+	unfinished := &oggWriter{serial: 1}
+	unfinished.page(0x02, 0, vorbisID(2, 44100, 0, 0, 0))
+	unfinished.raw(0, -1, []byte{255}, make([]byte, 255))
+	unfinished.page(0, 0, vorbisSetup)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"bad CRC", badCRC, audio.ErrCorrupt},
+		{"page sequence gap", skipped.out, audio.ErrCorrupt},
+		{"stream structure version", version, audio.ErrMalformed},
+		{"first page without BOS", noBOS.out, audio.ErrMalformed},
+		{"continuation without a packet", orphan.out, audio.ErrMalformed},
+		{"unfinished packet dropped", unfinished.out, audio.ErrMalformed},
+		{"stream ends within its headers", headers(vorbisID(2, 44100, 0, 0, 0), vorbisTags), audio.ErrTruncated},
+		{"short Vorbis id", headers(vorbisID(2, 44100, 0, 0, 0)[:20], vorbisTags, vorbisSetup), audio.ErrMalformed},
+		{"Vorbis version", headers(append([]byte("\x01vorbis\x01"), vorbisID(2, 44100, 0, 0, 0)[8:]...), vorbisTags, vorbisSetup), audio.ErrMalformed},
+		{"Vorbis without channels", headers(vorbisID(0, 44100, 0, 0, 0), vorbisTags, vorbisSetup), audio.ErrMalformed},
+		{"Vorbis without framing bit", headers(append(vorbisID(2, 44100, 0, 0, 0)[:29], 0), vorbisTags, vorbisSetup), audio.ErrMalformed},
+		{"Vorbis comment missing", headers(vorbisID(2, 44100, 0, 0, 0), vorbisSetup, vorbisSetup), audio.ErrMalformed},
+		{"Vorbis comment overrun", headers(vorbisID(2, 44100, 0, 0, 0), vorbisTags[:12], vorbisSetup), audio.ErrMalformed},
+		{"Vorbis setup missing", headers(vorbisID(2, 44100, 0, 0, 0), vorbisTags, vorbisTags), audio.ErrMalformed},
+		{"short OpusHead", headers(opusHead(2, 0, 48000, 0)[:12], tags), audio.ErrMalformed},
+		{"Opus version 2", headers(append([]byte("OpusHead\x20"), opusHead(2, 0, 48000, 0)[9:]...), tags), audio.ErrMalformed},
+		{"Opus without channels", headers(opusHead(0, 0, 48000, 0), tags), audio.ErrMalformed},
+		{"family 0 with 3 channels", headers(opusHead(3, 0, 48000, 0), tags), audio.ErrMalformed},
+		{"mapping past the streams", headers(opusHead(2, 0, 48000, 0, 1, 0, 0, 1), tags), audio.ErrMalformed},
+		{"mapping without streams", headers(opusHead(2, 0, 48000, 0, 0, 0, 0, 0), tags), audio.ErrMalformed},
+		{"OpusTags missing", headers(opusHead(2, 0, 48000, 0), opusHead(2, 0, 48000, 0)), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, "ogg", tt.want)
+		})
+	}
+
+	// Other Ogg codecs are not audio we describe
+	speex := headers([]byte("Speex   1.2"))
+	if _, err := probe(speex); !errors.Is(err, audio.ErrUnknownFormat) {
+		t.Fatalf("Speex: error %v, want ErrUnknownFormat", err)
+	}
+}
+
+func TestProbeOggTruncated(t *testing.T) {
+	w := vorbisFile()
+	headersEnd := w.offsets[1]
+	boundaries := map[int]bool{}
+	for _, off := range w.offsets {
+		boundaries[off] = true
+	}
+	// A cut between audio pages leaves a shorter valid stream, any other
+	// cut is truncation
+	for n := 4; n < len(w.out); n++ {
+		_, err := probe(w.out[:n])
+		if boundaries[n] && n >= headersEnd {
+			if err != nil {
+				t.Fatalf("cut to %d bytes at a page boundary: %v", n, err)
+			}
+		} else if !errors.Is(err, audio.ErrTruncated) {
+			t.Fatalf("cut to %d of %d bytes: error %v, want ErrTruncated", n, len(w.out), err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package audio
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotPacketizable is returned by Packets for streams it cannot split
+var ErrNotPacketizable = errors.New("only MP3 and AAC audio can be split into packets")
+
+// Packet is one encoded access unit (an MPEG audio frame, an AAC raw data
+// block) of a compressed stream
+type Packet struct {
+	Offset   int64 // in the file
+	Size     int
+	Duration int // in the index's timescale
+}
+
+// PacketIndex lists the packets of a compressed stream in decoding order,
+// so it can be cut into segments on packet boundaries
+type PacketIndex struct {
+	Codec      string // "mp3" or "aac"
+	Timescale  int    // ticks per second of packet durations
+	SampleRate int
+	Channels   int
+	Config     []byte // AAC AudioSpecificConfig
+	Packets    []Packet
+}
+
+// Duration returns the total duration in ticks
+func (idx *PacketIndex) Duration() int64 {
+	var total int64
+	for _, p := range idx.Packets {
+		total += int64(p.Duration)
+	}
+	return total
+}
+
+// Packets indexes the packets of an MP3 file, or of the AAC or MP3 track of
+// a non-fragmented MP4. Anything else yields ErrNotPacketizable.
+func Packets(r io.ReaderAt, size int64) (*PacketIndex, error) {
+	info, err := Probe(r, size)
+	if errors.Is(err, ErrUnknownFormat) {
+		return nil, ErrNotPacketizable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Format == "mp3" && info.Codec == "mp3":
+		return mp3Packets(r, info)
+	case info.Format == "mp4" && !info.Fragmented:
+		switch info.Codec {
+		case "aac", "he_aac", "he_aac_v2", "mp3":
+			return mp4Packets(r, size)
+		}
+	}
+	return nil, ErrNotPacketizable
+}
+
+// mp3Packets walks the frames parseMP3 found, leaving out the Xing or VBRI
+// header frame, which carries no audio
+func mp3Packets(r io.ReaderAt, info *Info) (*PacketIndex, error) {
+	const format = "mp3"
+
+	idx := &PacketIndex{Codec: "mp3", Timescale: info.SampleRate, SampleRate: info.SampleRate, Channels: info.Channels}
+	end := info.DataOffset + info.DataSize
+
+	var head mpegHeader
+	hdr := make([]byte, 4)
+	for pos := info.DataOffset; pos+4 <= end; {
+		if _, err := r.ReadAt(hdr, pos); err != nil {
+			return nil, truncated(format, pos, "frame header: %v", err)
+		}
+		h, ok := parseMPEGHeader(hdr)
+		if ok && len(idx.Packets) > 0 {
+			ok = h.compatible(head)
+		}
+		if !ok {
+			var compat *mpegHeader
+			if len(idx.Packets) > 0 {
+				compat = &head
+			}
+			next, found := findMPEGFrame(r, pos+1, end, compat)
+			if !found {
+				break
+			}
+			pos = next
+			continue
+		}
+		if pos+int64(h.length) > end {
+			break
+		}
+
+		if pos == info.DataOffset {
+			frame, err := readAt(r, pos, h.length)
+			if err != nil {
+				return nil, truncated(format, pos, "first frame: %v", err)
+			}
+			if parseVBRHeader(frame, h) != nil {
+				pos += int64(h.length)
+				continue
+			}
+		}
+		if len(idx.Packets) == 0 {
+			head = h
+		}
+		idx.Packets = append(idx.Packets, Packet{Offset: pos, Size: h.length, Duration: h.samples})
+		pos += int64(h.length)
+	}
+	if len(idx.Packets) == 0 {
+		return nil, malformed(format, info.DataOffset, "no complete MPEG audio frames")
+	}
+	return idx, nil
+}
+
+// mp4Packets resolves the samples of the first audio track from its sample
+// tables
+func mp4Packets(r io.ReaderAt, size int64) (*PacketIndex, error) {
+	const format = "mp4"
+
+	p := &mp4Parser{r: r, size: size, info: &Info{Tags: map[string]string{}}, trexDurations: map[uint32]uint32{}}
+	var moov *mp4Box
+	err := p.children(0, size, func(b mp4Box) error {
+		if b.typ == "moov" && moov == nil {
+			moov = &b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, truncated(format, size, "no moov box")
+	}
+	if err := p.parseMoov(*moov); err != nil {
+		return nil, err
+	}
+
+	var t *mp4Track
+	for _, c := range p.tracks {
+		if c.handler == "soun" && c.entry != nil {
+			t = c
+			break
+		}
+	}
+	if t == nil {
+		return nil, malformed(format, moov.off, "no audio track")
+	}
+	if t.timescale == 0 {
+		return nil, malformed(format, t.stbl.off, "audio track has no timescale")
+	}
+
+	tables := map[string][]byte{}
+	err = p.children(t.stbl.body, t.stbl.end, func(b mp4Box) error {
+		switch b.typ {
+		case "stts", "stsz", "stsc", "stco", "co64":
+			body, err := p.read(b)
+			if err != nil {
+				return err
+			}
+			if len(body) < 8 {
+				return malformed(format, b.off, "%q box is too short", b.typ)
+			}
+			tables[b.typ] = body
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stts, stsz := tables["stts"], tables["stsz"]
+	if stts == nil || len(stsz) < 12 {
+		return nil, malformed(format, t.stbl.off, "audio track lacks sample tables")
+	}
+
+	count := int(be.Uint32(stsz[8:12]))
+	var durations []int
+	for i := 0; i < int(be.Uint32(stts[4:8])) && 8+i*8+8 <= len(stts); i++ {
+		n, delta := be.Uint32(stts[8+i*8:]), be.Uint32(stts[12+i*8:])
+		if uint64(len(durations))+uint64(n) > uint64(count) {
+			return nil, malformed(format, t.stbl.off, "stts declares more samples than stsz")
+		}
+		for j := uint32(0); j < n; j++ {
+			durations = append(durations, int(delta))
+		}
+	}
+
+	offsets, sizes := mp4SampleLocations(tables, len(durations))
+	if len(offsets) == 0 {
+		return nil, malformed(format, t.stbl.off, "audio track has no samples")
+	}
+
+	codec := t.entry.codec
+	if codec != "mp3" {
+		codec = "aac"
+	}
+	idx := &PacketIndex{
+		Codec:      codec,
+		Timescale:  int(t.timescale),
+		SampleRate: t.entry.sampleRate,
+		Channels:   t.entry.channels,
+		Config:     t.entry.config,
+		Packets:    make([]Packet, len(offsets)),
+	}
+	for i := range offsets {
+		if offsets[i]+int64(sizes[i]) > size {
+			return nil, truncated(format, offsets[i], "sample %d runs past the end of the file", i)
+		}
+		idx.Packets[i] = Packet{Offset: offsets[i], Size: sizes[i], Duration: durations[i]}
+	}
+	return idx, nil
+}
@@ -0,0 +1,174 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func packets(b []byte) (*audio.PacketIndex, error) {
+	return audio.Packets(bytes.NewReader(b), int64(len(b)))
+}
+
+func TestPackets(t *testing.T) {
+	title := id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title"))
+	aac := aacEntry(128000, 128000, ascLC)
+	data, sizes := m4aSamples()
+
+	// mp3Packets lists n 417 byte frames from off on
+	mp3Packets := func(off int64, n int) []audio.Packet {
+		var p []audio.Packet
+		for i := 0; i < n; i++ {
+			p = append(p, audio.Packet{Offset: off + int64(i)*417, Size: 417, Duration: 1152})
+		}
+		return p
+	}
+	// aacPackets lists the m4aSamples from off on
+	aacPackets := func(off int64) []audio.Packet {
+		var p []audio.Packet
+		for i := range sizes {
+			p = append(p, audio.Packet{Offset: off + int64(i)*100, Size: 100, Duration: 1024})
+		}
+		return p
+	}
+
+	// Two chunks with a gap between them: six samples, then four
+	gap := make([]byte, 50)
+	chunked := mp4File(false, bytes.Join([][]byte{data[:600], gap, data[600:]}, nil), func(off uint32) []byte {
+		stsz := u32s(0, 10)
+		for range sizes {
+			stsz = append(stsz, u32s(100)...)
+		}
+		stbl := mp4Box("stbl", mp4FullBox("stsd", 0, 0, u32s(1), aac),
+			mp4FullBox("stts", 0, 0, u32s(2, 6, 1024, 4, 960)),
+			mp4FullBox("stsc", 0, 0, u32s(2, 1, 6, 1, 2, 4, 1)),
+			mp4FullBox("stsz", 0, 0, stsz),
+			mp4FullBox("stco", 0, 0, u32s(2, off, off+650)))
+		return mp4Box("moov", mvhdBox(1000, 232), mp4Trak(1, "soun", 48000, 10*1024, stbl))
+	})
+	chunkedOff := int64(len(chunked) - 1050)
+	var chunkedPackets []audio.Packet
+	for i := int64(0); i < 10; i++ {
+		p := audio.Packet{Offset: chunkedOff + i*100, Size: 100, Duration: 1024}
+		if i >= 6 {
+			p.Offset += 50
+			p.Duration = 960
+		}
+		chunkedPackets = append(chunkedPackets, p)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want audio.PacketIndex
+	}{
+		{
+			name: "MP3",
+			data: mp3Frames(5),
+			want: audio.PacketIndex{Codec: "mp3", Timescale: 44100, SampleRate: 44100, Channels: 2, Packets: mp3Packets(0, 5)},
+		},
+		{
+			name: "MP3 after ID3v2, without its Info frame",
+			data: bytes.Join([][]byte{title, xingFrame(3, 4, 0, 0), mp3Frames(3), id3v1Tag("T", "", 0, 0)}, nil),
+			want: audio.PacketIndex{Codec: "mp3", Timescale: 44100, SampleRate: 44100, Channels: 2,
+				Packets: mp3Packets(int64(len(title))+417, 3)},
+		},
+		{
+			name: "MP3 with junk between frames",
+			data: bytes.Join([][]byte{mp3Frames(2), []byte("junk"), mp3Frames(2)}, nil),
+			want: audio.PacketIndex{Codec: "mp3", Timescale: 44100, SampleRate: 44100, Channels: 2,
+				Packets: append(mp3Packets(0, 2), mp3Packets(2*417+4, 2)...)},
+		},
+		{
+			name: "AAC, moov before mdat",
+			data: m4aFile(false, aac),
+			want: audio.PacketIndex{Codec: "aac", Timescale: 44100, SampleRate: 44100, Channels: 2, Config: ascLC,
+				Packets: aacPackets(int64(len(m4aFile(false, aac)) - 1000))},
+		},
+		{
+			name: "AAC, moov after mdat",
+			data: m4aFile(true, aac),
+			want: audio.PacketIndex{Codec: "aac", Timescale: 44100, SampleRate: 44100, Channels: 2, Config: ascLC, Packets: aacPackets(36)},
+		},
+		{
+			name: "HE-AAC is AAC",
+			data: m4aFile(true, mp4AudioEntry("mp4a", 0, 2, 16, 22050, esdsBox(0x40, 0, 64000, ascHE))),
+			want: audio.PacketIndex{Codec: "aac", Timescale: 44100, SampleRate: 44100, Channels: 2, Config: ascHE, Packets: aacPackets(36)},
+		},
+		{
+			name: "MP3 in MP4",
+			data: m4aFile(true, mp4AudioEntry("mp4a", 0, 2, 16, 44100, esdsBox(0x6B, 128000, 128000, nil))),
+			want: audio.PacketIndex{Codec: "mp3", Timescale: 44100, SampleRate: 44100, Channels: 2, Packets: aacPackets(36)},
+		},
+		{
+			name: "chunks of different sample counts",
+			data: chunked,
+			want: audio.PacketIndex{Codec: "aac", Timescale: 48000, SampleRate: 44100, Channels: 2, Config: ascLC, Packets: chunkedPackets},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := packets(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*idx, tt.want) {
+				t.Fatalf("Packets =\n%+v\nwant\n%+v", *idx, tt.want)
+			}
+			var total int64
+			for _, p := range tt.want.Packets {
+				total += int64(p.Duration)
+			}
+			if idx.Duration() != total {
+				t.Fatalf("Duration = %d, want %d", idx.Duration(), total)
+			}
+		})
+	}
+}
+
+func TestPacketsInvalid(t *testing.T) {
+	aac := aacEntry(128000, 128000, ascLC)
+	data, sizes := m4aSamples()
+	// withStbl returns an M4A whose sample tables are the given boxes
+	withStbl := func(timescale uint32, tables ...[]byte) []byte {
+		return mp4File(true, data, func(off uint32) []byte {
+			stbl := mp4Box("stbl", append([][]byte{mp4FullBox("stsd", 0, 0, u32s(1), aac)}, tables...)...)
+			return mp4Box("moov", mvhdBox(1000, 232), mp4Trak(1, "soun", timescale, 10240, stbl))
+		})
+	}
+	stsz := u32s(0, 10)
+	for _, s := range sizes {
+		stsz = append(stsz, u32s(uint32(s))...)
+	}
+	stsc := mp4FullBox("stsc", 0, 0, u32s(1, 1, 10, 1))
+	stco := mp4FullBox("stco", 0, 0, u32s(1, 36))
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"WAV", encodeWAV(t, 8000, 1, 16, pcm.WAVOptions{BitDepth: 16}), audio.ErrNotPacketizable},
+		{"FLAC", encodeFLAC(t, 8000, 1, 16, 16), audio.ErrNotPacketizable},
+		{"Ogg", vorbisFile().out, audio.ErrNotPacketizable},
+		{"ALAC in MP4", m4aFile(true, mp4AudioEntry("alac", 0, 2, 16, 44100)), audio.ErrNotPacketizable},
+		{"not audio", []byte("plain text"), audio.ErrNotPacketizable},
+		{"Probe errors pass through", append(xingFrame(10, 4, 0, 0), mp3Frames(2)...), audio.ErrTruncated},
+		{"samples past the end", mp4File(false, data[:500], func(off uint32) []byte { return m4aMoov(off, aac) }), audio.ErrTruncated},
+		{"no timescale", withStbl(0, mp4FullBox("stts", 0, 0, u32s(1, 10, 1024)), stsc, mp4FullBox("stsz", 0, 0, stsz), stco), audio.ErrMalformed},
+		{"no stts", withStbl(44100, stsc, mp4FullBox("stsz", 0, 0, stsz), stco), audio.ErrMalformed},
+		{"stts beyond stsz", withStbl(44100, mp4FullBox("stts", 0, 0, u32s(1, 11, 1024)), stsc, mp4FullBox("stsz", 0, 0, stsz), stco), audio.ErrMalformed},
+		{"short table", withStbl(44100, mp4FullBox("stts", 0, 0), stsc, mp4FullBox("stsz", 0, 0, stsz), stco), audio.ErrMalformed},
+		{"no chunks", withStbl(44100, mp4FullBox("stts", 0, 0, u32s(1, 10, 1024)), stsc, mp4FullBox("stsz", 0, 0, stsz)), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := packets(tt.data); !errors.Is(err, tt.want) {
+				t.Fatalf("Packets error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package audio
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SniffLen is how many leading bytes Sniff needs to decide
+const SniffLen = 4096
+
+// Kind is what Sniff makes of a file
+type Kind struct {
+	MIME string `json:"mime_type"`
+	// Format is the audio format ("wav", "aiff", "flac", "mp3", "ogg",
+	// "opus", "mp4", or one we do not parse such as "aac" or "wma"), empty
+	// for anything that is not audio. "id3" is an ID3v2 tag too large to see
+	// past: MP3 or FLAC.
+	Format string `json:"format,omitempty"`
+}
+
+// IsAudio reports whether the file looks like audio of any format
+func (k Kind) IsAudio() bool {
+	return k.Format != ""
+}
+
+// sniffer recognizes one file type from its first bytes
+type sniffer struct {
+	kind  Kind
+	match func(b []byte) bool
+}
+
+func prefix(sigs ...string) func([]byte) bool {
+	return func(b []byte) bool {
+		for _, sig := range sigs {
+			if bytes.HasPrefix(b, []byte(sig)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func riff(form string) func([]byte) bool {
+	return func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == form
+	}
+}
+
+// sniffers are tried in order, the first match wins. Ogg, MP4, ID3 and raw
+// MPEG streams need a closer look and are handled by Sniff itself.
+var sniffers = []sniffer{
+	// Audio
+	{Kind{"audio/wav", "wav"}, func(b []byte) bool { return len(b) >= 12 && isRIFF(b) }},
+	{Kind{"audio/aiff", "aiff"}, func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "FORM" && (string(b[8:12]) == "AIFF" || string(b[8:12]) == "AIFC")
+	}},
+	{Kind{"audio/flac", "flac"}, prefix("fLaC")},
+	{Kind{"audio/x-caf", "caf"}, prefix("caff")},
+	{Kind{"audio/x-ms-wma", "wma"}, prefix("\x30\x26\xB2\x75\x8E\x66\xCF\x11")},
+	{Kind{"audio/ape", "ape"}, prefix("MAC ")},
+	{Kind{"audio/wavpack", "wavpack"}, prefix("wvpk")},
+	{Kind{"audio/x-dsf", "dsf"}, prefix("DSD ")},
+	{Kind{"audio/amr", "amr"}, prefix("#!AMR")},
+	{Kind{"audio/midi", "midi"}, prefix("MThd")},
+	{Kind{"audio/basic", "au"}, prefix(".snd")},
+	{Kind{"audio/ac3", "ac3"}, prefix("\x0B\x77")},
+
+	// Archives and documents
+	{Kind{"application/zip", ""}, prefix("PK\x03\x04", "PK\x05\x06", "PK\x07\x08")},
+	{Kind{"application/gzip", ""}, prefix("\x1F\x8B")},
+	{Kind{"application/x-bzip2", ""}, prefix("BZh")},
+	{Kind{"application/x-xz", ""}, prefix("\xFD7zXZ\x00")},
+	{Kind{"application/zstd", ""}, prefix("\x28\xB5\x2F\xFD")},
+	{Kind{"application/x-7z-compressed", ""}, prefix("7z\xBC\xAF\x27\x1C")},
+	{Kind{"application/vnd.rar", ""}, prefix("Rar!\x1A\x07")},
+	{Kind{"application/x-tar", ""}, func(b []byte) bool { return len(b) >= 262 && string(b[257:262]) == "ustar" }},
+	{Kind{"application/pdf", ""}, prefix("%PDF-")},
+	{Kind{"application/x-ole-storage", ""}, prefix("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")},
+	{Kind{"application/vnd.sqlite3", ""}, prefix("SQLite format 3\x00")},
+
+	// Executables
+	{Kind{"application/vnd.microsoft.portable-executable", ""}, prefix("MZ")},
+	{Kind{"application/x-elf", ""}, prefix("\x7FELF")},
+	{Kind{"application/x-mach-binary", ""}, prefix(
+		"\xFE\xED\xFA\xCE", "\xFE\xED\xFA\xCF", "\xCE\xFA\xED\xFE", "\xCF\xFA\xED\xFE")},
+	// Java classes share the magic of universal Mach-O binaries
+	{Kind{"application/java-vm", ""}, prefix("\xCA\xFE\xBA\xBE")},
+	{Kind{"application/wasm", ""}, prefix("\x00asm")},
+	{Kind{"text/x-shellscript", ""}, prefix("#!")},
+
+	// Text with a byte order mark. UTF-16LE could pass for an MPEG sync, so
+	// it also needs the zero high bytes of ASCII after the mark.
+	{Kind{"text/plain", ""}, prefix("\xEF\xBB\xBF", "\xFE\xFF")},
+	{Kind{"text/plain", ""}, func(b []byte) bool {
+		return len(b) >= 6 && b[0] == 0xFF && b[1] == 0xFE && b[3] == 0 && b[5] == 0
+	}},
+
+	// Images and video
+	{Kind{"image/png", ""}, prefix("\x89PNG\r\n\x1A\n")},
+	{Kind{"image/jpeg", ""}, prefix("\xFF\xD8\xFF")},
+	{Kind{"image/gif", ""}, prefix("GIF87a", "GIF89a")},
+	{Kind{"image/webp", ""}, riff("WEBP")},
+	{Kind{"image/bmp", ""}, prefix("BM")},
+	{Kind{"video/x-msvideo", ""}, riff("AVI ")},
+	{Kind{"video/x-matroska", ""}, prefix("\x1A\x45\xDF\xA3")},
+	{Kind{"video/x-flv", ""}, prefix("FLV")},
+}
+
+// Sniff identifies a file from its first bytes, at most SniffLen of them.
+// Unrecognized data is "application/octet-stream", or "text/plain" or
+// "text/html" when it is text.
+func Sniff(head []byte) Kind {
+	if len(head) > SniffLen {
+		head = head[:SniffLen]
+	}
+
+	for _, s := range sniffers {
+		if s.match(head) {
+			return s.kind
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return sniffOgg(head)
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return sniffFtyp(head)
+	case len(head) >= 8 && (string(head[4:8]) == "moov" || string(head[4:8]) == "mdat"):
+		return Kind{"video/quicktime", "mp4"}
+	}
+
+	if tagLen, ok := id3v2Length(head); ok {
+		if tagLen+4 > int64(len(head)) {
+			return Kind{"audio/mpeg", "id3"}
+		}
+		rest := head[tagLen:]
+		if bytes.HasPrefix(rest, []byte("fLaC")) {
+			return Kind{"audio/flac", "flac"}
+		}
+		if k := sniffMPEG(rest); k.IsAudio() {
+			return k
+		}
+		// Padding or junk between the tag and the first frame
+		if _, ok := findMPEGFrame(bytes.NewReader(rest), 0, int64(len(rest)), nil); ok {
+			return Kind{"audio/mpeg", "mp3"}
+		}
+		return Kind{"application/octet-stream", ""}
+	}
+	if k := sniffMPEG(head); k.IsAudio() {
+		return k
+	}
+	return sniffText(head)
+}
+
+// sniffMPEG recognizes an MPEG audio frame or an AAC ADTS header at the
+// start of b, followed by a second frame when b is long enough to hold it
+func sniffMPEG(b []byte) Kind {
+	if len(b) >= 7 && b[0] == 0xFF && b[1]&0xF6 == 0xF0 {
+		// ADTS: layer bits are zero, frame length is 13 bits from bit 30
+		n := int(b[3]&3)<<11 | int(b[4])<<3 | int(b[5]>>5)
+		if n >= 7 && (n+2 > len(b) || b[n] == 0xFF && b[n+1]&0xF6 == 0xF0) {
+			return Kind{"audio/aac", "aac"}
+		}
+		return Kind{}
+	}
+
+	h, ok := parseMPEGHeader(b)
+	if !ok {
+		return Kind{}
+	}
+	if h.length+4 <= len(b) {
+		h2, ok := parseMPEGHeader(b[h.length:])
+		if !ok || !h2.compatible(h) {
+			return Kind{}
+		}
+	}
+	return Kind{"audio/mpeg", "mp3"}
+}
+
+// sniffOgg looks at the codec identification packets on the stream
+// starting pages, which come first in a multiplexed file
+func sniffOgg(b []byte) Kind {
+	kind := Kind{"audio/ogg", "ogg"} // Vorbis, Speex, Ogg FLAC and the like
+	for len(b) >= oggHeaderSize && string(b[:4]) == "OggS" && b[5]&oggBOS != 0 {
+		nsegs := int(b[26])
+		if oggHeaderSize+nsegs > len(b) {
+			break
+		}
+		size := oggHeaderSize + nsegs
+		for _, l := range b[oggHeaderSize : oggHeaderSize+nsegs] {
+			size += int(l)
+		}
+		body := b[oggHeaderSize+nsegs : min(size, len(b))]
+		switch {
+		case bytes.HasPrefix(body, []byte("\x80theora")), bytes.HasPrefix(body, []byte("\x80kate")),
+			bytes.HasPrefix(body, []byte("\x4F\x56\x50\x38\x30")): // OVP80, VP8
+			return Kind{"video/ogg", ""}
+		case bytes.HasPrefix(body, []byte("OpusHead")):
+			kind = Kind{"audio/opus", "opus"}
+		}
+		b = b[min(size, len(b)):]
+	}
+	return kind
+}
+
+// sniffFtyp tells audio MP4 from other ISO base media files by their brands
+func sniffFtyp(b []byte) Kind {
+	size := int(be.Uint32(b[0:4]))
+	size = max(min(size, len(b)), 12)
+	brands := []string{string(b[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(b[i:i+4]))
+	}
+
+	audio := false
+	for _, brand := range brands {
+		switch strings.TrimSpace(brand) {
+		case "avif", "avis":
+			return Kind{"image/avif", ""}
+		case "heic", "heix", "mif1", "msf1":
+			return Kind{"image/heic", ""}
+		case "M4A", "M4B", "M4P", "F4A", "F4B":
+			audio = true
+		}
+	}
+	if audio {
+		return Kind{"audio/mp4", "mp4"}
+	}
+	if strings.HasPrefix(brands[0], "3g") {
+		return Kind{"video/3gpp", "mp4"}
+	}
+	if brands[0] == "qt  " {
+		return Kind{"video/quicktime", "mp4"}
+	}
+	// isom, mp41, mp42, dash...: may hold audio only
+	return Kind{"video/mp4", "mp4"}
+}
+
+// sniffText tells text from binary the way browsers do: no control bytes
+// other than whitespace and escape
+func sniffText(b []byte) Kind {
+	if len(b) == 0 {
+		return Kind{"application/octet-stream", ""}
+	}
+	for _, c := range b {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != 0x1B {
+			return Kind{"application/octet-stream", ""}
+		}
+	}
+	lower := strings.ToLower(strings.TrimSpace(string(b[:min(len(b), 512)])))
+	for _, tag := range []string{"<!doctype html", "<html", "<head", "<script", "<body", "<?xml", "<svg"} {
+		if strings.HasPrefix(lower, tag) {
+			if tag == "<?xml" || tag == "<svg" {
+				return Kind{"text/xml", ""}
+			}
+			return Kind{"text/html", ""}
+		}
+	}
+	return Kind{"text/plain", ""}
+}
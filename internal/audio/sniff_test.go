@@ -0,0 +1,93 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// adtsFrame returns an AAC LC ADTS frame of n bytes at 44.1 kHz stereo
+func adtsFrame(n int) []byte {
+	b := make([]byte, n)
+	copy(b, []byte{0xFF, 0xF1, 0x50, 0x80 | byte(n>>11&3), byte(n >> 3), byte(n&7)<<5 | 0x1F, 0xFC})
+	return b
+}
+
+func TestSniff(t *testing.T) {
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	oggBOS := func(packet string) []byte {
+		return oggPageBytes(0x02, 0, 1, 0, []byte{byte(len(packet))}, []byte(packet))
+	}
+	ftyp := func(brands ...string) []byte {
+		major := brands[0]
+		return cat(mp4Box("ftyp", []byte(major), u32s(0), []byte(strings.Join(brands[1:], ""))), mp4Box("free"))
+	}
+	title := id3v2Tag(4, 0, id3v2TextFrame(4, "TIT2", 3, "Title"))
+
+	tests := []struct {
+		name string
+		head []byte
+		want audio.Kind
+	}{
+		{"WAV", encodeWAV(t, 8000, 1, 16, pcm.WAVOptions{BitDepth: 16}), audio.Kind{MIME: "audio/wav", Format: "wav"}},
+		{"RF64", rf64File(16), audio.Kind{MIME: "audio/wav", Format: "wav"}},
+		{"AIFF", aiffFile("AIFF", aiffCommChunk(1, 0, 16, 8000, "")), audio.Kind{MIME: "audio/aiff", Format: "aiff"}},
+		{"AIFC", aiffFile("AIFC", aiffCommChunk(1, 0, 16, 8000, "sowt")), audio.Kind{MIME: "audio/aiff", Format: "aiff"}},
+		{"FLAC", encodeFLAC(t, 8000, 1, 16, 16), audio.Kind{MIME: "audio/flac", Format: "flac"}},
+		{"FLAC after ID3v2", cat(title, encodeFLAC(t, 8000, 1, 16, 16)), audio.Kind{MIME: "audio/flac", Format: "flac"}},
+		{"MP3", mp3Frames(3), audio.Kind{MIME: "audio/mpeg", Format: "mp3"}},
+		{"MP3 after ID3v2", cat(title, mp3Frames(3)), audio.Kind{MIME: "audio/mpeg", Format: "mp3"}},
+		{"MP3 after ID3v2 and padding", cat(title, make([]byte, 50), mp3Frames(3)), audio.Kind{MIME: "audio/mpeg", Format: "mp3"}},
+		{"ID3v2 longer than the head", id3v2Tag(4, 0, make([]byte, audio.SniffLen)), audio.Kind{MIME: "audio/mpeg", Format: "id3"}},
+		{"ID3v2 before junk", cat(title, make([]byte, 200)), audio.Kind{MIME: "application/octet-stream"}},
+		{"single MPEG frame header", mpegFrame(mp3Header128, 417)[:100], audio.Kind{MIME: "audio/mpeg", Format: "mp3"}},
+		{"mismatched second frame", cat(mpegFrame(mp3Header128, 417), mpegFrame([]byte{0xFF, 0xF3, 0x80, 0xC0}, 208)), audio.Kind{MIME: "application/octet-stream"}},
+		{"ADTS", cat(adtsFrame(100), adtsFrame(120)), audio.Kind{MIME: "audio/aac", Format: "aac"}},
+		{"ADTS cut after one frame", adtsFrame(100)[:60], audio.Kind{MIME: "audio/aac", Format: "aac"}},
+		{"ADTS without a second frame", cat(adtsFrame(100), make([]byte, 100)), audio.Kind{MIME: "application/octet-stream"}},
+		{"Ogg Vorbis", vorbisFile().out, audio.Kind{MIME: "audio/ogg", Format: "ogg"}},
+		{"Ogg Opus", opusFile(), audio.Kind{MIME: "audio/opus", Format: "opus"}},
+		{"Ogg Theora with Vorbis", cat(oggBOS("\x80theora"), oggBOS("\x01vorbis")), audio.Kind{MIME: "video/ogg"}},
+		{"Ogg Opus after Vorbis", cat(oggBOS("\x01vorbis"), oggBOS("OpusHead")), audio.Kind{MIME: "audio/opus", Format: "opus"}},
+		{"M4A", m4aFile(false, aacEntry(0, 0, ascLC)), audio.Kind{MIME: "audio/mp4", Format: "mp4"}},
+		{"M4B compatible brand", ftyp("isom", "iso2", "M4B "), audio.Kind{MIME: "audio/mp4", Format: "mp4"}},
+		{"MP4", ftyp("isom", "mp41"), audio.Kind{MIME: "video/mp4", Format: "mp4"}},
+		{"3GP", ftyp("3gp4", "isom"), audio.Kind{MIME: "video/3gpp", Format: "mp4"}},
+		{"QuickTime brand", ftyp("qt  "), audio.Kind{MIME: "video/quicktime", Format: "mp4"}},
+		{"QuickTime without ftyp", mp4Box("moov", mvhdBox(1000, 0)), audio.Kind{MIME: "video/quicktime", Format: "mp4"}},
+		{"HEIC", ftyp("mif1", "heic"), audio.Kind{MIME: "image/heic"}},
+		{"AVIF", ftyp("avif", "mif1"), audio.Kind{MIME: "image/avif"}},
+		{"CAF", []byte("caff\x00\x01\x00\x00"), audio.Kind{MIME: "audio/x-caf", Format: "caf"}},
+		{"WMA", []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11\xA6\xD9"), audio.Kind{MIME: "audio/x-ms-wma", Format: "wma"}},
+		{"WebP", riffFile("WEBP", riffChunk("VP8 ", make([]byte, 10))), audio.Kind{MIME: "image/webp"}},
+		{"AVI", riffFile("AVI ", riffChunk("hdrl", nil)), audio.Kind{MIME: "video/x-msvideo"}},
+		{"ZIP", []byte("PK\x03\x04\x14\x00"), audio.Kind{MIME: "application/zip"}},
+		{"PNG", []byte("\x89PNG\r\n\x1A\n\x00\x00\x00\x0DIHDR"), audio.Kind{MIME: "image/png"}},
+		{"JPEG", []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF"), audio.Kind{MIME: "image/jpeg"}},
+		{"tar", append(make([]byte, 257), "ustar\x0000"...), audio.Kind{MIME: "application/x-tar"}},
+		{"ELF", []byte("\x7FELF\x02\x01\x01"), audio.Kind{MIME: "application/x-elf"}},
+		{"shell script", []byte("#!/bin/sh\necho hi\n"), audio.Kind{MIME: "text/x-shellscript"}},
+		{"UTF-8 BOM", []byte("\xEF\xBB\xBFhello"), audio.Kind{MIME: "text/plain"}},
+		{"UTF-16LE BOM", []byte("\xFF\xFEh\x00i\x00"), audio.Kind{MIME: "text/plain"}},
+		{"plain text", []byte("Just some notes\r\n\tindented\n"), audio.Kind{MIME: "text/plain"}},
+		{"HTML", []byte("  <!DOCTYPE html><html></html>"), audio.Kind{MIME: "text/html"}},
+		{"SVG", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), audio.Kind{MIME: "text/xml"}},
+		{"XML", []byte("<?xml version=\"1.0\"?><a/>"), audio.Kind{MIME: "text/xml"}},
+		{"text with binary past SniffLen", append([]byte(strings.Repeat("a", audio.SniffLen)), 0, 1, 2), audio.Kind{MIME: "text/plain"}},
+		{"binary", []byte{0x00, 0x01, 0x02, 0x03}, audio.Kind{MIME: "application/octet-stream"}},
+		{"empty", nil, audio.Kind{MIME: "application/octet-stream"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := audio.Sniff(tt.head)
+			if got != tt.want {
+				t.Fatalf("Sniff = %+v, want %+v", got, tt.want)
+			}
+			if got.IsAudio() != (tt.want.Format != "") {
+				t.Fatalf("IsAudio = %v for %+v", got.IsAudio(), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,623 @@
+package audio
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Artist roles
+const (
+	RoleMain      = "main"
+	RoleFeatured  = "featured"
+	RoleConductor = "conductor"
+	RoleRemixer   = "remixer"
+	RoleLyricist  = "lyricist"
+	RoleArranger  = "arranger"
+	RoleProducer  = "producer"
+	RoleEngineer  = "engineer"
+	RoleMixer     = "mixer"
+	RolePerformer = "performer"
+)
+
+// Tags is the format independent view of a file's metadata
+type Tags struct {
+	Title       string          `json:"title,omitempty"`
+	Artists     []Artist        `json:"artists,omitempty"`
+	Album       string          `json:"album,omitempty"`
+	AlbumArtist string          `json:"album_artist,omitempty"`
+	Track       int             `json:"track,omitempty"`
+	TrackTotal  int             `json:"track_total,omitempty"`
+	Disc        int             `json:"disc,omitempty"`
+	DiscTotal   int             `json:"disc_total,omitempty"`
+	Date        string          `json:"date,omitempty"` // "2004", "2004-06" or "2004-06-21"
+	Genres      []string        `json:"genres,omitempty"`
+	ISRC        string          `json:"isrc,omitempty"`
+	Composers   []string        `json:"composers,omitempty"`
+	Label       string          `json:"label,omitempty"`
+	MusicBrainz *MusicBrainzIDs `json:"musicbrainz,omitempty"`
+	Comment     string          `json:"comment,omitempty"`
+	Lyrics      string          `json:"lyrics,omitempty"`
+	ReplayGain  *ReplayGain     `json:"replaygain,omitempty"`
+}
+
+// Artist is a credited person or group
+type Artist struct {
+	Name       string `json:"name"`
+	Role       string `json:"role"`
+	Instrument string `json:"instrument,omitempty"` // performers only
+}
+
+// MusicBrainzIDs are the MusicBrainz identifiers Picard and similar taggers write
+type MusicBrainzIDs struct {
+	RecordingID    string   `json:"recording_id,omitempty"`
+	TrackID        string   `json:"track_id,omitempty"` // release track
+	ReleaseID      string   `json:"release_id,omitempty"`
+	ReleaseGroupID string   `json:"release_group_id,omitempty"`
+	ArtistIDs      []string `json:"artist_ids,omitempty"`
+	AlbumArtistIDs []string `json:"album_artist_ids,omitempty"`
+}
+
+// ReplayGain holds ReplayGain 2.0 values: gains in dB that bring the track
+// or its album to -18 LUFS, and peaks as linear amplitudes. Values a file
+// lacks are nil.
+type ReplayGain struct {
+	TrackGain *float64 `json:"track_gain,omitempty"`
+	TrackPeak *float64 `json:"track_peak,omitempty"`
+	AlbumGain *float64 `json:"album_gain,omitempty"`
+	AlbumPeak *float64 `json:"album_peak,omitempty"`
+}
+
+// r128Offset converts ReplayGain gains to the R128 gains of Opus tags,
+// which are relative to -23 LUFS rather than -18
+const r128Offset = -5
+
+// MainArtist returns the main artists' names joined for display
+func (t *Tags) MainArtist() string {
+	var names []string
+	for _, a := range t.Artists {
+		if a.Role == RoleMain {
+			names = append(names, a.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// tagMapping maps a raw tag key to a normalized field
+type tagMapping struct {
+	key, field string
+}
+
+// Per-format mapping tables. Where several keys feed a field the first one
+// present wins, so preferred keys come first.
+var (
+	id3Mappings = []tagMapping{
+		{"TIT2", "title"},
+		{"TPE1", "artist"},
+		{"TALB", "album"},
+		{"TPE2", "album_artist"},
+		{"TRCK", "track"},
+		{"TPOS", "disc"},
+		{"TDRC", "date"},
+		{"TYER", "date"}, // v2.3, with TDAT for day and month
+		{"TCON", "genre"},
+		{"TSRC", "isrc"},
+		{"TCOM", "composer"},
+		{"TPUB", "label"},
+		{"COMM", "comment"},
+		{"USLT", "lyrics"},
+		{"TPE3", "conductor"},
+		{"TPE4", "remixer"},
+		{"TEXT", "lyricist"},
+		{"TIPL", "involved"},
+		{"IPLS", "involved"},
+		{"TMCL", "musicians"},
+		{"UFID:http://musicbrainz.org", "mb_recording"},
+		{"TXXX:MusicBrainz Release Track Id", "mb_track"},
+		{"TXXX:MusicBrainz Album Id", "mb_release"},
+		{"TXXX:MusicBrainz Release Group Id", "mb_release_group"},
+		{"TXXX:MusicBrainz Artist Id", "mb_artist"},
+		{"TXXX:MusicBrainz Album Artist Id", "mb_album_artist"},
+		{"TXXX:REPLAYGAIN_TRACK_GAIN", "rg_track_gain"},
+		{"TXXX:replaygain_track_gain", "rg_track_gain"},
+		{"TXXX:REPLAYGAIN_TRACK_PEAK", "rg_track_peak"},
+		{"TXXX:replaygain_track_peak", "rg_track_peak"},
+		{"TXXX:REPLAYGAIN_ALBUM_GAIN", "rg_album_gain"},
+		{"TXXX:replaygain_album_gain", "rg_album_gain"},
+		{"TXXX:REPLAYGAIN_ALBUM_PEAK", "rg_album_peak"},
+		{"TXXX:replaygain_album_peak", "rg_album_peak"},
+	}
+
+	vorbisMappings = []tagMapping{
+		{"TITLE", "title"},
+		{"ARTIST", "artist"},
+		{"ALBUM", "album"},
+		{"ALBUMARTIST", "album_artist"},
+		{"ALBUM ARTIST", "album_artist"},
+		{"TRACKNUMBER", "track"},
+		{"TRACKTOTAL", "track_total"},
+		{"TOTALTRACKS", "track_total"},
+		{"DISCNUMBER", "disc"},
+		{"DISCTOTAL", "disc_total"},
+		{"TOTALDISCS", "disc_total"},
+		{"DATE", "date"},
+		{"YEAR", "date"},
+		{"GENRE", "genre"},
+		{"ISRC", "isrc"},
+		{"COMPOSER", "composer"},
+		{"LABEL", "label"},
+		{"ORGANIZATION", "label"},
+		{"PUBLISHER", "label"},
+		{"COMMENT", "comment"},
+		{"DESCRIPTION", "comment"},
+		{"LYRICS", "lyrics"},
+		{"UNSYNCEDLYRICS", "lyrics"},
+		{"CONDUCTOR", "conductor"},
+		{"REMIXER", "remixer"},
+		{"LYRICIST", "lyricist"},
+		{"ARRANGER", "arranger"},
+		{"PRODUCER", "producer"},
+		{"ENGINEER", "engineer"},
+		{"MIXER", "mixer"},
+		{"PERFORMER", "performer"},
+		{"MUSICBRAINZ_TRACKID", "mb_recording"},
+		{"MUSICBRAINZ_RELEASETRACKID", "mb_track"},
+		{"MUSICBRAINZ_ALBUMID", "mb_release"},
+		{"MUSICBRAINZ_RELEASEGROUPID", "mb_release_group"},
+		{"MUSICBRAINZ_ARTISTID", "mb_artist"},
+		{"MUSICBRAINZ_ALBUMARTISTID", "mb_album_artist"},
+		{"REPLAYGAIN_TRACK_GAIN", "rg_track_gain"},
+		{"REPLAYGAIN_TRACK_PEAK", "rg_track_peak"},
+		{"REPLAYGAIN_ALBUM_GAIN", "rg_album_gain"},
+		{"REPLAYGAIN_ALBUM_PEAK", "rg_album_peak"},
+		{"R128_TRACK_GAIN", "r128_track_gain"}, // Opus, Q7.8 dB
+		{"R128_ALBUM_GAIN", "r128_album_gain"},
+	}
+
+	mp4Mappings = []tagMapping{
+		{"©nam", "title"},
+		{"©ART", "artist"},
+		{"©alb", "album"},
+		{"aART", "album_artist"},
+		{"trkn", "track"},
+		{"disk", "disc"},
+		{"©day", "date"},
+		{"©gen", "genre"},
+		{"----:com.apple.iTunes:ISRC", "isrc"},
+		{"©wrt", "composer"},
+		{"----:com.apple.iTunes:LABEL", "label"},
+		{"©cmt", "comment"},
+		{"©lyr", "lyrics"},
+		{"----:com.apple.iTunes:CONDUCTOR", "conductor"},
+		{"----:com.apple.iTunes:REMIXER", "remixer"},
+		{"----:com.apple.iTunes:LYRICIST", "lyricist"},
+		{"----:com.apple.iTunes:ARRANGER", "arranger"},
+		{"----:com.apple.iTunes:PRODUCER", "producer"},
+		{"----:com.apple.iTunes:ENGINEER", "engineer"},
+		{"----:com.apple.iTunes:MIXER", "mixer"},
+		{"----:com.apple.iTunes:MusicBrainz Track Id", "mb_recording"},
+		{"----:com.apple.iTunes:MusicBrainz Release Track Id", "mb_track"},
+		{"----:com.apple.iTunes:MusicBrainz Album Id", "mb_release"},
+		{"----:com.apple.iTunes:MusicBrainz Release Group Id", "mb_release_group"},
+		{"----:com.apple.iTunes:MusicBrainz Artist Id", "mb_artist"},
+		{"----:com.apple.iTunes:MusicBrainz Album Artist Id", "mb_album_artist"},
+		{"----:com.apple.iTunes:replaygain_track_gain", "rg_track_gain"},
+		{"----:com.apple.iTunes:REPLAYGAIN_TRACK_GAIN", "rg_track_gain"},
+		{"----:com.apple.iTunes:replaygain_track_peak", "rg_track_peak"},
+		{"----:com.apple.iTunes:REPLAYGAIN_TRACK_PEAK", "rg_track_peak"},
+		{"----:com.apple.iTunes:replaygain_album_gain", "rg_album_gain"},
+		{"----:com.apple.iTunes:REPLAYGAIN_ALBUM_GAIN", "rg_album_gain"},
+		{"----:com.apple.iTunes:replaygain_album_peak", "rg_album_peak"},
+		{"----:com.apple.iTunes:REPLAYGAIN_ALBUM_PEAK", "rg_album_peak"},
+	}
+
+	// RIFF INFO; note that ISRC there is the source, not a recording code
+	riffMappings = []tagMapping{
+		{"INAM", "title"},
+		{"IART", "artist"},
+		{"IPRD", "album"},
+		{"ITRK", "track"},
+		{"IPRT", "track"},
+		{"ICRD", "date"},
+		{"IGNR", "genre"},
+		{"ICMT", "comment"},
+	}
+
+	aiffMappings = []tagMapping{
+		{"NAME", "title"},
+		{"AUTH", "artist"},
+		{"ANNO", "comment"},
+	}
+)
+
+// tagMappings returns the tables that apply to a container, in order of
+// precedence. Several containers may also carry an ID3v2 tag.
+func tagMappings(format string) [][]tagMapping {
+	switch format {
+	case "mp3":
+		return [][]tagMapping{id3Mappings}
+	case "flac", "ogg":
+		return [][]tagMapping{vorbisMappings, id3Mappings}
+	case "mp4":
+		return [][]tagMapping{mp4Mappings}
+	case "wav", "rf64":
+		return [][]tagMapping{riffMappings, id3Mappings}
+	case "aiff", "aifc":
+		return [][]tagMapping{aiffMappings, id3Mappings}
+	}
+	return [][]tagMapping{id3Mappings, vorbisMappings, mp4Mappings, riffMappings, aiffMappings}
+}
+
+// roleFields are the fields that credit artists in a given role, in the
+// order they are listed
+var roleFields = []struct{ field, role string }{
+	{"conductor", RoleConductor},
+	{"remixer", RoleRemixer},
+	{"lyricist", RoleLyricist},
+	{"arranger", RoleArranger},
+	{"producer", RoleProducer},
+	{"engineer", RoleEngineer},
+	{"mixer", RoleMixer},
+}
+
+// NormalizeTags maps the raw tags of a container (Info.Tags) onto Tags.
+// Text is repaired for common charset mistakes; multiple values are split
+// on "; ", the separator the parsers join them with.
+func NormalizeTags(format string, raw map[string]string) Tags {
+	values := map[string]string{}
+	sources := map[string]string{}
+	for _, table := range tagMappings(format) {
+		for _, m := range table {
+			if _, ok := values[m.field]; ok {
+				continue
+			}
+			if v := repairText(raw[m.key]); v != "" {
+				values[m.field] = v
+				sources[m.field] = m.key
+			}
+		}
+	}
+
+	var t Tags
+	t.Title = values["title"]
+	t.Album = values["album"]
+	t.AlbumArtist = values["album_artist"]
+	t.Label = values["label"]
+	t.Comment = values["comment"]
+	t.Lyrics = values["lyrics"]
+	t.ISRC = normalizeISRC(values["isrc"])
+	t.Genres = splitValues(values["genre"])
+	t.Composers = splitValues(values["composer"])
+
+	t.Track, t.TrackTotal = parseNumberPair(values["track"])
+	if n, _ := parseNumberPair(values["track_total"]); n > 0 {
+		t.TrackTotal = n
+	}
+	t.Disc, t.DiscTotal = parseNumberPair(values["disc"])
+	if n, _ := parseNumberPair(values["disc_total"]); n > 0 {
+		t.DiscTotal = n
+	}
+
+	date := values["date"]
+	if sources["date"] == "TYER" {
+		// TDAT is DDMM
+		if d := raw["TDAT"]; len(d) == 4 {
+			date += "-" + d[2:4] + "-" + d[0:2]
+		}
+	}
+	t.Date = normalizeDate(date)
+
+	for _, name := range splitValues(values["artist"]) {
+		t.Artists = append(t.Artists, splitFeatured(name)...)
+	}
+	for _, rf := range roleFields {
+		for _, name := range splitValues(values[rf.field]) {
+			t.Artists = append(t.Artists, Artist{Name: name, Role: rf.role})
+		}
+	}
+	t.Artists = append(t.Artists, involvedPeople(values["involved"], false)...)
+	t.Artists = append(t.Artists, involvedPeople(values["musicians"], true)...)
+	for _, p := range splitValues(values["performer"]) {
+		// "Name (instrument)"
+		a := Artist{Name: p, Role: RolePerformer}
+		if i := strings.LastIndex(p, " ("); i > 0 && strings.HasSuffix(p, ")") {
+			a.Name, a.Instrument = p[:i], p[i+2:len(p)-1]
+		}
+		t.Artists = append(t.Artists, a)
+	}
+	sortArtists(t.Artists)
+
+	mb := MusicBrainzIDs{
+		RecordingID:    normalizeMBID(values["mb_recording"]),
+		TrackID:        normalizeMBID(values["mb_track"]),
+		ReleaseID:      normalizeMBID(values["mb_release"]),
+		ReleaseGroupID: normalizeMBID(values["mb_release_group"]),
+	}
+	for _, id := range splitValues(values["mb_artist"]) {
+		if id = normalizeMBID(id); id != "" {
+			mb.ArtistIDs = append(mb.ArtistIDs, id)
+		}
+	}
+	for _, id := range splitValues(values["mb_album_artist"]) {
+		if id = normalizeMBID(id); id != "" {
+			mb.AlbumArtistIDs = append(mb.AlbumArtistIDs, id)
+		}
+	}
+	if mb.RecordingID != "" || mb.TrackID != "" || mb.ReleaseID != "" || mb.ReleaseGroupID != "" ||
+		len(mb.ArtistIDs) > 0 || len(mb.AlbumArtistIDs) > 0 {
+		t.MusicBrainz = &mb
+	}
+	t.ReplayGain = parseReplayGain(values)
+	return t
+}
+
+// parseReplayGain reads gains written as "-7.03 dB" and linear peaks. Opus
+// R128 gains stand in for missing ReplayGain gains.
+func parseReplayGain(values map[string]string) *ReplayGain {
+	number := func(field string) *float64 {
+		f := strings.Fields(values[field])
+		if len(f) == 0 {
+			return nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(f[0]), "db"), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return &v
+	}
+	gain := func(field string) *float64 {
+		if v := number("rg_" + field); v != nil {
+			return v
+		}
+		if q, err := strconv.Atoi(strings.TrimSpace(values["r128_"+field])); err == nil {
+			v := float64(q)/256 - r128Offset
+			return &v
+		}
+		return nil
+	}
+
+	rg := ReplayGain{
+		TrackGain: gain("track_gain"),
+		TrackPeak: number("rg_track_peak"),
+		AlbumGain: gain("album_gain"),
+		AlbumPeak: number("rg_album_peak"),
+	}
+	if rg == (ReplayGain{}) {
+		return nil
+	}
+	return &rg
+}
+
+// splitValues splits a multi-value field, dropping blanks and duplicates
+func splitValues(s string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, v := range strings.Split(s, "; ") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+var (
+	featuredRe  = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.|featuring)\s+`)
+	artistSepRe = regexp.MustCompile(`\s*(?:,|&| and )\s*`)
+)
+
+// splitFeatured splits "A feat. B & C" into a main and featured artists
+func splitFeatured(name string) []Artist {
+	parts := featuredRe.Split(name, 2)
+	artists := []Artist{{Name: strings.TrimSpace(parts[0]), Role: RoleMain}}
+	if len(parts) == 2 {
+		for _, f := range artistSepRe.Split(parts[1], -1) {
+			if f = strings.Trim(f, " ()"); f != "" {
+				artists = append(artists, Artist{Name: f, Role: RoleFeatured})
+			}
+		}
+	}
+	return artists
+}
+
+// involvedPeople reads ID3 TIPL/TMCL style role and name pairs. For TMCL
+// the "role" is an instrument.
+func involvedPeople(s string, musicians bool) []Artist {
+	var out []Artist
+	pairs := strings.Split(s, "; ")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		role, name := strings.TrimSpace(pairs[i]), strings.TrimSpace(pairs[i+1])
+		if name == "" {
+			continue
+		}
+		if musicians {
+			out = append(out, Artist{Name: name, Role: RolePerformer, Instrument: role})
+			continue
+		}
+		r := strings.ToLower(role)
+		switch r {
+		case "mix", "mixer", "dj-mix":
+			r = RoleMixer
+		case "producer", "engineer", "arranger":
+		default:
+			r = RolePerformer
+		}
+		out = append(out, Artist{Name: name, Role: r})
+	}
+	return out
+}
+
+// sortArtists orders main and featured artists first, keeping the order
+// within each role stable
+func sortArtists(artists []Artist) {
+	rank := func(a Artist) int {
+		switch a.Role {
+		case RoleMain:
+			return 0
+		case RoleFeatured:
+			return 1
+		}
+		return 2
+	}
+	// insertion sort, lists are short
+	for i := 1; i < len(artists); i++ {
+		for j := i; j > 0 && rank(artists[j]) < rank(artists[j-1]); j-- {
+			artists[j], artists[j-1] = artists[j-1], artists[j]
+		}
+	}
+}
+
+// parseNumberPair reads "3", "03" or "3/12"
+func parseNumberPair(s string) (n, total int) {
+	a, b, _ := strings.Cut(s, "/")
+	n, _ = strconv.Atoi(strings.TrimSpace(a))
+	total, _ = strconv.Atoi(strings.TrimSpace(b))
+	return max(n, 0), max(total, 0)
+}
+
+var dateRe = regexp.MustCompile(`^(\d{4})(?:[-/.](\d{1,2})(?:[-/.](\d{1,2}))?)?`)
+
+// normalizeDate keeps the leading year, month and day of an ISO 8601-ish
+// date at whatever precision the tag has
+func normalizeDate(s string) string {
+	m := dateRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || m[1] == "0000" {
+		return ""
+	}
+	out := m[1]
+	if month, _ := strconv.Atoi(m[2]); month >= 1 && month <= 12 {
+		out += "-" + twoDigits(month)
+		if day, _ := strconv.Atoi(m[3]); day >= 1 && day <= 31 {
+			out += "-" + twoDigits(day)
+		}
+	}
+	return out
+}
+
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+var isrcRe = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+
+// normalizeISRC returns the 12 character form, or "" if s is not an ISRC
+func normalizeISRC(s string) string {
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+	if !isrcRe.MatchString(s) {
+		return ""
+	}
+	return s
+}
+
+var mbidRe = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// normalizeMBID returns a lower-case MusicBrainz UUID, or "" if s is not one
+func normalizeMBID(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !mbidRe.MatchString(s) {
+		return ""
+	}
+	return s
+}
+
+// cp1252 holds the characters Windows-1252 puts at 0x80-0x9F, which Latin-1
+// leaves to control codes
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// repairText undoes the usual charset mistakes of legacy taggers:
+//   - bytes that are not UTF-8 at all are taken as Windows-1252
+//   - UTF-8 stored in a Latin-1 ID3 frame ("Ã©" for "é") is decoded again
+//   - Windows-1252 stored as Latin-1 has its 0x80-0x9F characters restored
+//   - UTF-16 read with the wrong byte order is swapped back
+//
+// NULs and stray byte order marks are dropped.
+func repairText(s string) string {
+	if s == "" {
+		return ""
+	}
+	if !utf8.ValidString(s) {
+		s = decodeCP1252([]byte(s))
+	}
+
+	if b, ok := latin1Bytes(s); ok {
+		if utf8.Valid(b) && !isASCII(b) {
+			s = string(b)
+		} else {
+			s = decodeCP1252(b)
+		}
+	} else if swapped, ok := swapUTF16(s); ok {
+		s = swapped
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if r == 0 || r == 0xFEFF || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// latin1Bytes returns s as Latin-1 bytes if every rune fits and at least
+// one is outside ASCII
+func latin1Bytes(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	high := false
+	for _, r := range s {
+		if r > 0xFF {
+			return nil, false
+		}
+		high = high || r >= 0x80
+		b = append(b, byte(r))
+	}
+	return b, high
+}
+
+func decodeCP1252(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		if c >= 0x80 && c <= 0x9F {
+			runes[i] = cp1252[c-0x80]
+		} else {
+			runes[i] = rune(c)
+		}
+	}
+	return string(runes)
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// swapUTF16 detects text whose UTF-16 code units were read byte swapped:
+// every character then has a zero low byte and printable ASCII above it
+func swapUTF16(s string) (string, bool) {
+	var out []rune
+	for _, r := range s {
+		if r&0xFF != 0 || r > 0xFFFF {
+			return "", false
+		}
+		c := r >> 8
+		if c >= 0x80 || !(unicode.IsPrint(c) || unicode.IsSpace(c)) {
+			return "", false
+		}
+		out = append(out, c)
+	}
+	return string(out), len(out) > 0
+}
@@ -0,0 +1,207 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"reflect"
+	"testing"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestNormalizeTags(t *testing.T) {
+	const (
+		mbid1 = "f1b4c1e6-0000-4000-8000-000000000001"
+		mbid2 = "f1b4c1e6-0000-4000-8000-000000000002"
+	)
+
+	tests := []struct {
+		name   string
+		format string
+		raw    map[string]string
+		want   audio.Tags
+	}{
+		{
+			name:   "ID3v2",
+			format: "mp3",
+			raw: map[string]string{
+				"TIT2": "Song", "TPE1": "A feat. B & C", "TALB": "Album", "TPE2": "Various",
+				"TRCK": "3/12", "TPOS": "1/2", "TYER": "1999", "TDAT": "2106",
+				"TCON": "Rock; rock; Pop", "TSRC": "us-rc1-76-07839", "TCOM": "X; Y", "TPUB": "Label",
+				"COMM": "Nice", "USLT": "La la", "TPE3": "Cond", "TPE4": "Remix", "TEXT": "Lyr",
+				"TIPL": "producer; P; mix; M; vocals; V", "TMCL": "guitar; G",
+				"UFID:http://musicbrainz.org":      "F1B4C1E6-0000-4000-8000-000000000001",
+				"TXXX:MusicBrainz Artist Id":       mbid1 + "; bad; " + mbid2,
+				"TXXX:REPLAYGAIN_TRACK_GAIN":       "-7.03 dB",
+				"TXXX:replaygain_track_peak":       "0.988",
+				"TXXX:MusicBrainz Album Artist Id": "nope",
+			},
+			want: audio.Tags{
+				Title: "Song", Album: "Album", AlbumArtist: "Various",
+				Track: 3, TrackTotal: 12, Disc: 1, DiscTotal: 2, Date: "1999-06-21",
+				Genres: []string{"Rock", "Pop"}, ISRC: "USRC17607839", Composers: []string{"X", "Y"}, Label: "Label",
+				Comment: "Nice", Lyrics: "La la",
+				Artists: []audio.Artist{
+					{Name: "A", Role: audio.RoleMain},
+					{Name: "B", Role: audio.RoleFeatured},
+					{Name: "C", Role: audio.RoleFeatured},
+					{Name: "Cond", Role: audio.RoleConductor},
+					{Name: "Remix", Role: audio.RoleRemixer},
+					{Name: "Lyr", Role: audio.RoleLyricist},
+					{Name: "P", Role: audio.RoleProducer},
+					{Name: "M", Role: audio.RoleMixer},
+					{Name: "V", Role: audio.RolePerformer},
+					{Name: "G", Role: audio.RolePerformer, Instrument: "guitar"},
+				},
+				MusicBrainz: &audio.MusicBrainzIDs{RecordingID: mbid1, ArtistIDs: []string{mbid1, mbid2}},
+				ReplayGain:  &audio.ReplayGain{TrackGain: float(-7.03), TrackPeak: float(0.988)},
+			},
+		},
+		{
+			name:   "TDRC wins over TYER",
+			format: "mp3",
+			raw:    map[string]string{"TDRC": "2004-06", "TYER": "1999", "TDAT": "2106"},
+			want:   audio.Tags{Date: "2004-06"},
+		},
+		{
+			name:   "Vorbis comments",
+			format: "flac",
+			raw: map[string]string{
+				"TITLE": "Song", "ARTIST": "Solo; Duo", "ALBUM ARTIST": "Band",
+				"TRACKNUMBER": "03", "TRACKTOTAL": "12", "DISCNUMBER": "1", "TOTALDISCS": "2",
+				"DATE": "2004-6-1", "ORGANIZATION": "Label", "DESCRIPTION": "Notes",
+				"PERFORMER": "Pia (piano); Vox", "MUSICBRAINZ_ALBUMID": mbid1,
+				"REPLAYGAIN_ALBUM_GAIN": "+1.5 dB", "REPLAYGAIN_ALBUM_PEAK": "1.0",
+			},
+			want: audio.Tags{
+				Title: "Song", AlbumArtist: "Band", Track: 3, TrackTotal: 12, Disc: 1, DiscTotal: 2,
+				Date: "2004-06-01", Label: "Label", Comment: "Notes",
+				Artists: []audio.Artist{
+					{Name: "Solo", Role: audio.RoleMain},
+					{Name: "Duo", Role: audio.RoleMain},
+					{Name: "Pia", Role: audio.RolePerformer, Instrument: "piano"},
+					{Name: "Vox", Role: audio.RolePerformer},
+				},
+				MusicBrainz: &audio.MusicBrainzIDs{ReleaseID: mbid1},
+				ReplayGain:  &audio.ReplayGain{AlbumGain: float(1.5), AlbumPeak: float(1)},
+			},
+		},
+		{
+			name:   "Opus R128 gains",
+			format: "ogg",
+			raw:    map[string]string{"R128_TRACK_GAIN": "256", "R128_ALBUM_GAIN": "-512", "REPLAYGAIN_ALBUM_GAIN": "-3 dB"},
+			want:   audio.Tags{ReplayGain: &audio.ReplayGain{TrackGain: float(6), AlbumGain: float(-3)}},
+		},
+		{
+			name:   "ID3v2 in Ogg under the Vorbis comments",
+			format: "ogg",
+			raw:    map[string]string{"TITLE": "Vorbis", "TIT2": "ID3", "TALB": "Album"},
+			want:   audio.Tags{Title: "Vorbis", Album: "Album"},
+		},
+		{
+			name:   "iTunes",
+			format: "mp4",
+			raw: map[string]string{
+				"©nam": "Song", "©ART": "Artist", "aART": "Band", "trkn": "3/12", "disk": "1",
+				"©day": "2004-06-21T07:00:00Z", "©gen": "Jazz", "©wrt": "Writer",
+				"----:com.apple.iTunes:ISRC":                         "USRC17607839",
+				"----:com.apple.iTunes:MusicBrainz Track Id":         mbid2,
+				"----:com.apple.iTunes:replaygain_track_gain":        "-2.5 dB",
+				"----:com.apple.iTunes:PRODUCER":                     "Prod",
+				"----:com.apple.iTunes:MusicBrainz Release Group Id": mbid1,
+			},
+			want: audio.Tags{
+				Title: "Song", AlbumArtist: "Band", Track: 3, TrackTotal: 12, Disc: 1,
+				Date: "2004-06-21", Genres: []string{"Jazz"}, Composers: []string{"Writer"}, ISRC: "USRC17607839",
+				Artists: []audio.Artist{
+					{Name: "Artist", Role: audio.RoleMain},
+					{Name: "Prod", Role: audio.RoleProducer},
+				},
+				MusicBrainz: &audio.MusicBrainzIDs{RecordingID: mbid2, ReleaseGroupID: mbid1},
+				ReplayGain:  &audio.ReplayGain{TrackGain: float(-2.5)},
+			},
+		},
+		{
+			name:   "RIFF INFO before ID3v2",
+			format: "wav",
+			raw:    map[string]string{"INAM": "", "TIT2": "From ID3", "IART": "RIFF Artist", "TPE1": "ID3 Artist", "IPRT": "4", "ICRD": "2001"},
+			want: audio.Tags{
+				Title: "From ID3", Track: 4, Date: "2001",
+				Artists: []audio.Artist{{Name: "RIFF Artist", Role: audio.RoleMain}},
+			},
+		},
+		{
+			name:   "AIFF text chunks",
+			format: "aiff",
+			raw:    map[string]string{"NAME": "Song", "AUTH": "Author", "ANNO": "first\nsecond"},
+			want: audio.Tags{
+				Title: "Song", Comment: "first\nsecond",
+				Artists: []audio.Artist{{Name: "Author", Role: audio.RoleMain}},
+			},
+		},
+		{
+			name:   "unknown format tries every table",
+			format: "",
+			raw:    map[string]string{"TITLE": "Vorbis", "©alb": "Album", "INAM": "RIFF"},
+			want:   audio.Tags{Title: "Vorbis", Album: "Album"},
+		},
+		{
+			name:   "invalid values are dropped",
+			format: "flac",
+			raw: map[string]string{
+				"DATE": "0000", "ISRC": "bogus", "MUSICBRAINZ_TRACKID": "not-a-uuid",
+				"REPLAYGAIN_TRACK_GAIN": "loud", "REPLAYGAIN_TRACK_PEAK": "NaN", "TRACKNUMBER": "x/-3",
+			},
+			want: audio.Tags{},
+		},
+		{
+			name:   "charset repair",
+			format: "mp3",
+			raw: map[string]string{
+				"TIT2": "CafÃ©",                    // UTF-8 read as Latin-1
+				"TPE1": "\u5400\u6900\u6d00",       // byte-swapped UTF-16
+				"TALB": "\x93Quoted\x94",           // raw Windows-1252
+				"TPE2": "\u0093Smart\u0094 \u0080", // Windows-1252 read as Latin-1
+				"COMM": "Café",                     // Latin-1 stays
+				"TCOM": " \uFEFF日本\x00 ",           // stray BOM and NUL
+				"TPUB": "naïve – Œuvre",            // not Latin-1, left alone
+				"USLT": "\x00",                     // nothing left
+			},
+			want: audio.Tags{
+				Title: "Café", Album: "“Quoted”", AlbumArtist: "“Smart” €", Comment: "Café",
+				Composers: []string{"日本"}, Label: "naïve – Œuvre",
+				Artists: []audio.Artist{{Name: "Tim", Role: audio.RoleMain}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := audio.NormalizeTags(tt.format, tt.raw)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("NormalizeTags =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNormalizeProbedTags repairs text as the ID3v2 parser hands it over
+func TestNormalizeProbedTags(t *testing.T) {
+	tag := id3v2Tag(3, 0,
+		id3v2TextFrame(3, "TIT2", 0, "CafÃ©"),
+		id3v2Frame(3, "TPE1", 0, []byte{1, 0xFF, 0xFE, 0, 'H', 0, 'i'}), // big-endian behind a little-endian BOM
+		id3v2TextFrame(3, "TALB", 1, "Björk"))
+	info, err := probe(append(tag, mp3Frames(2)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := audio.NormalizeTags(info.Format, info.Tags)
+	if got.Title != "Café" || got.MainArtist() != "Hi" || got.Album != "Björk" {
+		t.Fatalf("title %q, artist %q, album %q, want Café, Hi and Björk", got.Title, got.MainArtist(), got.Album)
+	}
+}
+
+func TestMainArtist(t *testing.T) {
+	tags := audio.NormalizeTags("flac", map[string]string{"ARTIST": "A & B feat. C; D", "CONDUCTOR": "E"})
+	if got := tags.MainArtist(); got != "A & B, D" {
+		t.Fatalf("MainArtist = %q, want the main artists only", got)
+	}
+}
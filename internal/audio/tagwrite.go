@@ -0,0 +1,706 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // picture dimensions for FLAC
+	_ "image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNotRetaggable is returned by Retag for formats it cannot write tags to
+var ErrNotRetaggable = errors.New("format does not support tag rewriting")
+
+const (
+	mp4MaxMoov   = 64 << 20 // largest moov rewritten in memory
+	flacMaxBlock = 1<<24 - 1
+)
+
+// Segment is a piece of a retagged file: new bytes, or when Data is nil a
+// byte range of the original
+type Segment struct {
+	Data   []byte
+	Offset int64
+	Length int64
+}
+
+// Retagged describes a file whose metadata has been rewritten. Only the new
+// metadata is held in memory; the audio is referenced in the original, byte
+// for byte, so the result can be streamed without storing a copy.
+type Retagged struct {
+	Segments []Segment
+	Size     int64
+}
+
+func (rt *Retagged) data(b []byte) {
+	rt.Segments = append(rt.Segments, Segment{Data: b, Length: int64(len(b))})
+	rt.Size += int64(len(b))
+}
+
+func (rt *Retagged) copy(off, n int64) {
+	if n <= 0 {
+		return
+	}
+	rt.Segments = append(rt.Segments, Segment{Offset: off, Length: n})
+	rt.Size += n
+}
+
+// Retag plans a copy of the file with its metadata replaced by tags and
+// pictures: an ID3v2.4 tag for MP3, Vorbis comments and PICTURE blocks for
+// FLAC, an iTunes ilst for MP4. A nil pictures keeps the file's own
+// artwork. Other formats and fragmented MP4 yield ErrNotRetaggable.
+func Retag(r io.ReaderAt, size int64, tags Tags, pictures []Picture) (*Retagged, error) {
+	magic := make([]byte, 12)
+	n, err := r.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	magic = magic[:n]
+
+	switch {
+	case n >= 12 && (isRIFF(magic) || string(magic[:4]) == "FORM"), n >= 4 && string(magic[:4]) == "OggS":
+		return nil, ErrNotRetaggable
+	case isMP4(magic):
+		return retagMP4(r, size, tags, pictures)
+	}
+
+	start := int64(0)
+	if tagLen, ok := id3v2Length(magic); ok {
+		start = tagLen
+	}
+	if start > size {
+		return nil, truncated("id3", 0, "ID3v2 tag declares %d bytes but the file has %d", start, size)
+	}
+	if b, err := readAt(r, start, 4); err == nil && string(b) == "fLaC" {
+		return retagFLAC(r, size, start, tags, pictures)
+	}
+	if isMPEGStream(r, start, size) || (start > 0 && hasMPEGFrame(r, start, size)) {
+		return retagMP3(r, size, start, tags, pictures)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// tagValues flattens tags into the fields of the mapping tables, each with
+// its values in order. Fields a format has no key for are not written.
+func tagValues(t Tags) map[string][]string {
+	v := map[string][]string{}
+	add := func(field string, values ...string) {
+		for _, s := range values {
+			if s = strings.TrimSpace(s); s != "" {
+				v[field] = append(v[field], s)
+			}
+		}
+	}
+	number := func(n int) string {
+		if n <= 0 {
+			return ""
+		}
+		return strconv.Itoa(n)
+	}
+
+	add("title", t.Title)
+	add("album", t.Album)
+	add("album_artist", t.AlbumArtist)
+	add("track", number(t.Track))
+	add("track_total", number(t.TrackTotal))
+	add("disc", number(t.Disc))
+	add("disc_total", number(t.DiscTotal))
+	add("date", t.Date)
+	add("genre", t.Genres...)
+	add("isrc", t.ISRC)
+	add("composer", t.Composers...)
+	add("label", t.Label)
+	add("comment", t.Comment)
+	add("lyrics", t.Lyrics)
+
+	// Featured artists ride on the last main artist, as in "A feat. B, C"
+	var main, featured []string
+	for _, a := range t.Artists {
+		switch a.Role {
+		case RoleMain:
+			main = append(main, a.Name)
+		case RoleFeatured:
+			featured = append(featured, a.Name)
+		}
+	}
+	if len(main) > 0 && len(featured) > 0 {
+		main[len(main)-1] += " feat. " + strings.Join(featured, ", ")
+	}
+	add("artist", main...)
+
+	for _, rf := range roleFields {
+		for _, a := range t.Artists {
+			if a.Role == rf.role {
+				add(rf.field, a.Name)
+			}
+		}
+	}
+	// ID3 credits roles as pairs in TIPL and instruments in TMCL
+	for _, a := range t.Artists {
+		switch {
+		case a.Name == "":
+		case a.Role == RolePerformer && a.Instrument != "":
+			add("performer", a.Name+" ("+a.Instrument+")")
+			add("musicians", a.Instrument, a.Name)
+		case a.Role == RolePerformer:
+			add("performer", a.Name)
+			add("involved", "performer", a.Name)
+		case a.Role == RoleMixer:
+			add("involved", "mix", a.Name)
+		case a.Role == RoleArranger, a.Role == RoleProducer, a.Role == RoleEngineer:
+			add("involved", a.Role, a.Name)
+		}
+	}
+
+	if mb := t.MusicBrainz; mb != nil {
+		add("mb_recording", mb.RecordingID)
+		add("mb_track", mb.TrackID)
+		add("mb_release", mb.ReleaseID)
+		add("mb_release_group", mb.ReleaseGroupID)
+		add("mb_artist", mb.ArtistIDs...)
+		add("mb_album_artist", mb.AlbumArtistIDs...)
+	}
+
+	if rg := t.ReplayGain; rg != nil {
+		gain := func(field string, db *float64) {
+			if db != nil {
+				add("rg_"+field, fmt.Sprintf("%.2f dB", *db))
+				q := math.Round((*db + r128Offset) * 256)
+				add("r128_"+field, strconv.Itoa(int(max(min(q, math.MaxInt16), math.MinInt16))))
+			}
+		}
+		peak := func(field string, p *float64) {
+			if p != nil {
+				add(field, fmt.Sprintf("%.6f", *p))
+			}
+		}
+		gain("track_gain", rg.TrackGain)
+		peak("rg_track_peak", rg.TrackPeak)
+		gain("album_gain", rg.AlbumGain)
+		peak("rg_album_peak", rg.AlbumPeak)
+	}
+	return v
+}
+
+// writeKeys returns the preferred key of each field in a mapping table
+func writeKeys(table []tagMapping) []tagMapping {
+	var keys []tagMapping
+	seen := map[string]bool{}
+	for _, m := range table {
+		if !seen[m.field] {
+			seen[m.field] = true
+			keys = append(keys, m)
+		}
+	}
+	return keys
+}
+
+// numberPair renders a number and its total, "3/12" or "3"
+func numberPair(v map[string][]string, field string) string {
+	if len(v[field]) == 0 {
+		return ""
+	}
+	if total := v[field+"_total"]; len(total) > 0 {
+		return v[field][0] + "/" + total[0]
+	}
+	return v[field][0]
+}
+
+func retagMP3(r io.ReaderAt, size, start int64, tags Tags, pictures []Picture) (*Retagged, error) {
+	if pictures == nil && start > 0 {
+		old := &Info{}
+		if _, err := parseID3v2(r, 0, size, old); err != nil {
+			return nil, err
+		}
+		pictures = old.Pictures
+	}
+	// Trailing ID3v1 and APE tags would contradict the new tag
+	end := trailingTagsStart(r, start, size, &Info{})
+
+	rt := &Retagged{}
+	rt.data(buildID3v24(tags, pictures))
+	rt.copy(start, end-start)
+	return rt, nil
+}
+
+// buildID3v24 renders an ID3v2.4 tag with UTF-8 text frames
+func buildID3v24(tags Tags, pictures []Picture) []byte {
+	var body bytes.Buffer
+	frame := func(id string, data []byte) {
+		var h [10]byte
+		copy(h[:4], id)
+		putSynchsafe(h[4:8], len(data))
+		body.Write(h[:])
+		body.Write(data)
+	}
+	text := func(values ...string) []byte {
+		return append([]byte{id3UTF8}, strings.Join(values, "\x00")...)
+	}
+
+	v := tagValues(tags)
+	for _, m := range writeKeys(id3Mappings) {
+		values := v[m.field]
+		switch {
+		case m.field == "track" || m.field == "disc":
+			if s := numberPair(v, m.field); s != "" {
+				frame(m.key, text(s))
+			}
+		case len(values) == 0:
+		case m.key == "COMM" || m.key == "USLT":
+			// encoding, language, empty description, text
+			frame(m.key, append([]byte{id3UTF8, 'X', 'X', 'X', 0}, values[0]...))
+		case strings.HasPrefix(m.key, "TXXX:"):
+			frame("TXXX", text(append([]string{m.key[5:]}, values...)...))
+		case strings.HasPrefix(m.key, "UFID:"):
+			frame("UFID", append(append([]byte(m.key[5:]), 0), values[0]...))
+		default:
+			frame(m.key, text(values...))
+		}
+	}
+	for _, pic := range pictures {
+		// encoding, MIME type, picture type, description, data
+		data := append([]byte{id3UTF8}, pic.MIMEType...)
+		data = append(data, 0, byte(pic.Type))
+		data = append(data, pic.Description...)
+		data = append(data, 0)
+		frame("APIC", append(data, pic.Data...))
+	}
+
+	tag := make([]byte, 10, 10+body.Len())
+	copy(tag, "ID3\x04\x00\x00")
+	putSynchsafe(tag[6:10], body.Len())
+	return append(tag, body.Bytes()...)
+}
+
+func putSynchsafe(b []byte, n int) {
+	for i := 3; i >= 0; i-- {
+		b[i] = byte(n & 0x7F)
+		n >>= 7
+	}
+}
+
+// retagFLAC keeps the structural metadata blocks (STREAMINFO, SEEKTABLE,
+// APPLICATION, CUESHEET) and replaces comments, pictures and padding. An
+// ID3 tag around the stream is dropped.
+func retagFLAC(r io.ReaderAt, size, start int64, tags Tags, pictures []Picture) (*Retagged, error) {
+	const format = "flac"
+
+	type block struct {
+		typ       byte
+		off, size int64 // of the body in the original
+	}
+	var (
+		kept   []block
+		old    = &Info{}
+		vendor = "audio-go"
+	)
+	off := start + 4
+	for {
+		h, err := readAt(r, off, 4)
+		if err != nil {
+			return nil, truncated(format, off, "metadata block header: %v", err)
+		}
+		last := h[0]&0x80 != 0
+		typ := h[0] & 0x7F
+		length := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		if off+4+length > size {
+			return nil, truncated(format, off, "metadata block %d declares %d bytes but only %d remain", typ, length, size-off-4)
+		}
+
+		switch typ {
+		case flacStreamInfo, flacSeekTable, flacApplication, flacCueSheet:
+			kept = append(kept, block{typ, off + 4, length})
+		case flacVorbisComment:
+			b, err := readAt(r, off+4, int(length))
+			if err != nil {
+				return nil, truncated(format, off+4, "VORBIS_COMMENT: %v", err)
+			}
+			if parseVorbisComment(b, old) == nil && old.Encoder != "" {
+				vendor = old.Encoder
+			}
+		case flacPicture:
+			if pictures != nil {
+				break
+			}
+			b, err := readAt(r, off+4, int(length))
+			if err != nil {
+				return nil, truncated(format, off+4, "PICTURE: %v", err)
+			}
+			if pic, err := parseFLACPicture(b); err == nil {
+				old.Pictures = append(old.Pictures, pic)
+			}
+		}
+		off += 4 + length
+		if last {
+			break
+		}
+	}
+	if len(kept) == 0 || kept[0].typ != flacStreamInfo {
+		return nil, malformed(format, start+4, "first metadata block is not STREAMINFO")
+	}
+	if pictures == nil {
+		pictures = old.Pictures
+	}
+
+	end := size
+	if size-off >= 128 {
+		if b, err := readAt(r, size-128, 128); err == nil && string(b[:3]) == "TAG" {
+			end -= 128
+		}
+	}
+
+	var added [][]byte
+	comment := buildVorbisComment(vendor, tags)
+	if len(comment) > flacMaxBlock {
+		return nil, fmt.Errorf("flac: Vorbis comment of %d bytes does not fit a metadata block", len(comment))
+	}
+	added = append(added, comment)
+	for _, pic := range pictures {
+		b := buildFLACPicture(pic)
+		if len(b) > flacMaxBlock {
+			continue // too large for a metadata block, left out
+		}
+		added = append(added, b)
+	}
+
+	header := func(typ byte, n int64, last bool) []byte {
+		if last {
+			typ |= 0x80
+		}
+		return []byte{typ, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	rt := &Retagged{}
+	rt.data([]byte("fLaC"))
+	for _, b := range kept {
+		rt.data(header(b.typ, b.size, false))
+		rt.copy(b.off, b.size)
+	}
+	for i, b := range added {
+		typ := byte(flacVorbisComment)
+		if i > 0 {
+			typ = flacPicture
+		}
+		rt.data(append(header(typ, int64(len(b)), i == len(added)-1), b...))
+	}
+	rt.copy(off, end-off)
+	return rt, nil
+}
+
+// buildVorbisComment renders a Vorbis comment block, one field per value
+func buildVorbisComment(vendor string, tags Tags) []byte {
+	var fields []string
+	v := tagValues(tags)
+	for _, m := range writeKeys(vorbisMappings) {
+		for _, value := range v[m.field] {
+			fields = append(fields, m.key+"="+value)
+		}
+	}
+
+	var b bytes.Buffer
+	str := func(s string) {
+		binary.Write(&b, le, uint32(len(s)))
+		b.WriteString(s)
+	}
+	str(vendor)
+	binary.Write(&b, le, uint32(len(fields)))
+	for _, f := range fields {
+		str(f)
+	}
+	return b.Bytes()
+}
+
+// buildFLACPicture renders a PICTURE block body. Dimensions are read from
+// JPEG and PNG data and left zero otherwise.
+func buildFLACPicture(pic Picture) []byte {
+	var width, height, depth, colors int
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(pic.Data)); err == nil {
+		width, height = cfg.Width, cfg.Height
+		switch m := cfg.ColorModel.(type) {
+		case color.Palette:
+			depth, colors = 8, len(m)
+		default:
+			switch m {
+			case color.GrayModel:
+				depth = 8
+			case color.Gray16Model:
+				depth = 16
+			case color.RGBAModel, color.NRGBAModel:
+				depth = 32
+			case color.RGBA64Model, color.NRGBA64Model:
+				depth = 64
+			default:
+				depth = 24
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	u32 := func(n int) { binary.Write(&b, be, uint32(n)) }
+	u32(pic.Type)
+	u32(len(pic.MIMEType))
+	b.WriteString(pic.MIMEType)
+	u32(len(pic.Description))
+	b.WriteString(pic.Description)
+	u32(width)
+	u32(height)
+	u32(depth)
+	u32(colors)
+	u32(len(pic.Data))
+	b.Write(pic.Data)
+	return b.Bytes()
+}
+
+// retagMP4 rebuilds the moov box with a new udta/meta/ilst and moves the
+// sample chunk offsets by the change in size when the media data follows
+// it. Every other top-level box is copied as is.
+func retagMP4(r io.ReaderAt, size int64, tags Tags, pictures []Picture) (*Retagged, error) {
+	const format = "mp4"
+
+	p := &mp4Parser{r: r, size: size, info: &Info{}}
+	var moov *mp4Box
+	fragmented := false
+	err := p.children(0, size, func(b mp4Box) error {
+		switch b.typ {
+		case "moov":
+			if moov != nil {
+				return malformed(format, b.off, "duplicate moov box")
+			}
+			moov = &b
+		case "moof":
+			fragmented = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moov == nil {
+		return nil, truncated(format, size, "no moov box")
+	}
+	if fragmented {
+		// Fragments may address data by absolute offsets, left alone
+		return nil, ErrNotRetaggable
+	}
+	if n := moov.end - moov.off; n > mp4MaxMoov {
+		return nil, malformed(format, moov.off, "moov box of %d bytes is too large", n)
+	}
+	buf, err := readAt(r, moov.off, int(moov.end-moov.off))
+	if err != nil {
+		return nil, truncated(format, moov.off, "moov box: %v", err)
+	}
+
+	// Work on the in-memory copy, with offsets relative to it
+	mem := &mp4Parser{r: bytes.NewReader(buf), size: int64(len(buf)), info: &Info{Tags: map[string]string{}}}
+	body := moov.body - moov.off
+	if pictures == nil {
+		err := mem.children(body, int64(len(buf)), func(b mp4Box) error {
+			switch b.typ {
+			case "udta":
+				meta, err := mem.find(b, "meta")
+				if err != nil || meta == nil {
+					return err
+				}
+				return mem.parseMeta(*meta)
+			case "meta":
+				return mem.parseMeta(b)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		pictures = mem.info.Pictures
+	}
+
+	var out []byte
+	hasUdta := false
+	err = mem.children(body, int64(len(buf)), func(b mp4Box) error {
+		switch b.typ {
+		case "mvex":
+			fragmented = true
+		case "meta":
+			// QuickTime keys metadata, superseded by the new ilst
+		case "udta":
+			// Other user data (chapter lists, names) stays, meta is replaced
+			hasUdta = true
+			var udta []byte
+			err := mem.children(b.body, b.end, func(c mp4Box) error {
+				if c.typ != "meta" {
+					udta = append(udta, buf[c.off:c.end]...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			udta = append(udta, buildMP4Meta(tags, pictures)...)
+			out = append(out, mp4BoxBytes("udta", udta)...)
+		default:
+			out = append(out, buf[b.off:b.end]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fragmented {
+		return nil, ErrNotRetaggable
+	}
+	if !hasUdta {
+		out = append(out, mp4BoxBytes("udta", buildMP4Meta(tags, pictures))...)
+	}
+	newMoov := mp4BoxBytes("moov", out)
+
+	// Chunks stored after the moov move with its change in size
+	delta := int64(len(newMoov)) - (moov.end - moov.off)
+	if delta != 0 {
+		if err := shiftChunkOffsets(newMoov, moov.end, delta); err != nil {
+			return nil, err
+		}
+	}
+
+	rt := &Retagged{}
+	rt.copy(0, moov.off)
+	rt.data(newMoov)
+	rt.copy(moov.end, size-moov.end)
+	return rt, nil
+}
+
+// buildMP4Meta renders an iTunes metadata box: meta, its mdir handler and ilst
+func buildMP4Meta(tags Tags, pictures []Picture) []byte {
+	var ilst []byte
+	data := func(typ uint32, payload []byte) []byte {
+		b := make([]byte, 8, 8+len(payload))
+		be.PutUint32(b[0:4], typ) // version 0, then the type
+		return mp4BoxBytes("data", append(b, payload...))
+	}
+	item := func(key string, typ uint32, values ...[]byte) {
+		var body []byte
+		if mean, name, ok := strings.Cut(strings.TrimPrefix(key, "----:"), ":"); ok && strings.HasPrefix(key, "----:") {
+			body = append(body, mp4BoxBytes("mean", append(make([]byte, 4), mean...))...)
+			body = append(body, mp4BoxBytes("name", append(make([]byte, 4), name...))...)
+			key = "----"
+		}
+		for _, v := range values {
+			body = append(body, data(typ, v)...)
+		}
+		ilst = append(ilst, mp4BoxBytes(key, body)...)
+	}
+
+	v := tagValues(tags)
+	for _, m := range writeKeys(mp4Mappings) {
+		switch m.key {
+		case "trkn", "disk":
+			field := map[string]string{"trkn": "track", "disk": "disc"}[m.key]
+			if len(v[field]) == 0 {
+				continue
+			}
+			n, _ := strconv.Atoi(v[field][0])
+			total := 0
+			if t := v[field+"_total"]; len(t) > 0 {
+				total, _ = strconv.Atoi(t[0])
+			}
+			b := make([]byte, 8)
+			be.PutUint16(b[2:4], uint16(n))
+			be.PutUint16(b[4:6], uint16(total))
+			if m.key == "disk" {
+				b = b[:6]
+			}
+			item(m.key, 0, b)
+			continue
+		}
+		var values [][]byte
+		for _, s := range v[m.field] {
+			values = append(values, []byte(s))
+		}
+		if len(values) > 0 {
+			item(m.key, 1, values...)
+		}
+	}
+	var covr []byte
+	for _, pic := range pictures {
+		for typ, mime := range mp4ImageTypes {
+			if mime == pic.MIMEType {
+				covr = append(covr, data(typ, pic.Data)...)
+			}
+		}
+	}
+	if len(covr) > 0 {
+		ilst = append(ilst, mp4BoxBytes("covr", covr)...)
+	}
+
+	// hdlr: version and flags, pre_defined, handler type, reserved, empty name
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:12], "mdir")
+	copy(hdlr[12:16], "appl")
+	meta := make([]byte, 4) // full box version and flags
+	meta = append(meta, mp4BoxBytes("hdlr", hdlr)...)
+	meta = append(meta, mp4BoxBytes("ilst", ilst)...)
+	return mp4BoxBytes("meta", meta)
+}
+
+// mp4BoxBytes wraps body in a box header. Types are Latin-1, as in "©nam".
+func mp4BoxBytes(typ string, body []byte) []byte {
+	b := make([]byte, 8, 8+len(body))
+	be.PutUint32(b[0:4], uint32(8+len(body)))
+	i := 4
+	for _, c := range typ {
+		if i < 8 {
+			b[i] = byte(c)
+			i++
+		}
+	}
+	return append(b, body...)
+}
+
+// shiftChunkOffsets adds delta to the stco and co64 entries of every track
+// in moov that point at or past end
+func shiftChunkOffsets(moov []byte, end, delta int64) error {
+	const format = "mp4"
+
+	p := &mp4Parser{r: bytes.NewReader(moov), size: int64(len(moov)), info: &Info{}}
+	var walk func(b mp4Box) error
+	walk = func(b mp4Box) error {
+		switch b.typ {
+		case "trak", "mdia", "minf", "stbl":
+			return p.children(b.body, b.end, walk)
+		case "stco", "co64":
+			entries := moov[b.body:b.end]
+			if len(entries) < 8 {
+				return malformed(format, b.off, "%s box is too short", b.typ)
+			}
+			n := int64(be.Uint32(entries[4:8]))
+			width := int64(4)
+			if b.typ == "co64" {
+				width = 8
+			}
+			if 8+n*width > int64(len(entries)) {
+				return malformed(format, b.off, "%s box declares %d entries", b.typ, n)
+			}
+			for i := int64(0); i < n; i++ {
+				e := entries[8+i*width:]
+				if width == 4 {
+					off := int64(be.Uint32(e))
+					if off < end {
+						continue
+					}
+					if off+delta > 0xFFFFFFFF {
+						return malformed(format, b.off, "chunk offset no longer fits stco")
+					}
+					be.PutUint32(e, uint32(off+delta))
+				} else if off := int64(be.Uint64(e)); off >= end {
+					be.PutUint64(e, uint64(off+delta))
+				}
+			}
+		}
+		return nil
+	}
+	return p.children(8, int64(len(moov)), walk)
+}
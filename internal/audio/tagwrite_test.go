@@ -0,0 +1,229 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// retag runs Retag on src and joins the segments it plans
+func retag(t *testing.T, src []byte, tags audio.Tags, pictures []audio.Picture) []byte {
+	t.Helper()
+	rt, err := audio.Retag(bytes.NewReader(src), int64(len(src)), tags, pictures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, s := range rt.Segments {
+		if s.Data != nil {
+			if int64(len(s.Data)) != s.Length {
+				t.Fatalf("segment of %d bytes says it has %d", len(s.Data), s.Length)
+			}
+			out = append(out, s.Data...)
+		} else {
+			out = append(out, src[s.Offset:s.Offset+s.Length]...)
+		}
+	}
+	if int64(len(out)) != rt.Size {
+		t.Fatalf("segments add up to %d bytes, Size says %d", len(out), rt.Size)
+	}
+	return out
+}
+
+// retagTags are written in every round trip
+var retagTags = audio.Tags{
+	Title: "New Title", Album: "Album", AlbumArtist: "Band",
+	Artists: []audio.Artist{
+		{Name: "A", Role: audio.RoleMain},
+		{Name: "B", Role: audio.RoleFeatured},
+		{Name: "P", Role: audio.RoleProducer},
+		{Name: "G", Role: audio.RolePerformer, Instrument: "guitar"},
+	},
+	Track: 3, TrackTotal: 12, Disc: 1, DiscTotal: 2, Date: "2004-06-21",
+	Genres: []string{"Rock", "Pop"}, ISRC: "USRC17607839", Composers: []string{"X", "Y"},
+	Label: "Label", Comment: "Comment", Lyrics: "La la",
+	MusicBrainz: &audio.MusicBrainzIDs{RecordingID: "f1b4c1e6-0000-4000-8000-000000000001"},
+	ReplayGain:  &audio.ReplayGain{TrackGain: float(-7.03), TrackPeak: float(0.988)},
+}
+
+// retagTagsMP4 is what iTunes metadata keeps of retagTags: it has no key
+// for performers
+func retagTagsMP4() audio.Tags {
+	t := retagTags
+	t.Artists = t.Artists[:3]
+	return t
+}
+
+var retagCover = audio.Picture{Type: 3, MIMEType: "image/jpeg", Data: []byte{0xFF, 0xD8, 0xFF, 0xE0, 1, 2, 3}}
+
+func TestRetag(t *testing.T) {
+	oldTag := id3v2Tag(3, 0, id3v2TextFrame(3, "TIT2", 0, "Old"), id3v2TextFrame(3, "TCON", 0, "Old Genre"))
+	oldCover := audio.Picture{Type: 3, MIMEType: "image/png", Data: []byte("\x89PNG old")}
+	oldComment := flacBlock(4, vorbisComment("reference libFLAC 1.4.3", "TITLE=Old", "GENRE=Old Genre"))
+	seekTable := flacBlock(3, append(make([]byte, 10), 0, 0, 0, 0, 0x10, 0, 0, 0))
+	aac := aacEntry(128000, 128000, ascLC)
+	data, sizes := m4aSamples()
+
+	// co64 chunk offsets and a udta that holds more than the metadata
+	co64 := mp4File(false, data, func(off uint32) []byte {
+		stbl := mp4Stbl(aac, 1024, sizes, 0)
+		stbl = bytes.Replace(stbl, mp4FullBox("stco", 0, 0, u32s(1, 0)), mp4FullBox("co64", 0, 0, u32s(1, 0, off)), 1)
+		stbl = append(u32s(uint32(len(stbl))), stbl[4:]...)
+		trak := mp4Trak(1, "soun", 44100, 10240, stbl)
+		udta := mp4Box("udta", mp4Box("chpl", make([]byte, 9)), mp4FullBox("meta", 0, 0, hdlrBox("mdir"),
+			mp4Box("ilst", ilstItem("©nam", 1, []byte("Old")))))
+		return mp4Box("moov", mvhdBox(1000, 232), trak, udta)
+	})
+
+	tests := []struct {
+		name     string
+		src      []byte
+		want     audio.Tags
+		pictures []audio.Picture // written; nil keeps the file's own
+		keep     []audio.Picture // expected when pictures is nil
+	}{
+		{name: "MP3", src: mp3Frames(10), want: retagTags, pictures: []audio.Picture{retagCover}},
+		{
+			name: "MP3 with ID3v2, APE and ID3v1 tags",
+			src:  bytes.Join([][]byte{oldTag, mp3Frames(10), apeFooter(), id3v1Tag("Old", "Old Artist", 1, 17)}, nil),
+			want: retagTags, pictures: []audio.Picture{retagCover},
+		},
+		{
+			name: "MP3 keeps its cover",
+			src:  append(id3v2Tag(3, 0, id3v2Frame(3, "APIC", 0, append([]byte("\x00image/png\x00\x03\x00"), oldCover.Data...))), mp3Frames(5)...),
+			want: retagTags, keep: []audio.Picture{oldCover},
+		},
+		{
+			name: "VBR MP3",
+			src:  append(xingFrame(10, 4, 576, 1000), mp3Frames(10)...),
+			want: retagTags, pictures: []audio.Picture{retagCover},
+		},
+		{
+			name: "FLAC",
+			src:  withFLACBlocks(encodeFLAC(t, 8000, 2, 4096, 16), seekTable, oldComment, flacBlock(6, flacPicture(3, "image/png", "", oldCover.Data)), flacBlock(1, make([]byte, 100))),
+			want: retagTags, pictures: []audio.Picture{retagCover},
+		},
+		{
+			name: "FLAC keeps its cover",
+			src:  withFLACBlocks(encodeFLAC(t, 8000, 1, 1000, 16), oldComment, flacBlock(6, flacPicture(3, "image/png", "", oldCover.Data))),
+			want: retagTags, keep: []audio.Picture{oldCover},
+		},
+		{
+			name: "FLAC inside ID3 tags",
+			src:  bytes.Join([][]byte{oldTag, encodeFLAC(t, 8000, 1, 1000, 16), id3v1Tag("Old", "", 0, 255)}, nil),
+			want: retagTags, pictures: []audio.Picture{retagCover},
+		},
+		{name: "MP4, moov before mdat", src: m4aFile(false, aac), want: retagTagsMP4(), pictures: []audio.Picture{retagCover}},
+		{name: "MP4, moov after mdat", src: m4aFile(true, aac), want: retagTagsMP4(), pictures: []audio.Picture{retagCover}},
+		{
+			name: "MP4 keeps its cover",
+			src:  m4aFile(false, aac, mp4Udta(ilstItem("©nam", 1, []byte("Old")), ilstItem("covr", 14, oldCover.Data))),
+			want: retagTagsMP4(), keep: []audio.Picture{oldCover},
+		},
+		{name: "MP4 with co64 and other user data", src: co64, want: retagTagsMP4(), pictures: []audio.Picture{retagCover}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := probe(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := retag(t, tt.src, tt.want, tt.pictures)
+			after, err := probe(out)
+			if err != nil {
+				t.Fatalf("retagged file: %v", err)
+			}
+
+			if got := audio.NormalizeTags(after.Format, after.Tags); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("tags read back\n%+v\nwant\n%+v\nfrom %q", got, tt.want, after.Tags)
+			}
+			wantPictures := tt.pictures
+			if wantPictures == nil {
+				wantPictures = tt.keep
+			}
+			if !reflect.DeepEqual(after.Pictures, wantPictures) {
+				t.Fatalf("pictures %+v, want %+v", after.Pictures, wantPictures)
+			}
+
+			if after.Format != before.Format || after.Frames != before.Frames || after.DataSize != before.DataSize {
+				t.Fatalf("%s with %d frames in %d bytes, want %s with %d in %d",
+					after.Format, after.Frames, after.DataSize, before.Format, before.Frames, before.DataSize)
+			}
+			audioBefore := tt.src[before.DataOffset : before.DataOffset+before.DataSize]
+			if !bytes.Equal(out[after.DataOffset:after.DataOffset+after.DataSize], audioBefore) {
+				t.Fatal("the audio changed")
+			}
+			if len(out) != int(after.DataOffset+after.DataSize) && after.Format != "mp4" {
+				t.Fatalf("%d bytes after the audio, want the old trailing tags gone", len(out)-int(after.DataOffset+after.DataSize))
+			}
+
+			switch after.Format {
+			case "flac":
+				if before.Encoder != "" && after.Encoder != before.Encoder {
+					t.Fatalf("vendor %q, want %q kept", after.Encoder, before.Encoder)
+				}
+				if err := audio.VerifyFLAC(bytes.NewReader(out[after.DataOffset:]), after); err != nil {
+					t.Fatal(err)
+				}
+			case "mp4":
+				// Chunk offsets must still find every sample
+				idx, err := audio.Packets(bytes.NewReader(out), int64(len(out)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, p := range idx.Packets {
+					if got := out[p.Offset : p.Offset+int64(p.Size)]; !bytes.Equal(got, bytes.Repeat([]byte{byte(i + 1)}, 100)) {
+						t.Fatalf("packet %d at %d does not hold sample %d", i, p.Offset, i+1)
+					}
+				}
+			}
+		})
+	}
+
+	// Retagging a retagged file changes nothing
+	once := retag(t, m4aFile(false, aac), retagTags, nil)
+	if twice := retag(t, once, retagTags, nil); !bytes.Equal(once, twice) {
+		t.Fatal("retagging the retagged MP4 changed it")
+	}
+}
+
+func TestRetagUnsupported(t *testing.T) {
+	aac := aacEntry(128000, 128000, ascLC)
+	fragmented := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("M4A "), u32s(0)),
+		mp4Box("moov", mvhdBox(1000, 0), mp4Trak(1, "soun", 44100, 0, mp4Stbl(aac, 1024, nil, 0)), mp4Box("mvex")),
+		mp4Box("mdat", make([]byte, 10)),
+	}, nil)
+	withMoof := append(append([]byte{}, fragmented...), mp4Box("moof")...)
+
+	tests := []struct {
+		name string
+		src  []byte
+		want error
+	}{
+		{"WAV", encodeWAV(t, 8000, 1, 16, pcm.WAVOptions{BitDepth: 16}), audio.ErrNotRetaggable},
+		{"AIFF", aiffFile("AIFF", aiffCommChunk(1, 0, 16, 8000, "")), audio.ErrNotRetaggable},
+		{"Ogg", vorbisFile().out, audio.ErrNotRetaggable},
+		{"fragmented MP4", fragmented, audio.ErrNotRetaggable},
+		{"MP4 with movie fragments", withMoof, audio.ErrNotRetaggable},
+		{"text", []byte("not audio at all"), audio.ErrUnknownFormat},
+		{"MP4 without moov", append(mp4Box("ftyp", []byte("M4A "), u32s(0)), mp4Box("mdat")...), audio.ErrTruncated},
+		{"ID3v2 longer than the file", id3v2Tag(4, 0, make([]byte, 100))[:50], audio.ErrTruncated},
+		{"FLAC without STREAMINFO", append([]byte("fLaC"), flacBlock(0x84, vorbisComment("v"))...), audio.ErrMalformed},
+		{"FLAC block overruns the file", append([]byte("fLaC"), flacBlock(0x80, make([]byte, 34))[:20]...), audio.ErrTruncated},
+		{"chunk offset pushed past 32 bits", mp4File(false, make([]byte, 10), func(uint32) []byte {
+			return m4aMoov(0xFFFFFFF0, aac)
+		}), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := audio.Retag(bytes.NewReader(tt.src), int64(len(tt.src)), retagTags, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Retag error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package audio
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// WAVE format tags (wFormatTag)
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatADPCM      = 0x0002
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatALaw       = 0x0006
+	wavFormatMuLaw      = 0x0007
+	wavFormatIMAADPCM   = 0x0011
+	wavFormatMPEG       = 0x0050
+	wavFormatMPEGLayer3 = 0x0055
+	wavFormatExtensible = 0xFFFE
+)
+
+// ksDataFormatTail is the part of a KSDATAFORMAT_SUBTYPE GUID that follows the format tag
+var ksDataFormatTail = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// rf64Unknown marks a 32-bit size whose real value lives in the ds64 chunk
+const rf64Unknown = 0xFFFFFFFF
+
+// bextFixedSize is the size of the bext chunk up to CodingHistory
+const bextFixedSize = 602
+
+// BroadcastExtension is the Broadcast Wave Format bext chunk (EBU Tech 3285)
+type BroadcastExtension struct {
+	Description         string `json:"description,omitempty"`
+	Originator          string `json:"originator,omitempty"`
+	OriginatorReference string `json:"originator_reference,omitempty"`
+	OriginationDate     string `json:"origination_date,omitempty"` // yyyy:mm:dd
+	OriginationTime     string `json:"origination_time,omitempty"` // hh:mm:ss
+	TimeReference       uint64 `json:"time_reference"`             // first sample, counted from midnight
+	Version             int    `json:"version"`
+	UMID                string `json:"umid,omitempty"` // hex, version 1 and later
+
+	// Loudness, version 2 and later
+	LoudnessValue        float64 `json:"loudness_value,omitempty"` // LUFS
+	LoudnessRange        float64 `json:"loudness_range,omitempty"` // LU
+	MaxTruePeakLevel     float64 `json:"max_true_peak_level,omitempty"`
+	MaxMomentaryLoudness float64 `json:"max_momentary_loudness,omitempty"`
+	MaxShortTermLoudness float64 `json:"max_short_term_loudness,omitempty"`
+
+	CodingHistory string `json:"coding_history,omitempty"`
+}
+
+func isRIFF(magic []byte) bool {
+	switch string(magic[:4]) {
+	case "RIFF", "RF64", "BW64":
+		return string(magic[8:12]) == "WAVE"
+	}
+	return false
+}
+
+// ds64 carries the 64-bit sizes of an RF64/BW64 file
+type ds64 struct {
+	riffSize    int64
+	dataSize    int64
+	sampleCount int64
+	table       map[string]int64 // sizes of other oversized chunks
+}
+
+// wavFmt is the fmt chunk
+type wavFmt struct {
+	tag           uint16 // format tag, resolved through the extensible subformat
+	channels      int
+	sampleRate    int
+	byteRate      int64
+	blockAlign    int
+	bitsPerSample int
+	validBits     int
+	channelMask   uint32
+	known         bool // false for an extensible subformat outside the KSDATAFORMAT family
+}
+
+func parseWAV(r io.ReaderAt, size int64) (*Info, error) {
+	const format = "wav"
+
+	hdr, err := readAt(r, 0, 12)
+	if err != nil {
+		return nil, truncated(format, 0, "RIFF header: %v", err)
+	}
+
+	info := &Info{Format: "wav", Tags: map[string]string{}}
+	rf64 := string(hdr[:4]) != "RIFF"
+	if rf64 {
+		info.Format = "rf64"
+	}
+
+	riffSize := int64(le.Uint32(hdr[4:8]))
+	var ds *ds64
+	if rf64 {
+		if ds, err = parseDS64(r, size); err != nil {
+			return nil, err
+		}
+		if riffSize == rf64Unknown {
+			riffSize = ds.riffSize
+		}
+	}
+
+	// The RIFF size covers everything after the first 8 bytes. A missing
+	// final pad byte is a common writer bug and harmless.
+	end := 8 + riffSize
+	if end > size {
+		if end-size > 1 {
+			return nil, truncated(format, 4, "RIFF header declares %d bytes but the file has %d", end, size)
+		}
+		end = size
+	}
+	if riffSize < 4 {
+		return nil, malformed(format, 4, "RIFF size %d is too small", riffSize)
+	}
+
+	var (
+		fmtChunk  *wavFmt
+		factCount int64 = -1
+		dataFound bool
+	)
+
+	off := int64(12)
+	for off+8 <= end {
+		ch, err := readAt(r, off, 8)
+		if err != nil {
+			return nil, truncated(format, off, "chunk header: %v", err)
+		}
+		id := string(ch[:4])
+		csize := int64(le.Uint32(ch[4:8]))
+		body := off + 8
+
+		if rf64 && csize == rf64Unknown {
+			switch id {
+			case "data":
+				csize = ds.dataSize
+			default:
+				s, ok := ds.table[id]
+				if !ok {
+					return nil, malformed(format, off, "chunk %q has an RF64 size but no ds64 table entry", id)
+				}
+				csize = s
+			}
+		}
+
+		if body+csize > end {
+			if id == "data" {
+				return nil, truncated(format, off, "data chunk declares %d bytes but only %d remain", csize, end-body)
+			}
+			return nil, truncated(format, off, "chunk %q declares %d bytes but only %d remain", id, csize, end-body)
+		}
+
+		switch id {
+		case "fmt ":
+			if fmtChunk != nil {
+				return nil, malformed(format, off, "duplicate fmt chunk")
+			}
+			if fmtChunk, err = parseWAVFmt(r, body, csize); err != nil {
+				return nil, err
+			}
+		case "fact":
+			if csize < 4 {
+				return nil, malformed(format, off, "fact chunk is %d bytes, need 4", csize)
+			}
+			b, err := readAt(r, body, 4)
+			if err != nil {
+				return nil, truncated(format, body, "fact chunk: %v", err)
+			}
+			factCount = int64(le.Uint32(b))
+		case "data":
+			if dataFound {
+				return nil, malformed(format, off, "duplicate data chunk")
+			}
+			dataFound = true
+			info.DataOffset = body
+			info.DataSize = csize
+		case "bext":
+			if info.Broadcast, err = parseBext(r, body, csize); err != nil {
+				return nil, err
+			}
+		case "LIST":
+			if err := parseRIFFList(r, body, csize, info.Tags); err != nil {
+				return nil, err
+			}
+		case "id3 ", "ID3 ":
+			if _, err := parseID3v2(r, body, body+csize, info); err != nil {
+				return nil, err
+			}
+		}
+
+		off = body + csize + csize&1
+	}
+
+	if fmtChunk == nil {
+		return nil, malformed(format, 12, "no fmt chunk")
+	}
+	if !dataFound {
+		return nil, malformed(format, 12, "no data chunk")
+	}
+
+	f := fmtChunk
+	info.Codec = wavCodec(f)
+	info.SampleRate = f.sampleRate
+	info.Channels = f.channels
+	info.ChannelMask = f.channelMask
+	info.Layout = ChannelLayout(f.channels, f.channelMask)
+
+	switch f.tag {
+	case wavFormatPCM, wavFormatIEEEFloat, wavFormatALaw, wavFormatMuLaw:
+		if !f.known {
+			break
+		}
+		if info.DataSize%int64(f.blockAlign) != 0 {
+			return nil, malformed(format, info.DataOffset-8,
+				"data size %d is not a multiple of the %d byte block", info.DataSize, f.blockAlign)
+		}
+		info.BitDepth = f.bitsPerSample
+		if f.validBits > 0 {
+			info.BitDepth = f.validBits
+		}
+		info.setFrames(info.DataSize / int64(f.blockAlign))
+		info.Bitrate = f.sampleRate * f.blockAlign * 8
+		return info, nil
+	}
+
+	// Compressed data: the frame count comes from fact (or ds64), the
+	// bitrate from the declared byte rate
+	switch {
+	case rf64 && (factCount == rf64Unknown || factCount < 0) && ds.sampleCount > 0:
+		info.setFrames(ds.sampleCount)
+	case factCount >= 0:
+		info.setFrames(factCount)
+	case f.byteRate > 0:
+		info.Duration = bytesDuration(info.DataSize, f.byteRate)
+		info.Frames = int64(math.Round(info.Duration.Seconds() * float64(f.sampleRate)))
+	}
+	if f.byteRate > 0 {
+		info.Bitrate = int(f.byteRate * 8)
+	}
+	return info, nil
+}
+
+func parseDS64(r io.ReaderAt, size int64) (*ds64, error) {
+	const format = "wav"
+
+	ch, err := readAt(r, 12, 8)
+	if err != nil {
+		return nil, truncated(format, 12, "ds64 chunk header: %v", err)
+	}
+	if string(ch[:4]) != "ds64" {
+		return nil, malformed(format, 12, "RF64 file must start with a ds64 chunk, found %q", ch[:4])
+	}
+	csize := int64(le.Uint32(ch[4:8]))
+	if csize < 28 {
+		return nil, malformed(format, 12, "ds64 chunk is %d bytes, need at least 28", csize)
+	}
+	if 20+csize > size {
+		return nil, truncated(format, 12, "ds64 chunk declares %d bytes", csize)
+	}
+
+	b, err := readAt(r, 20, int(csize))
+	if err != nil {
+		return nil, truncated(format, 20, "ds64 chunk: %v", err)
+	}
+
+	ds := &ds64{
+		riffSize:    int64(le.Uint64(b[0:8])),
+		dataSize:    int64(le.Uint64(b[8:16])),
+		sampleCount: int64(le.Uint64(b[16:24])),
+		table:       map[string]int64{},
+	}
+	if ds.riffSize < 0 || ds.dataSize < 0 || ds.sampleCount < 0 {
+		return nil, malformed(format, 20, "ds64 size out of range")
+	}
+
+	entries := int64(le.Uint32(b[24:28]))
+	if 28+entries*12 > csize {
+		return nil, malformed(format, 20, "ds64 table of %d entries does not fit in %d bytes", entries, csize)
+	}
+	for i := int64(0); i < entries; i++ {
+		e := b[28+i*12:]
+		ds.table[string(e[:4])] = int64(le.Uint64(e[4:12]))
+	}
+	return ds, nil
+}
+
+func parseWAVFmt(r io.ReaderAt, off, size int64) (*wavFmt, error) {
+	const format = "wav"
+
+	if size < 16 {
+		return nil, malformed(format, off-8, "fmt chunk is %d bytes, need at least 16", size)
+	}
+	n := size
+	if n > 40 {
+		n = 40 // nothing we read lies beyond the extensible fields
+	}
+	b, err := readAt(r, off, int(n))
+	if err != nil {
+		return nil, truncated(format, off, "fmt chunk: %v", err)
+	}
+
+	f := &wavFmt{
+		tag:           le.Uint16(b[0:2]),
+		channels:      int(le.Uint16(b[2:4])),
+		sampleRate:    int(le.Uint32(b[4:8])),
+		byteRate:      int64(le.Uint32(b[8:12])),
+		blockAlign:    int(le.Uint16(b[12:14])),
+		bitsPerSample: int(le.Uint16(b[14:16])),
+		known:         true,
+	}
+
+	if f.channels == 0 {
+		return nil, malformed(format, off+2, "zero channels")
+	}
+	if f.sampleRate == 0 {
+		return nil, malformed(format, off+4, "zero sample rate")
+	}
+	if f.blockAlign == 0 {
+		return nil, malformed(format, off+12, "zero block align")
+	}
+
+	if f.tag == wavFormatExtensible {
+		if size < 40 {
+			return nil, malformed(format, off-8, "WAVE_FORMAT_EXTENSIBLE fmt chunk is %d bytes, need 40", size)
+		}
+		if cb := le.Uint16(b[16:18]); cb < 22 {
+			return nil, malformed(format, off+16, "WAVE_FORMAT_EXTENSIBLE extension is %d bytes, need 22", cb)
+		}
+		f.validBits = int(le.Uint16(b[18:20]))
+		f.channelMask = le.Uint32(b[20:24])
+		guid := b[24:40]
+		f.tag = le.Uint16(guid[0:2])
+		f.known = string(guid[2:]) == string(ksDataFormatTail)
+
+		if f.validBits > f.bitsPerSample {
+			return nil, malformed(format, off+18, "%d valid bits exceed the %d bit container", f.validBits, f.bitsPerSample)
+		}
+		if speakers := bits.OnesCount32(f.channelMask); speakers > f.channels {
+			return nil, malformed(format, off+20, "channel mask names %d speakers for %d channels", speakers, f.channels)
+		}
+	}
+
+	if !f.known {
+		return f, nil
+	}
+
+	switch f.tag {
+	case wavFormatPCM:
+		if f.bitsPerSample < 1 || f.bitsPerSample > 64 {
+			return nil, malformed(format, off+14, "unsupported PCM sample size of %d bits", f.bitsPerSample)
+		}
+	case wavFormatIEEEFloat:
+		if f.bitsPerSample != 32 && f.bitsPerSample != 64 {
+			return nil, malformed(format, off+14, "IEEE float samples must be 32 or 64 bits, not %d", f.bitsPerSample)
+		}
+	case wavFormatALaw, wavFormatMuLaw:
+		if f.bitsPerSample != 8 {
+			return nil, malformed(format, off+14, "G.711 samples must be 8 bits, not %d", f.bitsPerSample)
+		}
+	default:
+		return f, nil
+	}
+
+	if want := f.channels * ((f.bitsPerSample + 7) / 8); f.blockAlign != want {
+		return nil, malformed(format, off+12, "block align %d does not match %d channels of %d bits (want %d)",
+			f.blockAlign, f.channels, f.bitsPerSample, want)
+	}
+	return f, nil
+}
+
+func wavCodec(f *wavFmt) string {
+	if !f.known {
+		return "unknown"
+	}
+	switch f.tag {
+	case wavFormatPCM:
+		if f.bitsPerSample <= 8 {
+			return "pcm_u8"
+		}
+		return fmt.Sprintf("pcm_s%dle", (f.bitsPerSample+7)/8*8)
+	case wavFormatIEEEFloat:
+		return fmt.Sprintf("pcm_f%dle", f.bitsPerSample)
+	case wavFormatALaw:
+		return "pcm_alaw"
+	case wavFormatMuLaw:
+		return "pcm_mulaw"
+	case wavFormatADPCM:
+		return "adpcm_ms"
+	case wavFormatIMAADPCM:
+		return "adpcm_ima_wav"
+	case wavFormatMPEG:
+		return "mp2"
+	case wavFormatMPEGLayer3:
+		return "mp3"
+	}
+	return fmt.Sprintf("0x%04x", f.tag)
+}
+
+func parseBext(r io.ReaderAt, off, size int64) (*BroadcastExtension, error) {
+	const format = "wav"
+
+	if size < bextFixedSize {
+		return nil, malformed(format, off-8, "bext chunk is %d bytes, need at least %d", size, bextFixedSize)
+	}
+	b, err := readAt(r, off, int(min(size, bextFixedSize+maxTextSize)))
+	if err != nil {
+		return nil, truncated(format, off, "bext chunk: %v", err)
+	}
+
+	bext := &BroadcastExtension{
+		Description:         cString(b[0:256]),
+		Originator:          cString(b[256:288]),
+		OriginatorReference: cString(b[288:320]),
+		OriginationDate:     cString(b[320:330]),
+		OriginationTime:     cString(b[330:338]),
+		TimeReference:       le.Uint64(b[338:346]),
+		Version:             int(le.Uint16(b[346:348])),
+		CodingHistory:       cString(b[bextFixedSize:]),
+	}
+	if bext.Version >= 1 {
+		if umid := b[348:412]; !allZero(umid) {
+			bext.UMID = hex.EncodeToString(umid)
+		}
+	}
+	if bext.Version >= 2 {
+		// Stored as hundredths, signed
+		loudness := func(i int) float64 { return float64(int16(le.Uint16(b[i:i+2]))) / 100 }
+		bext.LoudnessValue = loudness(412)
+		bext.LoudnessRange = loudness(414)
+		bext.MaxTruePeakLevel = loudness(416)
+		bext.MaxMomentaryLoudness = loudness(418)
+		bext.MaxShortTermLoudness = loudness(420)
+	}
+	return bext, nil
+}
+
+// parseRIFFList reads the text fields of a LIST/INFO chunk into tags. Other
+// list types (e.g. adtl) are skipped.
+func parseRIFFList(r io.ReaderAt, off, size int64, tags map[string]string) error {
+	const format = "wav"
+
+	if size < 4 {
+		return malformed(format, off-8, "LIST chunk is %d bytes, need at least 4", size)
+	}
+	listType, err := readAt(r, off, 4)
+	if err != nil {
+		return truncated(format, off, "LIST chunk: %v", err)
+	}
+	if string(listType) != "INFO" {
+		return nil
+	}
+
+	end := off + size
+	pos := off + 4
+	for pos+8 <= end {
+		h, err := readAt(r, pos, 8)
+		if err != nil {
+			return truncated(format, pos, "INFO field header: %v", err)
+		}
+		id := string(h[:4])
+		n := int64(le.Uint32(h[4:8]))
+		if pos+8+n > end {
+			return malformed(format, pos, "INFO field %q of %d bytes overruns its LIST chunk", id, n)
+		}
+		v, err := readAt(r, pos+8, int(min(n, maxTextSize)))
+		if err != nil {
+			return truncated(format, pos+8, "INFO field %q: %v", id, err)
+		}
+		if s := cString(v); s != "" {
+			tags[id] = s
+		}
+		pos += 8 + n + n&1
+	}
+	return nil
+}
+
+// bytesDuration is the playing time of n bytes at a constant byte rate
+func bytesDuration(n, byteRate int64) time.Duration {
+	if byteRate <= 0 {
+		return 0
+	}
+	return time.Duration(n/byteRate)*time.Second + time.Duration(n%byteRate*int64(time.Second)/byteRate)
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,281 @@
+package audio_test
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// riffChunk returns a RIFF chunk, padded to an even length
+func riffChunk(id string, body []byte) []byte {
+	b := append([]byte(id), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// riffList returns a LIST chunk of the given type
+func riffList(listType string, chunks ...[]byte) []byte {
+	body := []byte(listType)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return riffChunk("LIST", body)
+}
+
+// riffFile returns a RIFF file of the given form type holding the chunks
+func riffFile(form string, chunks ...[]byte) []byte {
+	body := []byte(form)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	return riffChunk("RIFF", body)
+}
+
+// wavFmtChunk returns a plain (non-extensible) fmt chunk of PCM samples
+func wavFmtChunk(tag, channels, sampleRate, bitsPerSample int) []byte {
+	blockAlign := channels * ((bitsPerSample + 7) / 8)
+	return wavCodedFmtChunk(tag, channels, sampleRate, sampleRate*blockAlign, blockAlign, bitsPerSample)
+}
+
+// wavCodedFmtChunk returns a fmt chunk with every field given
+func wavCodedFmtChunk(tag, channels, sampleRate, byteRate, blockAlign, bitsPerSample int) []byte {
+	le := binary.LittleEndian
+	b := le.AppendUint16(nil, uint16(tag))
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, uint32(sampleRate))
+	b = le.AppendUint32(b, uint32(byteRate))
+	b = le.AppendUint16(b, uint16(blockAlign))
+	b = le.AppendUint16(b, uint16(bitsPerSample))
+	return riffChunk("fmt ", b)
+}
+
+func TestProbeWAVEncoded(t *testing.T) {
+	tests := []struct {
+		name       string
+		channels   int
+		opts       pcm.WAVOptions
+		wantCodec  string
+		wantLayout string
+	}{
+		{"16-bit stereo", 2, pcm.WAVOptions{BitDepth: 16}, "pcm_s16le", "stereo"},
+		{"8-bit mono", 1, pcm.WAVOptions{BitDepth: 8}, "pcm_u8", "mono"},
+		{"24-bit extensible", 2, pcm.WAVOptions{BitDepth: 24}, "pcm_s24le", "stereo"},
+		{"32-bit float", 2, pcm.WAVOptions{BitDepth: 32, Float: true}, "pcm_f32le", "stereo"},
+		{"5.1", 6, pcm.WAVOptions{BitDepth: 16}, "pcm_s16le", "5.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const frames = 1000
+			data := encodeWAV(t, 48000, tt.channels, frames, tt.opts)
+			info, err := probe(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			frameBytes := int64(tt.channels * tt.opts.BitDepth / 8)
+			if info.Format != "wav" || info.Codec != tt.wantCodec || info.Layout != tt.wantLayout {
+				t.Fatalf("format %s, codec %s, layout %s, want wav, %s, %s", info.Format, info.Codec, info.Layout, tt.wantCodec, tt.wantLayout)
+			}
+			if info.SampleRate != 48000 || info.Channels != tt.channels || info.BitDepth != tt.opts.BitDepth {
+				t.Fatalf("%d Hz, %d channels, %d bits, want 48000 Hz, %d channels, %d bits",
+					info.SampleRate, info.Channels, info.BitDepth, tt.channels, tt.opts.BitDepth)
+			}
+			if info.Frames != frames || info.Duration != audio.FramesDuration(frames, 48000) {
+				t.Fatalf("%d frames lasting %v, want %d", info.Frames, info.Duration, frames)
+			}
+			if info.DataSize != frames*frameBytes || info.DataOffset+info.DataSize != int64(len(data)) {
+				t.Fatalf("audio at [%d, +%d) in %d bytes, want the %d bytes at the end", info.DataOffset, info.DataSize, len(data), frames*frameBytes)
+			}
+			if want := int(48000 * frameBytes * 8); info.Bitrate != want {
+				t.Fatalf("bitrate %d, want %d", info.Bitrate, want)
+			}
+		})
+	}
+}
+
+// bextChunk returns a version 2 bext chunk with a description and loudness
+func bextChunk(description string, loudness int16) []byte {
+	b := make([]byte, 602)
+	copy(b, description)
+	binary.LittleEndian.PutUint16(b[346:], 2)
+	binary.LittleEndian.PutUint16(b[412:], uint16(loudness))
+	return riffChunk("bext", append(b, "A=PCM,F=48000\x00"...))
+}
+
+// rf64File returns an RF64 file whose data size is only in the ds64 chunk
+func rf64File(dataSize int) []byte {
+	le := binary.LittleEndian
+	fmtChunk := wavFmtChunk(1, 2, 48000, 16)
+	ds64 := make([]byte, 28)
+	le.PutUint64(ds64[0:], uint64(4+8+28+len(fmtChunk)+8+dataSize))
+	le.PutUint64(ds64[8:], uint64(dataSize))
+	le.PutUint64(ds64[16:], uint64(dataSize/4))
+
+	b := append([]byte("RF64\xff\xff\xff\xffWAVE"), riffChunk("ds64", ds64)...)
+	b = append(b, fmtChunk...)
+	b = append(b, "data\xff\xff\xff\xff"...)
+	return append(b, make([]byte, dataSize)...)
+}
+
+func TestProbeWAVChunks(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		check func(t *testing.T, info *audio.Info)
+	}{
+		{
+			name: "INFO tags",
+			data: riffFile("WAVE",
+				wavFmtChunk(1, 1, 8000, 16),
+				riffList("INFO", riffChunk("INAM", []byte("Title\x00")), riffChunk("IART", []byte(" Artist \x00\x00"))),
+				riffChunk("data", make([]byte, 8))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Tags["INAM"] != "Title" || info.Tags["IART"] != "Artist" || info.Frames != 4 {
+					t.Fatalf("tags %v over %d frames, want INAM Title and IART Artist over 4", info.Tags, info.Frames)
+				}
+			},
+		},
+		{
+			name: "odd chunks are padded",
+			data: riffFile("WAVE",
+				riffChunk("junk", []byte{1, 2, 3}),
+				wavFmtChunk(1, 1, 8000, 8),
+				riffChunk("data", []byte{0x80, 0x80, 0x80})),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 3 || info.DataSize != 3 {
+					t.Fatalf("%d frames in %d bytes, want 3", info.Frames, info.DataSize)
+				}
+			},
+		},
+		{
+			name: "missing final pad byte",
+			data: func() []byte {
+				b := riffFile("WAVE", wavFmtChunk(1, 1, 8000, 8), riffChunk("data", []byte{0x80, 0x80, 0x80}))
+				return b[:len(b)-1]
+			}(),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Frames != 3 {
+					t.Fatalf("%d frames, want 3", info.Frames)
+				}
+			},
+		},
+		{
+			name: "compressed with fact",
+			data: riffFile("WAVE",
+				wavCodedFmtChunk(0x55, 2, 44100, 16000, 1, 0),
+				riffChunk("fact", binary.LittleEndian.AppendUint32(nil, 1152)),
+				riffChunk("data", make([]byte, 417))),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Codec != "mp3" || info.Frames != 1152 || info.BitDepth != 0 || info.Bitrate != 128000 {
+					t.Fatalf("codec %s with %d frames of %d bits at %d bps, want mp3 with 1152 and no depth at 128000",
+						info.Codec, info.Frames, info.BitDepth, info.Bitrate)
+				}
+			},
+		},
+		{
+			name: "broadcast extension",
+			data: riffFile("WAVE",
+				bextChunk("Take 1", -2300),
+				wavFmtChunk(1, 2, 48000, 16),
+				riffChunk("data", make([]byte, 4))),
+			check: func(t *testing.T, info *audio.Info) {
+				bext := info.Broadcast
+				if bext == nil || bext.Description != "Take 1" || bext.Version != 2 ||
+					bext.LoudnessValue != -23 || bext.CodingHistory != "A=PCM,F=48000" {
+					t.Fatalf("bext %+v, want take 1 at -23 LUFS", bext)
+				}
+			},
+		},
+		{
+			name: "rf64",
+			data: rf64File(4000),
+			check: func(t *testing.T, info *audio.Info) {
+				if info.Format != "rf64" || info.DataSize != 4000 || info.Frames != 1000 || info.Duration != time.Second/48 {
+					t.Fatalf("%s with %d bytes and %d frames lasting %v, want rf64 with 4000 bytes and 1000 frames",
+						info.Format, info.DataSize, info.Frames, info.Duration)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := probe(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, info)
+		})
+	}
+}
+
+func TestProbeWAVInvalid(t *testing.T) {
+	data := riffChunk("data", make([]byte, 4))
+	extensible := func(validBits int, mask uint32) []byte {
+		le := binary.LittleEndian
+		b := le.AppendUint16(nil, 0xFFFE)
+		b = le.AppendUint16(b, 2)
+		b = le.AppendUint32(b, 48000)
+		b = le.AppendUint32(b, 48000*6)
+		b = le.AppendUint16(b, 6)
+		b = le.AppendUint16(b, 24)
+		b = le.AppendUint16(b, 22)
+		b = le.AppendUint16(b, uint16(validBits))
+		b = le.AppendUint32(b, mask)
+		b = le.AppendUint16(b, 1)
+		b = append(b, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71)
+		return riffChunk("fmt ", b)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"header only", []byte("RIFF\x64\x00\x00\x00WAVE"), audio.ErrTruncated},
+		{"data overruns the file", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 16), []byte("data\x64\x00\x00\x00")), audio.ErrTruncated},
+		{"RIFF size too small", []byte("RIFF\x02\x00\x00\x00WAVE"), audio.ErrMalformed},
+		{"no fmt", riffFile("WAVE", data), audio.ErrMalformed},
+		{"no data", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 16)), audio.ErrMalformed},
+		{"duplicate fmt", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 16), wavFmtChunk(1, 1, 8000, 16), data), audio.ErrMalformed},
+		{"duplicate data", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 16), data, data), audio.ErrMalformed},
+		{"short fmt", riffFile("WAVE", riffChunk("fmt ", make([]byte, 14)), data), audio.ErrMalformed},
+		{"zero channels", riffFile("WAVE", wavFmtChunk(1, 0, 8000, 16), data), audio.ErrMalformed},
+		{"zero sample rate", riffFile("WAVE", wavFmtChunk(1, 1, 0, 16), data), audio.ErrMalformed},
+		{"24-bit float", riffFile("WAVE", wavFmtChunk(3, 1, 8000, 24), data), audio.ErrMalformed},
+		{"16-bit A-law", riffFile("WAVE", wavFmtChunk(6, 1, 8000, 16), data), audio.ErrMalformed},
+		{"data not whole frames", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 24), data), audio.ErrMalformed},
+		{"valid bits exceed the container", riffFile("WAVE", extensible(32, 3), data), audio.ErrMalformed},
+		{"mask names more speakers than channels", riffFile("WAVE", extensible(24, 7), data), audio.ErrMalformed},
+		{"short fact", riffFile("WAVE", wavCodedFmtChunk(0x55, 2, 44100, 16000, 1, 0), riffChunk("fact", []byte{1, 2}), data), audio.ErrMalformed},
+		{"short bext", riffFile("WAVE", riffChunk("bext", make([]byte, 100)), wavFmtChunk(1, 1, 8000, 16), data), audio.ErrMalformed},
+		{"INFO field overruns its list", riffFile("WAVE", wavFmtChunk(1, 1, 8000, 16), riffList("INFO", []byte("INAM\x20\x00\x00\x00ab")), data), audio.ErrMalformed},
+		{"rf64 without ds64", append([]byte("RF64\x24\x00\x00\x00WAVE"), wavFmtChunk(1, 1, 8000, 16)...), audio.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := probe(tt.data)
+			checkParseError(t, err, "wav", tt.want)
+		})
+	}
+}
+
+func TestProbeWAVTruncated(t *testing.T) {
+	files := map[string][]byte{
+		"pcm":        encodeWAV(t, 8000, 2, 64, pcm.WAVOptions{BitDepth: 16}),
+		"extensible": encodeWAV(t, 8000, 2, 64, pcm.WAVOptions{BitDepth: 24}),
+		"rf64":       rf64File(256),
+	}
+	for name, data := range files {
+		// Past the magic, every cut is reported as truncation
+		for n := 12; n < len(data); n++ {
+			if _, err := probe(data[:n]); !errors.Is(err, audio.ErrTruncated) {
+				t.Fatalf("%s cut to %d of %d bytes: error %v, want ErrTruncated", name, n, len(data), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound     = errors.New("blob not found")
+	ErrInvalidKey   = errors.New("invalid blob key")
+	ErrSizeMismatch = errors.New("blob body does not match its size")
+)
+
+// Info describes a stored object
+type Info struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Store is a minimal object store. Implementations stream data in both
+// directions and never hold a whole object in memory.
+type Store interface {
+	// Put stores the content of r under key. size is the exact length when
+	// known or -1, in which case the store reads until EOF. If r returns an
+	// error, or a body of another length than size, nothing is stored under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the whole object
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens length bytes starting at offset (length < 0 reads to the end)
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*Info, error)
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+// cleanKey validates a key: slash separated, relative, without "." or ".." segments
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	if path.Clean(key) != key {
+		return "", ErrInvalidKey
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "." || seg == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return key, nil
+}
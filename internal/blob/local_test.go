@@ -0,0 +1,122 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func newTestLocalStore(t *testing.T, keys ...string) *LocalStore {
+	t.Helper()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := s.Put(context.Background(), key, strings.NewReader(key), int64(len(key)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestLocalPutSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		size    int64
+		wantErr error
+	}{
+		{"exact", "audio", 5, nil},
+		{"unknown", "audio", -1, nil},
+		{"empty", "", 0, nil},
+		{"short", "aud", 5, ErrSizeMismatch},
+		{"long", "audio!", 5, ErrSizeMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestLocalStore(t)
+			err := s.Put(context.Background(), "a/b", strings.NewReader(tt.body), tt.size, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Put = %v, want %v", err, tt.wantErr)
+			}
+
+			_, statErr := s.Stat(context.Background(), "a/b")
+			if stored := statErr == nil; stored != (tt.wantErr == nil) {
+				t.Fatalf("stored: %v after Put = %v", statErr, err)
+			}
+			// No temporary file is left behind either way
+			entries, _ := os.ReadDir(filepath.Join(s.root, "a"))
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".upload-") {
+					t.Fatalf("temporary file %s left behind", e.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("read error", func(t *testing.T) {
+		s := newTestLocalStore(t)
+		boom := errors.New("boom")
+		if err := s.Put(context.Background(), "a/b", iotest.ErrReader(boom), -1, ""); !errors.Is(err, boom) {
+			t.Fatalf("Put = %v, want boom", err)
+		}
+		if _, err := s.Stat(context.Background(), "a/b"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Stat after a failed Put: %v", err)
+		}
+	})
+}
+
+func TestLocalList(t *testing.T) {
+	s := newTestLocalStore(t,
+		"uploads/abc/chunk-0",
+		"uploads/abc/chunk-10",
+		"uploads/abcd/chunk-0",
+		"tracks/1/original",
+		"tracks/1/renditions/mp3_128",
+		"tracks/12/original",
+		"top",
+	)
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"uploads/abc/", []string{"uploads/abc/chunk-0", "uploads/abc/chunk-10"}},
+		{"uploads/abc", []string{"uploads/abc/chunk-0", "uploads/abc/chunk-10", "uploads/abcd/chunk-0"}},
+		{"uploads/abc/chunk-1", []string{"uploads/abc/chunk-10"}},
+		{"tracks/1/", []string{"tracks/1/original", "tracks/1/renditions/mp3_128"}},
+		{"tracks/1", []string{"tracks/1/original", "tracks/1/renditions/mp3_128", "tracks/12/original"}},
+		{"t", []string{"top", "tracks/1/original", "tracks/1/renditions/mp3_128", "tracks/12/original"}},
+		{"", []string{"top", "tracks/1/original", "tracks/1/renditions/mp3_128", "tracks/12/original", "uploads/abc/chunk-0", "uploads/abc/chunk-10", "uploads/abcd/chunk-0"}},
+		{"uploads/missing/", nil},
+		{"missing/deeper/", nil},
+		{"tracks/1/original/", nil},
+		{"../", nil},
+		{"../../etc/", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			infos, err := s.List(context.Background(), tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Key)
+				if info.Size != int64(len(info.Key)) {
+					t.Fatalf("%s has size %d", info.Key, info.Size)
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package blob
+
+import (
+	"context"
+	"io"
+)
+
+// readAtBlockSize is how much ReaderAt fetches per range request
+const readAtBlockSize = 64 << 10
+
+// ReaderAt gives random access to a stored object through range requests.
+// The most recently fetched block is kept, so header parsers doing many small
+// sequential reads cost one request per block rather than one per read.
+// It is not safe for concurrent use.
+type ReaderAt struct {
+	ctx   context.Context
+	store Store
+	key   string
+	size  int64
+
+	blockOff int64
+	block    []byte
+}
+
+// NewReaderAt returns a ReaderAt over the size bytes stored under key
+func NewReaderAt(ctx context.Context, store Store, key string, size int64) *ReaderAt {
+	return &ReaderAt{ctx: ctx, store: store, key: key, size: size, blockOff: -1}
+}
+
+// Size returns the length of the object
+func (ra *ReaderAt) Size() int64 {
+	return ra.size
+}
+
+func (ra *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if off >= ra.size {
+		return 0, io.EOF
+	}
+
+	// Large reads bypass the block cache
+	if len(p) >= readAtBlockSize {
+		return ra.readRange(p, off)
+	}
+
+	n := 0
+	for n < len(p) && off < ra.size {
+		start := off - off%readAtBlockSize
+		if start != ra.blockOff {
+			if err := ra.fill(start); err != nil {
+				return n, err
+			}
+		}
+		c := copy(p[n:], ra.block[off-start:])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (ra *ReaderAt) fill(start int64) error {
+	length := int64(readAtBlockSize)
+	if start+length > ra.size {
+		length = ra.size - start
+	}
+
+	if cap(ra.block) < readAtBlockSize {
+		ra.block = make([]byte, readAtBlockSize)
+	}
+	ra.block = ra.block[:length]
+	ra.blockOff = -1
+
+	if _, err := ra.readRange(ra.block, start); err != nil {
+		return err
+	}
+	ra.blockOff = start
+	return nil
+}
+
+func (ra *ReaderAt) readRange(p []byte, off int64) (int, error) {
+	length := int64(len(p))
+	if off+length > ra.size {
+		length = ra.size - off
+	}
+
+	rc, err := ra.store.GetRange(ra.ctx, ra.key, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	n, err := io.ReadFull(rc, p[:length])
+	if err == io.ErrUnexpectedEOF {
+		// The object is shorter than we were told
+		return n, io.EOF
+	}
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
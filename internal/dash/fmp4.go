@@ -0,0 +1,188 @@
+package dash
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/hls"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const trackID = 1
+
+// identity is the unity transformation matrix of mvhd and tkhd
+var identity = []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+
+// box serialises an ISO BMFF box
+func box(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := make([]byte, 0, n)
+	b = binary.BigEndian.AppendUint32(b, uint32(n))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// fullBox serialises a box with a version and flags
+func fullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	vf := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0xFFFFFF)
+	return box(typ, append([][]byte{vf}, payload...)...)
+}
+
+// fields packs big-endian integers: uint16, uint32, uint64, []uint32 or []byte
+func fields(vals ...any) []byte {
+	var b []byte
+	for _, v := range vals {
+		switch v := v.(type) {
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case []uint32:
+			for _, x := range v {
+				b = binary.BigEndian.AppendUint32(b, x)
+			}
+		case []byte:
+			b = append(b, v...)
+		default:
+			panic(fmt.Sprintf("dash: cannot pack %T", v))
+		}
+	}
+	return b
+}
+
+// descriptor serialises an MPEG-4 descriptor with a four byte length
+func descriptor(tag byte, payload ...[]byte) []byte {
+	n := 0
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := []byte{tag, byte(n>>21) | 0x80, byte(n>>14) | 0x80, byte(n>>7) | 0x80, byte(n & 0x7F)}
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// InitSegment returns the initialization segment of the plan's stream: a
+// moov with one audio track and no samples, and an mvex announcing
+// fragments
+func InitSegment(plan *hls.Plan) []byte {
+	timescale := uint32(plan.Timescale)
+	rate := plan.SampleRate
+	if rate == 0 {
+		rate = plan.Timescale
+	}
+	peak, average := plan.Bandwidth()
+
+	// Elementary stream descriptor: AAC with its AudioSpecificConfig, or MP3
+	oti, dsi := byte(0x40), []byte(nil)
+	if plan.Codec == "mp3" {
+		oti = 0x6B
+	} else {
+		dsi = descriptor(0x05, plan.Config)
+	}
+	decoderConfig := descriptor(0x04,
+		[]byte{oti, 0x15, 0, 0, 0}, // audio stream, buffer size unknown
+		fields(uint32(peak), uint32(average)),
+		dsi,
+	)
+	esds := fullBox("esds", 0, 0, descriptor(0x03,
+		[]byte{0, 0, 0}, // ES_ID, flags
+		decoderConfig,
+		descriptor(0x06, []byte{0x02}),
+	))
+
+	sampleRate := uint32(0) // 16.16, which cannot hold rates of 64 kHz and more
+	if rate < 1<<16 {
+		sampleRate = uint32(rate) << 16
+	}
+	mp4a := box("mp4a",
+		make([]byte, 6), fields(uint16(1)), // data reference index
+		make([]byte, 8),
+		fields(uint16(plan.Channels), uint16(16), uint16(0), uint16(0), sampleRate),
+		esds,
+	)
+
+	empty := fields(uint32(0))
+	stbl := box("stbl",
+		fullBox("stsd", 0, 0, fields(uint32(1)), mp4a),
+		fullBox("stts", 0, 0, empty),
+		fullBox("stsc", 0, 0, empty),
+		fullBox("stsz", 0, 0, empty, empty),
+		fullBox("stco", 0, 0, empty),
+	)
+	minf := box("minf",
+		fullBox("smhd", 0, 0, fields(uint32(0))),
+		box("dinf", fullBox("dref", 0, 0, fields(uint32(1)), fullBox("url ", 0, 1))),
+		stbl,
+	)
+	mdia := box("mdia",
+		fullBox("mdhd", 0, 0, fields(uint32(0), uint32(0), timescale, uint32(0), uint16(0x55C4), uint16(0))), // "und"
+		fullBox("hdlr", 0, 0, fields(uint32(0)), []byte("soun"), make([]byte, 12), []byte("SoundHandler\x00")),
+		minf,
+	)
+	trak := box("trak",
+		fullBox("tkhd", 0, 3, fields(uint32(0), uint32(0), uint32(trackID), uint32(0), uint32(0)),
+			make([]byte, 8), fields(uint16(0), uint16(0), uint16(0x0100), uint16(0), identity, uint32(0), uint32(0))),
+		mdia,
+	)
+	moov := box("moov",
+		fullBox("mvhd", 0, 0, fields(uint32(0), uint32(0), timescale, uint32(0), uint32(0x10000), uint16(0x0100)),
+			make([]byte, 10), fields(identity), make([]byte, 24), fields(uint32(trackID+1))),
+		trak,
+		box("mvex", fullBox("trex", 0, 0, fields(uint32(trackID), uint32(1), uint32(0), uint32(0), uint32(0)))),
+	)
+	ftyp := box("ftyp", []byte("iso6"), fields(uint32(0)), []byte("iso6cmfcmp41dash"))
+	return append(ftyp, moov...)
+}
+
+// moof builds the fragment header of a segment, its samples' data starting
+// dataOffset bytes after the moof
+func moof(seq int, seg hls.Segment, packets []audio.Packet, dataOffset int32) []byte {
+	samples := make([]byte, 0, len(packets)*8)
+	for _, p := range packets {
+		samples = binary.BigEndian.AppendUint32(samples, uint32(p.Duration))
+		samples = binary.BigEndian.AppendUint32(samples, uint32(p.Size))
+	}
+	return box("moof",
+		fullBox("mfhd", 0, 0, fields(uint32(seq+1))),
+		box("traf",
+			fullBox("tfhd", 0, 0x020000, fields(uint32(trackID))), // default base is moof
+			fullBox("tfdt", 1, 0, fields(uint64(seg.Start))),
+			fullBox("trun", 0, 0x000301, // data offset, sample durations and sizes
+				fields(uint32(len(packets)), uint32(dataOffset)), samples),
+		),
+	)
+}
+
+// WriteMediaSegment writes segment seq of a plan as a movie fragment,
+// its packets read from r
+func WriteMediaSegment(w io.Writer, seq int, seg hls.Segment, packets []audio.Packet, r io.ReaderAt) error {
+	if len(packets) != seg.Count {
+		return fmt.Errorf("dash: segment has %d packets, got %d", seg.Count, len(packets))
+	}
+	if seg.Size > 1<<32-9 {
+		return fmt.Errorf("dash: segment of %d bytes is too large", seg.Size)
+	}
+
+	// The moof's size doesn't depend on the offset it carries
+	n := len(moof(seq, seg, packets, 0))
+	header := box("styp", []byte("msdh"), fields(uint32(0)), []byte("msdhmsix"))
+	header = append(header, moof(seq, seg, packets, int32(n+8))...)
+	header = fields(header, uint32(8+seg.Size), []byte("mdat"))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return hls.ReadPackets(r, packets, func(data []byte) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,175 @@
+// Package dash packages MP3 and AAC audio as fragmented MP4 and describes
+// it in static MPEG-DASH manifests. Segments follow the same packet-aligned
+// plan as the HLS packaging.
+package dash
+
+import (
+	"audio-go/internal/hls"
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of manifests
+const ContentType = "application/dash+xml"
+
+// SegmentContentType is the content type of initialization and media segments
+const SegmentContentType = "audio/mp4"
+
+// Program is the catalog information carried in the manifest's
+// ProgramInformation
+type Program struct {
+	Title  string
+	Artist string
+	Album  string
+}
+
+// ManifestOptions names the segments in a manifest. Both URLs are relative
+// to the manifest; Media contains $Number$.
+type ManifestOptions struct {
+	Initialization string
+	Media          string
+}
+
+type mpd struct {
+	XMLName                   xml.Name            `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles                  string              `xml:"profiles,attr"`
+	Type                      string              `xml:"type,attr"`
+	MediaPresentationDuration string              `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string              `xml:"minBufferTime,attr"`
+	ProgramInformation        *programInformation `xml:"ProgramInformation,omitempty"`
+	Period                    period              `xml:"Period"`
+}
+
+type programInformation struct {
+	Title  string `xml:"Title,omitempty"`
+	Source string `xml:"Source,omitempty"`
+}
+
+type period struct {
+	ID            string        `xml:"id,attr"`
+	Start         string        `xml:"start,attr"`
+	AdaptationSet adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               string         `xml:"id,attr"`
+	ContentType      string         `xml:"contentType,attr"`
+	MimeType         string         `xml:"mimeType,attr"`
+	SegmentAlignment bool           `xml:"segmentAlignment,attr"`
+	Label            string         `xml:"Label,omitempty"`
+	Representation   representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID                string          `xml:"id,attr"`
+	Codecs            string          `xml:"codecs,attr"`
+	Bandwidth         int             `xml:"bandwidth,attr"`
+	AudioSamplingRate int             `xml:"audioSamplingRate,attr"`
+	ChannelConfig     descriptorValue `xml:"AudioChannelConfiguration"`
+	SegmentTemplate   segmentTemplate `xml:"SegmentTemplate"`
+}
+
+type descriptorValue struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type segmentTemplate struct {
+	Timescale      int         `xml:"timescale,attr"`
+	Initialization string      `xml:"initialization,attr"`
+	Media          string      `xml:"media,attr"`
+	StartNumber    int         `xml:"startNumber,attr"`
+	Timeline       []timelineS `xml:"SegmentTimeline>S"`
+}
+
+type timelineS struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+// Codecs is the RFC 6381 codec string of the plan's stream in MP4
+func Codecs(plan *hls.Plan) string {
+	if plan.Codec == "mp3" {
+		return "mp4a.6B"
+	}
+	return plan.Codecs()
+}
+
+// Manifest renders a static, live-profile MPD for the plan: one audio
+// representation whose segments are listed in a SegmentTemplate with a
+// SegmentTimeline, numbered from 0 as in the plan
+func Manifest(plan *hls.Plan, program Program, opts ManifestOptions) ([]byte, error) {
+	rate := plan.SampleRate
+	if rate == 0 {
+		rate = plan.Timescale
+	}
+	peak, _ := plan.Bandwidth()
+
+	var timeline []timelineS
+	for i, s := range plan.Segments {
+		if n := len(timeline); n > 0 && timeline[n-1].D == s.Duration {
+			timeline[n-1].R++
+			continue
+		}
+		e := timelineS{D: s.Duration}
+		if i == 0 {
+			start := s.Start
+			e.T = &start
+		}
+		timeline = append(timeline, e)
+	}
+
+	m := mpd{
+		Profiles:                  "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                      "static",
+		MediaPresentationDuration: isoDuration(plan.Seconds(plan.Duration())),
+		MinBufferTime:             isoDuration(float64(plan.TargetDuration())),
+		Period: period{
+			ID:    "0",
+			Start: "PT0S",
+			AdaptationSet: adaptationSet{
+				ID:               "0",
+				ContentType:      "audio",
+				MimeType:         SegmentContentType,
+				SegmentAlignment: true,
+				Representation: representation{
+					ID:                "audio",
+					Codecs:            Codecs(plan),
+					Bandwidth:         peak,
+					AudioSamplingRate: rate,
+					ChannelConfig: descriptorValue{
+						SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+						Value:       strconv.Itoa(plan.Channels),
+					},
+					SegmentTemplate: segmentTemplate{
+						Timescale:      plan.Timescale,
+						Initialization: opts.Initialization,
+						Media:          opts.Media,
+						Timeline:       timeline,
+					},
+				},
+			},
+		},
+	}
+	if program != (Program{}) {
+		// Source is the work the program comes from: here its artist and album
+		source := program.Artist
+		if program.Album != "" {
+			source = strings.TrimPrefix(source+" - "+program.Album, " - ")
+		}
+		m.ProgramInformation = &programInformation{Title: program.Title, Source: source}
+	}
+
+	body, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// isoDuration formats seconds as an xs:duration
+func isoDuration(seconds float64) string {
+	return "PT" + strconv.FormatFloat(seconds, 'f', 3, 64) + "S"
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaLagQuery reports how far (in seconds) a streaming replica is behind
+// its primary, and whether its WAL receiver is still streaming. An idle
+// replica that has replayed everything it received is reported as zero lag
+// rather than "time since last transaction", which only holds while it is
+// still connected upstream: a replica whose receiver is gone has replayed
+// everything too, but falls further behind with every write. Roles without
+// pg_read_all_stats see the receiver's row with a NULL status, so a row
+// alone counts as streaming for them.
+const replicaLagQuery = `
+	SELECT
+		CASE
+			WHEN NOT pg_is_in_recovery() THEN 0
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END,
+		NOT pg_is_in_recovery() OR EXISTS (
+			SELECT 1 FROM pg_stat_wal_receiver WHERE COALESCE(status, 'streaming') = 'streaming'
+		)`
+
+// ReplicaConfig describes the read replicas attached to a Cluster
+type ReplicaConfig struct {
+	Addrs         []string // DSNs of the read replicas
+	MaxOpenConns  int
+	MaxIdleConns  int
+	MaxIdleTime   string // e.g. "15m"
+	MaxLag        string // replicas lagging more than this are skipped, e.g. "10s"
+	CheckInterval string // how often replicas are health checked, e.g. "5s"
+}
+
+// Cluster routes queries between a primary database and its read replicas.
+// Writes always go to the primary, reads are spread round-robin over the
+// replicas that are healthy and within the configured lag.
+type Cluster struct {
+	primary  *sql.DB
+	replicas []*replica
+	maxLag   time.Duration
+	interval time.Duration
+	next     atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// replica is a single read replica together with its last observed state
+type replica struct {
+	addr    string
+	db      *sql.DB
+	healthy atomic.Bool
+	lag     atomic.Int64 // nanoseconds
+}
+
+// ReplicaStatus is a snapshot of a replica's health, used for diagnostics
+type ReplicaStatus struct {
+	Addr    string        `json:"-"`
+	Healthy bool          `json:"healthy"`
+	Lag     time.Duration `json:"lag"`
+}
+
+// NewCluster wraps an already connected primary and opens the configured
+// replicas. Replicas that are unreachable at startup are not fatal: they
+// are marked unhealthy and picked up again by the background health check.
+func NewCluster(primary *sql.DB, cfg ReplicaConfig) (*Cluster, error) {
+	maxLag, err := parseDurationOr(cfg.MaxLag, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := parseDurationOr(cfg.CheckInterval, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	idleTime, err := parseDurationOr(cfg.MaxIdleTime, 15*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		primary:  primary,
+		maxLag:   maxLag,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	for _, addr := range cfg.Addrs {
+		if addr == "" {
+			continue
+		}
+		rdb, err := sql.Open("postgres", addr)
+		if err != nil {
+			c.closeReplicas()
+			return nil, err
+		}
+		rdb.SetMaxOpenConns(cfg.MaxOpenConns)
+		rdb.SetMaxIdleConns(cfg.MaxIdleConns)
+		rdb.SetConnMaxIdleTime(idleTime)
+
+		c.replicas = append(c.replicas, &replica{addr: addr, db: rdb})
+	}
+
+	// Run a first check synchronously so routing is correct from the first request
+	c.checkReplicas()
+
+	if len(c.replicas) > 0 {
+		c.wg.Add(1)
+		go c.healthLoop()
+	}
+
+	return c, nil
+}
+
+// Primary returns the primary database handle
+func (c *Cluster) Primary() *sql.DB {
+	return c.primary
+}
+
+// Writer returns the primary for a mutation and records the write on the
+// request session (if any), so later reads in the same request see it.
+func (c *Cluster) Writer(ctx context.Context) *sql.DB {
+	markWritten(ctx)
+	return c.primary
+}
+
+// Reader returns a handle for read-only queries. It falls back to the primary
+// when the context asks for it, when the request already wrote something, or
+// when no replica is currently usable.
+func (c *Cluster) Reader(ctx context.Context) *sql.DB {
+	if len(c.replicas) == 0 || requiresPrimary(ctx) {
+		return c.primary
+	}
+
+	n := uint64(len(c.replicas))
+	start := c.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if r.usable(c.maxLag) {
+			return r.db
+		}
+	}
+
+	return c.primary
+}
+
+// Replicas returns the current status of each replica
+func (c *Cluster) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(c.replicas))
+	for _, r := range c.replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Addr:    r.addr,
+			Healthy: r.healthy.Load(),
+			Lag:     time.Duration(r.lag.Load()),
+		})
+	}
+	return statuses
+}
+
+// Close stops the health checker and closes every connection pool
+func (c *Cluster) Close() error {
+	close(c.stop)
+	c.wg.Wait()
+
+	err := c.closeReplicas()
+	if perr := c.primary.Close(); perr != nil {
+		err = errors.Join(err, perr)
+	}
+	return err
+}
+
+func (c *Cluster) closeReplicas() error {
+	var err error
+	for _, r := range c.replicas {
+		if cerr := r.db.Close(); cerr != nil {
+			err = errors.Join(err, cerr)
+		}
+	}
+	return err
+}
+
+// healthLoop periodically refreshes replica health until the cluster is closed
+func (c *Cluster) healthLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.checkReplicas()
+		}
+	}
+}
+
+// checkReplicas pings every replica and measures its replication lag
+func (c *Cluster) checkReplicas() {
+	var wg sync.WaitGroup
+	for _, r := range c.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			r.check(c.interval)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (r *replica) check(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		seconds   float64
+		streaming bool
+	)
+	if err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&seconds, &streaming); err != nil {
+		r.healthy.Store(false)
+		return
+	}
+
+	// Without a WAL receiver the lag above can't be trusted to grow
+	r.lag.Store(int64(seconds * float64(time.Second)))
+	r.healthy.Store(streaming)
+}
+
+func (r *replica) usable(maxLag time.Duration) bool {
+	return r.healthy.Load() && time.Duration(r.lag.Load()) <= maxLag
+}
+
+func parseDurationOr(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type contextKey string
+
+const (
+	primaryContextKey contextKey = "db.primary"
+	sessionContextKey contextKey = "db.session"
+)
+
+// session tracks whether the current request has written to the primary
+type session struct {
+	written atomic.Bool
+}
+
+// WithPrimary forces every read made with the returned context to the primary
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryContextKey, true)
+}
+
+// WithSession starts a read-your-writes session. Once a mutation has gone
+// through Cluster.Writer with this context, subsequent reads with the same
+// context are served by the primary instead of a possibly lagging replica.
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionContextKey, &session{})
+}
+
+func markWritten(ctx context.Context) {
+	if s, ok := ctx.Value(sessionContextKey).(*session); ok {
+		s.written.Store(true)
+	}
+}
+
+func requiresPrimary(ctx context.Context) bool {
+	if forced, ok := ctx.Value(primaryContextKey).(bool); ok && forced {
+		return true
+	}
+	if s, ok := ctx.Value(sessionContextKey).(*session); ok {
+		return s.written.Load()
+	}
+	return false
+}
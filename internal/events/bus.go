@@ -0,0 +1,81 @@
+package events
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// Handler consumes an event. Returning an error makes the relay retry the
+// event later, so handlers must be idempotent (see Idempotent).
+type Handler func(ctx context.Context, e Event) error
+
+// Bus is an in-process sink that fans events out to subscribers
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[string][]Handler // by event type, "*" receives everything
+}
+
+// NewBus creates an empty in-process bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[string][]Handler)}
+}
+
+// Subscribe registers h for the given event type ("*" for all types)
+func (b *Bus) Subscribe(eventType string, h Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[eventType] = append(b.subs[eventType], h)
+}
+
+func (b *Bus) Name() string { return "bus" }
+
+// Publish delivers e to every matching subscriber, returning their joined errors
+func (b *Bus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.subs[e.Type]...), b.subs["*"]...)
+	b.mu.RUnlock()
+
+	var err error
+	for _, h := range handlers {
+		if herr := h(ctx, e); herr != nil {
+			err = errors.Join(err, herr)
+		}
+	}
+	return err
+}
+
+// Idempotent wraps h so redelivered events (same ID) are skipped. It remembers
+// the last size successfully handled ids, which covers relay retries; durable
+// consumers should still persist the ids they have processed.
+func Idempotent(size int, h Handler) Handler {
+	var (
+		mu    sync.Mutex
+		order = list.New()
+		seen  = make(map[string]*list.Element)
+	)
+
+	return func(ctx context.Context, e Event) error {
+		mu.Lock()
+		if _, ok := seen[e.ID]; ok {
+			mu.Unlock()
+			return nil
+		}
+		mu.Unlock()
+
+		if err := h(ctx, e); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		seen[e.ID] = order.PushBack(e.ID)
+		if order.Len() > size {
+			oldest := order.Front()
+			order.Remove(oldest)
+			delete(seen, oldest.Value.(string))
+		}
+		return nil
+	}
+}
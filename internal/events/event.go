@@ -0,0 +1,64 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Domain event types
+const (
+	UserSignedUp = "user.signed_up"
+	UserUpdated  = "user.updated"
+	TrackCreated = "track.created"
+	TrackUpdated = "track.updated"
+	TrackDeleted = "track.deleted"
+)
+
+// Event is a domain event. Events are written to the outbox in the same
+// transaction as the change they describe and delivered at least once, so
+// consumers must use ID as an idempotency key.
+type Event struct {
+	ID            string          `json:"id"`             // unique id, doubles as idempotency key
+	Type          string          `json:"type"`           // e.g. "user.signed_up"
+	AggregateType string          `json:"aggregate_type"` // e.g. "user"
+	AggregateID   string          `json:"aggregate_id"`   // id of the changed entity
+	Payload       json.RawMessage `json:"payload"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// New creates an event with a fresh id, marshalling payload to JSON
+func New(eventType, aggregateType string, aggregateID any, payload any) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:            newID(),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   fmt.Sprint(aggregateID),
+		Payload:       data,
+		OccurredAt:    time.Now().UTC(),
+	}, nil
+}
+
+// Sink is a destination events are published to
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, e Event) error
+}
+
+// newID returns a random RFC 4122 version 4 UUID
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
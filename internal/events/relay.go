@@ -0,0 +1,141 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Record is an event as stored in the outbox
+type Record struct {
+	Event
+	OutboxID int64
+	Attempts int
+}
+
+// Outbox is the persistent queue of events waiting to be published
+type Outbox interface {
+	// Claim leases up to limit due events so concurrent relays don't pick them
+	// up; a lease that isn't resolved in time makes the event due again.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Record, error)
+	MarkPublished(ctx context.Context, outboxID int64) error
+	// MarkFailed records a failed delivery. A dead event is never claimed
+	// again; otherwise it is due at retryAt.
+	MarkFailed(ctx context.Context, outboxID int64, cause error, retryAt time.Time, dead bool) error
+}
+
+// RelayConfig tunes the relay loop
+type RelayConfig struct {
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int // deliveries tried before an event is dead-lettered
+}
+
+// Relay moves events from the outbox to the sinks. Delivery is at least once:
+// an event is marked published only after every sink accepted it, and a
+// failure retries it on all sinks (they deduplicate by event id). An event
+// still failing after MaxAttempts deliveries is dead-lettered so it can't
+// hold up or flood the sinks.
+type Relay struct {
+	outbox Outbox
+	sinks  []Sink
+	cfg    RelayConfig
+	logger *zap.SugaredLogger
+}
+
+// NewRelay creates a relay, filling in defaults for zero config values
+func NewRelay(outbox Outbox, sinks []Sink, cfg RelayConfig, logger *zap.SugaredLogger) *Relay {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 10 * time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	return &Relay{outbox: outbox, sinks: sinks, cfg: cfg, logger: logger}
+}
+
+// Run relays events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while full batches come back, then wait for the next tick
+		n, err := r.RelayOnce(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			r.logger.Errorw("outbox relay failed", "error", err)
+		}
+		if n == r.cfg.BatchSize && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayOnce publishes one batch and returns the number of events claimed
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	records, err := r.outbox.Claim(ctx, r.cfg.BatchSize, r.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rec := range records {
+		if err := r.deliver(ctx, rec.Event); err != nil {
+			attempts := rec.Attempts + 1
+			retryAt := time.Now().Add(r.backoff(rec.Attempts))
+			dead := attempts >= r.cfg.MaxAttempts
+			if dead {
+				r.logger.Errorw("event dead-lettered", "event_id", rec.ID, "type", rec.Type, "attempts", attempts, "error", err)
+			} else {
+				r.logger.Warnw("event delivery failed", "event_id", rec.ID, "type", rec.Type, "attempts", attempts, "retry_at", retryAt, "error", err)
+			}
+			if merr := r.outbox.MarkFailed(ctx, rec.OutboxID, err, retryAt, dead); merr != nil {
+				return len(records), merr
+			}
+			continue
+		}
+
+		if err := r.outbox.MarkPublished(ctx, rec.OutboxID); err != nil {
+			return len(records), err
+		}
+	}
+
+	return len(records), nil
+}
+
+func (r *Relay) deliver(ctx context.Context, e Event) error {
+	var err error
+	for _, sink := range r.sinks {
+		if serr := sink.Publish(ctx, e); serr != nil {
+			err = errors.Join(err, errors.New(sink.Name()+": "+serr.Error()))
+		}
+	}
+	return err
+}
+
+// backoff doubles from one second per failed attempt, capped at MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := time.Second
+	for i := 0; i < attempts && d < r.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, r.cfg.MaxBackoff)
+}
@@ -0,0 +1,120 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memOutbox is an outbox of events that are always due
+type memOutbox struct {
+	records map[int64]*Record
+	dead    map[int64]bool
+	done    map[int64]bool
+	retryAt map[int64]time.Time
+}
+
+func newMemOutbox(events ...Event) *memOutbox {
+	o := &memOutbox{
+		records: map[int64]*Record{},
+		dead:    map[int64]bool{},
+		done:    map[int64]bool{},
+		retryAt: map[int64]time.Time{},
+	}
+	for i, e := range events {
+		o.records[int64(i+1)] = &Record{Event: e, OutboxID: int64(i + 1)}
+	}
+	return o
+}
+
+func (o *memOutbox) Claim(ctx context.Context, limit int, lease time.Duration) ([]Record, error) {
+	var claimed []Record
+	for id := int64(1); id <= int64(len(o.records)) && len(claimed) < limit; id++ {
+		if !o.dead[id] && !o.done[id] {
+			claimed = append(claimed, *o.records[id])
+		}
+	}
+	return claimed, nil
+}
+
+func (o *memOutbox) MarkPublished(ctx context.Context, outboxID int64) error {
+	o.done[outboxID] = true
+	return nil
+}
+
+func (o *memOutbox) MarkFailed(ctx context.Context, outboxID int64, cause error, retryAt time.Time, dead bool) error {
+	o.records[outboxID].Attempts++
+	o.retryAt[outboxID] = retryAt
+	o.dead[outboxID] = dead
+	return nil
+}
+
+// poisonSink rejects events of one type and accepts the rest
+type poisonSink struct {
+	poison    string
+	published []string
+}
+
+func (s *poisonSink) Name() string { return "poison" }
+
+func (s *poisonSink) Publish(ctx context.Context, e Event) error {
+	if e.Type == s.poison {
+		return errors.New("rejected")
+	}
+	s.published = append(s.published, e.ID)
+	return nil
+}
+
+func TestRelayDeadLetters(t *testing.T) {
+	poisoned := Event{ID: "a", Type: TrackUpdated}
+	healthy := Event{ID: "b", Type: TrackCreated}
+	outbox := newMemOutbox(poisoned, healthy)
+	sink := &poisonSink{poison: TrackUpdated}
+	relay := NewRelay(outbox, []Sink{sink}, RelayConfig{MaxAttempts: 3, MaxBackoff: time.Minute}, zap.NewNop().Sugar())
+
+	for i := 1; i <= 3; i++ {
+		start := time.Now()
+		if _, err := relay.RelayOnce(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := outbox.records[1].Attempts; got != i {
+			t.Fatalf("attempts = %d after %d relays", got, i)
+		}
+		if dead := outbox.dead[1]; dead != (i == 3) {
+			t.Fatalf("dead = %v after %d of 3 attempts", dead, i)
+		}
+		if wait := outbox.retryAt[1].Sub(start); wait < relay.backoff(i-1) {
+			t.Fatalf("retry in %v after attempt %d, want %v", wait, i, relay.backoff(i-1))
+		}
+	}
+
+	// The dead event is never claimed again and never held up the healthy one
+	if n, err := relay.RelayOnce(context.Background()); err != nil || n != 0 {
+		t.Fatalf("RelayOnce = %d, %v after dead-lettering, want nothing claimed", n, err)
+	}
+	if !outbox.done[2] || len(sink.published) != 1 || sink.published[0] != "b" {
+		t.Fatalf("published %v, want only b", sink.published)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(newMemOutbox(), nil, RelayConfig{MaxBackoff: 10 * time.Second}, zap.NewNop().Sugar())
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := relay.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package hls packages MP3 and AAC audio for HTTP Live Streaming. Segments
+// are packed audio (raw MPEG frames, or AAC in ADTS) cut on packet
+// boundaries and stamped with an ID3 timestamp, optionally encrypted with
+// AES-128.
+package hls
+
+import (
+	"audio-go/internal/audio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported is returned for streams that cannot be packed: AAC object
+// types without an ADTS profile, or configurations ADTS cannot signal
+var ErrUnsupported = errors.New("stream cannot be packaged for HLS")
+
+// Plan is how a track is cut into segments. It holds no packet locations,
+// those are stored separately and read per segment.
+type Plan struct {
+	Codec      string    `json:"codec"` // "mp3" or "aac"
+	Timescale  int       `json:"timescale"`
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Config     []byte    `json:"config,omitempty"` // AAC AudioSpecificConfig
+	Segments   []Segment `json:"segments"`
+}
+
+// Segment is a run of packets. Its media sequence number is its index in
+// the plan.
+type Segment struct {
+	First    int   `json:"first"` // index of its first packet
+	Count    int   `json:"count"`
+	Start    int64 `json:"start"`    // ticks
+	Duration int64 `json:"duration"` // ticks
+	Size     int64 `json:"size"`     // bytes of packet payload
+}
+
+// NewPlan cuts a packet index into segments of at least target duration,
+// the last one excepted
+func NewPlan(idx *audio.PacketIndex, target time.Duration) (*Plan, error) {
+	if idx.Timescale <= 0 || len(idx.Packets) == 0 {
+		return nil, fmt.Errorf("%w: no packets", ErrUnsupported)
+	}
+	p := &Plan{
+		Codec:      idx.Codec,
+		Timescale:  idx.Timescale,
+		SampleRate: idx.SampleRate,
+		Channels:   idx.Channels,
+		Config:     idx.Config,
+	}
+	switch idx.Codec {
+	case "mp3":
+	case "aac":
+		if _, err := parseADTSConfig(idx.Config); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: codec %q", ErrUnsupported, idx.Codec)
+	}
+
+	targetTicks := int64(target.Seconds() * float64(idx.Timescale))
+	seg := Segment{}
+	var start int64
+	for i, pkt := range idx.Packets {
+		if seg.Count == 0 {
+			seg = Segment{First: i, Start: start}
+		}
+		seg.Count++
+		seg.Duration += int64(pkt.Duration)
+		seg.Size += int64(pkt.Size)
+		start += int64(pkt.Duration)
+		if seg.Duration >= targetTicks {
+			p.Segments = append(p.Segments, seg)
+			seg.Count = 0
+		}
+	}
+	if seg.Count > 0 {
+		p.Segments = append(p.Segments, seg)
+	}
+	return p, nil
+}
+
+// Ext is the file extension of the plan's segments
+func (p *Plan) Ext() string {
+	if p.Codec == "aac" {
+		return "aac"
+	}
+	return "mp3"
+}
+
+// ContentType is the content type of the plan's segments
+func (p *Plan) ContentType() string {
+	if p.Codec == "aac" {
+		return "audio/aac"
+	}
+	return "audio/mpeg"
+}
+
+// Codecs is the RFC 6381 codec string for the CODECS attribute
+func (p *Plan) Codecs() string {
+	if p.Codec == "aac" {
+		if cfg, err := parseADTSConfig(p.Config); err == nil {
+			return fmt.Sprintf("mp4a.40.%d", cfg.objectType)
+		}
+		return "mp4a.40.2"
+	}
+	return "mp4a.40.34"
+}
+
+// Duration returns the total duration in ticks
+func (p *Plan) Duration() int64 {
+	var total int64
+	for _, s := range p.Segments {
+		total += s.Duration
+	}
+	return total
+}
+
+// Seconds converts ticks of the plan's timescale to seconds
+func (p *Plan) Seconds(ticks int64) float64 {
+	return float64(ticks) / float64(p.Timescale)
+}
+
+// TargetDuration is the EXT-X-TARGETDURATION: no segment's duration, rounded
+// to the nearest second, may exceed it
+func (p *Plan) TargetDuration() int {
+	target := 1
+	for _, s := range p.Segments {
+		target = max(target, int(math.Round(p.Seconds(s.Duration))))
+	}
+	return target
+}
+
+// SegmentLength is the size of a segment as written by WriteSegment, before
+// encryption
+func (p *Plan) SegmentLength(seg Segment) int64 {
+	n := int64(len(id3Timestamp(0))) + seg.Size
+	if p.Codec == "aac" {
+		n += int64(seg.Count) * adtsHeaderSize
+	}
+	return n
+}
+
+// Bandwidth returns the peak and average bits per second of the segments as
+// sent, AES padding included
+func (p *Plan) Bandwidth() (peak, average int) {
+	var bytes, ticks int64
+	for _, s := range p.Segments {
+		n := (p.SegmentLength(s)/16 + 1) * 16
+		bytes += n
+		ticks += s.Duration
+		if secs := p.Seconds(s.Duration); secs > 0 {
+			peak = max(peak, int(math.Ceil(float64(n*8)/secs)))
+		}
+	}
+	if ticks > 0 {
+		average = int(math.Ceil(float64(bytes*8) / p.Seconds(ticks)))
+	}
+	return peak, max(average, 1)
+}
+
+// MediaPlaylist renders the VOD media playlist. Segment URIs are relative,
+// "<seq>.<ext>" followed by query (e.g. "?sig=..."), so signed access
+// carries over from the playlist. keyURI, if set, adds an EXT-X-KEY for
+// AES-128 with the default, sequence number IVs.
+func (p *Plan) MediaPlaylist(keyURI, query string) []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", p.TargetDuration())
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	if keyURI != "" {
+		fmt.Fprintf(&b, "#EXT-X-KEY:METHOD=AES-128,URI=%q\n", keyURI)
+	}
+	for seq, s := range p.Segments {
+		fmt.Fprintf(&b, "#EXTINF:%.5f,\n%d.%s%s\n", p.Seconds(s.Duration), seq, p.Ext(), query)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.Bytes()
+}
+
+// MasterPlaylist renders a master playlist with the plan's single variant
+func (p *Plan) MasterPlaylist(mediaURI string) []byte {
+	peak, average := p.Bandwidth()
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=%q\n", peak, average, p.Codecs())
+	b.WriteString(mediaURI + "\n")
+	return b.Bytes()
+}
+
+// ParseSegmentName reads the sequence number from a "<seq>.<ext>" segment
+// name, checking it against the plan
+func (p *Plan) ParseSegmentName(name, ext string) (int, bool) {
+	num, ok := strings.CutSuffix(name, "."+ext)
+	if !ok || num == "" || len(num) > 9 {
+		return 0, false
+	}
+	seq := 0
+	for _, c := range num {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		seq = seq*10 + int(c-'0')
+	}
+	if seq >= len(p.Segments) {
+		return 0, false
+	}
+	return seq, true
+}
@@ -0,0 +1,204 @@
+package hls
+
+import (
+	"audio-go/internal/audio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	adtsHeaderSize = 7
+	maxReadRun     = 1 << 20 // contiguous packets are read in runs of up to this many bytes
+	packetRecord   = 16      // bytes per packet in EncodePackets
+)
+
+// adtsConfig is what an ADTS header carries of an AudioSpecificConfig
+type adtsConfig struct {
+	objectType   int // as in the config, for the CODECS attribute
+	profile      int // ADTS profile, object type - 1
+	rateIndex    int
+	channelIndex int
+}
+
+// parseADTSConfig reads the start of an AudioSpecificConfig. SBR and PS
+// streams are signalled implicitly: ADTS carries the AAC LC core.
+func parseADTSConfig(asc []byte) (adtsConfig, error) {
+	if len(asc) < 2 {
+		return adtsConfig{}, fmt.Errorf("%w: missing AudioSpecificConfig", ErrUnsupported)
+	}
+	cfg := adtsConfig{
+		objectType:   int(asc[0] >> 3),
+		rateIndex:    int(asc[0]&7)<<1 | int(asc[1]>>7),
+		channelIndex: int(asc[1]>>3) & 0xF,
+	}
+	core := cfg.objectType
+	if core == 5 || core == 29 {
+		core = 2
+	}
+	switch {
+	case core < 1 || core > 4:
+		return adtsConfig{}, fmt.Errorf("%w: AAC object type %d", ErrUnsupported, cfg.objectType)
+	case cfg.rateIndex > 12:
+		return adtsConfig{}, fmt.Errorf("%w: explicit AAC sample rate", ErrUnsupported)
+	case cfg.channelIndex < 1 || cfg.channelIndex > 7:
+		return adtsConfig{}, fmt.Errorf("%w: AAC channel configuration %d", ErrUnsupported, cfg.channelIndex)
+	}
+	cfg.profile = core - 1
+	return cfg, nil
+}
+
+// header returns the ADTS header of a raw data block of n bytes
+func (cfg adtsConfig) header(n int) []byte {
+	length := n + adtsHeaderSize
+	return []byte{
+		0xFF,
+		0xF1, // MPEG-4, layer 0, no CRC
+		byte(cfg.profile<<6 | cfg.rateIndex<<2 | cfg.channelIndex>>2),
+		byte(cfg.channelIndex&3<<6 | length>>11),
+		byte(length >> 3),
+		byte(length&7<<5 | 0x1F),
+		0xFC, // buffer fullness 0x7FF (VBR), one raw data block
+	}
+}
+
+// id3Timestamp is the ID3 tag that starts a packed audio segment, carrying
+// the presentation time of its first sample as a 33-bit 90 kHz timestamp
+func id3Timestamp(ts90k int64) []byte {
+	const owner = "com.apple.streaming.transportStreamTimestamp"
+
+	frame := make([]byte, 0, len(owner)+1+8)
+	frame = append(frame, owner...)
+	frame = append(frame, 0)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(ts90k)&(1<<33-1))
+
+	tag := make([]byte, 0, 20+len(frame))
+	tag = append(tag, 'I', 'D', '3', 4, 0, 0)
+	tag = appendSynchsafe(tag, 10+len(frame))
+	tag = append(tag, 'P', 'R', 'I', 'V')
+	tag = appendSynchsafe(tag, len(frame))
+	tag = append(tag, 0, 0)
+	return append(tag, frame...)
+}
+
+func appendSynchsafe(b []byte, n int) []byte {
+	return append(b, byte(n>>21&0x7F), byte(n>>14&0x7F), byte(n>>7&0x7F), byte(n&0x7F))
+}
+
+// WriteSegment writes a segment of the plan: the ID3 timestamp, then its
+// packets, read from r, as raw MPEG frames or ADTS frames
+func (p *Plan) WriteSegment(w io.Writer, seg Segment, packets []audio.Packet, r io.ReaderAt) error {
+	if len(packets) != seg.Count {
+		return fmt.Errorf("hls: segment has %d packets, got %d", seg.Count, len(packets))
+	}
+	var adts adtsConfig
+	if p.Codec == "aac" {
+		var err error
+		if adts, err = parseADTSConfig(p.Config); err != nil {
+			return err
+		}
+	}
+
+	ts := seg.Start * 90000 / int64(p.Timescale)
+	if _, err := w.Write(id3Timestamp(ts)); err != nil {
+		return err
+	}
+
+	return ReadPackets(r, packets, func(data []byte) error {
+		if p.Codec == "aac" {
+			if len(data)+adtsHeaderSize >= 1<<13 {
+				return fmt.Errorf("%w: AAC packet of %d bytes", ErrUnsupported, len(data))
+			}
+			if _, err := w.Write(adts.header(len(data))); err != nil {
+				return err
+			}
+		}
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// ReadPackets reads packets from r in order, passing each one's payload to
+// fn. Adjacent packets are read at once; the payload is only valid during
+// the call.
+func ReadPackets(r io.ReaderAt, packets []audio.Packet, fn func(data []byte) error) error {
+	var buf []byte
+	for i := 0; i < len(packets); {
+		start, end := packets[i].Offset, packets[i].Offset+int64(packets[i].Size)
+		j := i + 1
+		for j < len(packets) && packets[j].Offset == end && end-start+int64(packets[j].Size) <= maxReadRun {
+			end += int64(packets[j].Size)
+			j++
+		}
+		if int64(cap(buf)) < end-start {
+			buf = make([]byte, end-start)
+		}
+		run := buf[:end-start]
+		if n, err := r.ReadAt(run, start); n < len(run) {
+			return fmt.Errorf("hls: reading packets at %d: %w", start, err)
+		}
+
+		for ; i < j; i++ {
+			if err := fn(run[packets[i].Offset-start:][:packets[i].Size]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Encrypt encrypts a segment with AES-128-CBC and PKCS#7 padding, using
+// the sequence number as IV as EXT-X-KEY without an IV attribute implies
+func Encrypt(key []byte, seq int, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], uint64(seq))
+
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	out := make([]byte, len(plain)+pad)
+	copy(out, plain)
+	for i := len(plain); i < len(out); i++ {
+		out[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+	return out, nil
+}
+
+// EncodePackets stores packet locations as fixed-size records, so the
+// packets of one segment can be read back with a range request
+func EncodePackets(packets []audio.Packet) []byte {
+	b := make([]byte, 0, len(packets)*packetRecord)
+	for _, pkt := range packets {
+		b = binary.BigEndian.AppendUint64(b, uint64(pkt.Offset))
+		b = binary.BigEndian.AppendUint32(b, uint32(pkt.Size))
+		b = binary.BigEndian.AppendUint32(b, uint32(pkt.Duration))
+	}
+	return b
+}
+
+// DecodePackets reads records written by EncodePackets
+func DecodePackets(b []byte) ([]audio.Packet, error) {
+	if len(b)%packetRecord != 0 {
+		return nil, fmt.Errorf("hls: packet records of %d bytes", len(b))
+	}
+	packets := make([]audio.Packet, len(b)/packetRecord)
+	for i := range packets {
+		rec := b[i*packetRecord:]
+		packets[i] = audio.Packet{
+			Offset:   int64(binary.BigEndian.Uint64(rec)),
+			Size:     int(binary.BigEndian.Uint32(rec[8:])),
+			Duration: int(binary.BigEndian.Uint32(rec[12:])),
+		}
+	}
+	return packets, nil
+}
+
+// PacketRange is the byte range of a segment's records in EncodePackets output
+func PacketRange(seg Segment) (off, length int64) {
+	return int64(seg.First) * packetRecord, int64(seg.Count) * packetRecord
+}
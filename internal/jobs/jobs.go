@@ -0,0 +1,142 @@
+// Package jobs runs work outside the request cycle. Jobs are stored in a
+// Queue (Postgres in production, see store.JobStore, or MemoryQueue) and
+// run by a Pool of workers with one typed handler per kind. A dequeued job
+// is leased to its worker, which keeps the lease alive with heartbeats; if
+// the worker dies the lease runs out and the job becomes due again. Failed
+// jobs are retried with exponential backoff until they run out of
+// attempts and are dead-lettered.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrLeaseLost = errors.New("job lease lost") // the job is no longer held by the worker
+)
+
+// Job states. Completed jobs are removed.
+const (
+	StateQueued  = "queued"
+	StateRunning = "running"
+	StateDead    = "dead" // out of attempts or failed permanently, kept for inspection
+)
+
+// DefaultMaxAttempts is used for jobs enqueued without a limit
+const DefaultMaxAttempts = 5
+
+// Job is a unit of background work
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"` // higher runs first
+	State       string          `json:"state"`
+	RunAt       time.Time       `json:"run_at"`   // not before
+	Attempts    int             `json:"attempts"` // started so far, including the running one
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	LockedBy    string          `json:"locked_by,omitempty"`
+	LockedUntil time.Time       `json:"locked_until,omitempty"` // end of the lease while running
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// Queue stores jobs. Every method but Enqueue and Get acts only on a job
+// leased to the given worker and returns ErrLeaseLost otherwise.
+type Queue interface {
+	// Enqueue stores a new job and fills in its id, state and timestamps
+	Enqueue(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id int64) (*Job, error)
+	// Dequeue leases up to limit due jobs of the given kinds to worker,
+	// highest priority first, counting an attempt on each. Running jobs
+	// whose lease expired are due again, or dead once out of attempts.
+	Dequeue(ctx context.Context, worker string, kinds []string, limit int, lease time.Duration) ([]*Job, error)
+	// Heartbeat extends a running job's lease
+	Heartbeat(ctx context.Context, id int64, worker string, lease time.Duration) error
+	// Complete removes a job that succeeded
+	Complete(ctx context.Context, id int64, worker string) error
+	// Fail records a failed attempt and queues the job again at retryAt,
+	// or moves it to the dead state
+	Fail(ctx context.Context, id int64, worker string, cause error, retryAt time.Time, dead bool) error
+	// Release queues a job again without counting the attempt, e.g. when
+	// its worker shuts down
+	Release(ctx context.Context, id int64, worker string) error
+}
+
+// Options are the scheduling of an enqueued job. Zero values run it now,
+// at priority zero, with DefaultMaxAttempts.
+type Options struct {
+	Priority    int
+	RunAt       time.Time
+	MaxAttempts int
+}
+
+// Kind names a type of job whose payload is a T, encoded as JSON
+type Kind[T any] string
+
+// Enqueue adds a job of this kind to the queue
+func (k Kind[T]) Enqueue(ctx context.Context, q Queue, payload T, opts Options) (*Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: encoding %s payload: %w", k, err)
+	}
+	job := &Job{
+		Kind:        string(k),
+		Payload:     b,
+		Priority:    opts.Priority,
+		RunAt:       opts.RunAt,
+		MaxAttempts: opts.MaxAttempts,
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = DefaultMaxAttempts
+	}
+	return job, q.Enqueue(ctx, job)
+}
+
+// Handler runs the jobs of one kind
+type Handler struct {
+	kind string
+	run  func(ctx context.Context, job *Job) error
+}
+
+// Handle returns the handler of this kind. A payload that doesn't decode
+// fails the job permanently.
+func (k Kind[T]) Handle(fn func(ctx context.Context, job *Job, payload T) error) Handler {
+	return Handler{kind: string(k), run: func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("jobs: decoding %s payload: %w", k, err))
+		}
+		return fn(ctx, job, payload)
+	}}
+}
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err so the job is dead-lettered without further attempts
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
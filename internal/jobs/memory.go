@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is a Queue kept in process memory, for tests and single
+// process development setups. Jobs do not survive a restart.
+type MemoryQueue struct {
+	mu     sync.Mutex
+	jobs   map[int64]*Job
+	nextID int64
+	now    func() time.Time
+}
+
+// NewMemoryQueue returns an empty queue
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{jobs: map[int64]*Job{}, now: time.Now}
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextID++
+	job.ID = q.nextID
+	job.State = StateQueued
+	job.CreatedAt = q.now()
+	stored := *job
+	q.jobs[job.ID] = &stored
+	return nil
+}
+
+func (q *MemoryQueue) Get(ctx context.Context, id int64) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// Jobs returns a copy of every stored job, ordered by id
+func (q *MemoryQueue) Jobs() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := make([]*Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		copied := *job
+		jobs = append(jobs, &copied)
+	}
+	slices.SortFunc(jobs, func(a, b *Job) int { return int(a.ID - b.ID) })
+	return jobs
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context, worker string, kinds []string, limit int, lease time.Duration) ([]*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	var due []*Job
+	for _, job := range q.jobs {
+		if !slices.Contains(kinds, job.Kind) {
+			continue
+		}
+		switch {
+		case job.State == StateQueued && !job.RunAt.After(now):
+		case job.State == StateRunning && !job.LockedUntil.After(now):
+			if job.Attempts >= job.MaxAttempts {
+				job.State, job.LastError = StateDead, "lease expired"
+				job.LockedBy, job.LockedUntil = "", time.Time{}
+				continue
+			}
+		default:
+			continue
+		}
+		due = append(due, job)
+	}
+	slices.SortFunc(due, func(a, b *Job) int {
+		switch {
+		case a.Priority != b.Priority:
+			return b.Priority - a.Priority
+		case !a.RunAt.Equal(b.RunAt):
+			return a.RunAt.Compare(b.RunAt)
+		}
+		return int(a.ID - b.ID)
+	})
+
+	leased := make([]*Job, 0, min(limit, len(due)))
+	for _, job := range due[:min(limit, len(due))] {
+		job.State = StateRunning
+		job.Attempts++
+		job.LockedBy = worker
+		job.LockedUntil = now.Add(lease)
+		copied := *job
+		leased = append(leased, &copied)
+	}
+	return leased, nil
+}
+
+// leased returns the job if worker holds it. q.mu must be held.
+func (q *MemoryQueue) leased(id int64, worker string) (*Job, error) {
+	job, ok := q.jobs[id]
+	if !ok || job.State != StateRunning || job.LockedBy != worker {
+		return nil, ErrLeaseLost
+	}
+	return job, nil
+}
+
+func (q *MemoryQueue) Heartbeat(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.leased(id, worker)
+	if err != nil {
+		return err
+	}
+	job.LockedUntil = q.now().Add(lease)
+	return nil
+}
+
+func (q *MemoryQueue) Complete(ctx context.Context, id int64, worker string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.leased(id, worker); err != nil {
+		return err
+	}
+	delete(q.jobs, id)
+	return nil
+}
+
+func (q *MemoryQueue) Fail(ctx context.Context, id int64, worker string, cause error, retryAt time.Time, dead bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.leased(id, worker)
+	if err != nil {
+		return err
+	}
+	job.State = StateQueued
+	if dead {
+		job.State = StateDead
+	}
+	job.LastError = cause.Error()
+	job.RunAt = retryAt
+	job.LockedBy, job.LockedUntil = "", time.Time{}
+	return nil
+}
+
+func (q *MemoryQueue) Release(ctx context.Context, id int64, worker string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, err := q.leased(id, worker)
+	if err != nil {
+		return err
+	}
+	job.State = StateQueued
+	job.Attempts--
+	job.LockedBy, job.LockedUntil = "", time.Time{}
+	return nil
+}
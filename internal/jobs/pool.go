@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PoolConfig tunes a worker pool
+type PoolConfig struct {
+	Workers      int
+	PollInterval time.Duration // how often idle workers look for due jobs
+	Lease        time.Duration // visibility timeout, renewed by heartbeats
+	BaseBackoff  time.Duration // before the second attempt, doubled after each
+	MaxBackoff   time.Duration
+}
+
+// Pool runs the jobs of its handlers' kinds with a fixed number of workers
+type Pool struct {
+	queue    Queue
+	handlers map[string]Handler
+	kinds    []string
+	cfg      PoolConfig
+	id       string // names this pool's leases
+	logger   *zap.SugaredLogger
+
+	stop      chan struct{}
+	stopOnce  sync.Once
+	jobCtx    context.Context // parent of every job's context
+	cancelJob context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewPool creates a pool, filling in defaults for zero config values
+func NewPool(queue Queue, cfg PoolConfig, logger *zap.SugaredLogger, handlers ...Handler) *Pool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = 10 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Hour
+	}
+
+	p := &Pool{
+		queue:    queue,
+		handlers: make(map[string]Handler, len(handlers)),
+		cfg:      cfg,
+		id:       workerID(),
+		logger:   logger,
+		stop:     make(chan struct{}),
+	}
+	for _, h := range handlers {
+		p.handlers[h.kind] = h
+		p.kinds = append(p.kinds, h.kind)
+	}
+	p.jobCtx, p.cancelJob = context.WithCancel(context.Background())
+	return p
+}
+
+// workerID is unique to this process: host, pid and a random suffix
+func workerID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// Start launches the workers. They run until Shutdown.
+func (p *Pool) Start() {
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go func(worker string) {
+			defer p.wg.Done()
+			p.work(worker)
+		}(fmt.Sprintf("%s/%d", p.id, i))
+	}
+}
+
+// Shutdown stops taking jobs and waits for the running ones. When ctx is
+// done first their contexts are cancelled and they are released back to
+// the queue; Shutdown then still waits for the handlers to return.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancelJob()
+		return nil
+	case <-ctx.Done():
+		p.cancelJob()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// work is one worker's loop: take a due job, run it, repeat, and sleep
+// for the poll interval when there is none
+func (p *Pool) work(worker string) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-timer.C:
+		}
+
+		jobs, err := p.queue.Dequeue(p.jobCtx, worker, p.kinds, 1, p.cfg.Lease)
+		if err != nil {
+			p.logger.Errorw("dequeuing jobs failed", "worker", worker, "error", err)
+		}
+		for _, job := range jobs {
+			p.run(worker, job)
+		}
+
+		if len(jobs) > 0 {
+			timer.Reset(0)
+		} else {
+			timer.Reset(p.cfg.PollInterval)
+		}
+	}
+}
+
+// run handles one leased job and records its outcome
+func (p *Pool) run(worker string, job *Job) {
+	ctx, cancel := context.WithCancel(p.jobCtx)
+	defer cancel()
+
+	// Heartbeats keep the lease; losing it means another worker may
+	// already have the job, so this run is abandoned
+	beat := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(p.cfg.Lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-beat:
+				return
+			case <-ticker.C:
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := p.queue.Heartbeat(ctx, job.ID, worker, p.cfg.Lease); err != nil {
+				p.logger.Warnw("job heartbeat failed", "job_id", job.ID, "kind", job.Kind, "error", err)
+				if errors.Is(err, ErrLeaseLost) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := p.handle(ctx, job)
+	close(beat)
+
+	// Bookkeeping outlives the job's context
+	bg := context.WithoutCancel(ctx)
+	leaseLost := false
+	select {
+	case <-ctx.Done():
+		leaseLost = p.jobCtx.Err() == nil
+	default:
+	}
+	switch {
+	case leaseLost:
+		return
+	case err == nil:
+		err = p.queue.Complete(bg, job.ID, worker)
+	case p.jobCtx.Err() != nil:
+		// Shutting down: give the job back without counting the attempt
+		err = p.queue.Release(bg, job.ID, worker)
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		p.logger.Errorw("job dead-lettered", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+		err = p.queue.Fail(bg, job.ID, worker, err, time.Now(), true)
+	default:
+		retryAt := time.Now().Add(p.backoff(job.Attempts))
+		p.logger.Warnw("job failed", "job_id", job.ID, "kind", job.Kind, "attempts", job.Attempts, "retry_at", retryAt, "error", err)
+		err = p.queue.Fail(bg, job.ID, worker, err, retryAt, false)
+	}
+	if err != nil {
+		p.logger.Errorw("recording job outcome failed", "job_id", job.ID, "kind", job.Kind, "error", err)
+	}
+}
+
+// handle runs the job's handler, turning a panic into a permanent failure
+func (p *Pool) handle(ctx context.Context, job *Job) (err error) {
+	h, ok := p.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("jobs: no handler for kind %q", job.Kind))
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = Permanent(fmt.Errorf("jobs: %s handler panicked: %v", job.Kind, v))
+		}
+	}()
+	return h.run(ctx, job)
+}
+
+// backoff doubles from BaseBackoff per failed attempt, capped at MaxBackoff
+func (p *Pool) backoff(attempts int) time.Duration {
+	d := p.cfg.BaseBackoff
+	for i := 1; i < attempts && d < p.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.cfg.MaxBackoff)
+}
@@ -0,0 +1,237 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type testPayload struct {
+	N int `json:"n"`
+}
+
+const testKind Kind[testPayload] = "test"
+
+const testWorker = "worker-1"
+
+// runOnce enqueues a job, leases it to testWorker and runs it through
+// p.run, returning the job as stored afterwards (nil once completed)
+func runOnce(t *testing.T, q *MemoryQueue, p *Pool, opts Options) *Job {
+	t.Helper()
+	ctx := context.Background()
+	job, err := testKind.Enqueue(ctx, q, testPayload{N: 1}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runLeased(t, q, p, job.ID)
+}
+
+// runLeased leases the due job with the given id and runs it
+func runLeased(t *testing.T, q *MemoryQueue, p *Pool, id int64) *Job {
+	t.Helper()
+	ctx := context.Background()
+	leased, err := q.Dequeue(ctx, testWorker, []string{string(testKind)}, 1, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leased) != 1 || leased[0].ID != id {
+		t.Fatalf("leased %+v, want job %d", leased, id)
+	}
+	p.run(testWorker, leased[0])
+
+	stored, err := q.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func newTestPool(q *MemoryQueue, cfg PoolConfig, fn func(ctx context.Context, job *Job, p testPayload) error) *Pool {
+	return NewPool(q, cfg, zap.NewNop().Sugar(), testKind.Handle(fn))
+}
+
+func TestPoolRun(t *testing.T) {
+	boom := errors.New("boom")
+	cfg := PoolConfig{BaseBackoff: time.Minute, MaxBackoff: time.Hour}
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		handler     func(ctx context.Context, job *Job, p testPayload) error
+		wantGone    bool // completed and removed
+		wantState   string
+		wantError   string
+		wantBackoff time.Duration // until RunAt, for retried jobs
+	}{
+		{
+			name:     "success completes",
+			handler:  func(context.Context, *Job, testPayload) error { return nil },
+			wantGone: true,
+		},
+		{
+			name:        "failure is retried after the base backoff",
+			maxAttempts: 3,
+			handler:     func(context.Context, *Job, testPayload) error { return boom },
+			wantState:   StateQueued,
+			wantError:   "boom",
+			wantBackoff: time.Minute,
+		},
+		{
+			name:        "failure on the last attempt is dead-lettered",
+			maxAttempts: 1,
+			handler:     func(context.Context, *Job, testPayload) error { return boom },
+			wantState:   StateDead,
+			wantError:   "boom",
+		},
+		{
+			name:        "permanent failure is dead-lettered at once",
+			maxAttempts: 5,
+			handler:     func(context.Context, *Job, testPayload) error { return Permanent(boom) },
+			wantState:   StateDead,
+			wantError:   "boom",
+		},
+		{
+			name:        "panic becomes a permanent failure",
+			maxAttempts: 5,
+			handler:     func(context.Context, *Job, testPayload) error { panic("kaboom") },
+			wantState:   StateDead,
+			wantError:   "test handler panicked: kaboom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewMemoryQueue()
+			p := newTestPool(q, cfg, tt.handler)
+
+			start := time.Now()
+			job := runOnce(t, q, p, Options{MaxAttempts: tt.maxAttempts})
+			if tt.wantGone {
+				if job != nil {
+					t.Fatalf("job left as %+v, want it removed", job)
+				}
+				return
+			}
+			if job == nil {
+				t.Fatal("job removed")
+			}
+			if job.State != tt.wantState || !strings.Contains(job.LastError, tt.wantError) {
+				t.Fatalf("job %s with error %q, want %s with %q", job.State, job.LastError, tt.wantState, tt.wantError)
+			}
+			if job.LockedBy != "" {
+				t.Fatalf("job still locked by %q", job.LockedBy)
+			}
+			if tt.wantBackoff > 0 {
+				if wait := job.RunAt.Sub(start); wait < tt.wantBackoff || wait > tt.wantBackoff+time.Second {
+					t.Fatalf("retry in %v, want %v", wait, tt.wantBackoff)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolRunDeadLettersAtMaxAttempts(t *testing.T) {
+	q := NewMemoryQueue()
+	calls := 0
+	p := newTestPool(q, PoolConfig{BaseBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond},
+		func(context.Context, *Job, testPayload) error { calls++; return errors.New("boom") })
+
+	job := runOnce(t, q, p, Options{MaxAttempts: 3})
+	for job.State == StateQueued {
+		time.Sleep(time.Millisecond) // past RunAt
+		job = runLeased(t, q, p, job.ID)
+	}
+	if job.State != StateDead || job.Attempts != 3 || calls != 3 {
+		t.Fatalf("job %s after %d attempts and %d calls, want dead after 3", job.State, job.Attempts, calls)
+	}
+}
+
+func TestPoolRunLeaseLost(t *testing.T) {
+	q := NewMemoryQueue()
+	var handlerErr error
+	p := newTestPool(q, PoolConfig{Lease: 30 * time.Millisecond}, func(ctx context.Context, job *Job, _ testPayload) error {
+		// Another worker takes the job over, as after an expired lease
+		q.mu.Lock()
+		q.jobs[job.ID].LockedBy = "worker-2"
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			handlerErr = ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+		return handlerErr
+	})
+
+	job := runOnce(t, q, p, Options{})
+	if !errors.Is(handlerErr, context.Canceled) {
+		t.Fatalf("handler ended with %v, want its context cancelled", handlerErr)
+	}
+	// The outcome belongs to the new holder, nothing is recorded
+	if job == nil || job.State != StateRunning || job.LockedBy != "worker-2" || job.LastError != "" {
+		t.Fatalf("job left as %+v, want it untouched and running on worker-2", job)
+	}
+}
+
+func TestPoolShutdownReleases(t *testing.T) {
+	q := NewMemoryQueue()
+	started := make(chan struct{})
+	p := newTestPool(q, PoolConfig{PollInterval: time.Millisecond}, func(ctx context.Context, job *Job, _ testPayload) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	job, err := testKind.Enqueue(context.Background(), q, testPayload{}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Start()
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job never started")
+	}
+
+	// The shutdown deadline passes with the job still running
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Shutdown = %v, want context.Canceled", err)
+	}
+
+	stored, err := q.Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != StateQueued || stored.Attempts != 0 || stored.LockedBy != "" || stored.LastError != "" {
+		t.Fatalf("job left as %+v, want it queued again without the attempt counted", stored)
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	p := NewPool(NewMemoryQueue(), PoolConfig{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}, zap.NewNop().Sugar())
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{40, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package loudness
+
+import "math"
+
+// biquad is a second order IIR section, a0 normalized to 1
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+}
+
+// biquadState is the direct form II transposed state of one channel
+type biquadState struct {
+	z1, z2 float64
+}
+
+func (f *biquad) process(s *biquadState, x float64) float64 {
+	y := f.b0*x + s.z1
+	s.z1 = f.b1*x - f.a1*y + s.z2
+	s.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting returns the two stages of the BS.1770 K-weighting filter, a
+// high shelf modelling the head and a high-pass (the revised low-frequency
+// B curve), for any sample rate. The analog prototypes are those the
+// standard's 48 kHz coefficients were derived from, so at 48 kHz they
+// match the published values.
+func kWeighting(sampleRate int) (shelf, highPass biquad) {
+	fs := float64(sampleRate)
+
+	// Stage 1, +4 dB above about 1.5 kHz
+	const (
+		shelfFreq = 1681.974450955533
+		shelfGain = 3.999843853973347
+		shelfQ    = 0.7071752369554196
+	)
+	k := math.Tan(math.Pi * shelfFreq / fs)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	// Stage 2, second order high-pass at about 38 Hz
+	const (
+		hpFreq = 38.13547087602444
+		hpQ    = 0.5003270373238773
+	)
+	k = math.Tan(math.Pi * hpFreq / fs)
+	a0 = 1 + k/hpQ + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/hpQ + k*k) / a0,
+	}
+	return shelf, highPass
+}
@@ -0,0 +1,137 @@
+package loudness
+
+import (
+	"math"
+	"slices"
+)
+
+// binsPerLU is the resolution of histograms
+const binsPerLU = 10
+
+// Histogram counts a programme's gating blocks that pass the absolute
+// gate, in 0.1 LU bins numbered from -70 LUFS. The gates only need block
+// loudness, so the histograms of several programmes add up to that of
+// them played in sequence: that is how album loudness is measured
+// without decoding the album again.
+type Histogram struct {
+	Momentary map[int]int `json:"momentary"`  // 400 ms blocks
+	ShortTerm map[int]int `json:"short_term"` // 3 s blocks
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{Momentary: map[int]int{}, ShortTerm: map[int]int{}}
+}
+
+// bin returns the bin of a block's loudness, false when it is gated out
+func bin(lufs float64) (int, bool) {
+	if !(lufs > absoluteGate) {
+		return 0, false
+	}
+	return int((lufs - absoluteGate) * binsPerLU), true
+}
+
+// binLoudness is the loudness at the middle of bin i
+func binLoudness(i int) float64 {
+	return absoluteGate + (float64(i)+0.5)/binsPerLU
+}
+
+// Add merges o into h
+func (h *Histogram) Add(o *Histogram) {
+	if h.Momentary == nil {
+		h.Momentary = map[int]int{}
+	}
+	if h.ShortTerm == nil {
+		h.ShortTerm = map[int]int{}
+	}
+	for i, n := range o.Momentary {
+		h.Momentary[i] += n
+	}
+	for i, n := range o.ShortTerm {
+		h.ShortTerm[i] += n
+	}
+}
+
+// expand lists the block loudness of each count of a histogram, quietest
+// first
+func expand(bins map[int]int) []float64 {
+	keys := make([]int, 0, len(bins))
+	for i := range bins {
+		keys = append(keys, i)
+	}
+	slices.Sort(keys)
+	var out []float64
+	for _, i := range keys {
+		for n := bins[i]; n > 0; n-- {
+			out = append(out, binLoudness(i))
+		}
+	}
+	return out
+}
+
+// Integrated is the gated loudness of the blocks counted, in LUFS, -Inf
+// when none pass the gates
+func (h *Histogram) Integrated() float64 {
+	return integrated(expand(h.Momentary))
+}
+
+// Range is the loudness range of the blocks counted, in LU
+func (h *Histogram) Range() float64 {
+	return loudnessRange(expand(h.ShortTerm))
+}
+
+// integrated gates blocks of the given loudness twice: at -70 LUFS, then
+// 10 LU below the mean of what passed, and returns the mean of the rest
+func integrated(blocks []float64) float64 {
+	mean := func(gate float64) float64 {
+		var sum float64
+		var n int
+		for _, l := range blocks {
+			if l > gate {
+				sum += energy(l)
+				n++
+			}
+		}
+		if n == 0 {
+			return math.Inf(-1)
+		}
+		return lufs(sum / float64(n))
+	}
+	ungated := mean(absoluteGate)
+	if math.IsInf(ungated, -1) {
+		return ungated
+	}
+	return mean(max(ungated+relativeGate, absoluteGate))
+}
+
+// loudnessRange is the spread between the 10th and 95th percentiles of
+// short-term loudness, after gating at -70 LUFS and 20 LU below the mean
+// (EBU Tech 3342)
+func loudnessRange(blocks []float64) float64 {
+	var sum float64
+	var n int
+	for _, l := range blocks {
+		if l > absoluteGate {
+			sum += energy(l)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	gate := max(lufs(sum/float64(n))+rangeGate, absoluteGate)
+
+	var gated []float64
+	for _, l := range blocks {
+		if l > gate {
+			gated = append(gated, l)
+		}
+	}
+	if len(gated) == 0 {
+		return 0
+	}
+	slices.Sort(gated)
+	percentile := func(p float64) float64 {
+		return gated[int(math.Round(float64(len(gated)-1)*p))]
+	}
+	return percentile(0.95) - percentile(0.10)
+}
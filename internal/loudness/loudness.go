@@ -0,0 +1,212 @@
+// Package loudness measures programme loudness as ITU-R BS.1770-4 and
+// EBU R 128 define it: K-weighted, gated integrated loudness, the loudness
+// range of EBU Tech 3342 and the true peak, and derives the ReplayGain 2.0
+// and R128 gains players normalize with.
+package loudness
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"errors"
+	"io"
+	"math"
+)
+
+// Gates and reference levels, in LUFS and LU
+const (
+	absoluteGate = -70.0
+	relativeGate = -10.0 // integrated loudness
+	rangeGate    = -20.0 // loudness range
+
+	ReplayGainReference = -18.0 // ReplayGain 2.0
+	R128Reference       = -23.0 // EBU R 128, and the R128_*_GAIN tags of Opus
+)
+
+var ErrFormat = errors.New("loudness: no channels or sample rate")
+
+// Result is the measurement of a programme
+type Result struct {
+	Integrated float64 // LUFS, -Inf when too short or quiet to pass the gates
+	Range      float64 // LU
+	TruePeak   float64 // dBTP
+	SamplePeak float64 // dBFS
+}
+
+// Meter measures interleaved frames as they are decoded. Loudness is
+// summed over 100 ms steps; gating blocks are the last 4 steps (400 ms,
+// 75% overlap) for integrated loudness and the last 30 (3 s) for the
+// loudness range, taken every step.
+type Meter struct {
+	channels int
+	weights  []float64
+	shelf    biquad
+	highPass biquad
+	states   [][2]biquadState
+
+	step      int       // frames per 100 ms
+	count     int       // frames in the current step
+	sum       float64   // weighted sum of squares of the current step
+	steps     []float64 // sums of the last 30 steps, oldest first
+	momentary []float64 // loudness of each 400 ms block
+	shortTerm []float64 // loudness of each 3 s block
+
+	peak *truePeak
+}
+
+// NewMeter starts a measurement of audio in the given format
+func NewMeter(format pcm.Format) (*Meter, error) {
+	if format.Channels <= 0 || format.SampleRate <= 0 {
+		return nil, ErrFormat
+	}
+	m := &Meter{
+		channels: format.Channels,
+		weights:  channelWeights(format),
+		states:   make([][2]biquadState, format.Channels),
+		step:     max((format.SampleRate+5)/10, 1),
+		peak:     newTruePeak(format.SampleRate, format.Channels),
+	}
+	m.shelf, m.highPass = kWeighting(format.SampleRate)
+	return m, nil
+}
+
+// channelWeights are the BS.1770 weights of the channels: 1.41 (+1.5 dB)
+// for surrounds, none for the LFE channel, 1 for the rest
+func channelWeights(format pcm.Format) []float64 {
+	weights := make([]float64, format.Channels)
+	mask := format.ChannelMask
+	if mask == 0 {
+		mask = audio.DefaultChannelMask(format.Channels)
+	}
+	for c := range weights {
+		weights[c] = 1
+		if mask == 0 {
+			continue
+		}
+		speaker := mask & -mask
+		mask &^= speaker
+		switch speaker {
+		case audio.SpeakerLowFrequency:
+			weights[c] = 0
+		case audio.SpeakerBackLeft, audio.SpeakerBackRight, audio.SpeakerSideLeft, audio.SpeakerSideRight:
+			weights[c] = 1.41
+		}
+	}
+	return weights
+}
+
+// Write adds interleaved frames
+func (m *Meter) Write(frames []float32) {
+	for i := 0; i+m.channels <= len(frames); i += m.channels {
+		for c, w := range m.weights {
+			x := float64(frames[i+c])
+			m.peak.add(c, x)
+			if w == 0 {
+				continue
+			}
+			s := &m.states[c]
+			y := m.highPass.process(&s[1], m.shelf.process(&s[0], x))
+			m.sum += w * y * y
+		}
+		if m.count++; m.count == m.step {
+			m.endStep()
+		}
+	}
+}
+
+// endStep closes a 100 ms step and the blocks that end with it
+func (m *Meter) endStep() {
+	if len(m.steps) == 30 {
+		copy(m.steps, m.steps[1:])
+		m.steps = m.steps[:29]
+	}
+	m.steps = append(m.steps, m.sum)
+	m.count, m.sum = 0, 0
+
+	block := func(n int) float64 {
+		var sum float64
+		for _, s := range m.steps[len(m.steps)-n:] {
+			sum += s
+		}
+		return lufs(sum / float64(n*m.step))
+	}
+	if len(m.steps) >= 4 {
+		m.momentary = append(m.momentary, block(4))
+	}
+	if len(m.steps) == 30 {
+		m.shortTerm = append(m.shortTerm, block(30))
+	}
+}
+
+// Result returns the measurement of the frames written so far. A partial
+// gating block at the end is left out.
+func (m *Meter) Result() Result {
+	return Result{
+		Integrated: integrated(m.momentary),
+		Range:      loudnessRange(m.shortTerm),
+		TruePeak:   decibels(m.peak.peak),
+		SamplePeak: decibels(m.peak.sample),
+	}
+}
+
+// Histogram returns the gating blocks of the frames written so far
+func (m *Meter) Histogram() *Histogram {
+	h := newHistogram()
+	for _, l := range m.momentary {
+		if i, ok := bin(l); ok {
+			h.Momentary[i]++
+		}
+	}
+	for _, l := range m.shortTerm {
+		if i, ok := bin(l); ok {
+			h.ShortTerm[i]++
+		}
+	}
+	return h
+}
+
+// Measure reads src to the end and measures it
+func Measure(src pcm.Source) (Result, error) {
+	format := src.Format()
+	m, err := NewMeter(format)
+	if err != nil {
+		return Result{}, err
+	}
+	buf := make([]float32, 4096*format.Channels)
+	for {
+		n, err := src.ReadFrames(buf)
+		m.Write(buf[:n*format.Channels])
+		if err == io.EOF {
+			return m.Result(), nil
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+}
+
+// ReplayGain is the ReplayGain 2.0 gain, in dB, of a programme of the
+// given integrated loudness
+func ReplayGain(integrated float64) float64 {
+	return ReplayGainReference - integrated
+}
+
+// R128Gain is the gain to -23 LUFS in the Q7.8 fixed point of the Opus
+// R128_TRACK_GAIN and R128_ALBUM_GAIN tags
+func R128Gain(integrated float64) int16 {
+	q := math.Round((R128Reference - integrated) * 256)
+	return int16(max(min(q, math.MaxInt16), math.MinInt16))
+}
+
+// lufs is the loudness of a mean square, weighted sum of channels
+func lufs(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
+
+func energy(lufs float64) float64 {
+	return math.Pow(10, (lufs+0.691)/10)
+}
+
+// decibels converts a linear amplitude
+func decibels(v float64) float64 {
+	return 20 * math.Log10(v)
+}
@@ -0,0 +1,147 @@
+package loudness
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/pcm"
+	"io"
+	"math"
+	"testing"
+)
+
+// signal is a synthetic reference signal: a sine of the same frequency and
+// phase on every channel, its level changing from segment to segment, as
+// in the EBU Tech 3341 and 3342 test material
+type signal struct {
+	sampleRate  int
+	channels    int
+	channelMask uint32
+	frequency   float64   // Hz
+	phase       float64   // radians, at the first sample
+	offsets     []float64 // dB added to each channel's level, none when empty
+	segments    []segment
+}
+
+// segment is a stretch of a signal at one level
+type segment struct {
+	seconds float64
+	level   float64 // peak amplitude of the sine, dBFS
+}
+
+// fadeSeconds is the raised cosine signals start and end with. A sine
+// switched on abruptly overshoots once band-limited, which a true-peak
+// meter rightly reads.
+const fadeSeconds = 0.01
+
+// stereo is a 48 kHz stereo 1 kHz sine through the given segments
+func stereo(segments ...segment) signal {
+	return signal{sampleRate: 48000, channels: 2, frequency: 1000, segments: segments}
+}
+
+func (s signal) source() pcm.Source {
+	src := &signalSource{signal: s}
+	for _, seg := range s.segments {
+		src.frames += int64(math.Round(seg.seconds * float64(s.sampleRate)))
+		src.ends = append(src.ends, src.frames)
+	}
+	return src
+}
+
+type signalSource struct {
+	signal signal
+	ends   []int64 // frame each segment ends at
+	frames int64
+	pos    int64
+	seg    int
+}
+
+func (s *signalSource) Format() pcm.Format {
+	return pcm.Format{
+		SampleRate:  s.signal.sampleRate,
+		Channels:    s.signal.channels,
+		ChannelMask: s.signal.channelMask,
+		Frames:      s.frames,
+	}
+}
+
+func (s *signalSource) ReadFrames(buf []float32) (int, error) {
+	sig := s.signal
+	n := 0
+	for ; (n+1)*sig.channels <= len(buf) && s.pos < s.frames; n++ {
+		for s.pos >= s.ends[s.seg] {
+			s.seg++
+		}
+		v := math.Sin(2*math.Pi*sig.frequency*float64(s.pos)/float64(sig.sampleRate) + sig.phase)
+		edge := float64(min(s.pos, s.frames-1-s.pos)) / (fadeSeconds * float64(sig.sampleRate))
+		if edge < 1 {
+			v *= (1 - math.Cos(math.Pi*edge)) / 2
+		}
+		for c := 0; c < sig.channels; c++ {
+			db := sig.segments[s.seg].level
+			if c < len(sig.offsets) {
+				db += sig.offsets[c]
+			}
+			buf[n*sig.channels+c] = float32(math.Pow(10, db/20) * v)
+		}
+		s.pos++
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func integratedOf(r Result) float64 { return r.Integrated }
+func rangeOf(r Result) float64      { return r.Range }
+func truePeakOf(r Result) float64   { return r.TruePeak }
+
+// TestMeasure runs the synthetic cases of EBU Tech 3341 (minimum
+// requirements, 1-6) and Tech 3342 (loudness range, 1-4), and true-peak
+// cases in the manner of Tech 3341 15-23: sines at a quarter of the sample
+// rate or close to Nyquist whose samples miss the crests. A conforming
+// meter reads each within want-below and want+above.
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		name         string
+		signal       signal
+		quantity     func(Result) float64
+		want         float64
+		below, above float64
+	}{
+		{"3341-1", stereo(segment{20, -23}), integratedOf, -23, 0.1, 0.1},
+		{"3341-2", stereo(segment{20, -33}), integratedOf, -33, 0.1, 0.1},
+		{"3341-3", stereo(segment{10, -36}, segment{60, -23}, segment{10, -36}), integratedOf, -23, 0.1, 0.1},
+		{"3341-4", stereo(segment{10, -72}, segment{10, -36}, segment{60, -23}, segment{10, -36}, segment{10, -72}), integratedOf, -23, 0.1, 0.1},
+		{"3341-5", stereo(segment{20, -26}, segment{20.1, -20}, segment{20, -26}), integratedOf, -23, 0.1, 0.1},
+		{"3341-6", signal{
+			sampleRate:  48000,
+			channels:    5,
+			channelMask: audio.SpeakerFrontLeft | audio.SpeakerFrontRight | audio.SpeakerFrontCenter | audio.SpeakerSideLeft | audio.SpeakerSideRight,
+			frequency:   1000,
+			offsets:     []float64{0, 0, 4, -2, -2},
+			segments:    []segment{{20, -28}},
+		}, integratedOf, -23, 0.1, 0.1},
+
+		{"3342-1", stereo(segment{20, -20}, segment{20, -30}), rangeOf, 10, 1, 1},
+		{"3342-2", stereo(segment{20, -20}, segment{20, -15}), rangeOf, 5, 1, 1},
+		{"3342-3", stereo(segment{20, -40}, segment{20, -20}), rangeOf, 20, 1, 1},
+		{"3342-4", stereo(segment{20, -50}, segment{20, -35}, segment{20, -20}, segment{20, -35}, segment{20, -50}), rangeOf, 15, 1, 1},
+
+		{"tp-48k-fs/4", signal{sampleRate: 48000, channels: 2, frequency: 12000, phase: math.Pi / 4, segments: []segment{{1, -6}}}, truePeakOf, -6, 0.4, 0.2},
+		{"tp-44k1-fs/4", signal{sampleRate: 44100, channels: 2, frequency: 11025, phase: math.Pi / 4, segments: []segment{{1, 0}}}, truePeakOf, 0, 0.4, 0.2},
+		{"tp-48k-1k", signal{sampleRate: 48000, channels: 1, frequency: 1000, phase: 0.1, segments: []segment{{1, -1}}}, truePeakOf, -1, 0.4, 0.2},
+		{"tp-48k-18k", signal{sampleRate: 48000, channels: 2, frequency: 18000, phase: 0.3, segments: []segment{{1, -3}}}, truePeakOf, -3, 0.4, 0.2},
+		{"tp-96k-fs/4", signal{sampleRate: 96000, channels: 2, frequency: 24000, phase: math.Pi / 4, segments: []segment{{1, -6}}}, truePeakOf, -6, 0.4, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			r, err := Measure(tt.signal.source())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.quantity(r); !(got >= tt.want-tt.below && got <= tt.want+tt.above) {
+				t.Fatalf("measured %.2f, want %.2f (-%.1f/+%.1f)", got, tt.want, tt.below, tt.above)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package loudness
+
+import "math"
+
+// tapsPerPhase is the length of each polyphase branch of the true-peak
+// interpolator. BS.1770-4 Annex 2 sketches 12 taps at 4x; twice that keeps
+// the pass band flat close to Nyquist, where inter-sample peaks are
+// largest.
+const tapsPerPhase = 24
+
+// truePeak estimates the peak of the continuous signal by oversampling
+// each channel: 4x below 96 kHz, 2x below 192 kHz, none above
+type truePeak struct {
+	phases  [][]float64 // [phase][tap]
+	history [][]float64 // per channel, a ring of the last samples written twice over
+	pos     []int
+	peak    float64 // never below the sample peak
+	sample  float64
+}
+
+func newTruePeak(sampleRate, channels int) *truePeak {
+	factor := 4
+	switch {
+	case sampleRate >= 192000:
+		factor = 1
+	case sampleRate >= 96000:
+		factor = 2
+	}
+
+	tp := &truePeak{history: make([][]float64, channels), pos: make([]int, channels)}
+	for c := range tp.history {
+		tp.history[c] = make([]float64, 2*tapsPerPhase)
+	}
+	if factor == 1 {
+		return tp
+	}
+
+	// A Kaiser-windowed sinc low-pass at the original Nyquist frequency,
+	// split into factor branches that each interpolate one fractional
+	// position between input samples. Centred on an input sample, so the
+	// first branch passes the input through.
+	const beta = 7
+	n := tapsPerPhase * factor
+	center := float64(n / 2)
+	tp.phases = make([][]float64, factor)
+	for p := range tp.phases {
+		tp.phases[p] = make([]float64, tapsPerPhase)
+		sum := 0.0
+		for k := range tp.phases[p] {
+			m := float64(k*factor + p)
+			x := (m - center) / float64(factor)
+			r := (m - center) / center
+			h := sinc(x) * bessel0(beta*math.Sqrt(max(1-r*r, 0))) / bessel0(beta)
+			tp.phases[p][k] = h
+			sum += h
+		}
+		// Unity gain at DC in every branch
+		for k := range tp.phases[p] {
+			tp.phases[p][k] /= sum
+		}
+	}
+	return tp
+}
+
+// add takes the next sample of channel c
+func (tp *truePeak) add(c int, x float64) {
+	tp.sample = max(tp.sample, math.Abs(x))
+	tp.peak = max(tp.peak, tp.sample)
+	if tp.phases == nil {
+		return
+	}
+	// Each sample goes in both halves of the ring so the last
+	// tapsPerPhase are always contiguous, oldest first
+	p := (tp.pos[c] + 1) % tapsPerPhase
+	tp.pos[c] = p
+	h := tp.history[c]
+	h[p], h[p+tapsPerPhase] = x, x
+	window := h[p+1 : p+1+tapsPerPhase]
+	for _, taps := range tp.phases {
+		var y float64
+		for k, t := range taps {
+			y += t * window[tapsPerPhase-1-k]
+		}
+		tp.peak = max(tp.peak, math.Abs(y))
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// bessel0 is the zeroth order modified Bessel function of the first kind
+func bessel0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / 2) / float64(k)
+		sum += term * term
+		if term*term < sum*1e-16 {
+			break
+		}
+	}
+	return sum
+}
@@ -0,0 +1,61 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Cursor is the decoded form of an opaque keyset cursor. It remembers the
+// position (sort value + id of the boundary row), which way to page, and
+// the sort it was issued for so it can't be replayed against another order.
+type Cursor struct {
+	Sort  string `json:"s"`           // sort spec the cursor belongs to, e.g. "-created_at"
+	Value string `json:"v"`           // sort column value of the boundary row
+	Null  bool   `json:"n,omitempty"` // the boundary row's sort value is NULL
+	ID    int64  `json:"i"`           // id of the boundary row (tie breaker)
+	Prev  bool   `json:"p,omitempty"` // page backwards from the boundary
+}
+
+// encodeCursor serialises and signs a cursor as "<payload>.<signature>"
+func encodeCursor(secret []byte, c Cursor) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + sign(secret, body), nil
+}
+
+// decodeCursor verifies the signature and decodes a cursor produced by encodeCursor
+func decodeCursor(secret []byte, s string) (*Cursor, error) {
+	body, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(sign(secret, body))) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+func sign(secret []byte, body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
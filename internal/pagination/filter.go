@@ -0,0 +1,158 @@
+package pagination
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operator is a comparison a filter may use
+type Operator string
+
+const (
+	OpEq       Operator = "eq"
+	OpNe       Operator = "ne"
+	OpGt       Operator = "gt"
+	OpGte      Operator = "gte"
+	OpLt       Operator = "lt"
+	OpLte      Operator = "lte"
+	OpIn       Operator = "in"       // comma separated list
+	OpContains Operator = "contains" // case-insensitive substring match
+)
+
+var operatorSQL = map[Operator]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+}
+
+// Type controls how filter values are validated and converted
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Bool
+	Time // RFC 3339
+)
+
+// Filter whitelists one filterable field
+type Filter struct {
+	Column string
+	Type   Type
+	Ops    []Operator // allowed operators; defaults to eq only
+}
+
+func (f Filter) allows(op Operator) bool {
+	if len(f.Ops) == 0 {
+		return op == OpEq
+	}
+	for _, o := range f.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Condition is one parsed and validated filter
+type Condition struct {
+	Field  string
+	Column string
+	Op     Operator
+	Values []any
+}
+
+// sql renders the condition with placeholders numbered after n
+func (c Condition) sql(n int) (string, []any) {
+	switch c.Op {
+	case OpIn:
+		placeholders := make([]string, len(c.Values))
+		for i := range c.Values {
+			placeholders[i] = fmt.Sprintf("$%d", n+i+1)
+		}
+		return fmt.Sprintf("%s IN (%s)", c.Column, strings.Join(placeholders, ", ")), c.Values
+	case OpContains:
+		return fmt.Sprintf("%s ILIKE $%d ESCAPE '\\'", c.Column, n+1), c.Values
+	default:
+		return fmt.Sprintf("%s %s $%d", c.Column, operatorSQL[c.Op], n+1), c.Values
+	}
+}
+
+// parseFilters turns field=value and field[op]=value query parameters into
+// conditions. Unknown fields are ignored (they may belong to the handler),
+// but a known field with a bad operator or value is an error.
+func parseFilters(filters map[string]Filter, q url.Values) ([]Condition, error) {
+	// Sort keys so the generated SQL (and its argument order) is stable
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var conds []Condition
+	for _, key := range keys {
+		field, op := key, OpEq
+		if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+			field, op = key[:i], Operator(key[i+1:len(key)-1])
+		}
+
+		f, ok := filters[field]
+		if !ok {
+			continue
+		}
+		if !f.allows(op) {
+			return nil, fmt.Errorf("%w: operator %q not allowed on %q", ErrInvalidFilter, op, field)
+		}
+
+		for _, raw := range q[key] {
+			cond := Condition{Field: field, Column: f.Column, Op: op}
+
+			parts := []string{raw}
+			if op == OpIn {
+				parts = strings.Split(raw, ",")
+			}
+			for _, part := range parts {
+				v, err := convert(f.Type, part)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %q: %v", ErrInvalidFilter, field, err)
+				}
+				if op == OpContains {
+					v = "%" + escapeLike(part) + "%"
+				}
+				cond.Values = append(cond.Values, v)
+			}
+			conds = append(conds, cond)
+		}
+	}
+
+	return conds, nil
+}
+
+func convert(t Type, s string) (any, error) {
+	switch t {
+	case Int:
+		return strconv.ParseInt(s, 10, 64)
+	case Bool:
+		return strconv.ParseBool(s)
+	case Time:
+		return time.Parse(time.RFC3339, s)
+	default:
+		if len(s) > 256 {
+			return nil, fmt.Errorf("value too long")
+		}
+		return s, nil
+	}
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,113 @@
+package pagination
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Key identifies a row's position in the sort order
+type Key struct {
+	Value any   // value of the sort column, nil or a nil pointer for NULL
+	ID    int64 // value of the id column
+}
+
+// Page is the standard list response envelope. It is meant to be passed
+// as-is to jsonResponse, which wraps it in {"data": ...}.
+type Page[T any] struct {
+	Items      []T  `json:"items"`
+	Pagination Meta `json:"pagination"`
+}
+
+// Meta carries the cursors needed to fetch the neighbouring pages
+type Meta struct {
+	Limit   int    `json:"limit"`
+	Sort    string `json:"sort"`
+	Next    string `json:"next_cursor,omitempty"`
+	Prev    string `json:"prev_cursor,omitempty"`
+	HasMore bool   `json:"has_more"`
+}
+
+// NewPage builds the response for rows fetched with the query produced by
+// req.SQL. rows may hold one extra row (used only to detect another page);
+// key extracts the sort value and id of a row for building cursors.
+func NewPage[T any](p *Paginator, req *Request, rows []T, key func(T) Key) (*Page[T], error) {
+	backwards := req.Cursor != nil && req.Cursor.Prev
+
+	hasMore := len(rows) > req.Limit
+	if hasMore {
+		rows = rows[:req.Limit]
+	}
+
+	// Rows of a backwards page were fetched in reverse order
+	if backwards {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := &Page[T]{
+		Items: rows,
+		Pagination: Meta{
+			Limit: req.Limit,
+			Sort:  req.Sort,
+		},
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if len(rows) == 0 {
+		return page, nil
+	}
+
+	// A forward page has a next page if we over-fetched, and a previous one
+	// whenever we arrived through a cursor; backwards pages are the mirror image.
+	hasNext := hasMore
+	hasPrev := req.Cursor != nil
+	if backwards {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	var err error
+	if hasNext {
+		if page.Pagination.Next, err = p.cursorFor(req, key(rows[len(rows)-1]), false); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Pagination.Prev, err = p.cursorFor(req, key(rows[0]), true); err != nil {
+			return nil, err
+		}
+	}
+	page.Pagination.HasMore = hasNext
+
+	return page, nil
+}
+
+func (p *Paginator) cursorFor(req *Request, k Key, prev bool) (string, error) {
+	value, null := formatValue(k.Value)
+	return encodeCursor(p.cfg.Secret, Cursor{
+		Sort:  req.Sort,
+		Value: value,
+		Null:  null,
+		ID:    k.ID,
+		Prev:  prev,
+	})
+}
+
+// formatValue renders a sort value the way Postgres will parse it back.
+// Pointers are followed; nil reports a NULL sort value.
+func formatValue(v any) (string, bool) {
+	if rv := reflect.ValueOf(v); !rv.IsValid() {
+		return "", true
+	} else if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return "", true
+		}
+		v = rv.Elem().Interface()
+	}
+	if t, ok := v.(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano), false
+	}
+	return fmt.Sprint(v), false
+}
@@ -0,0 +1,188 @@
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+	ErrInvalidSort   = errors.New("invalid sort field")
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// Config describes how a single list endpoint may be paged, sorted and filtered.
+// Only the fields listed in Sorts and Filters can ever reach the SQL query.
+type Config struct {
+	Secret       []byte            // key used to sign cursors
+	DefaultLimit int               // page size when the client sends none
+	MaxLimit     int               // upper bound for the limit parameter
+	IDColumn     string            // unique tie-breaker column, e.g. "id"
+	Sorts        map[string]string // sortable API field -> SQL column
+	DefaultSort  string            // e.g. "-created_at" (leading "-" means descending)
+	Filters      map[string]Filter // filterable API field -> column definition
+}
+
+// Paginator parses list requests for one endpoint
+type Paginator struct {
+	cfg Config
+}
+
+// New creates a Paginator, filling in sensible defaults
+func New(cfg Config) *Paginator {
+	if cfg.DefaultLimit <= 0 {
+		cfg.DefaultLimit = 20
+	}
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = 100
+	}
+	if cfg.IDColumn == "" {
+		cfg.IDColumn = "id"
+	}
+	// Without an explicit default, newest ids come first
+	if cfg.DefaultSort == "" {
+		cfg.DefaultSort = "-id"
+		if cfg.Sorts == nil {
+			cfg.Sorts = map[string]string{}
+		}
+		if _, ok := cfg.Sorts["id"]; !ok {
+			cfg.Sorts["id"] = cfg.IDColumn
+		}
+	}
+	return &Paginator{cfg: cfg}
+}
+
+// Request is a validated list request
+type Request struct {
+	Limit   int
+	Sort    string // sort spec as given, e.g. "-created_at"
+	Field   string // API sort field, e.g. "created_at"
+	Column  string // SQL sort column
+	Desc    bool
+	Cursor  *Cursor
+	Filters []Condition
+
+	idColumn string
+}
+
+// Parse validates limit, sort, cursor and filter query parameters.
+// Recognised parameters are limit, sort, cursor and one entry per filter
+// in the form field=value or field[op]=value.
+func (p *Paginator) Parse(q url.Values) (*Request, error) {
+	req := &Request{
+		Limit:    p.cfg.DefaultLimit,
+		Sort:     p.cfg.DefaultSort,
+		idColumn: p.cfg.IDColumn,
+	}
+
+	// Limit
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > p.cfg.MaxLimit {
+			return nil, fmt.Errorf("%w: must be between 1 and %d", ErrInvalidLimit, p.cfg.MaxLimit)
+		}
+		req.Limit = limit
+	}
+
+	// Sort
+	if v := q.Get("sort"); v != "" {
+		req.Sort = v
+	}
+	req.Field = strings.TrimPrefix(req.Sort, "-")
+	req.Desc = strings.HasPrefix(req.Sort, "-")
+	column, ok := p.cfg.Sorts[req.Field]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSort, req.Field)
+	}
+	req.Column = column
+
+	// Cursor
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(p.cfg.Secret, v)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != req.Sort {
+			return nil, fmt.Errorf("%w: cursor was issued for a different sort", ErrInvalidCursor)
+		}
+		req.Cursor = c
+	}
+
+	// Filters
+	conds, err := parseFilters(p.cfg.Filters, q)
+	if err != nil {
+		return nil, err
+	}
+	req.Filters = conds
+
+	return req, nil
+}
+
+// SQL returns the WHERE fragment (without the keyword, "TRUE" when empty),
+// the ORDER BY fragment, the number of rows to fetch and the arguments.
+// Placeholders are numbered starting after argOffset so the fragment can be
+// appended to a query that already has its own arguments.
+//
+// One row more than Limit is fetched so NewPage can tell whether another
+// page exists.
+func (r *Request) SQL(argOffset int) (where, orderBy string, limit int, args []any) {
+	var clauses []string
+	n := argOffset
+
+	for _, c := range r.Filters {
+		clause, cargs := c.sql(n)
+		clauses = append(clauses, clause)
+		args = append(args, cargs...)
+		n += len(cargs)
+	}
+
+	// Walking backwards means flipping both the comparison and the order
+	desc := r.Desc
+	if r.Cursor != nil && r.Cursor.Prev {
+		desc = !desc
+	}
+
+	if r.Cursor != nil {
+		clause, cargs := r.keyset(n, desc)
+		clauses = append(clauses, clause)
+		args = append(args, cargs...)
+	}
+
+	where = "TRUE"
+	if len(clauses) > 0 {
+		where = strings.Join(clauses, " AND ")
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	// NULLs sort after every value, so walking backwards meets them first
+	nulls := "NULLS LAST"
+	if desc {
+		nulls = "NULLS FIRST"
+	}
+	orderBy = fmt.Sprintf("%s %s %s, %s %s", r.Column, dir, nulls, r.idColumn, dir)
+
+	return where, orderBy, r.Limit + 1, args
+}
+
+// keyset returns the predicate selecting rows past the cursor in the
+// given direction. NULL sort values count as greater than any other,
+// matching the NULLS LAST/FIRST of the ORDER BY.
+func (r *Request) keyset(n int, desc bool) (string, []any) {
+	switch {
+	case r.Cursor.Null && desc:
+		return fmt.Sprintf("(%s IS NOT NULL OR %s < $%d)", r.Column, r.idColumn, n+1), []any{r.Cursor.ID}
+	case r.Cursor.Null:
+		return fmt.Sprintf("(%s IS NULL AND %s > $%d)", r.Column, r.idColumn, n+1), []any{r.Cursor.ID}
+	case desc:
+		return fmt.Sprintf("(%s, %s) < ($%d, $%d)", r.Column, r.idColumn, n+1, n+2), []any{r.Cursor.Value, r.Cursor.ID}
+	default:
+		return fmt.Sprintf("((%s, %s) > ($%d, $%d) OR %s IS NULL)", r.Column, r.idColumn, n+1, n+2, r.Column), []any{r.Cursor.Value, r.Cursor.ID}
+	}
+}
@@ -0,0 +1,354 @@
+package pagination
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test secret")
+
+func newTestPaginator() *Paginator {
+	return New(Config{
+		Secret:      testSecret,
+		MaxLimit:    50,
+		Sorts:       map[string]string{"created_at": "t.created_at", "title": "t.title", "id": "t.id"},
+		DefaultSort: "-created_at",
+		IDColumn:    "t.id",
+		Filters: map[string]Filter{
+			"title":  {Column: "t.title", Ops: []Operator{OpEq, OpContains}},
+			"year":   {Column: "t.year", Type: Int, Ops: []Operator{OpEq, OpGte, OpIn}},
+			"public": {Column: "t.public", Type: Bool},
+		},
+	})
+}
+
+func TestDecodeCursor(t *testing.T) {
+	valid, err := encodeCursor(testSecret, Cursor{Sort: "-created_at", Value: "2024-01-02T03:04:05Z", ID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, sig, _ := strings.Cut(valid, ".")
+
+	// A forged body signed with the right key still has to decode
+	signed := func(body string) string { return body + "." + sign(testSecret, body) }
+
+	tests := []struct {
+		name   string
+		secret []byte
+		cursor string
+		want   *Cursor
+	}{
+		{"valid", testSecret, valid, &Cursor{Sort: "-created_at", Value: "2024-01-02T03:04:05Z", ID: 42}},
+		{"other secret", []byte("other"), valid, nil},
+		{"tampered body", testSecret, strings.Replace(body, body[:4], "AAAA", 1) + "." + sig, nil},
+		{"tampered signature", testSecret, body + "." + strings.Repeat("A", len(sig)), nil},
+		{"no signature", testSecret, body, nil},
+		{"empty signature", testSecret, body + ".", nil},
+		{"signed non-base64", testSecret, signed("!!!"), nil},
+		{"signed non-JSON", testSecret, signed("bm90IGpzb24"), nil},
+		{"empty", testSecret, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(tt.secret, tt.cursor)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidCursor) {
+					t.Fatalf("decodeCursor = %+v, %v, want ErrInvalidCursor", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("decodeCursor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	p := newTestPaginator()
+	cursor, err := encodeCursor(testSecret, Cursor{Sort: "title", Value: "a", ID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query     string
+		wantLimit int
+		wantSort  string
+		wantErr   error
+	}{
+		{"", 20, "-created_at", nil},
+		{"limit=1", 1, "-created_at", nil},
+		{"limit=50", 50, "-created_at", nil},
+		{"limit=51", 0, "", ErrInvalidLimit},
+		{"limit=0", 0, "", ErrInvalidLimit},
+		{"limit=-5", 0, "", ErrInvalidLimit},
+		{"limit=ten", 0, "", ErrInvalidLimit},
+		{"sort=title", 20, "title", nil},
+		{"sort=-id", 20, "-id", nil},
+		{"sort=duration", 0, "", ErrInvalidSort},
+		{"sort=" + url.QueryEscape("title; DROP TABLE tracks"), 0, "", ErrInvalidSort},
+		{"sort=" + url.QueryEscape("t.title"), 0, "", ErrInvalidSort},
+		{"sort=title&cursor=" + url.QueryEscape(cursor), 20, "title", nil},
+		{"sort=-title&cursor=" + url.QueryEscape(cursor), 0, "", ErrInvalidCursor},
+		{"cursor=garbage", 0, "", ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := p.Parse(q)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Parse = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Limit != tt.wantLimit || req.Sort != tt.wantSort {
+				t.Fatalf("limit %d sort %q, want %d %q", req.Limit, req.Sort, tt.wantLimit, tt.wantSort)
+			}
+			if _, ok := p.cfg.Sorts[req.Field]; !ok || req.Column != p.cfg.Sorts[req.Field] {
+				t.Fatalf("column %q is not the whitelisted one for %q", req.Column, req.Field)
+			}
+		})
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	p := newTestPaginator()
+	tests := []struct {
+		name      string
+		query     url.Values
+		wantWhere string
+		wantArgs  []any
+		wantErr   bool
+	}{
+		{
+			name:      "none",
+			query:     url.Values{},
+			wantWhere: "TRUE",
+		},
+		{
+			name:      "equality",
+			query:     url.Values{"title": {"Intro"}, "public": {"true"}},
+			wantWhere: "t.public = $3 AND t.title = $4",
+			wantArgs:  []any{true, "Intro"},
+		},
+		{
+			name:      "operators",
+			query:     url.Values{"year[gte]": {"1999"}, "year[in]": {"2001,2002"}},
+			wantWhere: "t.year >= $3 AND t.year IN ($4, $5)",
+			wantArgs:  []any{int64(1999), int64(2001), int64(2002)},
+		},
+		{
+			name:      "injection in a value stays an argument",
+			query:     url.Values{"title": {"x' OR '1'='1"}},
+			wantWhere: "t.title = $3",
+			wantArgs:  []any{"x' OR '1'='1"},
+		},
+		{
+			name:      "contains escapes wildcards",
+			query:     url.Values{"title[contains]": {`50%_off\`}},
+			wantWhere: `t.title ILIKE $3 ESCAPE '\'`,
+			wantArgs:  []any{`%50\%\_off\\%`},
+		},
+		{
+			name:      "unknown and injection-shaped fields are ignored",
+			query:     url.Values{"owner_id": {"1"}, "title) OR (1=1": {"x"}, "t.title": {"x"}, "title[eq] OR 1=1": {"x"}},
+			wantWhere: "TRUE",
+		},
+		{
+			name:    "operator outside the whitelist",
+			query:   url.Values{"title[gt]": {"a"}},
+			wantErr: true,
+		},
+		{
+			name:    "injection-shaped operator",
+			query:   url.Values{"title[= '' OR 1=1 --]": {"a"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad int",
+			query:   url.Values{"year": {"1999 OR 1=1"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad list element",
+			query:   url.Values{"year[in]": {"2001,x"}},
+			wantErr: true,
+		},
+		{
+			name:    "overlong string",
+			query:   url.Values{"title": {strings.Repeat("a", 257)}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := p.Parse(tt.query)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidFilter) {
+					t.Fatalf("Parse = %v, want ErrInvalidFilter", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			where, _, _, args := req.SQL(2)
+			if where != tt.wantWhere || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Fatalf("SQL = %q %v, want %q %v", where, args, tt.wantWhere, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestKeysetSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		desc      bool
+		cursor    Cursor
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			name:      "ascending",
+			cursor:    Cursor{Value: "b", ID: 7},
+			wantWhere: "((t.title, t.id) > ($1, $2) OR t.title IS NULL)",
+			wantOrder: "t.title ASC NULLS LAST, t.id ASC",
+			wantArgs:  []any{"b", int64(7)},
+		},
+		{
+			name:      "ascending from a NULL",
+			cursor:    Cursor{Null: true, ID: 7},
+			wantWhere: "(t.title IS NULL AND t.id > $1)",
+			wantOrder: "t.title ASC NULLS LAST, t.id ASC",
+			wantArgs:  []any{int64(7)},
+		},
+		{
+			name:      "descending",
+			desc:      true,
+			cursor:    Cursor{Value: "b", ID: 7},
+			wantWhere: "(t.title, t.id) < ($1, $2)",
+			wantOrder: "t.title DESC NULLS FIRST, t.id DESC",
+			wantArgs:  []any{"b", int64(7)},
+		},
+		{
+			name:      "descending from a NULL",
+			desc:      true,
+			cursor:    Cursor{Null: true, ID: 7},
+			wantWhere: "(t.title IS NOT NULL OR t.id < $1)",
+			wantOrder: "t.title DESC NULLS FIRST, t.id DESC",
+			wantArgs:  []any{int64(7)},
+		},
+		{
+			name:      "backwards through an ascending sort",
+			cursor:    Cursor{Value: "b", ID: 7, Prev: true},
+			wantWhere: "(t.title, t.id) < ($1, $2)",
+			wantOrder: "t.title DESC NULLS FIRST, t.id DESC",
+			wantArgs:  []any{"b", int64(7)},
+		},
+		{
+			name:      "backwards from a NULL through a descending sort",
+			desc:      true,
+			cursor:    Cursor{Null: true, ID: 7, Prev: true},
+			wantWhere: "(t.title IS NULL AND t.id > $1)",
+			wantOrder: "t.title ASC NULLS LAST, t.id ASC",
+			wantArgs:  []any{int64(7)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := tt.cursor
+			req := &Request{Limit: 10, Column: "t.title", Desc: tt.desc, Cursor: &cursor, idColumn: "t.id"}
+			where, orderBy, limit, args := req.SQL(0)
+			if where != tt.wantWhere || orderBy != tt.wantOrder || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Fatalf("SQL = %q, %q, %v\nwant %q, %q, %v", where, orderBy, args, tt.wantWhere, tt.wantOrder, tt.wantArgs)
+			}
+			if limit != 11 {
+				t.Fatalf("limit = %d, want one past the page", limit)
+			}
+		})
+	}
+}
+
+func TestNewPageCursors(t *testing.T) {
+	p := newTestPaginator()
+	type row struct {
+		id    int64
+		title *string
+	}
+	title := "b"
+	key := func(r row) Key { return Key{Value: r.title, ID: r.id} }
+
+	req, err := p.Parse(url.Values{"sort": {"title"}, "limit": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := NewPage(p, req, []row{{1, &title}, {2, nil}, {3, nil}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 2 || !page.Pagination.HasMore || page.Pagination.Prev != "" {
+		t.Fatalf("first page %+v", page)
+	}
+
+	// The next cursor starts after the NULL-titled row
+	next, err := p.Parse(url.Values{"sort": {"title"}, "limit": {"2"}, "cursor": {page.Pagination.Next}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := next.Cursor; !c.Null || c.ID != 2 || c.Prev {
+		t.Fatalf("next cursor = %+v, want NULL at id 2", c)
+	}
+
+	// Walking back from there gets a cursor at the first row of the page
+	page, err = NewPage(p, next, []row{{3, nil}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := p.Parse(url.Values{"sort": {"title"}, "limit": {"2"}, "cursor": {page.Pagination.Prev}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := prev.Cursor; !c.Null || c.ID != 3 || !c.Prev || page.Pagination.HasMore {
+		t.Fatalf("prev cursor = %+v, has more %v", c, page.Pagination.HasMore)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	s := "x"
+	var nilString *string
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("CET", 3600))
+	tests := []struct {
+		in       any
+		want     string
+		wantNull bool
+	}{
+		{nil, "", true},
+		{nilString, "", true},
+		{&s, "x", false},
+		{int64(5), "5", false},
+		{ts, "2024-01-02T02:04:05.000000006Z", false},
+		{&ts, "2024-01-02T02:04:05.000000006Z", false},
+	}
+	for _, tt := range tests {
+		got, null := formatValue(tt.in)
+		if got != tt.want || null != tt.wantNull {
+			t.Errorf("formatValue(%#v) = %q, %v, want %q, %v", tt.in, got, null, tt.want, tt.wantNull)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pcm
+
+import (
+	"audio-go/internal/audio"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Decode probes a WAV, AIFF or FLAC file and returns its audio as frames
+func Decode(r io.ReaderAt, size int64) (Source, error) {
+	info, err := audio.Probe(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecoder(r, info)
+}
+
+// NewDecoder decodes the file info was probed from. Uncompressed PCM
+// (integer, float, A-law and μ-law) and FLAC are supported.
+func NewDecoder(r io.ReaderAt, info *audio.Info) (Source, error) {
+	if info.Channels <= 0 || info.SampleRate <= 0 {
+		return nil, fmt.Errorf("%w: %d channels at %d Hz", ErrUnsupported, info.Channels, info.SampleRate)
+	}
+	format := Format{
+		SampleRate:  info.SampleRate,
+		Channels:    info.Channels,
+		ChannelMask: info.ChannelMask,
+		Frames:      info.Frames,
+	}
+	if format.ChannelMask == 0 {
+		format.ChannelMask = audio.DefaultChannelMask(info.Channels)
+	}
+	data := io.NewSectionReader(r, info.DataOffset, info.DataSize)
+
+	if info.Codec == "flac" {
+		blocks, err := audio.NewFLACBlocks(data, info)
+		if err != nil {
+			return nil, err
+		}
+		if format.Frames == 0 {
+			// STREAMINFO leaves the length unset for streamed encodes
+			format.Frames = -1
+		}
+		return &flacSource{format: format, blocks: blocks, scale: 1 / float32(int64(1)<<(info.BitDepth-1))}, nil
+	}
+
+	enc, err := parseSampleEncoding(info.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return &pcmSource{
+		format: format,
+		enc:    enc,
+		r:      bufio.NewReaderSize(data, 64<<10),
+		left:   info.Frames,
+	}, nil
+}
+
+// sampleEncoding is how one sample is stored
+type sampleEncoding struct {
+	kind      byte // 's' signed, 'u' unsigned, 'f' float, 'a' A-law, 'm' μ-law
+	bytes     int
+	bigEndian bool
+}
+
+// parseSampleEncoding reads an audio.Info codec name, e.g. "pcm_s24le"
+func parseSampleEncoding(codec string) (sampleEncoding, error) {
+	switch codec {
+	case "pcm_alaw":
+		return sampleEncoding{kind: 'a', bytes: 1}, nil
+	case "pcm_mulaw":
+		return sampleEncoding{kind: 'm', bytes: 1}, nil
+	}
+	name, ok := strings.CutPrefix(codec, "pcm_")
+	if !ok || len(name) < 2 {
+		return sampleEncoding{}, fmt.Errorf("%w: codec %q", ErrUnsupported, codec)
+	}
+	enc := sampleEncoding{kind: name[0]}
+	name = name[1:]
+	if n, ok := strings.CutSuffix(name, "be"); ok {
+		name, enc.bigEndian = n, true
+	} else {
+		name = strings.TrimSuffix(name, "le")
+	}
+	bits, err := strconv.Atoi(name)
+	enc.bytes = bits / 8
+	switch {
+	case err != nil || bits%8 != 0:
+	case enc.kind == 's' && bits >= 8 && bits <= 32, enc.kind == 'u' && bits == 8, enc.kind == 'f' && (bits == 32 || bits == 64):
+		return enc, nil
+	}
+	return sampleEncoding{}, fmt.Errorf("%w: codec %q", ErrUnsupported, codec)
+}
+
+// pcmSource decodes uncompressed samples
+type pcmSource struct {
+	format Format
+	enc    sampleEncoding
+	r      io.Reader
+	left   int64 // frames not yet read
+	raw    []byte
+}
+
+func (s *pcmSource) Format() Format {
+	return s.format
+}
+
+func (s *pcmSource) ReadFrames(buf []float32) (int, error) {
+	ch := s.format.Channels
+	n := int(min(int64(len(buf)/ch), s.left))
+	if n == 0 {
+		if s.left == 0 {
+			return 0, io.EOF
+		}
+		return 0, nil
+	}
+
+	frameBytes := ch * s.enc.bytes
+	if cap(s.raw) < n*frameBytes {
+		s.raw = make([]byte, n*frameBytes)
+	}
+	raw := s.raw[:n*frameBytes]
+	if _, err := io.ReadFull(s.r, raw); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, fmt.Errorf("pcm: reading %d frames: %w", n, err)
+	}
+	s.left -= int64(n)
+
+	for i := range buf[:n*ch] {
+		buf[i] = s.enc.decode(raw[i*s.enc.bytes:][:s.enc.bytes])
+	}
+	return n, nil
+}
+
+// decode converts one sample to float32
+func (e sampleEncoding) decode(b []byte) float32 {
+	switch e.kind {
+	case 'u':
+		return float32(int(b[0])-128) / 128
+	case 'a':
+		return float32(alaw(b[0])) / 32768
+	case 'm':
+		return float32(mulaw(b[0])) / 32768
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if e.bigEndian {
+		order = binary.BigEndian
+	}
+	if e.kind == 'f' {
+		if e.bytes == 8 {
+			return float32(math.Float64frombits(order.Uint64(b)))
+		}
+		return math.Float32frombits(order.Uint32(b))
+	}
+
+	// Signed integers of any width, read into the top of an int32
+	var v uint32
+	for i := 0; i < e.bytes; i++ {
+		k := i
+		if !e.bigEndian {
+			k = e.bytes - 1 - i
+		}
+		v |= uint32(b[k]) << (24 - 8*i)
+	}
+	return float32(int32(v)) / (1 << 31)
+}
+
+// alaw expands a G.711 A-law byte to 16-bit linear
+func alaw(b byte) int16 {
+	b ^= 0x55
+	t := int16(b&0x0F)<<4 + 8
+	if seg := (b & 0x70) >> 4; seg > 0 {
+		t = (t + 0x100) << (seg - 1)
+	}
+	if b&0x80 == 0 {
+		return -t
+	}
+	return t
+}
+
+// mulaw expands a G.711 μ-law byte to 16-bit linear
+func mulaw(b byte) int16 {
+	b = ^b
+	t := (int16(b&0x0F)<<3 + 0x84) << ((b & 0x70) >> 4)
+	if b&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+// flacSource adapts decoded FLAC blocks to frames
+type flacSource struct {
+	format Format
+	blocks *audio.FLACBlocks
+	scale  float32
+	block  [][]int64
+	pos    int // next frame of block
+}
+
+func (s *flacSource) Format() Format {
+	return s.format
+}
+
+func (s *flacSource) ReadFrames(buf []float32) (int, error) {
+	ch := s.format.Channels
+	for len(s.block) == 0 || s.pos == len(s.block[0]) {
+		block, err := s.blocks.Next()
+		if err != nil {
+			return 0, err
+		}
+		if len(block) != ch {
+			return 0, fmt.Errorf("pcm: FLAC block has %d channels, stream has %d", len(block), ch)
+		}
+		s.block, s.pos = block, 0
+	}
+
+	n := min(len(buf)/ch, len(s.block[0])-s.pos)
+	for i := 0; i < n; i++ {
+		for c := 0; c < ch; c++ {
+			buf[i*ch+c] = float32(s.block[c][s.pos+i]) * s.scale
+		}
+	}
+	s.pos += n
+	return n, nil
+}
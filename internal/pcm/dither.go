@@ -0,0 +1,46 @@
+package pcm
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// ditherer quantizes to a bit depth with TPDF dither
+type ditherer struct {
+	src   Source
+	steps float32 // quantization steps per unit, 2^(bits-1)
+	rng   *rand.Rand
+}
+
+// Dither reduces src to the given bit depth: samples are rounded to
+// multiples of 2^-(bits-1) after adding triangular (TPDF) dither of ±1
+// LSB, which decorrelates the quantization error from the signal. An
+// integer encoder at that depth then stores them exactly. The noise is
+// seeded, so the output is reproducible.
+func Dither(src Source, bits int) (Source, error) {
+	// float32 samples hold up to 24 bits exactly
+	if bits < 2 || bits > 24 {
+		return nil, fmt.Errorf("%w: dither to %d bits", ErrUnsupported, bits)
+	}
+	return &ditherer{
+		src:   src,
+		steps: float32(int64(1) << (bits - 1)),
+		rng:   rand.New(rand.NewPCG(0x5eed, uint64(bits))),
+	}, nil
+}
+
+func (d *ditherer) Format() Format {
+	return d.src.Format()
+}
+
+func (d *ditherer) ReadFrames(buf []float32) (int, error) {
+	n, err := d.src.ReadFrames(buf)
+	hi := (d.steps - 1) / d.steps
+	for i, v := range buf[:n*d.src.Format().Channels] {
+		noise := d.rng.Float32() - d.rng.Float32()
+		q := float32(math.Round(float64(v*d.steps+noise))) / d.steps
+		buf[i] = min(max(q, -1), hi)
+	}
+	return n, err
+}
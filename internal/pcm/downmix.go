@@ -0,0 +1,132 @@
+package pcm
+
+import (
+	"audio-go/internal/audio"
+	"fmt"
+)
+
+// Mixing levels of ITU-R BS.775: -3 dB for centre and surround channels
+const (
+	minus3dB = 0.70710678
+	minus6dB = 0.5
+)
+
+// stereoLevels is how much of each speaker goes to the left and right
+var stereoLevels = map[uint32][2]float32{
+	audio.SpeakerFrontLeft:          {1, 0},
+	audio.SpeakerFrontRight:         {0, 1},
+	audio.SpeakerFrontCenter:        {minus3dB, minus3dB},
+	audio.SpeakerLowFrequency:       {0, 0},
+	audio.SpeakerBackLeft:           {minus3dB, 0},
+	audio.SpeakerBackRight:          {0, minus3dB},
+	audio.SpeakerFrontLeftOfCenter:  {1, 0},
+	audio.SpeakerFrontRightOfCenter: {0, 1},
+	audio.SpeakerBackCenter:         {minus6dB, minus6dB},
+	audio.SpeakerSideLeft:           {minus3dB, 0},
+	audio.SpeakerSideRight:          {0, minus3dB},
+}
+
+// mixer applies a channel matrix
+type mixer struct {
+	src    Source
+	format Format
+	matrix [][]float32 // [out][in]
+	in     []float32
+}
+
+// Downmix mixes src down to mono or stereo. Speakers are placed by the
+// channel mask (the default layout for the count if there is none) and
+// mixed at BS.775 levels, the LFE dropped; the matrix is then scaled so a
+// full-scale signal on every channel cannot clip. Mono is duplicated to
+// stereo, and a stream already at the requested count is returned as is.
+func Downmix(src Source, channels int) (Source, error) {
+	in := src.Format()
+	switch {
+	case channels == in.Channels:
+		return src, nil
+	case channels != 1 && channels != 2:
+		return nil, fmt.Errorf("%w: down-mix to %d channels", ErrUnsupported, channels)
+	}
+
+	// Left and right levels of each input channel
+	stereo := make([][2]float32, in.Channels)
+	mask := in.ChannelMask
+	if mask == 0 {
+		mask = audio.DefaultChannelMask(in.Channels)
+	}
+	for c := range stereo {
+		switch {
+		case in.Channels == 1:
+			stereo[c] = [2]float32{1, 1}
+		case mask != 0:
+			speaker := mask & -mask
+			mask &^= speaker
+			levels, ok := stereoLevels[speaker]
+			if !ok {
+				levels = [2]float32{minus3dB, minus3dB}
+			}
+			stereo[c] = levels
+		default:
+			// Unplaced channels alternate between left and right
+			stereo[c][c%2] = 1
+		}
+	}
+
+	m := &mixer{src: src, format: in}
+	m.format.Channels = channels
+	if channels == 1 {
+		row := make([]float32, in.Channels)
+		for c, lr := range stereo {
+			row[c] = (lr[0] + lr[1]) / 2
+		}
+		m.matrix = [][]float32{row}
+		m.format.ChannelMask = audio.SpeakerFrontCenter
+	} else {
+		m.matrix = [][]float32{make([]float32, in.Channels), make([]float32, in.Channels)}
+		for c, lr := range stereo {
+			m.matrix[0][c], m.matrix[1][c] = lr[0], lr[1]
+		}
+		m.format.ChannelMask = audio.SpeakerFrontLeft | audio.SpeakerFrontRight
+	}
+
+	var peak float32
+	for _, row := range m.matrix {
+		var sum float32
+		for _, v := range row {
+			sum += v
+		}
+		peak = max(peak, sum)
+	}
+	if peak > 1 {
+		for _, row := range m.matrix {
+			for c := range row {
+				row[c] /= peak
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *mixer) Format() Format {
+	return m.format
+}
+
+func (m *mixer) ReadFrames(buf []float32) (int, error) {
+	inCh, outCh := len(m.matrix[0]), m.format.Channels
+	frames := min(len(buf)/outCh, blockFrames)
+	if cap(m.in) < frames*inCh {
+		m.in = make([]float32, frames*inCh)
+	}
+	n, err := m.src.ReadFrames(m.in[:frames*inCh])
+	for i := 0; i < n; i++ {
+		frame := m.in[i*inCh:][:inCh]
+		for o, row := range m.matrix {
+			var acc float32
+			for c, v := range frame {
+				acc += row[c] * v
+			}
+			buf[i*outCh+o] = acc
+		}
+	}
+	return n, err
+}
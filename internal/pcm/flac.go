@@ -0,0 +1,510 @@
+package pcm
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	flacBlockSize     = 4096
+	flacMaxPartitions = 8 // partition order limit of the residual coding
+)
+
+// FLACOptions selects the sample format of EncodeFLAC
+type FLACOptions struct {
+	BitDepth int // 8 to 24
+}
+
+// EncodeFLAC writes src as a FLAC stream of fixed-size blocks and returns
+// the number of frames written. Each channel is coded with the cheapest of
+// a constant, a fixed predictor of order 0 to 4 or verbatim samples, and
+// stereo with the cheapest of the four channel decorrelations. Samples are
+// rounded and clipped: reduce the bit depth with Dither first.
+//
+// If w is an io.WriteSeeker, the STREAMINFO block is completed at the end
+// with the frame sizes, sample count and MD5 of the audio. Otherwise it
+// carries the format's frame count, if known, and no MD5.
+func EncodeFLAC(w io.Writer, src Source, opts FLACOptions) (int64, error) {
+	format := src.Format()
+	if opts.BitDepth < 8 || opts.BitDepth > 24 {
+		return 0, fmt.Errorf("%w: %d-bit FLAC", ErrUnsupported, opts.BitDepth)
+	}
+	if format.Channels < 1 || format.Channels > 8 || format.SampleRate <= 0 || format.SampleRate >= 1<<20 {
+		return 0, fmt.Errorf("%w: FLAC of %d channels at %d Hz", ErrUnsupported, format.Channels, format.SampleRate)
+	}
+
+	e := &flacEncoder{
+		w:       w,
+		format:  format,
+		bps:     opts.BitDepth,
+		md5:     md5.New(),
+		samples: make([][]int64, format.Channels),
+		minSize: math.MaxUint32,
+	}
+	for ch := range e.samples {
+		e.samples[ch] = make([]int64, flacBlockSize)
+	}
+	if _, err := e.w.Write(e.streamInfo(max(format.Frames, 0), nil)); err != nil {
+		return 0, err
+	}
+
+	buf := make([]float32, flacBlockSize*format.Channels)
+	fill := 0 // frames buffered for the next block
+	for {
+		n, err := src.ReadFrames(buf[fill*format.Channels:])
+		fill += n
+		if fill == flacBlockSize || (err == io.EOF && fill > 0) {
+			if err := e.writeBlock(buf[:fill*format.Channels], fill); err != nil {
+				return e.frames, err
+			}
+			fill = 0
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return e.frames, err
+		}
+	}
+
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		if format.Frames >= 0 && format.Frames != e.frames {
+			return e.frames, fmt.Errorf("pcm: stream declared %d frames but had %d", format.Frames, e.frames)
+		}
+		return e.frames, nil
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return e.frames, err
+	}
+	if _, err := ws.Write(e.streamInfo(e.frames, e.md5.Sum(nil))); err != nil {
+		return e.frames, err
+	}
+	_, err := ws.Seek(0, io.SeekEnd)
+	return e.frames, err
+}
+
+type flacEncoder struct {
+	w                io.Writer
+	format           Format
+	bps              int
+	md5              hash.Hash
+	samples          [][]int64 // per channel, of the current block
+	frames           int64
+	blocks           int
+	minSize, maxSize int
+	raw              []byte
+	bw               flacBitWriter
+}
+
+// streamInfo returns the stream marker and the STREAMINFO block
+func (e *flacEncoder) streamInfo(frames int64, sum []byte) []byte {
+	b := append([]byte("fLaC"), 0x80, 0, 0, 34) // last metadata block, STREAMINFO
+	b = binary.BigEndian.AppendUint16(b, flacBlockSize)
+	b = binary.BigEndian.AppendUint16(b, flacBlockSize)
+	minSize, maxSize := e.minSize, e.maxSize
+	if e.blocks == 0 {
+		minSize = 0
+	}
+	b = append(b, byte(minSize>>16), byte(minSize>>8), byte(minSize))
+	b = append(b, byte(maxSize>>16), byte(maxSize>>8), byte(maxSize))
+	packed := uint64(e.format.SampleRate)<<44 | uint64(e.format.Channels-1)<<41 | uint64(e.bps-1)<<36 | uint64(frames)&(1<<36-1)
+	b = binary.BigEndian.AppendUint64(b, packed)
+	if sum == nil {
+		sum = make([]byte, md5.Size)
+	}
+	return append(b, sum...)
+}
+
+// writeBlock quantizes and encodes n interleaved frames as one FLAC frame
+func (e *flacEncoder) writeBlock(buf []float32, n int) error {
+	ch := e.format.Channels
+	steps := float64(int64(1) << (e.bps - 1))
+	bytesPerSample := (e.bps + 7) / 8
+
+	// MD5 is over interleaved little-endian samples, as decoders check it
+	e.raw = e.raw[:0]
+	for i := 0; i < n; i++ {
+		for c := 0; c < ch; c++ {
+			q := int64(math.Round(float64(buf[i*ch+c]) * steps))
+			q = min(max(q, int64(-steps)), int64(steps)-1)
+			e.samples[c][i] = q
+			for b := 0; b < bytesPerSample; b++ {
+				e.raw = append(e.raw, byte(q>>(8*b)))
+			}
+		}
+	}
+	e.md5.Write(e.raw)
+
+	bw := &e.bw
+	bw.reset()
+	bw.bits(0x3FFE, 14) // sync
+	bw.bits(0, 2)       // reserved, fixed blocking
+	blockCode, extra := uint64(12), 0
+	if n != flacBlockSize {
+		blockCode, extra = 7, 16
+	}
+	bw.bits(blockCode, 4)
+	bw.bits(0, 4) // sample rate from STREAMINFO
+
+	// Channels: independent, or the cheapest stereo decorrelation
+	assignment := ch - 1
+	subframes := make([][]int64, ch)
+	for c := range subframes {
+		subframes[c] = e.samples[c][:n]
+	}
+	sideBits := make([]int, ch)
+	if ch == 2 {
+		assignment, subframes, sideBits = chooseStereo(subframes[0], subframes[1])
+	}
+	bw.bits(uint64(assignment), 4)
+	bw.bits(flacSizeCode(e.bps), 3)
+	bw.bits(0, 1)
+	bw.utf8(uint64(e.blocks))
+	if extra > 0 {
+		bw.bits(uint64(n-1), extra)
+	}
+	bw.bits(uint64(bw.crc8), 8)
+
+	for c, s := range subframes {
+		writeSubframe(bw, s, e.bps+sideBits[c])
+	}
+	bw.align()
+	bw.bits(uint64(bw.crc16), 16)
+
+	frame := bw.bytes()
+	if _, err := e.w.Write(frame); err != nil {
+		return err
+	}
+	e.minSize, e.maxSize = min(e.minSize, len(frame)), max(e.maxSize, len(frame))
+	e.frames += int64(n)
+	e.blocks++
+	return nil
+}
+
+// flacSizeCode is the frame header's sample size code, 0 meaning "as in
+// STREAMINFO" for depths without one
+func flacSizeCode(bps int) uint64 {
+	switch bps {
+	case 8:
+		return 1
+	case 12:
+		return 2
+	case 16:
+		return 4
+	case 20:
+		return 5
+	case 24:
+		return 6
+	}
+	return 0
+}
+
+// chooseStereo picks the channel assignment whose residuals are smallest:
+// left/right, left/side, side/right or mid/side. The side channel needs
+// one more bit.
+func chooseStereo(l, r []int64) (int, [][]int64, []int) {
+	n := len(l)
+	side := make([]int64, n)
+	mid := make([]int64, n)
+	for i := range l {
+		side[i] = l[i] - r[i]
+		mid[i] = (l[i] + r[i]) >> 1
+	}
+	cost := func(s []int64) int {
+		_, size := bestFixedOrder(s)
+		return size
+	}
+	left, right, sideCost, midCost := cost(l), cost(r), cost(side), cost(mid)
+
+	best, assignment := left+right, 1
+	if c := left + sideCost; c < best {
+		best, assignment = c, 8
+	}
+	if c := sideCost + right; c < best {
+		best, assignment = c, 9
+	}
+	if c := midCost + sideCost; c < best {
+		assignment = 10
+	}
+	switch assignment {
+	case 8:
+		return 8, [][]int64{l, side}, []int{0, 1}
+	case 9:
+		return 9, [][]int64{side, r}, []int{1, 0}
+	case 10:
+		return 10, [][]int64{mid, side}, []int{0, 1}
+	}
+	return 1, [][]int64{l, r}, []int{0, 0}
+}
+
+// fixedResidual computes the residual of a fixed predictor of the given
+// order into res, which must have room for len(s)-order values
+func fixedResidual(s []int64, order int, res []int64) []int64 {
+	res = res[:0]
+	for i := order; i < len(s); i++ {
+		var p int64
+		switch order {
+		case 1:
+			p = s[i-1]
+		case 2:
+			p = 2*s[i-1] - s[i-2]
+		case 3:
+			p = 3*s[i-1] - 3*s[i-2] + s[i-3]
+		case 4:
+			p = 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+		}
+		res = append(res, s[i]-p)
+	}
+	return res
+}
+
+// bestFixedOrder picks the fixed predictor with the smallest sum of
+// absolute residuals, returning its order and an estimate of the bits
+func bestFixedOrder(s []int64) (int, int) {
+	if len(s) <= 4 {
+		return 0, len(s) * 64
+	}
+	var sums [5]uint64
+	for i := 4; i < len(s); i++ {
+		// Residuals of orders 0 to 4 as repeated differences
+		e0 := s[i]
+		e1 := e0 - s[i-1]
+		e2 := e1 - (s[i-1] - s[i-2])
+		e3 := e2 - (s[i-1] - 2*s[i-2] + s[i-3])
+		e4 := e3 - (s[i-1] - 3*s[i-2] + 3*s[i-3] - s[i-4])
+		sums[0] += uint64(abs(e0))
+		sums[1] += uint64(abs(e1))
+		sums[2] += uint64(abs(e2))
+		sums[3] += uint64(abs(e3))
+		sums[4] += uint64(abs(e4))
+	}
+	best := 0
+	for o := range sums {
+		if sums[o] < sums[best] {
+			best = o
+		}
+	}
+	// Rice coding costs about log2 of the mean residual plus two bits each
+	mean := float64(sums[best])/float64(len(s)-4) + 1
+	return best, int(float64(len(s)) * (math.Log2(mean) + 2))
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// writeSubframe codes one channel as a constant, a fixed predictor or
+// verbatim, whichever is shortest
+func writeSubframe(bw *flacBitWriter, s []int64, bps int) {
+	constant := true
+	for _, v := range s[1:] {
+		if v != s[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.bits(0, 8) // padding bit, CONSTANT, no wasted bits
+		bw.signed(s[0], bps)
+		return
+	}
+
+	order, _ := bestFixedOrder(s)
+	order = min(order, len(s)-1)
+	res := fixedResidual(s, order, make([]int64, 0, len(s)))
+	partOrder, params, size := bestPartitions(res, len(s), order)
+	if verbatim := len(s) * bps; size+order*bps >= verbatim {
+		bw.bits(1<<1, 8) // VERBATIM
+		for _, v := range s {
+			bw.signed(v, bps)
+		}
+		return
+	}
+
+	bw.bits(uint64(8|order)<<1, 8) // FIXED of the order
+	for _, v := range s[:order] {
+		bw.signed(v, bps)
+	}
+	wide := false
+	for _, k := range params {
+		wide = wide || k >= 15
+	}
+	paramBits := 4
+	if wide {
+		bw.bits(1, 2) // RICE2
+		paramBits = 5
+	} else {
+		bw.bits(0, 2)
+	}
+	bw.bits(uint64(partOrder), 4)
+
+	parts := 1 << partOrder
+	per := len(s) >> partOrder
+	pos := 0
+	for p := 0; p < parts; p++ {
+		count := per
+		if p == 0 {
+			count -= order
+		}
+		k := params[p]
+		bw.bits(uint64(k), paramBits)
+		for _, v := range res[pos : pos+count] {
+			bw.rice(v, k)
+		}
+		pos += count
+	}
+}
+
+// bestPartitions chooses the partition order and Rice parameters that code
+// the residual in the fewest bits
+func bestPartitions(res []int64, blockSize, predOrder int) (int, []int, int) {
+	bestOrder, bestSize := 0, math.MaxInt
+	var bestParams []int
+	for po := 0; po <= flacMaxPartitions; po++ {
+		parts := 1 << po
+		if blockSize%parts != 0 || blockSize>>po < predOrder {
+			break
+		}
+		per := blockSize >> po
+		params := make([]int, parts)
+		size := 0
+		pos := 0
+		for p := 0; p < parts; p++ {
+			count := per
+			if p == 0 {
+				count -= predOrder
+			}
+			var sum uint64
+			for _, v := range res[pos : pos+count] {
+				sum += zigzag(v)
+			}
+			k := riceParam(sum, count)
+			params[p] = k
+			size += 5 + count*(k+1) + int(sum>>uint(k))
+			pos += count
+		}
+		if size < bestSize {
+			bestOrder, bestSize, bestParams = po, size, params
+		}
+	}
+	return bestOrder, bestParams, bestSize + 6
+}
+
+// riceParam estimates the best Rice parameter for count values summing to
+// sum after zigzag folding
+func riceParam(sum uint64, count int) int {
+	if count == 0 || sum <= uint64(count) {
+		return 0
+	}
+	k := bits.Len64(sum/uint64(count)) - 1
+	return min(max(k, 0), 30)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// flacBitWriter builds a frame MSB first, keeping its CRC-8 and CRC-16
+type flacBitWriter struct {
+	buf   []byte
+	acc   uint64
+	n     uint
+	crc8  uint8
+	crc16 uint16
+}
+
+func (bw *flacBitWriter) reset() {
+	bw.buf, bw.acc, bw.n, bw.crc8, bw.crc16 = bw.buf[:0], 0, 0, 0, 0
+}
+
+func (bw *flacBitWriter) bits(v uint64, n int) {
+	for n > 0 {
+		take := min(n, 32)
+		n -= take
+		bw.acc = bw.acc<<uint(take) | (v>>uint(n))&(1<<uint(take)-1)
+		bw.n += uint(take)
+		for bw.n >= 8 {
+			bw.n -= 8
+			bw.byte(byte(bw.acc >> bw.n))
+		}
+	}
+}
+
+func (bw *flacBitWriter) byte(c byte) {
+	bw.buf = append(bw.buf, c)
+	bw.crc8 = flacCRC8[bw.crc8^c]
+	bw.crc16 = bw.crc16<<8 ^ flacCRC16[byte(bw.crc16>>8)^c]
+}
+
+func (bw *flacBitWriter) signed(v int64, n int) {
+	bw.bits(uint64(v)&(1<<uint(n)-1), n)
+}
+
+// rice writes v zigzag folded: the quotient in unary, then k low bits
+func (bw *flacBitWriter) rice(v int64, k int) {
+	u := zigzag(v)
+	for q := u >> uint(k); q > 0; {
+		take := min(q, 32)
+		bw.bits(0, int(take))
+		q -= take
+	}
+	bw.bits(1, 1)
+	bw.bits(u, k)
+}
+
+// utf8 writes a frame number in FLAC's extended UTF-8 coding
+func (bw *flacBitWriter) utf8(v uint64) {
+	if v < 0x80 {
+		bw.bits(v, 8)
+		return
+	}
+	n := (bits.Len64(v) - 2) / 5 // continuation bytes, each carrying 6 bits
+	bw.bits(uint64(0xFF00>>(n+1))&0xFF|v>>(6*n), 8)
+	for i := n - 1; i >= 0; i-- {
+		bw.bits(0x80|v>>(6*i)&0x3F, 8)
+	}
+}
+
+func (bw *flacBitWriter) align() {
+	if bw.n > 0 {
+		bw.bits(0, int(8-bw.n))
+	}
+}
+
+func (bw *flacBitWriter) bytes() []byte {
+	return bw.buf
+}
+
+var (
+	flacCRC8  [256]uint8
+	flacCRC16 [256]uint16
+)
+
+func init() {
+	// CRC-8 (poly 0x07) and CRC-16 (poly 0x8005), MSB first
+	for i := 0; i < 256; i++ {
+		c8, c16 := uint8(i), uint16(i)<<8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		flacCRC8[i], flacCRC16[i] = c8, c16
+	}
+}
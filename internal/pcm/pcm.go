@@ -0,0 +1,31 @@
+// Package pcm processes decoded audio as streams of float32 frames: it
+// decodes PCM and FLAC files, resamples, reduces bit depth with dither,
+// down-mixes and encodes WAV. Every stage pulls a bounded number of frames
+// at a time, so files of any length are processed in constant memory.
+package pcm
+
+import "errors"
+
+// ErrUnsupported is returned for audio the package cannot decode or produce
+var ErrUnsupported = errors.New("unsupported sample format")
+
+// Format describes a stream of frames
+type Format struct {
+	SampleRate  int
+	Channels    int
+	ChannelMask uint32 // speaker positions as in WAVE_FORMAT_EXTENSIBLE, 0 if unknown
+	Frames      int64  // total frames, -1 if unknown
+}
+
+// Source is a stream of PCM frames. Samples are interleaved float32, full
+// scale being [-1, 1).
+type Source interface {
+	Format() Format
+
+	// ReadFrames reads up to len(buf)/Channels frames into buf and returns
+	// how many it read. At the end of the stream it returns 0, io.EOF.
+	ReadFrames(buf []float32) (int, error)
+}
+
+// blockFrames is how many frames stages read from their source at once
+const blockFrames = 4096
@@ -0,0 +1,208 @@
+package pcm
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// Filter design: the stopband starts at the lower Nyquist frequency and
+	// is attenuated by stopbandDB, the passband ends transitionWidth (as a
+	// fraction of that frequency) below it
+	stopbandDB      = 100
+	transitionWidth = 0.09
+
+	// maxPhases bounds the filter table. Ratios with more phases, like
+	// 44100:47999, interpolate between neighbouring phases.
+	maxPhases = 512
+)
+
+// resampler converts the sample rate with a polyphase windowed-sinc
+// filter. Output frame n lies at input position n·m/l.
+type resampler struct {
+	src      Source
+	format   Format
+	l, m     int64
+	half     int       // taps on each side of the output position
+	phases   int       // rows of table, less one
+	table    []float32 // phases+1 rows of 2·half taps
+	coeffs   []float32 // taps of the current output frame
+	buf      []float32 // input frames from base on, interleaved
+	base     int64
+	next     int64 // output frame
+	inFrames int64 // input frames, once the source has ended
+	eof      bool
+}
+
+// Resample converts src to the given sample rate with a band-limited
+// (Kaiser-windowed sinc) filter: about 100 dB of stopband attenuation from
+// the lower of the two Nyquist frequencies, and a flat passband up to 91%
+// of it (20 kHz at 44.1 kHz)
+func Resample(src Source, rate int) (Source, error) {
+	in := src.Format()
+	if rate <= 0 {
+		return nil, fmt.Errorf("%w: sample rate %d", ErrUnsupported, rate)
+	}
+	if rate == in.SampleRate {
+		return src, nil
+	}
+
+	g := gcd(int64(in.SampleRate), int64(rate))
+	r := &resampler{
+		src:      src,
+		l:        int64(rate) / g,
+		m:        int64(in.SampleRate) / g,
+		inFrames: -1,
+	}
+	r.format = in
+	r.format.SampleRate = rate
+	if in.Frames >= 0 {
+		r.format.Frames = (in.Frames*r.l + r.m - 1) / r.m
+	}
+
+	// Cutoff relative to the input Nyquist frequency, and the Kaiser
+	// window length for the transition band it leaves
+	scale := min(1, float64(rate)/float64(in.SampleRate))
+	cutoff := scale * (1 - transitionWidth/2)
+	width := scale * transitionWidth / 2 // cycles per input sample
+	taps := (stopbandDB - 7.95) / (14.36 * width)
+	r.half = int(math.Ceil(taps / 2))
+	beta := 0.1102 * (stopbandDB - 8.7)
+
+	r.phases = int(min(r.l, maxPhases))
+	n := 2 * r.half
+	r.table = make([]float32, (r.phases+1)*n)
+	r.coeffs = make([]float32, n)
+	w := make([]float64, n)
+	for p := 0; p <= r.phases; p++ {
+		frac := float64(p) / float64(r.phases)
+		row := r.table[p*n : (p+1)*n]
+		var sum float64
+		for j := range w {
+			// Distance from the output position to input half-1-j frames on
+			t := frac + float64(r.half-1-j)
+			w[j] = cutoff * sinc(cutoff*t) * kaiser(t/float64(r.half), beta)
+			sum += w[j]
+		}
+		for j := range row {
+			row[j] = float32(w[j] / sum) // unity gain at DC in every phase
+		}
+	}
+	return r, nil
+}
+
+func (r *resampler) Format() Format {
+	return r.format
+}
+
+func (r *resampler) ReadFrames(out []float32) (int, error) {
+	ch := r.format.Channels
+	taps := 2 * r.half
+	n := 0
+	for n < len(out)/ch {
+		if r.eof && r.next*r.m >= r.inFrames*r.l {
+			break
+		}
+		pos := r.next * r.m
+		i0, ph := pos/r.l, pos%r.l
+		first := i0 - int64(r.half) + 1
+
+		if !r.eof && first+int64(taps) > r.base+int64(len(r.buf)/ch) {
+			if err := r.fill(first); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		coeffs := r.coeffs
+		if int64(r.phases) == r.l {
+			coeffs = r.table[ph*int64(taps):][:taps]
+		} else {
+			f := float64(ph) / float64(r.l) * float64(r.phases)
+			p := int(f)
+			f -= float64(p)
+			a, b := r.table[p*taps:][:taps], r.table[(p+1)*taps:][:taps]
+			for j := range coeffs {
+				coeffs[j] = a[j] + float32(f)*(b[j]-a[j])
+			}
+		}
+
+		// Taps outside the buffered input (before the start, after the end)
+		// see silence
+		lo := max(0, int(first-r.base))
+		hi := min(len(r.buf)/ch, int(first-r.base)+taps)
+		frame := out[n*ch : (n+1)*ch]
+		for c := range frame {
+			var acc float32
+			for k := lo; k < hi; k++ {
+				acc += coeffs[k-int(first-r.base)] * r.buf[k*ch+c]
+			}
+			frame[c] = acc
+		}
+		n++
+		r.next++
+	}
+	if n == 0 && r.eof {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// fill drops input before frame first and reads another block
+func (r *resampler) fill(first int64) error {
+	ch := r.format.Channels
+	if drop := first - r.base; drop > 0 {
+		drop = min(drop, int64(len(r.buf)/ch))
+		r.buf = r.buf[:copy(r.buf, r.buf[drop*int64(ch):])]
+		r.base += drop
+	}
+
+	have := len(r.buf)
+	want := have + blockFrames*ch
+	if cap(r.buf) < want {
+		buf := make([]float32, have, want+2*r.half*ch)
+		copy(buf, r.buf)
+		r.buf = buf
+	}
+	k, err := r.src.ReadFrames(r.buf[have:want])
+	r.buf = r.buf[:have+k*ch]
+	if err == io.EOF {
+		r.eof = true
+		r.inFrames = r.base + int64(len(r.buf)/ch)
+		return nil
+	}
+	return err
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser is the Kaiser window over [-1, 1]
+func kaiser(x, beta float64) float64 {
+	if x < -1 || x > 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-12; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
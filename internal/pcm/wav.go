@@ -0,0 +1,205 @@
+package pcm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// WAVOptions selects the sample format of EncodeWAV
+type WAVOptions struct {
+	BitDepth int  // 8, 16, 24 or 32
+	Float    bool // 32-bit IEEE float instead of integers
+}
+
+// EncodeWAV writes src as a WAV file and returns the number of frames
+// written. Integer samples are rounded and clipped: reduce the bit depth
+// with Dither first.
+//
+// The header needs the data size. It is computed from the format's frame
+// count when known; otherwise, if w is an io.WriteSeeker, the sizes are
+// written once the stream ends, and if not they are left at their maximum
+// as streaming readers expect. Data over 4 GiB makes the file RF64.
+func EncodeWAV(w io.Writer, src Source, opts WAVOptions) (int64, error) {
+	format := src.Format()
+	bytesPerSample, err := wavSampleBytes(opts)
+	if err != nil {
+		return 0, err
+	}
+	ws, seekable := w.(io.WriteSeeker)
+
+	frameBytes := int64(format.Channels * bytesPerSample)
+	dataSize := int64(-1)
+	if format.Frames >= 0 {
+		dataSize = format.Frames * frameBytes
+	}
+	header := wavHeader(format, opts, dataSize, dataSize < 0)
+	reserved := dataSize < 0 || string(header[:4]) == "RF64"
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+
+	buf := make([]float32, blockFrames*format.Channels)
+	out := make([]byte, 0, blockFrames*int(frameBytes))
+	var frames int64
+	for {
+		n, err := src.ReadFrames(buf)
+		if n > 0 {
+			out = out[:0]
+			for _, v := range buf[:n*format.Channels] {
+				out = appendWAVSample(out, v, opts)
+			}
+			if _, err := w.Write(out); err != nil {
+				return frames, err
+			}
+			frames += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return frames, err
+		}
+	}
+
+	size := frames * frameBytes
+	if size%2 == 1 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return frames, err
+		}
+	}
+	if size == dataSize || (dataSize < 0 && !seekable) {
+		return frames, nil
+	}
+	if !seekable {
+		return frames, fmt.Errorf("pcm: stream declared %d frames but had %d", format.Frames, frames)
+	}
+
+	// Rewrite the header with the actual sizes, in the room it had
+	format.Frames = frames
+	if h := wavHeader(format, opts, size, reserved); len(h) == len(header) {
+		header = h
+	} else {
+		return frames, fmt.Errorf("pcm: stream declared %d frames but had %d", src.Format().Frames, frames)
+	}
+	if _, err := ws.Seek(0, io.SeekStart); err != nil {
+		return frames, err
+	}
+	if _, err := ws.Write(header); err != nil {
+		return frames, err
+	}
+	_, err = ws.Seek(0, io.SeekEnd)
+	return frames, err
+}
+
+func wavSampleBytes(opts WAVOptions) (int, error) {
+	switch {
+	case opts.Float && opts.BitDepth == 32:
+	case opts.Float:
+		return 0, fmt.Errorf("%w: %d-bit float WAV", ErrUnsupported, opts.BitDepth)
+	case opts.BitDepth == 8, opts.BitDepth == 16, opts.BitDepth == 24, opts.BitDepth == 32:
+	default:
+		return 0, fmt.Errorf("%w: %d-bit WAV", ErrUnsupported, opts.BitDepth)
+	}
+	return opts.BitDepth / 8, nil
+}
+
+// wavHeader returns everything up to the data chunk's payload. dataSize
+// -1 means unknown, the sizes are left at their maximum. With reserve, a
+// JUNK chunk makes room for the ds64 chunk of RF64, should the data turn
+// out to need it.
+func wavHeader(format Format, opts WAVOptions, dataSize int64, reserve bool) []byte {
+	le := binary.LittleEndian
+	bytesPerSample := opts.BitDepth / 8
+
+	// fmt: WAVE_FORMAT_EXTENSIBLE where the plain header is ambiguous
+	tag := uint16(wavFormatPCM)
+	if opts.Float {
+		tag = wavFormatIEEEFloat
+	}
+	fmtChunk := le.AppendUint16(nil, tag)
+	fmtChunk = le.AppendUint16(fmtChunk, uint16(format.Channels))
+	fmtChunk = le.AppendUint32(fmtChunk, uint32(format.SampleRate))
+	fmtChunk = le.AppendUint32(fmtChunk, uint32(format.SampleRate*format.Channels*bytesPerSample))
+	fmtChunk = le.AppendUint16(fmtChunk, uint16(format.Channels*bytesPerSample))
+	fmtChunk = le.AppendUint16(fmtChunk, uint16(opts.BitDepth))
+	if format.Channels > 2 || opts.BitDepth > 16 {
+		le.PutUint16(fmtChunk, wavFormatExtensible)
+		fmtChunk = le.AppendUint16(fmtChunk, 22)
+		fmtChunk = le.AppendUint16(fmtChunk, uint16(opts.BitDepth)) // valid bits
+		fmtChunk = le.AppendUint32(fmtChunk, format.ChannelMask)
+		// KSDATAFORMAT_SUBTYPE_PCM or _IEEE_FLOAT
+		fmtChunk = le.AppendUint16(fmtChunk, tag)
+		fmtChunk = append(fmtChunk, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71)
+	}
+
+	riffSize := 4 + 8 + int64(len(fmtChunk)) + 8 + dataSize + dataSize%2
+	rf64 := riffSize > math.MaxUint32
+	var ds64 []byte
+	if rf64 || reserve {
+		ds64 = make([]byte, 28) // RIFF and data sizes, frames, empty table
+		riffSize += 8 + int64(len(ds64))
+	}
+
+	var b []byte
+	switch {
+	case rf64:
+		le.PutUint64(ds64[0:], uint64(riffSize))
+		le.PutUint64(ds64[8:], uint64(dataSize))
+		le.PutUint64(ds64[16:], uint64(format.Frames))
+		b = append(b, "RF64"...)
+		b = le.AppendUint32(b, math.MaxUint32)
+		b = append(b, "WAVEds64"...)
+	case dataSize < 0:
+		b = append(b, "RIFF"...)
+		b = le.AppendUint32(b, math.MaxUint32)
+		b = append(b, "WAVE"...)
+	default:
+		b = append(b, "RIFF"...)
+		b = le.AppendUint32(b, uint32(riffSize))
+		b = append(b, "WAVE"...)
+	}
+	if ds64 != nil {
+		if !rf64 {
+			b = append(b, "JUNK"...)
+		}
+		b = le.AppendUint32(b, uint32(len(ds64)))
+		b = append(b, ds64...)
+	}
+
+	b = append(b, "fmt "...)
+	b = le.AppendUint32(b, uint32(len(fmtChunk)))
+	b = append(b, fmtChunk...)
+
+	b = append(b, "data"...)
+	if rf64 || dataSize < 0 {
+		return le.AppendUint32(b, math.MaxUint32)
+	}
+	return le.AppendUint32(b, uint32(dataSize))
+}
+
+// appendWAVSample appends one sample in the WAV encoding of opts
+func appendWAVSample(b []byte, v float32, opts WAVOptions) []byte {
+	if opts.Float {
+		return binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+	}
+	steps := float64(int64(1) << (opts.BitDepth - 1))
+	q := int64(math.Round(float64(v) * steps))
+	q = min(max(q, int64(-steps)), int64(steps)-1)
+	switch opts.BitDepth {
+	case 8:
+		return append(b, byte(q+128))
+	case 16:
+		return binary.LittleEndian.AppendUint16(b, uint16(q))
+	case 24:
+		return append(b, byte(q), byte(q>>8), byte(q>>16))
+	}
+	return binary.LittleEndian.AppendUint32(b, uint32(q))
+}
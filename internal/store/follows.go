@@ -0,0 +1,56 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"context"
+)
+
+// FollowStore records which users follow which artist accounts
+type FollowStore struct {
+	db *db.Cluster
+}
+
+// Follow makes follower follow followee. Following twice is not an error.
+func (s *FollowStore) Follow(ctx context.Context, followerID, followeeID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx,
+		"INSERT INTO follows (follower_id, followee_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		followerID, followeeID)
+	return err
+}
+
+// Unfollow removes the follow, if there is one
+func (s *FollowStore) Unfollow(ctx context.Context, followerID, followeeID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx,
+		"DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2", followerID, followeeID)
+	return err
+}
+
+// ListFollowees returns the ids of the users follower follows, most recent first
+func (s *FollowStore) ListFollowees(ctx context.Context, followerID int64) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.Reader(ctx).QueryContext(ctx,
+		"SELECT followee_id FROM follows WHERE follower_id = $1 ORDER BY created_at DESC, followee_id", followerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
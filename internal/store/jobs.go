@@ -0,0 +1,171 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"audio-go/internal/jobs"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// JobStore is the Postgres jobs.Queue
+type JobStore struct {
+	db *db.Cluster
+}
+
+const jobColumns = `id, kind, payload, priority, state, run_at, attempts, max_attempts,
+	COALESCE(last_error, ''), COALESCE(locked_by, ''), locked_until, created_at`
+
+func scanJob(row interface{ Scan(...any) error }, j *jobs.Job) error {
+	var payload []byte
+	var lockedUntil sql.NullTime
+	err := row.Scan(&j.ID, &j.Kind, &payload, &j.Priority, &j.State, &j.RunAt, &j.Attempts, &j.MaxAttempts,
+		&j.LastError, &j.LockedBy, &lockedUntil, &j.CreatedAt)
+	if err != nil {
+		return err
+	}
+	j.Payload = payload
+	j.LockedUntil = lockedUntil.Time
+	return nil
+}
+
+// Enqueue inserts a job and fills in its id, state and creation time
+func (s *JobStore) Enqueue(ctx context.Context, job *jobs.Job) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		INSERT INTO jobs (kind, payload, priority, run_at, max_attempts)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, state, created_at`
+
+	return s.db.Writer(ctx).QueryRowContext(ctx, query,
+		job.Kind, []byte(job.Payload), job.Priority, job.RunAt, job.MaxAttempts,
+	).Scan(&job.ID, &job.State, &job.CreatedAt)
+}
+
+// Get returns the job with the given id
+func (s *JobStore) Get(ctx context.Context, id int64) (*jobs.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	job := &jobs.Job{}
+	row := s.db.Reader(ctx).QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", id)
+	if err := scanJob(row, job); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, jobs.ErrNotFound
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// Dequeue leases up to limit due jobs of the given kinds to worker
+func (s *JobStore) Dequeue(ctx context.Context, worker string, kinds []string, limit int, lease time.Duration) ([]*jobs.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// A running job whose lease ran out lost its worker: it is due again,
+	// unless that was its last attempt
+	expire := `
+		UPDATE jobs SET state = 'dead', last_error = 'lease expired', locked_by = NULL, locked_until = NULL,
+			updated_at = NOW()
+		WHERE kind = ANY($1) AND state = 'running' AND locked_until <= NOW() AND attempts >= max_attempts`
+
+	// SKIP LOCKED lets concurrent workers take different jobs without waiting
+	query := `
+		UPDATE jobs SET state = 'running', attempts = attempts + 1, locked_by = $3,
+			locked_until = NOW() + $4 * INTERVAL '1 millisecond', heartbeat_at = NOW(), updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1) AND (
+				state = 'queued' AND run_at <= NOW() OR
+				state = 'running' AND locked_until <= NOW())
+			ORDER BY priority DESC, run_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+
+	var leased []*jobs.Job
+	err := withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, expire, pq.Array(kinds)); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, query, pq.Array(kinds), limit, worker, lease.Milliseconds())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			job := &jobs.Job{}
+			if err := scanJob(rows, job); err != nil {
+				return err
+			}
+			leased = append(leased, job)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leased, nil
+}
+
+// Heartbeat extends the lease of a job held by worker
+func (s *JobStore) Heartbeat(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	return s.execLeased(ctx, `
+		UPDATE jobs SET locked_until = NOW() + $3 * INTERVAL '1 millisecond', heartbeat_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND state = 'running'`,
+		id, worker, lease.Milliseconds())
+}
+
+// Complete removes a job held by worker
+func (s *JobStore) Complete(ctx context.Context, id int64, worker string) error {
+	return s.execLeased(ctx,
+		"DELETE FROM jobs WHERE id = $1 AND locked_by = $2 AND state = 'running'", id, worker)
+}
+
+// Fail records a failed attempt of a job held by worker and queues it again
+// at retryAt, or dead-letters it
+func (s *JobStore) Fail(ctx context.Context, id int64, worker string, cause error, retryAt time.Time, dead bool) error {
+	return s.execLeased(ctx, `
+		UPDATE jobs SET state = CASE WHEN $5 THEN 'dead' ELSE 'queued' END, last_error = $3, run_at = $4,
+			locked_by = NULL, locked_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND state = 'running'`,
+		id, worker, cause.Error(), retryAt, dead)
+}
+
+// Release queues a job held by worker again without counting the attempt
+func (s *JobStore) Release(ctx context.Context, id int64, worker string) error {
+	return s.execLeased(ctx, `
+		UPDATE jobs SET state = 'queued', attempts = attempts - 1, locked_by = NULL, locked_until = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2 AND state = 'running'`,
+		id, worker)
+}
+
+// execLeased runs an update of a leased job, returning jobs.ErrLeaseLost
+// when it matched no row
+func (s *JobStore) execLeased(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.Writer(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return jobs.ErrLeaseLost
+	}
+	return nil
+}
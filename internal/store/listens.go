@@ -0,0 +1,78 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"context"
+	"time"
+)
+
+// Listen is one play of a track in a user's listening history
+type Listen struct {
+	ID            int64     `json:"id"`
+	UserID        int64     `json:"user_id"`
+	TrackID       int64     `json:"track_id"`
+	PlayedAt      time.Time `json:"played_at"`
+	SecondsPlayed float64   `json:"seconds_played"`
+}
+
+// ListenStore handles listening history
+type ListenStore struct {
+	db *db.Cluster
+}
+
+// Record adds a listen. A play of the same track by the same user at the
+// same instant is only recorded once, so a retried report is harmless;
+// listen.ID stays 0 for such a duplicate.
+func (s *ListenStore) Record(ctx context.Context, listen *Listen) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		INSERT INTO listens (user_id, track_id, played_at, seconds_played)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, track_id, played_at) DO NOTHING
+		RETURNING id`
+
+	rows, err := s.db.Writer(ctx).QueryContext(ctx, query, listen.UserID, listen.TrackID, listen.PlayedAt, listen.SecondsPlayed)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&listen.ID); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListByUser returns up to limit of the user's most recent listens
+func (s *ListenStore) ListByUser(ctx context.Context, userID int64, limit int) ([]*Listen, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, track_id, played_at, seconds_played
+		FROM listens
+		WHERE user_id = $1
+		ORDER BY played_at DESC, id DESC
+		LIMIT $2`
+
+	rows, err := s.db.Reader(ctx).QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var listens []*Listen
+	for rows.Next() {
+		listen := &Listen{}
+		if err := rows.Scan(&listen.ID, &listen.UserID, &listen.TrackID, &listen.PlayedAt, &listen.SecondsPlayed); err != nil {
+			return nil, err
+		}
+		listens = append(listens, listen)
+	}
+
+	return listens, rows.Err()
+}
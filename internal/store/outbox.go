@@ -0,0 +1,89 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"audio-go/internal/events"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// OutboxStore persists domain events for the events.Relay
+type OutboxStore struct {
+	db *db.Cluster
+}
+
+// insertEvent writes e to the outbox inside tx, so the event is stored if and
+// only if the change it describes is committed
+func insertEvent(ctx context.Context, tx *sql.Tx, e events.Event) error {
+	query := `
+		INSERT INTO outbox (event_id, event_type, aggregate_type, aggregate_id, payload, occurred_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+
+	_, err := tx.ExecContext(ctx, query, e.ID, e.Type, e.AggregateType, e.AggregateID, []byte(e.Payload), e.OccurredAt)
+	return err
+}
+
+// Claim leases up to limit unpublished events that are due
+func (s *OutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]events.Record, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// Pushing available_at forward is the lease: if the relay dies before
+	// resolving the event it becomes due again once the lease expires
+	query := `
+		UPDATE outbox SET available_at = NOW() + $2 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND dead_at IS NULL AND available_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_id, event_type, aggregate_type, aggregate_id, payload, occurred_at, attempts`
+
+	rows, err := s.db.Writer(ctx).QueryContext(ctx, query, limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []events.Record
+	for rows.Next() {
+		var rec events.Record
+		var payload []byte
+		if err := rows.Scan(&rec.OutboxID, &rec.ID, &rec.Type, &rec.AggregateType, &rec.AggregateID, &payload, &rec.OccurredAt, &rec.Attempts); err != nil {
+			return nil, err
+		}
+		rec.Payload = payload
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
+
+// MarkPublished records that every sink accepted the event
+func (s *OutboxStore) MarkPublished(ctx context.Context, outboxID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx,
+		"UPDATE outbox SET published_at = NOW(), last_error = NULL WHERE id = $1", outboxID)
+	return err
+}
+
+// MarkFailed records a failed delivery and either schedules the next
+// attempt or dead-letters the event
+func (s *OutboxStore) MarkFailed(ctx context.Context, outboxID int64, cause error, retryAt time.Time, dead bool) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		UPDATE outbox
+		SET attempts = attempts + 1, last_error = $2, available_at = $3,
+			dead_at = CASE WHEN $4 THEN NOW() END
+		WHERE id = $1`
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx, query, outboxID, cause.Error(), retryAt, dead)
+	return err
+}
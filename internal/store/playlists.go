@@ -0,0 +1,112 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Playlist is a user's ordered list of tracks
+type Playlist struct {
+	ID        int64     `json:"id"`
+	OwnerID   int64     `json:"owner_id"`
+	Title     string    `json:"title"`
+	TrackIDs  []int64   `json:"track_ids"` // in playlist order
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PlaylistStore handles playlists and their tracks
+type PlaylistStore struct {
+	db *db.Cluster
+}
+
+// Create inserts a playlist with its tracks. Titles are unique per owner,
+// ErrConflict is returned when the owner already has one with the title.
+func (s *PlaylistStore) Create(ctx context.Context, playlist *Playlist) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			"INSERT INTO playlists (owner_id, title) VALUES ($1, $2) RETURNING id, created_at",
+			playlist.OwnerID, playlist.Title,
+		).Scan(&playlist.ID, &playlist.CreatedAt)
+		if err != nil {
+			if isUniqueViolation(err) {
+				return ErrConflict
+			}
+			return err
+		}
+
+		for i, trackID := range playlist.TrackIDs {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO playlist_tracks (playlist_id, position, track_id) VALUES ($1, $2, $3)",
+				playlist.ID, i, trackID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetByID returns the playlist with its tracks in order
+func (s *PlaylistStore) GetByID(ctx context.Context, id int64) (*Playlist, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	reader := s.db.Reader(ctx)
+	playlist := &Playlist{}
+	err := reader.QueryRowContext(ctx,
+		"SELECT id, owner_id, title, created_at FROM playlists WHERE id = $1", id,
+	).Scan(&playlist.ID, &playlist.OwnerID, &playlist.Title, &playlist.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := reader.QueryContext(ctx,
+		"SELECT track_id FROM playlist_tracks WHERE playlist_id = $1 ORDER BY position", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlist.TrackIDs = []int64{}
+	for rows.Next() {
+		var trackID int64
+		if err := rows.Scan(&trackID); err != nil {
+			return nil, err
+		}
+		playlist.TrackIDs = append(playlist.TrackIDs, trackID)
+	}
+
+	return playlist, rows.Err()
+}
+
+// ListByOwner returns the owner's playlists without their tracks, oldest first
+func (s *PlaylistStore) ListByOwner(ctx context.Context, ownerID int64) ([]*Playlist, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.Reader(ctx).QueryContext(ctx,
+		"SELECT id, owner_id, title, created_at FROM playlists WHERE owner_id = $1 ORDER BY id", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []*Playlist
+	for rows.Next() {
+		playlist := &Playlist{}
+		if err := rows.Scan(&playlist.ID, &playlist.OwnerID, &playlist.Title, &playlist.CreatedAt); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+
+	return playlists, rows.Err()
+}
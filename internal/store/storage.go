@@ -1,16 +1,23 @@
 package store
 
 import (
+	"audio-go/internal/auth"
+	"audio-go/internal/db"
+	"audio-go/internal/events"
+	"audio-go/internal/jobs"
+	"audio-go/internal/pagination"
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
 	"time"
-	// "audio-go/internal/auth" // Make sure to import the auth package where JWT authenticator is defined
+
+	"github.com/lib/pq"
 )
 
 var (
 	ErrNotFound          = errors.New("resource not found")
 	ErrConflict          = errors.New("resource already exists")
+	ErrVersionConflict   = fmt.Errorf("%w: version moved", ErrConflict)
 	QueryTimeoutDuration = time.Second * 5
 )
 
@@ -18,43 +25,79 @@ type Storage struct {
 	Users interface {
 		SignIn(context.Context, *User) (*SignInResponse, error)
 		SignUp(context.Context, *User) (*SignUpResponse, error)
+		GetByID(context.Context, int64) (*User, error)
+		GetByEmail(context.Context, string) (*User, error)
+		Update(context.Context, *User) error
+	}
+	Tracks interface {
+		Create(context.Context, *Track) error
+		GetByID(context.Context, int64) (*Track, error)
+		Update(context.Context, *Track) error
+		SetRendition(context.Context, int64, string, string, *Rendition) error
+		SetWaveform(context.Context, int64, string, *Waveform) error
+		SetLoudness(context.Context, int64, string, *Loudness) error
+		SetAlbumLoudness(context.Context, int64, string, *AlbumLoudness) error
+		Delete(context.Context, int64, int64) error
+		ListByOwner(context.Context, int64, bool, *pagination.Request) ([]*Track, error)
+		ListByAlbum(context.Context, int64, string, string, int) ([]*Track, error)
+	}
+	Uploads interface {
+		Create(context.Context, *Upload) error
+		GetByID(context.Context, string) (*Upload, error)
+		Advance(context.Context, string, int64, int64, time.Time) error
+		Complete(context.Context, string, int64) error
+		Delete(context.Context, string) error
+		ListExpired(context.Context, time.Time, int) ([]*Upload, error)
+	}
+	Playlists interface {
+		Create(context.Context, *Playlist) error
+		GetByID(context.Context, int64) (*Playlist, error)
+		ListByOwner(context.Context, int64) ([]*Playlist, error)
+	}
+	Follows interface {
+		Follow(context.Context, int64, int64) error
+		Unfollow(context.Context, int64, int64) error
+		ListFollowees(context.Context, int64) ([]int64, error)
+	}
+	Listens interface {
+		Record(context.Context, *Listen) error
+		ListByUser(context.Context, int64, int) ([]*Listen, error)
+	}
+	Outbox interface {
+		Claim(context.Context, int, time.Duration) ([]events.Record, error)
+		MarkPublished(context.Context, int64) error
+		MarkFailed(context.Context, int64, error, time.Time, bool) error
+	}
+	Jobs interface {
+		Enqueue(context.Context, *jobs.Job) error
+		Get(context.Context, int64) (*jobs.Job, error)
+		Dequeue(context.Context, string, []string, int, time.Duration) ([]*jobs.Job, error)
+		Heartbeat(context.Context, int64, string, time.Duration) error
+		Complete(context.Context, int64, string) error
+		Fail(context.Context, int64, string, error, time.Time, bool) error
+		Release(context.Context, int64, string) error
 	}
 }
 
+// isUniqueViolation reports whether err is a Postgres unique_violation (23505)
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 // NewStorage creates a new Storage instance and initializes UserStore
-func NewStorage(db *sql.DB) Storage {
+func NewStorage(cluster *db.Cluster, jwtAuth *auth.JWTAuthenticator) Storage {
 	return Storage{
 		Users: &UserStore{
-			db: db,
-			// jwtAuth: &jwt, // Pass the jwt authenticator
+			db:      cluster,
+			jwtAuth: jwtAuth, // Pass the jwt authenticator
 		},
+		Tracks:    &TrackStore{db: cluster},
+		Uploads:   &UploadStore{db: cluster},
+		Playlists: &PlaylistStore{db: cluster},
+		Follows:   &FollowStore{db: cluster},
+		Listens:   &ListenStore{db: cluster},
+		Outbox:    &OutboxStore{db: cluster},
+		Jobs:      &JobStore{db: cluster},
 	}
 }
-
-// package store
-
-// import (
-// 	"context"
-// 	"database/sql"
-// 	"errors"
-// 	"time"
-// )
-
-// var (
-// 	ErrNotFound          = errors.New("resource not found")
-// 	ErrConflict          = errors.New("resource already exists")
-// 	QueryTimeoutDuration = time.Second * 5
-// )
-
-// type Storage struct {
-// 	Users interface {
-// 		SignIn(context.Context, *User)(*SignInResponse, error)
-// 		SignUp(context.Context, *User) (*SignUpResponse, error)
-// 	}
-// }
-
-// func NewStorage(db *sql.DB, jwt jwtAuthenticator) Storage {
-// 	return Storage{
-// 		Users: &UserStore{db},
-// 	}
-// }
@@ -0,0 +1,522 @@
+package store
+
+import (
+	"audio-go/internal/audio"
+	"audio-go/internal/db"
+	"audio-go/internal/events"
+	"audio-go/internal/pagination"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Track visibilities
+const (
+	VisibilityPrivate  = "private"  // only the owner can see it
+	VisibilityUnlisted = "unlisted" // anyone with the id can see it, not listed
+	VisibilityPublic   = "public"
+)
+
+// Track represents an audio track in the catalog
+type Track struct {
+	ID            int64                 `json:"id"`
+	OwnerID       int64                 `json:"owner_id"`
+	Title         string                `json:"title"`
+	Artist        string                `json:"artist"`
+	DurationMs    int64                 `json:"duration_ms"`
+	Format        string                `json:"format"`      // container/codec, e.g. "wav", "flac", "mp3"
+	SampleRate    int                   `json:"sample_rate"` // Hz
+	Channels      int                   `json:"channels"`
+	ChannelLayout string                `json:"channel_layout"` // e.g. "stereo", "5.1"
+	BitDepth      int                   `json:"bit_depth"`      // 0 for lossy formats
+	Bitrate       int                   `json:"bitrate"`        // bits per second
+	Size          int64                 `json:"size"`           // bytes
+	Checksum      string                `json:"checksum"`
+	Integrity     string                `json:"integrity"`  // lossless only: "verified", "unverified" or "no_checksum"
+	Tags          audio.Tags            `json:"tags"`       // normalized from the file's metadata
+	RawTags       map[string]string     `json:"raw_tags"`   // as found in the file, keyed by the container's field names
+	Artwork       *Artwork              `json:"artwork"`    // nil until cover art is extracted or uploaded
+	Renditions    map[string]*Rendition `json:"renditions"` // delivery encodings by ladder name
+	Waveform      *Waveform             `json:"waveform"`   // nil until computed from the audio
+	Loudness      *Loudness             `json:"loudness"`   // nil until measured, and for silence
+	Visibility    string                `json:"visibility"`
+	AudioKey      string                `json:"-"` // blob store key of the uploaded original
+	Version       int64                 `json:"version"`
+	CreatedAt     time.Time             `json:"created_at"`
+	UpdatedAt     time.Time             `json:"updated_at"`
+}
+
+// Artwork sources
+const (
+	ArtworkEmbedded = "embedded" // extracted from the audio file
+	ArtworkUpload   = "upload"   // uploaded by the owner
+)
+
+// Artwork describes a track's cover art. The source image and its square
+// variants are stored in the blob store under a prefix versioned by Hash.
+type Artwork struct {
+	Hash          string `json:"hash"` // of the source image
+	Source        string `json:"source"`
+	MIMEType      string `json:"mime_type"` // of the source image
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Sizes         []int  `json:"sizes"`          // of the variants, ascending
+	Format        string `json:"format"`         // of the variants besides WebP, "jpeg" or "png"
+	DominantColor string `json:"dominant_color"` // "#rrggbb"
+}
+
+// Rendition states
+const (
+	RenditionPending    = "pending"
+	RenditionProcessing = "processing"
+	RenditionReady      = "ready"
+	RenditionFailed     = "failed"  // gave up after retrying
+	RenditionSkipped    = "skipped" // cannot be made from this master, e.g. a lossy one
+)
+
+// Rendition is a delivery encoding of the track's audio, made in the
+// background after upload. Ready renditions are stored in the blob store
+// under a prefix versioned by the track's checksum.
+type Rendition struct {
+	Format     string    `json:"format"`
+	SampleRate int       `json:"sample_rate,omitempty"`
+	BitDepth   int       `json:"bit_depth,omitempty"`
+	Channels   int       `json:"channels,omitempty"`
+	Bitrate    int       `json:"bitrate,omitempty"`
+	Status     string    `json:"status"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error,omitempty"` // of the last failed attempt
+	Size       int64     `json:"size,omitempty"`  // bytes, once ready
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Waveform describes the peak data of a track's audio, stored in the blob
+// store at each zoom level under a prefix versioned like renditions
+type Waveform struct {
+	SampleRate int   `json:"sample_rate"`
+	Bits       int   `json:"bits"`   // of the peaks, 8 or 16
+	Levels     []int `json:"levels"` // samples per pixel, ascending
+}
+
+// Loudness is the EBU R 128 measurement of a track's audio. The gating
+// blocks it was computed from are kept in the blob store, next to the
+// waveform, for album measurements.
+type Loudness struct {
+	Integrated float64        `json:"integrated"`  // LUFS
+	Range      float64        `json:"range"`       // LU
+	TruePeak   float64        `json:"true_peak"`   // dBTP
+	SamplePeak float64        `json:"sample_peak"` // dBFS
+	Album      *AlbumLoudness `json:"album"`       // nil for tracks without an album
+}
+
+// AlbumLoudness is the measurement of the measured tracks of an album as a
+// whole: the owner's tracks tagged with the same album and album artist
+type AlbumLoudness struct {
+	Integrated float64 `json:"integrated"` // LUFS
+	Range      float64 `json:"range"`      // LU
+	TruePeak   float64 `json:"true_peak"`  // dBTP, the loudest track's
+	Tracks     int     `json:"tracks"`
+}
+
+// VisibleTo reports whether the user may read the track
+func (t *Track) VisibleTo(userID int64) bool {
+	return t.OwnerID == userID || t.Visibility != VisibilityPrivate
+}
+
+// TrackStore handles track-related database operations
+type TrackStore struct {
+	db *db.Cluster
+}
+
+const trackColumns = `id, owner_id, title, artist, duration_ms, format, sample_rate, channels,
+	channel_layout, bit_depth, bitrate, size, checksum, integrity, tags, raw_tags, artwork, renditions, waveform, loudness, visibility, audio_key,
+	version, created_at, updated_at`
+
+func scanTrack(row interface{ Scan(...any) error }, t *Track) error {
+	var tags, rawTags, artwork, renditions, waveform, loudness []byte
+	err := row.Scan(
+		&t.ID, &t.OwnerID, &t.Title, &t.Artist, &t.DurationMs, &t.Format, &t.SampleRate, &t.Channels,
+		&t.ChannelLayout, &t.BitDepth, &t.Bitrate, &t.Size, &t.Checksum, &t.Integrity, &tags, &rawTags,
+		&artwork, &renditions, &waveform, &loudness, &t.Visibility, &t.AudioKey, &t.Version, &t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(tags, &t.Tags); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(rawTags, &t.RawTags); err != nil {
+		return err
+	}
+	t.Renditions = nil
+	if err := json.Unmarshal(renditions, &t.Renditions); err != nil {
+		return err
+	}
+	t.Waveform = nil
+	if waveform != nil {
+		t.Waveform = &Waveform{}
+		if err := json.Unmarshal(waveform, t.Waveform); err != nil {
+			return err
+		}
+	}
+	t.Loudness = nil
+	if loudness != nil {
+		t.Loudness = &Loudness{}
+		if err := json.Unmarshal(loudness, t.Loudness); err != nil {
+			return err
+		}
+	}
+	t.Artwork = nil
+	if artwork != nil {
+		t.Artwork = &Artwork{}
+		return json.Unmarshal(artwork, t.Artwork)
+	}
+	return nil
+}
+
+// marshalTrackTags encodes the tag columns, storing no raw tags as {}
+func marshalTrackTags(t *Track) (tags, rawTags []byte, err error) {
+	if tags, err = json.Marshal(t.Tags); err != nil {
+		return nil, nil, err
+	}
+	raw := t.RawTags
+	if raw == nil {
+		raw = map[string]string{}
+	}
+	if rawTags, err = json.Marshal(raw); err != nil {
+		return nil, nil, err
+	}
+	return tags, rawTags, nil
+}
+
+// marshalArtwork encodes the artwork column, NULL when there is none
+func marshalArtwork(t *Track) (any, error) {
+	if t.Artwork == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t.Artwork)
+	return b, err
+}
+
+// marshalWaveform encodes the waveform column, NULL when there is none
+func marshalWaveform(t *Track) (any, error) {
+	if t.Waveform == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t.Waveform)
+	return b, err
+}
+
+// marshalLoudness encodes the loudness column, NULL when there is none
+func marshalLoudness(t *Track) (any, error) {
+	if t.Loudness == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t.Loudness)
+	return b, err
+}
+
+// marshalRenditions encodes the renditions column, storing none as {}
+func marshalRenditions(t *Track) ([]byte, error) {
+	if t.Renditions == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(t.Renditions)
+}
+
+// Create inserts a track and fills in its id, version and timestamps
+func (s *TrackStore) Create(ctx context.Context, track *Track) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	tags, rawTags, err := marshalTrackTags(track)
+	if err != nil {
+		return err
+	}
+	artwork, err := marshalArtwork(track)
+	if err != nil {
+		return err
+	}
+	renditions, err := marshalRenditions(track)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO tracks (owner_id, title, artist, duration_ms, format, sample_rate, channels,
+			channel_layout, bit_depth, bitrate, size, checksum, integrity, tags, raw_tags, artwork, renditions, visibility)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id, version, created_at, updated_at`
+
+	return withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			track.OwnerID, track.Title, track.Artist, track.DurationMs, track.Format, track.SampleRate,
+			track.Channels, track.ChannelLayout, track.BitDepth, track.Bitrate, track.Size, track.Checksum, track.Integrity,
+			tags, rawTags, artwork, renditions, track.Visibility,
+		).Scan(&track.ID, &track.Version, &track.CreatedAt, &track.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		return insertTrackEvent(ctx, tx, events.TrackCreated, track)
+	})
+}
+
+// GetByID returns the track with the given id
+func (s *TrackStore) GetByID(ctx context.Context, id int64) (*Track, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	track := &Track{}
+	row := s.db.Reader(ctx).QueryRowContext(ctx, "SELECT "+trackColumns+" FROM tracks WHERE id = $1", id)
+	if err := scanTrack(row, track); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return track, nil
+}
+
+// Update saves every mutable column of the track. track.Version must be the
+// version the caller read, otherwise ErrVersionConflict is returned.
+func (s *TrackStore) Update(ctx context.Context, track *Track) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	tags, rawTags, err := marshalTrackTags(track)
+	if err != nil {
+		return err
+	}
+	artwork, err := marshalArtwork(track)
+	if err != nil {
+		return err
+	}
+	renditions, err := marshalRenditions(track)
+	if err != nil {
+		return err
+	}
+	waveform, err := marshalWaveform(track)
+	if err != nil {
+		return err
+	}
+	loudness, err := marshalLoudness(track)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE tracks
+		SET title = $1, artist = $2, duration_ms = $3, format = $4, sample_rate = $5, channels = $6,
+			channel_layout = $7, bit_depth = $8, bitrate = $9, size = $10, checksum = $11, integrity = $12,
+			tags = $13, raw_tags = $14, artwork = $15, renditions = $16, waveform = $17, loudness = $18,
+			visibility = $19, audio_key = $20, version = version + 1, updated_at = NOW()
+		WHERE id = $21 AND version = $22
+		RETURNING version, updated_at`
+
+	return withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query,
+			track.Title, track.Artist, track.DurationMs, track.Format, track.SampleRate, track.Channels,
+			track.ChannelLayout, track.BitDepth, track.Bitrate, track.Size, track.Checksum, track.Integrity,
+			tags, rawTags, artwork, renditions, waveform, loudness, track.Visibility, track.AudioKey, track.ID,
+			track.Version,
+		).Scan(&track.Version, &track.UpdatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrVersionConflict
+			}
+			return err
+		}
+
+		return insertTrackEvent(ctx, tx, events.TrackUpdated, track)
+	})
+}
+
+// SetRendition saves one rendition of a track without touching the rest of
+// the row, so background workers don't race edits. It only applies while
+// the track's audio still has the given checksum and returns ErrVersionConflict
+// once it was replaced.
+func (s *TrackStore) SetRendition(ctx context.Context, trackID int64, checksum, name string, r *Rendition) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.updateAnalysis(ctx, trackID, checksum,
+		"renditions = renditions || jsonb_build_object($3::text, $4::jsonb)", name, b)
+}
+
+// SetWaveform saves the waveform computed from the track's audio, on the
+// same terms as SetRendition
+func (s *TrackStore) SetWaveform(ctx context.Context, trackID int64, checksum string, w *Waveform) error {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	return s.updateAnalysis(ctx, trackID, checksum, "waveform = $3", b)
+}
+
+// SetLoudness saves the loudness measured on the track's audio, keeping the
+// album measurement it had, on the same terms as SetRendition
+func (s *TrackStore) SetLoudness(ctx context.Context, trackID int64, checksum string, l *Loudness) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.updateAnalysis(ctx, trackID, checksum,
+		"loudness = jsonb_set($3::jsonb, '{album}', COALESCE(loudness->'album', 'null'::jsonb))", b)
+}
+
+// SetAlbumLoudness saves the measurement of the track's album, nil for
+// none, on a track whose own loudness is measured
+func (s *TrackStore) SetAlbumLoudness(ctx context.Context, trackID int64, checksum string, a *AlbumLoudness) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.updateAnalysis(ctx, trackID, checksum, "loudness = jsonb_set(loudness, '{album}', $3::jsonb)", b)
+}
+
+// updateAnalysis applies set, whose arguments start at $3, to a track
+// whose audio still has the given checksum
+func (s *TrackStore) updateAnalysis(ctx context.Context, trackID int64, checksum, set string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		UPDATE tracks
+		SET ` + set + `, version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND checksum = $2
+		RETURNING ` + trackColumns
+	args = append([]any{trackID, checksum}, args...)
+
+	return withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		var track Track
+		if err := scanTrack(tx.QueryRowContext(ctx, query, args...), &track); err != nil {
+			if err != sql.ErrNoRows {
+				return err
+			}
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tracks WHERE id = $1)", trackID).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return ErrVersionConflict
+			}
+			return ErrNotFound
+		}
+
+		return insertTrackEvent(ctx, tx, events.TrackUpdated, &track)
+	})
+}
+
+// Delete removes a track. A version of zero deletes unconditionally,
+// otherwise the delete only happens if the version still matches.
+func (s *TrackStore) Delete(ctx context.Context, id, version int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(ctx, s.db.Writer(ctx), func(tx *sql.Tx) error {
+		var deleted Track
+		row := tx.QueryRowContext(ctx,
+			"DELETE FROM tracks WHERE id = $1 AND ($2 = 0 OR version = $2) RETURNING "+trackColumns, id, version)
+		if err := scanTrack(row, &deleted); err != nil {
+			if err != sql.ErrNoRows {
+				return err
+			}
+			// Tell "gone" apart from "changed since you read it"
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM tracks WHERE id = $1)", id).Scan(&exists); err != nil {
+				return err
+			}
+			if exists {
+				return ErrVersionConflict
+			}
+			return ErrNotFound
+		}
+
+		return insertTrackEvent(ctx, tx, events.TrackDeleted, &deleted)
+	})
+}
+
+// ListByOwner returns one page worth of rows (plus one, see pagination.Request.SQL)
+// of the owner's tracks. When publicOnly is set only public tracks are returned.
+func (s *TrackStore) ListByOwner(ctx context.Context, ownerID int64, publicOnly bool, req *pagination.Request) ([]*Track, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	args := []any{ownerID}
+	visibility := ""
+	if publicOnly {
+		args = append(args, VisibilityPublic)
+		visibility = " AND visibility = $2"
+	}
+
+	where, orderBy, limit, pageArgs := req.SQL(len(args))
+	args = append(args, pageArgs...)
+
+	query := fmt.Sprintf(
+		"SELECT %s FROM tracks WHERE owner_id = $1%s AND %s ORDER BY %s LIMIT %d",
+		trackColumns, visibility, where, orderBy, limit,
+	)
+
+	rows, err := s.db.Reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []*Track
+	for rows.Next() {
+		track := &Track{}
+		if err := scanTrack(rows, track); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+// ListByAlbum returns up to limit of the owner's tracks tagged with the
+// album and album artist, compared case-insensitively, oldest first
+func (s *TrackStore) ListByAlbum(ctx context.Context, ownerID int64, album, albumArtist string, limit int) ([]*Track, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks
+		WHERE owner_id = $1 AND lower(tags->>'album') = lower($2)
+			AND lower(COALESCE(tags->>'album_artist', '')) = lower($3)
+		ORDER BY id
+		LIMIT $4`
+
+	rows, err := s.db.Reader(ctx).QueryContext(ctx, query, ownerID, album, albumArtist, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tracks []*Track
+	for rows.Next() {
+		track := &Track{}
+		if err := scanTrack(rows, track); err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+func insertTrackEvent(ctx context.Context, tx *sql.Tx, eventType string, track *Track) error {
+	event, err := events.New(eventType, "track", track.ID, track)
+	if err != nil {
+		return err
+	}
+	return insertEvent(ctx, tx, event)
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// withTx runs fn inside a transaction, committing on success and rolling back on error
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
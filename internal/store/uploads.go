@@ -0,0 +1,141 @@
+package store
+
+import (
+	"audio-go/internal/db"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Upload is the state of a resumable (tus) upload
+type Upload struct {
+	ID          string            `json:"id"`
+	OwnerID     int64             `json:"owner_id"`
+	TrackID     *int64            `json:"track_id"` // set up front or once the upload is finalized
+	Length      int64             `json:"length"`
+	Offset      int64             `json:"offset"`
+	Metadata    map[string]string `json:"metadata"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// UploadStore handles resumable upload state
+type UploadStore struct {
+	db *db.Cluster
+}
+
+const uploadColumns = `id, owner_id, track_id, upload_length, upload_offset, metadata, expires_at, completed_at, created_at`
+
+func scanUpload(row interface{ Scan(...any) error }, u *Upload) error {
+	var metadata []byte
+	if err := row.Scan(&u.ID, &u.OwnerID, &u.TrackID, &u.Length, &u.Offset, &metadata, &u.ExpiresAt, &u.CompletedAt, &u.CreatedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal(metadata, &u.Metadata)
+}
+
+// Create inserts a new upload
+func (s *UploadStore) Create(ctx context.Context, upload *Upload) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	metadata, err := json.Marshal(upload.Metadata)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO uploads (id, owner_id, track_id, upload_length, metadata, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at`
+
+	return s.db.Writer(ctx).QueryRowContext(ctx, query,
+		upload.ID, upload.OwnerID, upload.TrackID, upload.Length, metadata, upload.ExpiresAt,
+	).Scan(&upload.CreatedAt)
+}
+
+// GetByID returns an upload. Its offset must be current, so it is always read from the primary.
+func (s *UploadStore) GetByID(ctx context.Context, id string) (*Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	upload := &Upload{}
+	row := s.db.Reader(db.WithPrimary(ctx)).QueryRowContext(ctx, "SELECT "+uploadColumns+" FROM uploads WHERE id = $1", id)
+	if err := scanUpload(row, upload); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// Advance moves the offset from "from" to "to" and pushes the expiry out.
+// It fails with ErrVersionConflict if another request moved the offset first.
+func (s *UploadStore) Advance(ctx context.Context, id string, from, to int64, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.Writer(ctx).ExecContext(ctx,
+		"UPDATE uploads SET upload_offset = $1, expires_at = $2 WHERE id = $3 AND upload_offset = $4 AND completed_at IS NULL",
+		to, expiresAt, id, from)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// Complete marks the upload finalized into the given track
+func (s *UploadStore) Complete(ctx context.Context, id string, trackID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx,
+		"UPDATE uploads SET track_id = $1, completed_at = NOW() WHERE id = $2", trackID, id)
+	return err
+}
+
+// Delete removes an upload
+func (s *UploadStore) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.Writer(ctx).ExecContext(ctx, "DELETE FROM uploads WHERE id = $1", id)
+	return err
+}
+
+// ListExpired returns uploads (finished or not) that expired before the given time
+func (s *UploadStore) ListExpired(ctx context.Context, before time.Time, limit int) ([]*Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.Reader(db.WithPrimary(ctx)).QueryContext(ctx,
+		"SELECT "+uploadColumns+" FROM uploads WHERE expires_at < $1 ORDER BY expires_at LIMIT $2",
+		before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*Upload
+	for rows.Next() {
+		upload := &Upload{}
+		if err := scanUpload(rows, upload); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, rows.Err()
+}
@@ -2,6 +2,8 @@ package store
 
 import (
 	"audio-go/internal/auth"
+	"audio-go/internal/db"
+	"audio-go/internal/events"
 	"context"
 	"database/sql"
 	"errors"
@@ -17,12 +19,21 @@ var (
 	ErrPasswordNotSet  = errors.New("password must be set before signing up")
 )
 
+// User roles
+const (
+	RoleListener = "listener"
+	RoleArtist   = "artist"
+	RoleAdmin    = "admin"
+)
+
 // User represents a user in the system
 type User struct {
 	ID        int64    `json:"id"`
 	Email     string   `json:"email"`
-	Password  password `json:"-"` // Unexported password field (we don't expose it in the response)
+	Password  password `json:"-"`    // Unexported password field (we don't expose it in the response)
+	Role      string   `json:"role"` // One of the Role constants, listener unless set at sign-up
 	CreatedAt string   `json:"created_at"`
+	Version   int64    `json:"version"` // Incremented on every update (optimistic locking)
 }
 
 // password manages password hashing and verification
@@ -63,14 +74,14 @@ func (p *password) Compare(plainText string) error {
 
 // UserStore handles user-related database operations
 type UserStore struct {
-	db      *sql.DB
+	db      *db.Cluster
 	jwtAuth *auth.JWTAuthenticator
 }
 
 // NewUserStore creates a new UserStore
-func NewUserStore(db *sql.DB, jwtAuth *auth.JWTAuthenticator) *UserStore {
+func NewUserStore(cluster *db.Cluster, jwtAuth *auth.JWTAuthenticator) *UserStore {
 	return &UserStore{
-		db:      db,
+		db:      cluster,
 		jwtAuth: jwtAuth,
 	}
 }
@@ -88,7 +99,7 @@ func (us *UserStore) SignIn(ctx context.Context, user *User) (*SignInResponse, e
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 	// Retrieve stored password hash from the database
-	err := us.db.QueryRowContext(ctx, "SELECT id, password FROM users WHERE email = ?", user.Email).Scan(&user.ID, &storedHash)
+	err := us.db.Reader(ctx).QueryRowContext(ctx, "SELECT id, password FROM users WHERE email = $1", user.Email).Scan(&user.ID, &storedHash)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -130,9 +141,9 @@ type SignUpResponse struct {
 
 // SignUp registers a new user and returns a JWT token if successful
 func (us *UserStore) SignUp(ctx context.Context, user *User) (*SignUpResponse, error) {
-	// Check if the user already exists
+	// Check if the user already exists (on the primary, a replica may not have seen it yet)
 	var existingID int64
-	err := us.db.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", user.Email).Scan(&existingID)
+	err := us.db.Writer(ctx).QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", user.Email).Scan(&existingID)
 	if err == nil {
 		// Email already taken
 		return nil, ErrEmailTaken
@@ -153,14 +164,25 @@ func (us *UserStore) SignUp(ctx context.Context, user *User) (*SignUpResponse, e
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
-	// Insert the new user into the database
-	result, err := us.db.ExecContext(ctx, "INSERT INTO users (email, password, created_at) VALUES (?, ?, NOW())", user.Email, user.Password.hash)
-	if err != nil {
-		return nil, err
-	}
+	// Insert the new user and its signed-up event in one transaction
+	err = withTx(ctx, us.db.Writer(ctx), func(tx *sql.Tx) error {
+		if user.Role == "" {
+			user.Role = RoleListener
+		}
+		query := "INSERT INTO users (email, password, role, created_at) VALUES ($1, $2, $3, NOW()) RETURNING id, created_at, version"
+		if err := tx.QueryRowContext(ctx, query, user.Email, user.Password.hash, user.Role).Scan(&user.ID, &user.CreatedAt, &user.Version); err != nil {
+			if isUniqueViolation(err) {
+				return ErrEmailTaken
+			}
+			return err
+		}
 
-	// Get the new user's ID
-	user.ID, err = result.LastInsertId()
+		event, err := events.New(events.UserSignedUp, "user", user.ID, user)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, event)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -180,3 +202,84 @@ func (us *UserStore) SignUp(ctx context.Context, user *User) (*SignUpResponse, e
 		User:  user,
 	}, nil
 }
+
+// GetByID returns the user with the given id
+func (us *UserStore) GetByID(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user := &User{}
+	err := us.db.Reader(ctx).QueryRowContext(ctx,
+		"SELECT id, email, role, created_at, version FROM users WHERE id = $1", id,
+	).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt, &user.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail returns the user with the given email
+func (us *UserStore) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user := &User{}
+	err := us.db.Reader(ctx).QueryRowContext(ctx,
+		"SELECT id, email, role, created_at, version FROM users WHERE email = $1", email,
+	).Scan(&user.ID, &user.Email, &user.Role, &user.CreatedAt, &user.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Update saves the user's mutable fields. user.Version must hold the version
+// the caller read; if someone else updated the row in the meantime the update
+// is rejected with ErrVersionConflict, and with ErrUserNotFound once the user
+// is gone. On success user.Version is the new version.
+func (us *UserStore) Update(ctx context.Context, user *User) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET email = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	return withTx(ctx, us.db.Writer(ctx), func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, user.Email, user.ID, user.Version).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case err == sql.ErrNoRows:
+				// Tell "gone" apart from "changed since you read it"
+				var exists bool
+				if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", user.ID).Scan(&exists); err != nil {
+					return err
+				}
+				if exists {
+					return ErrVersionConflict
+				}
+				return ErrUserNotFound
+			case isUniqueViolation(err):
+				return ErrEmailTaken
+			default:
+				return err
+			}
+		}
+
+		event, err := events.New(events.UserUpdated, "user", user.ID, user)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, event)
+	})
+}
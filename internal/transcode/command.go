@@ -0,0 +1,93 @@
+package transcode
+
+import (
+	"audio-go/internal/pcm"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// maxStderr is how much of an encoder's error output is kept for errors
+const maxStderr = 4 << 10
+
+// Command is an Encoder running an external program such as ffmpeg or
+// lame. The audio is piped to its standard input as 32-bit float WAV and
+// the rendition read from its standard output. Arguments may contain
+// {bitrate} (bits per second), {kbps}, {rate} and {channels}.
+type Command struct {
+	Path string
+	Args []string
+}
+
+// ParseCommand splits a command line on spaces, e.g.
+// "ffmpeg -i pipe:0 -b:a {bitrate} -f mp3 pipe:1". It returns an error
+// if the program cannot be found.
+func ParseCommand(line string) (Command, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Command{}, errors.New("transcode: empty command")
+	}
+	path, err := exec.LookPath(fields[0])
+	if err != nil {
+		return Command{}, fmt.Errorf("transcode: %w", err)
+	}
+	return Command{Path: path, Args: fields[1:]}, nil
+}
+
+func (c Command) Encode(ctx context.Context, w io.Writer, src pcm.Source, spec Spec) error {
+	format := src.Format()
+	replacer := strings.NewReplacer(
+		"{bitrate}", strconv.Itoa(spec.Bitrate),
+		"{kbps}", strconv.Itoa(spec.Bitrate/1000),
+		"{rate}", strconv.Itoa(format.SampleRate),
+		"{channels}", strconv.Itoa(format.Channels),
+	)
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = replacer.Replace(a)
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &tailBuffer{max: maxStderr}
+	cmd.Stdout, cmd.Stderr = w, stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	_, feedErr := pcm.EncodeWAV(stdin, src, pcm.WAVOptions{BitDepth: 32, Float: true})
+	stdin.Close()
+	waitErr := cmd.Wait()
+	switch {
+	case waitErr != nil:
+		return fmt.Errorf("transcode: %s: %w: %s", cmd.Path, waitErr, strings.TrimSpace(stderr.String()))
+	case feedErr != nil:
+		return fmt.Errorf("transcode: feeding %s: %w", cmd.Path, feedErr)
+	}
+	return nil
+}
+
+// tailBuffer keeps the last max bytes written to it
+type tailBuffer struct {
+	max int
+	b   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.b = append(t.b, p...)
+	if over := len(t.b) - t.max; over > 0 {
+		t.b = append(t.b[:0], t.b[over:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.b)
+}
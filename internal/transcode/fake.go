@@ -0,0 +1,68 @@
+package transcode
+
+import (
+	"audio-go/internal/pcm"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sync"
+)
+
+// ErrFakeFailure is what FakeEncoder returns for the calls it fails
+var ErrFakeFailure = errors.New("fake encoder failure")
+
+// FakeEncoder stands in for real encoders in tests and in setups without
+// encoder programs. It reads the whole source and writes one line with the
+// spec, format, frame count and a CRC-32 of the samples, so the pipeline
+// in front of it can be checked. The first Failures calls fail instead.
+type FakeEncoder struct {
+	Failures int
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *FakeEncoder) Encode(ctx context.Context, w io.Writer, src pcm.Source, spec Spec) error {
+	f.mu.Lock()
+	f.calls++
+	fail := f.calls <= f.Failures
+	f.mu.Unlock()
+	if fail {
+		return ErrFakeFailure
+	}
+
+	format := src.Format()
+	sum := crc32.NewIEEE()
+	buf := make([]float32, 4096*format.Channels)
+	b := make([]byte, 4)
+	var frames int64
+	for {
+		n, err := src.ReadFrames(buf)
+		for _, v := range buf[:n*format.Channels] {
+			bits := math.Float32bits(v)
+			b[0], b[1], b[2], b[3] = byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24)
+			sum.Write(b)
+		}
+		frames += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%s %s %d Hz %d ch %d bit %d bps: %d frames, crc32 %08x\n",
+		spec.Name, spec.Format, format.SampleRate, format.Channels, spec.BitDepth, spec.Bitrate, frames, sum.Sum32())
+	return err
+}
+
+// Calls returns how many times Encode was called
+func (f *FakeEncoder) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
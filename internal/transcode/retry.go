@@ -0,0 +1,60 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// permanentError marks a failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Backoff retries failed attempts with exponentially growing delays
+type Backoff struct {
+	Attempts int           // in total, at least one
+	Base     time.Duration // delay after the first failure, doubled after each
+	Max      time.Duration // cap on a single delay
+}
+
+// Retry calls fn until it succeeds, fails permanently, the attempts run
+// out or ctx is done, and returns its last error. fn gets the attempt
+// number, from 1. Delays are jittered by up to a quarter either way.
+func (b Backoff) Retry(ctx context.Context, fn func(attempt int) error) error {
+	delay := b.Base
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || IsPermanent(err) || attempt >= b.Attempts {
+			return err
+		}
+
+		jitter := time.Duration((rand.Float64() - 0.5) / 2 * float64(delay))
+		t := time.NewTimer(delay + jitter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		delay = min(delay*2, b.Max)
+	}
+}
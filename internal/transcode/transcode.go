@@ -0,0 +1,241 @@
+// Package transcode turns uploaded masters into delivery renditions. A
+// ladder of Specs says what to produce; Encoders, pure Go for WAV and FLAC
+// or external programs for lossy formats, write each one from the decoded
+// master after it is down-mixed, resampled and dithered to the spec.
+package transcode
+
+import (
+	"audio-go/internal/pcm"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrNoEncoder is returned for a spec whose format has no registered encoder
+var ErrNoEncoder = errors.New("no encoder for format")
+
+// formats are the rendition formats and how they are delivered
+var formats = map[string]struct {
+	ext         string
+	contentType string
+	lossless    bool
+}{
+	"wav":  {"wav", "audio/wav", true},
+	"flac": {"flac", "audio/flac", true},
+	"mp3":  {"mp3", "audio/mpeg", false},
+	"aac":  {"m4a", "audio/mp4", false},
+	"opus": {"opus", "audio/ogg; codecs=opus", false},
+}
+
+// Spec describes one rendition of the ladder. Zero values keep the
+// master's property.
+type Spec struct {
+	Name       string `json:"name"`
+	Format     string `json:"format"` // "wav", "flac", "mp3", "aac" or "opus"
+	SampleRate int    `json:"sample_rate,omitempty"`
+	BitDepth   int    `json:"bit_depth,omitempty"` // lossless formats, at most 24
+	Channels   int    `json:"channels,omitempty"`  // 1 or 2
+	Bitrate    int    `json:"bitrate,omitempty"`   // lossy formats, bits per second
+}
+
+// Lossless reports whether the spec's format is lossless
+func (s Spec) Lossless() bool {
+	return formats[s.Format].lossless
+}
+
+// Ext is the file extension of the rendition
+func (s Spec) Ext() string {
+	return formats[s.Format].ext
+}
+
+// ContentType is the content type of the rendition
+func (s Spec) ContentType() string {
+	return formats[s.Format].contentType
+}
+
+// ParseLadder reads a comma separated list of renditions, each a name, "="
+// and the format followed by "/"-separated options: "16bit", "44.1khz",
+// "192kbps", "mono" or "stereo". For example:
+//
+//	flac_16_44=flac/16bit/44.1khz, mp3_192=mp3/192kbps/stereo
+func ParseLadder(s string) ([]Spec, error) {
+	var ladder []Spec
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, def, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("transcode: rendition %q needs a name", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("transcode: duplicate rendition %q", name)
+		}
+		seen[name] = true
+
+		parts := strings.Split(strings.ToLower(def), "/")
+		spec := Spec{Name: name, Format: parts[0]}
+		if _, ok := formats[spec.Format]; !ok {
+			return nil, fmt.Errorf("transcode: rendition %q has unknown format %q", name, spec.Format)
+		}
+		for _, opt := range parts[1:] {
+			if err := spec.setOption(opt); err != nil {
+				return nil, fmt.Errorf("transcode: rendition %q: %w", name, err)
+			}
+		}
+		if spec.BitDepth != 0 && !spec.Lossless() || spec.Bitrate != 0 && spec.Lossless() {
+			return nil, fmt.Errorf("transcode: rendition %q mixes lossless and lossy options", name)
+		}
+		ladder = append(ladder, spec)
+	}
+	return ladder, nil
+}
+
+func (s *Spec) setOption(opt string) error {
+	number := func(suffix string, scale float64) (int, bool) {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(opt, suffix), 64)
+		return int(v * scale), err == nil && v > 0
+	}
+	var ok bool
+	switch {
+	case opt == "mono":
+		s.Channels, ok = 1, true
+	case opt == "stereo":
+		s.Channels, ok = 2, true
+	case strings.HasSuffix(opt, "bit"):
+		s.BitDepth, ok = number("bit", 1)
+		ok = ok && s.BitDepth >= 8 && s.BitDepth <= 24
+	case strings.HasSuffix(opt, "khz"):
+		s.SampleRate, ok = number("khz", 1000)
+	case strings.HasSuffix(opt, "hz"):
+		s.SampleRate, ok = number("hz", 1)
+	case strings.HasSuffix(opt, "kbps"):
+		s.Bitrate, ok = number("kbps", 1000)
+	}
+	if !ok {
+		return fmt.Errorf("invalid option %q", opt)
+	}
+	return nil
+}
+
+// Encoder writes audio in one format. The source it gets is already at the
+// spec's sample rate and channel count and, for lossless formats, on the
+// grid of spec.BitDepth.
+type Encoder interface {
+	Encode(ctx context.Context, w io.Writer, src pcm.Source, spec Spec) error
+}
+
+// Transcoder makes renditions with the encoders registered for each format
+type Transcoder struct {
+	encoders map[string]Encoder
+}
+
+// New returns a Transcoder with the pure Go WAV and FLAC encoders
+func New() *Transcoder {
+	return &Transcoder{encoders: map[string]Encoder{
+		"wav":  WAVEncoder{},
+		"flac": FLACEncoder{},
+	}}
+}
+
+// Register sets the encoder of a format, replacing any other
+func (t *Transcoder) Register(format string, e Encoder) {
+	t.encoders[format] = e
+}
+
+// Supports reports whether the format has an encoder
+func (t *Transcoder) Supports(format string) bool {
+	return t.encoders[format] != nil
+}
+
+// Transcode writes the rendition of spec from a decoded master whose
+// samples have masterBits of precision (0 for float or lossy masters).
+// Errors retrying cannot fix are marked Permanent.
+func (t *Transcoder) Transcode(ctx context.Context, w io.Writer, master pcm.Source, masterBits int, spec Spec) error {
+	enc := t.encoders[spec.Format]
+	if enc == nil {
+		return Permanent(fmt.Errorf("%w %q", ErrNoEncoder, spec.Format))
+	}
+
+	src, err := Prepare(master, masterBits, &spec)
+	if err != nil {
+		return Permanent(err)
+	}
+	err = enc.Encode(ctx, w, &ctxSource{ctx: ctx, Source: src}, spec)
+	if errors.Is(err, pcm.ErrUnsupported) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// Prepare converts a master to the spec's channels, then sample rate, and
+// for lossless specs dithers to the bit depth, which it fills in when the
+// spec leaves it to the master
+func Prepare(master pcm.Source, masterBits int, spec *Spec) (pcm.Source, error) {
+	src := master
+	var err error
+	if spec.Channels > 0 {
+		if src, err = pcm.Downmix(src, spec.Channels); err != nil {
+			return nil, err
+		}
+	}
+	if spec.SampleRate > 0 {
+		if src, err = pcm.Resample(src, spec.SampleRate); err != nil {
+			return nil, err
+		}
+	}
+	if !spec.Lossless() {
+		return src, nil
+	}
+
+	if spec.BitDepth == 0 {
+		spec.BitDepth = 24
+		if masterBits > 0 {
+			spec.BitDepth = min(max(masterBits, 8), 24)
+		}
+	}
+	// Untouched samples that already fit need no dither
+	if src == master && masterBits > 0 && masterBits <= spec.BitDepth {
+		return src, nil
+	}
+	return pcm.Dither(src, spec.BitDepth)
+}
+
+// ctxSource stops reading once the context is done
+type ctxSource struct {
+	ctx context.Context
+	pcm.Source
+}
+
+func (s *ctxSource) ReadFrames(buf []float32) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.Source.ReadFrames(buf)
+}
+
+// WAVEncoder writes integer PCM WAV at the spec's bit depth
+type WAVEncoder struct{}
+
+func (WAVEncoder) Encode(ctx context.Context, w io.Writer, src pcm.Source, spec Spec) error {
+	_, err := pcm.EncodeWAV(w, src, pcm.WAVOptions{BitDepth: wavBitDepth(spec.BitDepth)})
+	return err
+}
+
+// wavBitDepth rounds a bit depth up to a whole number of bytes
+func wavBitDepth(bits int) int {
+	return (bits + 7) / 8 * 8
+}
+
+// FLACEncoder writes FLAC at the spec's bit depth
+type FLACEncoder struct{}
+
+func (FLACEncoder) Encode(ctx context.Context, w io.Writer, src pcm.Source, spec Spec) error {
+	_, err := pcm.EncodeFLAC(w, src, pcm.FLACOptions{BitDepth: spec.BitDepth})
+	return err
+}
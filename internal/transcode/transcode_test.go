@@ -0,0 +1,244 @@
+package transcode
+
+import (
+	"audio-go/internal/pcm"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sineSource is a 1 kHz sine at half scale on every channel
+type sineSource struct {
+	format pcm.Format
+	pos    int64
+}
+
+func newSine(sampleRate, channels int, frames int64) *sineSource {
+	return &sineSource{format: pcm.Format{SampleRate: sampleRate, Channels: channels, Frames: frames}}
+}
+
+func (s *sineSource) Format() pcm.Format { return s.format }
+
+func (s *sineSource) ReadFrames(buf []float32) (int, error) {
+	n := 0
+	for ; (n+1)*s.format.Channels <= len(buf) && s.pos < s.format.Frames; n++ {
+		v := float32(0.5 * math.Sin(2*math.Pi*1000*float64(s.pos)/float64(s.format.SampleRate)))
+		for c := 0; c < s.format.Channels; c++ {
+			buf[n*s.format.Channels+c] = v
+		}
+		s.pos++
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func TestParseLadder(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Spec
+		wantErr string
+	}{
+		{in: "", want: nil},
+		{
+			in: "flac_16_44=flac/16bit/44.1khz, mp3_192=mp3/192kbps/stereo",
+			want: []Spec{
+				{Name: "flac_16_44", Format: "flac", BitDepth: 16, SampleRate: 44100},
+				{Name: "mp3_192", Format: "mp3", Bitrate: 192000, Channels: 2},
+			},
+		},
+		{
+			in: " wav=WAV/24bit/96000Hz/mono ,, opus=opus/64kbps ",
+			want: []Spec{
+				{Name: "wav", Format: "wav", BitDepth: 24, SampleRate: 96000, Channels: 1},
+				{Name: "opus", Format: "opus", Bitrate: 64000},
+			},
+		},
+		{in: "aac=aac", want: []Spec{{Name: "aac", Format: "aac"}}},
+		{in: "flac", wantErr: "needs a name"},
+		{in: "=flac", wantErr: "needs a name"},
+		{in: "a=flac,a=mp3", wantErr: "duplicate rendition"},
+		{in: "a=ogg", wantErr: "unknown format"},
+		{in: "a=flac/32bit", wantErr: "invalid option"},
+		{in: "a=flac/0khz", wantErr: "invalid option"},
+		{in: "a=mp3/loud", wantErr: "invalid option"},
+		{in: "a=mp3/16bit", wantErr: "mixes lossless and lossy"},
+		{in: "a=flac/320kbps", wantErr: "mixes lossless and lossy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLadder(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseLadder(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseLadder(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	tests := []struct {
+		name         string
+		masterBits   int
+		spec         Spec
+		wantFormat   pcm.Format
+		wantBitDepth int
+		untouched    bool // the master itself comes back
+	}{
+		{"lossless at master depth", 16, Spec{Format: "flac", BitDepth: 16}, pcm.Format{SampleRate: 48000, Channels: 2}, 16, true},
+		{"depth taken from master", 16, Spec{Format: "flac"}, pcm.Format{SampleRate: 48000, Channels: 2}, 16, true},
+		{"float master gets 24 bits", 0, Spec{Format: "wav"}, pcm.Format{SampleRate: 48000, Channels: 2}, 24, false},
+		{"deep master is capped at 24 bits", 32, Spec{Format: "wav"}, pcm.Format{SampleRate: 48000, Channels: 2}, 24, false},
+		{"reduced depth is dithered", 24, Spec{Format: "flac", BitDepth: 16}, pcm.Format{SampleRate: 48000, Channels: 2}, 16, false},
+		{"mono and resampled", 16, Spec{Format: "flac", BitDepth: 16, SampleRate: 44100, Channels: 1}, pcm.Format{SampleRate: 44100, Channels: 1}, 16, false},
+		{"lossy keeps no depth", 16, Spec{Format: "mp3", Bitrate: 128000}, pcm.Format{SampleRate: 48000, Channels: 2}, 0, true},
+		{"lossy stereo master", 16, Spec{Format: "opus", Channels: 2, SampleRate: 48000}, pcm.Format{SampleRate: 48000, Channels: 2}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			master := newSine(48000, 2, 4800)
+			spec := tt.spec
+			src, err := Prepare(master, tt.masterBits, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := src.Format(); got.SampleRate != tt.wantFormat.SampleRate || got.Channels != tt.wantFormat.Channels {
+				t.Fatalf("format = %d Hz %d ch, want %d Hz %d ch",
+					got.SampleRate, got.Channels, tt.wantFormat.SampleRate, tt.wantFormat.Channels)
+			}
+			if spec.BitDepth != tt.wantBitDepth {
+				t.Fatalf("spec.BitDepth = %d, want %d", spec.BitDepth, tt.wantBitDepth)
+			}
+			if untouched := src == pcm.Source(master); untouched != tt.untouched {
+				t.Fatalf("master returned as is: %v, want %v", untouched, tt.untouched)
+			}
+		})
+	}
+
+	t.Run("unsupported channels", func(t *testing.T) {
+		spec := Spec{Format: "flac", Channels: 6}
+		if _, err := Prepare(newSine(48000, 2, 480), 16, &spec); !errors.Is(err, pcm.ErrUnsupported) {
+			t.Fatalf("Prepare to 6 channels: %v, want pcm.ErrUnsupported", err)
+		}
+	})
+}
+
+func TestTranscode(t *testing.T) {
+	tr := New()
+	fake := &FakeEncoder{}
+	tr.Register("mp3", fake)
+
+	var out bytes.Buffer
+	spec := Spec{Name: "mp3_128", Format: "mp3", Bitrate: 128000, Channels: 1, SampleRate: 24000}
+	if err := tr.Transcode(context.Background(), &out, newSine(48000, 2, 4800), 16, spec); err != nil {
+		t.Fatal(err)
+	}
+	if want := "mp3_128 mp3 24000 Hz 1 ch 0 bit 128000 bps: 2400 frames"; !strings.HasPrefix(out.String(), want) {
+		t.Fatalf("encoded %q, want it to start with %q", out.String(), want)
+	}
+	if fake.Calls() != 1 {
+		t.Fatalf("encoder called %d times, want 1", fake.Calls())
+	}
+
+	err := tr.Transcode(context.Background(), io.Discard, newSine(48000, 2, 480), 16, Spec{Format: "opus"})
+	if !errors.Is(err, ErrNoEncoder) || !IsPermanent(err) {
+		t.Fatalf("Transcode without an encoder: %v, want a permanent ErrNoEncoder", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = tr.Transcode(ctx, io.Discard, newSine(48000, 2, 480), 16, Spec{Format: "mp3"})
+	if !errors.Is(err, context.Canceled) || IsPermanent(err) {
+		t.Fatalf("Transcode with a cancelled context: %v, want a retryable context.Canceled", err)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	base := errors.New("boom")
+	if Permanent(nil) != nil {
+		t.Fatal("Permanent(nil) != nil")
+	}
+	if IsPermanent(base) {
+		t.Fatal("plain error reported permanent")
+	}
+	err := fmt.Errorf("wrapped: %w", Permanent(base))
+	if !IsPermanent(err) || !errors.Is(err, base) || err.Error() != "wrapped: boom" {
+		t.Fatalf("wrapped permanent error: %v, permanent %v", err, IsPermanent(err))
+	}
+}
+
+func TestBackoffRetry(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int // attempts that fail before one succeeds
+		permanent bool
+		wantCalls int
+		wantErr   error
+	}{
+		{"first try", 3, 0, false, 1, nil},
+		{"succeeds after failures", 5, 2, false, 3, nil},
+		{"attempts run out", 3, 10, false, 3, boom},
+		{"single attempt", 1, 10, false, 1, boom},
+		{"permanent stops at once", 5, 10, true, 1, boom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Backoff{Attempts: tt.attempts, Base: time.Millisecond, Max: 2 * time.Millisecond}
+			var calls []int
+			err := b.Retry(context.Background(), func(attempt int) error {
+				calls = append(calls, attempt)
+				if attempt > tt.failures {
+					return nil
+				}
+				if tt.permanent {
+					return Permanent(boom)
+				}
+				return boom
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Retry = %v, want %v", err, tt.wantErr)
+			}
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("fn called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			for i, attempt := range calls {
+				if attempt != i+1 {
+					t.Fatalf("attempt numbers %v, want 1, 2, ...", calls)
+				}
+			}
+		})
+	}
+
+	t.Run("context done while waiting", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		b := Backoff{Attempts: 5, Base: time.Hour, Max: time.Hour}
+		calls := 0
+		start := time.Now()
+		err := b.Retry(ctx, func(int) error { calls++; return boom })
+		if !errors.Is(err, boom) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Retry = %v, want boom and the context's error", err)
+		}
+		if calls != 1 || time.Since(start) > time.Minute {
+			t.Fatalf("fn called %d times in %v, want once before the deadline", calls, time.Since(start))
+		}
+	})
+}
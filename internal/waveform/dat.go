@@ -0,0 +1,132 @@
+package waveform
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Content types of the two encodings
+const (
+	DatContentType  = "application/octet-stream"
+	JSONContentType = "application/json"
+)
+
+// flag8Bit marks 8-bit peaks in the .dat header
+const flag8Bit = 1
+
+var errShortDat = errors.New("waveform: truncated .dat data")
+
+// MarshalBinary encodes the waveform in the audiowaveform .dat layout:
+// version 1, a little-endian header and then min/max pairs of one channel
+func (d *Data) MarshalBinary() ([]byte, error) {
+	if d.Bits != 8 && d.Bits != 16 {
+		return nil, ErrBits
+	}
+	flags := uint32(0)
+	if d.Bits == 8 {
+		flags = flag8Bit
+	}
+
+	b := make([]byte, 0, 20+len(d.Peaks)*d.Bits/8)
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, flags)
+	b = binary.LittleEndian.AppendUint32(b, uint32(d.SampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(d.SamplesPerPixel))
+	b = binary.LittleEndian.AppendUint32(b, uint32(d.Len()))
+	for _, v := range d.Peaks {
+		if d.Bits == 8 {
+			b = append(b, byte(int8(v)))
+		} else {
+			b = binary.LittleEndian.AppendUint16(b, uint16(v))
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes .dat data of version 1, or of version 2 with
+// the channels merged into one
+func (d *Data) UnmarshalBinary(b []byte) error {
+	if len(b) < 20 {
+		return errShortDat
+	}
+	version := binary.LittleEndian.Uint32(b)
+	flags := binary.LittleEndian.Uint32(b[4:])
+	sampleRate := int(binary.LittleEndian.Uint32(b[8:]))
+	spp := int(binary.LittleEndian.Uint32(b[12:]))
+	length := int(binary.LittleEndian.Uint32(b[16:]))
+	b = b[20:]
+
+	channels := 1
+	switch version {
+	case 1:
+	case 2:
+		if len(b) < 4 {
+			return errShortDat
+		}
+		channels = int(binary.LittleEndian.Uint32(b))
+		b = b[4:]
+		if channels < 1 || channels > 24 {
+			return fmt.Errorf("waveform: %d channels in .dat data", channels)
+		}
+	default:
+		return fmt.Errorf("waveform: unsupported .dat version %d", version)
+	}
+
+	bits := 16
+	if flags&flag8Bit != 0 {
+		bits = 8
+	}
+	if spp <= 0 {
+		return ErrLevel
+	}
+	if len(b) < length*channels*2*bits/8 {
+		return errShortDat
+	}
+
+	*d = Data{SampleRate: sampleRate, SamplesPerPixel: spp, Bits: bits, Peaks: make([]int16, 0, 2*length)}
+	sample := func(i int) int16 {
+		if bits == 8 {
+			return int16(int8(b[i]))
+		}
+		return int16(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+	for i := 0; i < length; i++ {
+		lo, hi := sample(2*i*channels), sample(2*i*channels+1)
+		for c := 1; c < channels; c++ {
+			lo = min(lo, sample(2*(i*channels+c)))
+			hi = max(hi, sample(2*(i*channels+c)+1))
+		}
+		d.Peaks = append(d.Peaks, lo, hi)
+	}
+	return nil
+}
+
+// jsonData is the audiowaveform JSON layout
+type jsonData struct {
+	Version         int     `json:"version"`
+	Channels        int     `json:"channels"`
+	SampleRate      int     `json:"sample_rate"`
+	SamplesPerPixel int     `json:"samples_per_pixel"`
+	Bits            int     `json:"bits"`
+	Length          int     `json:"length"`
+	Data            []int16 `json:"data"`
+}
+
+// MarshalJSON encodes the waveform in the audiowaveform JSON layout
+func (d *Data) MarshalJSON() ([]byte, error) {
+	peaks := d.Peaks
+	if peaks == nil {
+		peaks = []int16{}
+	}
+	return json.Marshal(jsonData{
+		Version:         2,
+		Channels:        1,
+		SampleRate:      d.SampleRate,
+		SamplesPerPixel: d.SamplesPerPixel,
+		Bits:            d.Bits,
+		Length:          d.Len(),
+		Data:            peaks,
+	})
+}
@@ -0,0 +1,109 @@
+package waveform
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Style is how a waveform image is drawn
+type Style struct {
+	Width, Height int
+	Color         color.NRGBA
+	Background    color.NRGBA // fully transparent for none
+}
+
+// ParseColor reads "#rrggbb" or "#rrggbbaa"
+func ParseColor(s string) (color.NRGBA, error) {
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 && len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("waveform: invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("waveform: invalid color %q", s)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// columns returns the min and max of each of width columns as fractions
+// of full scale, taking the span of pixels each one covers
+func (d *Data) columns(width int) [][2]float64 {
+	cols := make([][2]float64, width)
+	n := d.Len()
+	if n == 0 {
+		return cols
+	}
+	scale := d.Scale()
+	for x := range cols {
+		from := x * n / width
+		to := max((x+1)*n/width, from+1)
+		lo, hi := d.span(from, min(to, n))
+		cols[x] = [2]float64{max(float64(lo)/scale, -1), min(float64(hi)/scale, 1)}
+	}
+	return cols
+}
+
+// WriteSVG draws the waveform as a single filled path, scaled to the style's
+// size. Viewers stretch it to any size without loss.
+func WriteSVG(w io.Writer, d *Data, style Style) error {
+	bw := bufio.NewWriter(w)
+	width, height := style.Width, float64(style.Height)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" preserveAspectRatio="none">`,
+		width, style.Height, width, style.Height)
+	if style.Background.A > 0 {
+		fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"%s/>`, hexColor(style.Background), opacity(style.Background))
+	}
+
+	// Along the maxima left to right, back along the minima
+	y := func(v float64) float64 { return (1 - v) * height / 2 }
+	cols := d.columns(width)
+	fmt.Fprintf(bw, `<path fill="%s"%s d="M0 %.1f`, hexColor(style.Color), opacity(style.Color), height/2)
+	for x, c := range cols {
+		fmt.Fprintf(bw, "L%d %.1fL%d %.1f", x, y(c[1]), x+1, y(c[1]))
+	}
+	for x := len(cols) - 1; x >= 0; x-- {
+		fmt.Fprintf(bw, "L%d %.1fL%d %.1f", x+1, y(cols[x][0]), x, y(cols[x][0]))
+	}
+	bw.WriteString(`Z"/></svg>`)
+	return bw.Flush()
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func opacity(c color.NRGBA) string {
+	if c.A == 0xff {
+		return ""
+	}
+	return fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/0xff)
+}
+
+// WritePNG draws the waveform as one vertical bar per column, at least a
+// pixel tall so silence shows as a line
+func WritePNG(w io.Writer, d *Data, style Style) error {
+	img := image.NewNRGBA(image.Rect(0, 0, style.Width, style.Height))
+	bg, fg := style.Background, style.Color
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+
+	height := float64(style.Height)
+	for x, c := range d.columns(style.Width) {
+		top := int((1 - c[1]) * height / 2)
+		bottom := max(int((1-c[0])*height/2+0.5), top+1)
+		for y := max(top, 0); y < min(bottom, style.Height); y++ {
+			img.SetNRGBA(x, y, fg)
+		}
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,166 @@
+// Package waveform computes the min/max peaks players draw waveforms from.
+// Peaks are kept at several zoom levels, each a number of samples per
+// pixel, and stored in the binary .dat and JSON formats of BBC's
+// audiowaveform, which waveform-data.js and peaks.js read directly.
+package waveform
+
+import (
+	"audio-go/internal/pcm"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+)
+
+var (
+	ErrBits  = errors.New("waveform: bits must be 8 or 16")
+	ErrLevel = errors.New("waveform: samples per pixel must be positive")
+)
+
+// Data is one zoom level of a waveform: the lowest and highest sample of
+// every pixel, across channels, scaled to signed integers of Bits bits
+type Data struct {
+	SampleRate      int
+	SamplesPerPixel int
+	Bits            int     // 8 or 16
+	Peaks           []int16 // min, max of each pixel in turn
+}
+
+// Len is the number of pixels
+func (d *Data) Len() int {
+	return len(d.Peaks) / 2
+}
+
+// Pixel returns the min and max of pixel i
+func (d *Data) Pixel(i int) (lo, hi int16) {
+	return d.Peaks[2*i], d.Peaks[2*i+1]
+}
+
+// Scale is the value of a full scale sample
+func (d *Data) Scale() float64 {
+	return float64(int(1)<<(d.Bits-1) - 1)
+}
+
+// span returns the min and max over pixels [from, to)
+func (d *Data) span(from, to int) (lo, hi int16) {
+	lo, hi = math.MaxInt16, math.MinInt16
+	for i := from; i < to; i++ {
+		l, h := d.Pixel(i)
+		lo, hi = min(lo, l), max(hi, h)
+	}
+	return lo, hi
+}
+
+// Rescale returns the waveform at a coarser level. A pixel that straddles
+// two of the new pixels counts towards both.
+func (d *Data) Rescale(samplesPerPixel int) (*Data, error) {
+	if samplesPerPixel < d.SamplesPerPixel {
+		return nil, fmt.Errorf("waveform: cannot rescale %d samples per pixel to %d", d.SamplesPerPixel, samplesPerPixel)
+	}
+	if samplesPerPixel == d.SamplesPerPixel {
+		return d, nil
+	}
+
+	total := int64(d.Len()) * int64(d.SamplesPerPixel)
+	n := int((total + int64(samplesPerPixel) - 1) / int64(samplesPerPixel))
+	out := &Data{SampleRate: d.SampleRate, SamplesPerPixel: samplesPerPixel, Bits: d.Bits, Peaks: make([]int16, 0, 2*n)}
+	for i := 0; i < n; i++ {
+		from := int(int64(i) * int64(samplesPerPixel) / int64(d.SamplesPerPixel))
+		to := int((int64(i+1)*int64(samplesPerPixel) + int64(d.SamplesPerPixel) - 1) / int64(d.SamplesPerPixel))
+		lo, hi := d.span(from, min(to, d.Len()))
+		out.Peaks = append(out.Peaks, lo, hi)
+	}
+	return out, nil
+}
+
+// Builder computes the levels of a waveform from frames as they are decoded
+type Builder struct {
+	channels int
+	levels   []*level
+	scale    float32
+}
+
+// level accumulates the pixel being filled
+type level struct {
+	data   *Data
+	count  int
+	lo, hi float32
+}
+
+// NewBuilder starts a waveform of the given format at each level of
+// samples per pixel, with peaks of bits bits
+func NewBuilder(format pcm.Format, levels []int, bits int) (*Builder, error) {
+	if bits != 8 && bits != 16 {
+		return nil, ErrBits
+	}
+	b := &Builder{channels: format.Channels, scale: float32(int(1)<<(bits-1) - 1)}
+	for _, spp := range levels {
+		if spp <= 0 {
+			return nil, ErrLevel
+		}
+		b.levels = append(b.levels, &level{
+			data: &Data{SampleRate: format.SampleRate, SamplesPerPixel: spp, Bits: bits},
+			lo:   math.MaxFloat32,
+			hi:   -math.MaxFloat32,
+		})
+	}
+	return b, nil
+}
+
+// Write adds interleaved frames
+func (b *Builder) Write(frames []float32) {
+	for i := 0; i+b.channels <= len(frames); i += b.channels {
+		lo, hi := frames[i], frames[i]
+		for _, v := range frames[i+1 : i+b.channels] {
+			lo, hi = min(lo, v), max(hi, v)
+		}
+		for _, l := range b.levels {
+			l.lo, l.hi = min(l.lo, lo), max(l.hi, hi)
+			if l.count++; l.count == l.data.SamplesPerPixel {
+				b.flush(l)
+			}
+		}
+	}
+}
+
+func (b *Builder) flush(l *level) {
+	quantize := func(v float32) int16 {
+		return int16(max(-b.scale-1, min(b.scale, float32(math.Round(float64(v*b.scale))))))
+	}
+	l.data.Peaks = append(l.data.Peaks, quantize(l.lo), quantize(l.hi))
+	l.count, l.lo, l.hi = 0, math.MaxFloat32, -math.MaxFloat32
+}
+
+// Data finishes the last pixels and returns the levels, finest first
+func (b *Builder) Data() []*Data {
+	out := make([]*Data, 0, len(b.levels))
+	for _, l := range b.levels {
+		if l.count > 0 {
+			b.flush(l)
+		}
+		out = append(out, l.data)
+	}
+	slices.SortFunc(out, func(x, y *Data) int { return x.SamplesPerPixel - y.SamplesPerPixel })
+	return out
+}
+
+// Compute reads src to the end and returns its waveform at each level
+func Compute(src pcm.Source, levels []int, bits int) ([]*Data, error) {
+	format := src.Format()
+	b, err := NewBuilder(format, levels, bits)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]float32, 4096*format.Channels)
+	for {
+		n, err := src.ReadFrames(buf)
+		b.Write(buf[:n*format.Channels])
+		if err == io.EOF {
+			return b.Data(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}